@@ -12,8 +12,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/busybytelab.com/glimmer/internal/health"
 	"github.com/busybytelab.com/glimmer/internal/llm"
 	chatRoutePkg "github.com/busybytelab.com/glimmer/internal/route/chat"
+	healthRoutePkg "github.com/busybytelab.com/glimmer/internal/route/health"
 	llmRoutePkg "github.com/busybytelab.com/glimmer/internal/route/llm"
 	practiceRoutePkg "github.com/busybytelab.com/glimmer/internal/route/practice"
 	"github.com/pocketbase/pocketbase"
@@ -31,7 +33,10 @@ type Application struct {
 	embedFs      fs.FS
 	config       *Config
 	llmService   llm.Service
+	llmCache     llm.CacheStorage
+	llmModels    llm.ModelManager
 	chatService  llm.ChatService
+	health       *health.Checker
 }
 
 // create a new application instance with the provided filesystem for static files.
@@ -51,6 +56,7 @@ func (app *Application) Initialize() error {
 	log.Debug().Msg("Initializing application...")
 	app.setupMigrations()
 	app.setupLLMService()
+	app.setupHealthChecks()
 	app.setupRoutes()
 	app.setupCollectionsAndHooks()
 	app.setupCommands()
@@ -71,15 +77,20 @@ func (a *Application) setupMigrations() {
 func (app *Application) setupCommands() {
 	log.Trace().Msg("Setting up custom commands...")
 	setupCommands(app.pb)
+	setupDoctorCommand(app.pb, app.health)
 	log.Trace().Msg("Custom commands setup completed")
 }
 
 // configures the HTTP routes for the application
 func (app *Application) setupRoutes() {
 	llmRoutes := llmRoutePkg.New(app.llmService)
+	cacheRoutes := llmRoutePkg.NewCacheRoutes(app.llmCache)
+	modelRoutes := llmRoutePkg.NewModelRoutes(app.llmModels)
 	practiceRoute := practiceRoutePkg.NewPracticeSessionRoute(app.llmService)
 	answerRoute := practiceRoutePkg.NewAnswerRoute()
+	worksheetRoute := practiceRoutePkg.NewWorksheetRoute(app.llmService)
 	chatRoutes := chatRoutePkg.New(app.chatService)
+	healthRoutes := healthRoutePkg.New(app.health)
 
 	app.pb.OnServe().BindFunc(func(e *core.ServeEvent) error {
 		// API routes - register these first for priority
@@ -87,13 +98,25 @@ func (app *Application) setupRoutes() {
 		// LLM chat API endpoint for common chat requests
 		e.Router.POST("/api/glimmer/v1/llm/chat", llmRoutes.HandleChatRequest).Bind(apis.RequireAuth())
 		e.Router.GET("/api/glimmer/v1/llm/info", llmRoutes.HandleInfoRequest).Bind(apis.RequireAuth())
+		e.Router.GET("/api/glimmer/v1/llm/budget", llmRoutes.HandleBudgetRequest).Bind(apis.RequireAuth())
+		e.Router.GET("/api/glimmer/v1/llm/cache/stats", cacheRoutes.HandleCacheStatsRequest).Bind(apis.RequireSuperuserAuth())
+		e.Router.POST("/api/glimmer/v1/llm/cache/purge", cacheRoutes.HandleCachePurgeRequest).Bind(apis.RequireSuperuserAuth())
+		e.Router.POST("/api/glimmer/v1/llm/models/pull", modelRoutes.HandlePullRequest).Bind(apis.RequireAuth())
+		e.Router.POST("/api/glimmer/v1/llm/models/delete", modelRoutes.HandleDeleteRequest).Bind(apis.RequireAuth())
+		e.Router.GET("/api/glimmer/v1/llm/models/show", modelRoutes.HandleShowRequest).Bind(apis.RequireAuth())
+		e.Router.POST("/api/glimmer/v1/llm/models/load", modelRoutes.HandleLoadRequest).Bind(apis.RequireAuth())
 		e.Router.POST("/api/glimmer/v1/practice/session", practiceRoute.HandleCreatePracticeSession).Bind(apis.RequireAuth())
+		e.Router.POST("/api/glimmer/v1/practice/worksheet", worksheetRoute.HandleCreateWorksheetSession).Bind(apis.RequireAuth())
 		e.Router.POST("/api/glimmer/v1/practice/evaluate-answer", answerRoute.HandleEvaluateAnswer).Bind(apis.RequireAuth())
 		e.Router.POST("/api/glimmer/v1/practice/process-answer", answerRoute.HandleProcessAnswer).Bind(apis.RequireAuth())
 
 		// Chat API endpoints
 		e.Router.POST("/api/glimmer/v1/chat", chatRoutes.HandleChatRequest).Bind(apis.RequireAuth())
 
+		// Health endpoints for Docker and uptime monitors
+		e.Router.GET("/api/glimmer/v1/health/live", healthRoutes.HandleLiveRequest)
+		e.Router.GET("/api/glimmer/v1/health/ready", healthRoutes.HandleReadyRequest)
+
 		// Create a custom handler for static files that ensures correct MIME types
 		staticHandler := func(c *core.RequestEvent) error {
 			// Get requested path
@@ -133,6 +156,7 @@ func (app *Application) setupRoutes() {
 // register PocketBase collections and hooks
 func (app *Application) setupCollectionsAndHooks() {
 	app.setupUserHooks()
+	practiceRoutePkg.RegisterReviewHooks(app.pb)
 }
 
 // initialize the LLM service
@@ -140,15 +164,35 @@ func (app *Application) setupLLMService() {
 	llmConfig := llm.LoadConfig()
 
 	// Setup with PocketBase app for cache storage if needed
-	app.llmService = llm.AppService(llmConfig, app.pb)
+	app.llmCache = llm.NewCacheStorage(llmConfig, app.pb)
+	app.llmService = llm.AppService(llmConfig, app.pb, app.llmCache)
+	app.llmModels = llm.NewModelManager(app.llmService)
+
+	if err := llm.RegisterCacheCleanup(app.pb, llmConfig.Cache, app.llmCache); err != nil {
+		log.Error().Err(err).Str("cron", llmConfig.Cache.CleanupCron).Msg("Failed to schedule the LLM cache cleanup")
+	}
 
 	log.Info().Msg("LLM service initialized")
 	// Initialize the chat service with PocketBase app and LLM service
-	app.chatService = llm.NewChatService(app.pb, app.llmService)
+	app.chatService = llm.NewChatService(app.pb, app.llmService, app.chatTools())
 
 	log.Info().Msg("Chat service initialized")
 }
 
+// initialize the checks of the dependencies, reported by the readiness endpoint and the doctor command
+func (app *Application) setupHealthChecks() {
+	app.health = health.NewChecker(app.pb, app.llmService, app.llmCache)
+}
+
+// chatTools returns the tools offered to the model in the account chat
+func (app *Application) chatTools() *llm.ToolRegistry {
+	tools := llm.NewToolRegistry()
+	if err := practiceRoutePkg.RegisterChatTools(tools, app.pb, app.llmService); err != nil {
+		log.Error().Err(err).Msg("Failed to register the practice chat tools")
+	}
+	return tools
+}
+
 // configure signal handling for graceful shutdown
 func (app *Application) setupGracefulShutdown() {
 	// register for SIGINT (Ctrl+C) and SIGTERM
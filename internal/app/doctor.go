@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/busybytelab.com/glimmer/internal/health"
+	"github.com/pocketbase/pocketbase"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// doctorCommand holds the dependencies of the doctor command
+type doctorCommand struct {
+	checker *health.Checker
+}
+
+// handleDoctor prints the checks of the readiness endpoint, it exits with 1 when a check failed
+func (d *doctorCommand) handleDoctor(cmd *cobra.Command, args []string) {
+	report := d.checker.Run(context.Background())
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tTIME\tMESSAGE")
+	for _, check := range report.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%dms\t%s\n", check.Name, check.Status, check.Duration, check.Message)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to print the diagnostics")
+	}
+
+	fmt.Printf("\nStatus: %s\n", report.Status)
+	if report.Status == health.StatusFail {
+		os.Exit(1)
+	}
+}
+
+// setupDoctorCommand configures the doctor command for the application
+func setupDoctorCommand(pb *pocketbase.PocketBase, checker *health.Checker) {
+	handler := &doctorCommand{checker: checker}
+
+	pb.RootCmd.AddCommand(&cobra.Command{
+		Use:   "doctor",
+		Short: "Check the database, the migrations, the LLM platforms and the LLM cache",
+		Run:   handler.handleDoctor,
+	})
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -14,21 +15,36 @@ type ChatItem struct {
 	Order   int       `json:"order"`
 	Created time.Time `json:"created"`
 	Updated time.Time `json:"updated"`
+	// ToolCalls are the tools an assistant message asks to call
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID is the call a tool message answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// ModerationReason is set when the content was withheld for a parent review
+	ModerationReason string `json:"moderation_reason,omitempty"`
+}
+
+// ToolCall is a call of a tool requested by the model, the arguments are a JSON object
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
 }
 
 // Chat represents a chat conversation
 type Chat struct {
-	ID           string      `json:"id"`
-	UserID       string      `json:"user"`
-	Label        string      `json:"label"`
-	SystemPrompt string      `json:"system_prompt"`
-	Model        string      `json:"model"`
-	TotalTokens  int         `json:"total_tokens"`
-	TotalCost    float64     `json:"total_cost"`
-	Archived     bool        `json:"archived"`
-	Created      time.Time   `json:"created"`
-	Updated      time.Time   `json:"updated"`
-	Items        []*ChatItem `json:"items,omitempty"`
+	ID           string  `json:"id"`
+	UserID       string  `json:"user"`
+	Label        string  `json:"label"`
+	SystemPrompt string  `json:"system_prompt"`
+	Model        string  `json:"model"`
+	TotalTokens  int     `json:"total_tokens"`
+	TotalCost    float64 `json:"total_cost"`
+	Archived     bool    `json:"archived"`
+	// LLMParameters override the sampling parameters for the chat
+	LLMParameters *SamplingParameters `json:"llm_parameters,omitempty"`
+	Created       time.Time           `json:"created"`
+	Updated       time.Time           `json:"updated"`
+	Items         []*ChatItem         `json:"items,omitempty"`
 }
 
 // ChatItemRole defines the possible roles for chat messages
@@ -36,4 +52,6 @@ const (
 	ChatItemRoleUser      = "user"
 	ChatItemRoleAssistant = "assistant"
 	ChatItemRoleSystem    = "system"
+	// ChatItemRoleTool holds the result of a tool call
+	ChatItemRoleTool = "tool"
 )
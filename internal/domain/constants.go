@@ -13,6 +13,10 @@ const (
 	CollectionEarnedAchievements     = "earned_achievements"
 	CollectionChats                  = "chats"
 	CollectionChatItems              = "chat_items"
+	CollectionLLMUsage               = "llm_usage"
+	CollectionPromptVersions         = "prompt_versions"
+	CollectionPromptGenerations      = "prompt_generations"
+	CollectionPromptVersionStats     = "prompt_version_stats"
 	// Library collections
 	CollectionPracticeTopicsLibrary   = "practice_topics_library"
 	CollectionPracticeItemsLibrary    = "practice_items_library"
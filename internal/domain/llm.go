@@ -2,10 +2,59 @@ package domain
 
 // Usage represents the token usage and cost information for an LLM request
 type Usage struct {
-	LlmModelName     string  `json:"llmModelName"`
-	CacheHit         bool    `json:"cacheHit"`
-	Cost             float64 `json:"cost"`
-	PromptTokens     int     `json:"promptTokens"`
-	CompletionTokens int     `json:"completionTokens"`
-	TotalTokens      int     `json:"totalTokens"`
+	LlmModelName string `json:"llmModelName"`
+	// Platform is the platform that answered, which differs from the requested one after a fallback
+	Platform     string  `json:"platform"`
+	CacheHit     bool    `json:"cacheHit"`
+	Cost         float64 `json:"cost"`
+	PromptTokens int     `json:"promptTokens"`
+	// CachedPromptTokens are the prompt tokens read from the prompt cache of the platform, they are cheaper
+	CachedPromptTokens int `json:"cachedPromptTokens"`
+	CompletionTokens   int `json:"completionTokens"`
+	TotalTokens        int `json:"totalTokens"`
+	// Moderation is set when the moderation flagged the response for a parent review
+	Moderation *Moderation `json:"moderation,omitempty"`
+}
+
+// Moderation tells why the moderation flagged an LLM response
+type Moderation struct {
+	Reason string `json:"reason"`
+	// Source is the classifier that flagged the response, "local" for the word list and patterns or "judge"
+	Source string `json:"source"`
+}
+
+// SamplingParameters tune how the LLM generates a response, nil values use the model defaults
+type SamplingParameters struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"topP,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	// MaxTokens is the upper bound of generated tokens
+	MaxTokens *int `json:"maxTokens,omitempty"`
+	// ContextLength is the size of the context window, only Ollama supports it
+	ContextLength *int `json:"contextLength,omitempty"`
+}
+
+// IsEmpty returns true when no parameter is set
+func (p SamplingParameters) IsEmpty() bool {
+	return p.Temperature == nil && p.TopP == nil && p.Seed == nil && p.MaxTokens == nil && p.ContextLength == nil
+}
+
+// Merge returns the parameters with every value set in other taking precedence
+func (p SamplingParameters) Merge(other SamplingParameters) SamplingParameters {
+	if other.Temperature != nil {
+		p.Temperature = other.Temperature
+	}
+	if other.TopP != nil {
+		p.TopP = other.TopP
+	}
+	if other.Seed != nil {
+		p.Seed = other.Seed
+	}
+	if other.MaxTokens != nil {
+		p.MaxTokens = other.MaxTokens
+	}
+	if other.ContextLength != nil {
+		p.ContextLength = other.ContextLength
+	}
+	return p
 }
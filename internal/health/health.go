@@ -0,0 +1,194 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type (
+	// Status is the result of a check, a failed check makes Glimmer not ready
+	Status string
+
+	// Check is the result of a single diagnostic
+	Check struct {
+		Name   string `json:"name"`
+		Status Status `json:"status"`
+		// Message explains the status, it may hold internal addresses such as the URL of Ollama
+		Message  string `json:"message,omitempty"`
+		Duration int64  `json:"durationMs"`
+	}
+
+	// Report holds the checks, its status is the worst status of the checks
+	Report struct {
+		Status Status  `json:"status"`
+		Checks []Check `json:"checks"`
+	}
+
+	// Checker diagnoses the dependencies of Glimmer: the database, its migrations, the LLM platforms and the LLM cache
+	Checker struct {
+		app        core.App
+		llmService llm.Service
+		cache      llm.CacheStorage
+		migrations []*core.MigrationsList
+	}
+)
+
+const (
+	StatusOK Status = "ok"
+	// StatusWarn is a degraded dependency Glimmer works without, such as a fallback LLM platform
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+const (
+	// checkTimeout bounds the checks, a hung LLM platform shouldn't hang the readiness probe
+	checkTimeout = 10 * time.Second
+	// maxListedMigrations is the number of pending migrations named in the message of the check
+	maxListedMigrations = 3
+)
+
+// NewChecker creates the checker of the dependencies, the cache is nil when disabled
+func NewChecker(app core.App, llmService llm.Service, cache llm.CacheStorage) *Checker {
+	return &Checker{
+		app:        app,
+		llmService: llmService,
+		cache:      cache,
+		migrations: []*core.MigrationsList{&core.SystemMigrations, &core.AppMigrations},
+	}
+}
+
+// Run runs every check
+func (c *Checker) Run(ctx context.Context) *Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := &Report{Status: StatusOK}
+	report.run("database", c.checkDatabase)
+	report.run("migrations", c.checkMigrations)
+
+	// The platforms are probed, the models cached by the service don't tell whether a platform is still up
+	info := c.llmService.Info(ctx, llm.WithProbedModels())
+	for _, platform := range info.Platforms {
+		status, message := checkPlatform(platform)
+		report.add(Check{Name: "llm:" + platform.Name, Status: status, Message: message, Duration: platform.Duration.Milliseconds()})
+	}
+
+	report.run("cache", c.checkCache)
+
+	return report
+}
+
+// Summary returns the report without the messages of the checks, for the clients that aren't superusers
+func (r *Report) Summary() *Report {
+	summary := &Report{Status: r.Status, Checks: make([]Check, 0, len(r.Checks))}
+	for _, check := range r.Checks {
+		check.Message = ""
+		summary.Checks = append(summary.Checks, check)
+	}
+	return summary
+}
+
+// run runs the check and adds its result
+func (r *Report) run(name string, check func() (Status, string)) {
+	start := time.Now()
+	status, message := check()
+	r.add(Check{Name: name, Status: status, Message: message, Duration: time.Since(start).Milliseconds()})
+}
+
+// add adds the check, lowering the status of the report to the one of the check
+func (r *Report) add(check Check) {
+	r.Checks = append(r.Checks, check)
+	if check.Status == StatusFail || (check.Status == StatusWarn && r.Status == StatusOK) {
+		r.Status = check.Status
+	}
+}
+
+// checkDatabase runs a query on the SQLite database
+func (c *Checker) checkDatabase() (Status, string) {
+	if _, err := c.app.DB().NewQuery("SELECT 1").Execute(); err != nil {
+		return StatusFail, err.Error()
+	}
+	return StatusOK, ""
+}
+
+// checkMigrations fails when registered migrations are not applied, the database is behind the code
+func (c *Checker) checkMigrations() (Status, string) {
+	var files []string
+	if err := c.app.DB().Select("file").From(core.DefaultMigrationsTable).Column(&files); err != nil {
+		return StatusFail, fmt.Sprintf("failed to load the applied migrations: %v", err)
+	}
+
+	applied := make(map[string]bool, len(files))
+	for _, file := range files {
+		applied[file] = true
+	}
+
+	var pending []string
+	for _, list := range c.migrations {
+		for _, migration := range list.Items() {
+			if !applied[migration.File] {
+				pending = append(pending, migration.File)
+			}
+		}
+	}
+
+	if len(pending) > maxListedMigrations {
+		return StatusFail, fmt.Sprintf("%d pending migrations: %s, ...", len(pending), strings.Join(pending[:maxListedMigrations], ", "))
+	}
+	if len(pending) > 0 {
+		return StatusFail, fmt.Sprintf("%d pending migrations: %s", len(pending), strings.Join(pending, ", "))
+	}
+	return StatusOK, fmt.Sprintf("%d migrations applied", len(files))
+}
+
+// checkPlatform fails when the default platform can't list its models, the other platforms only warn
+// as the default platform serves without them. A degraded platform rejects requests until its circuit breaker closes.
+func checkPlatform(platform llm.PlatformInfo) (Status, string) {
+	failure := StatusWarn
+	if platform.IsDefault {
+		failure = StatusFail
+	}
+
+	if platform.Err != nil {
+		return failure, platform.Err.Error()
+	}
+	if platform.Status == llm.PlatformStatusDegraded {
+		return failure, "requests are rejected by the circuit breaker after repeated failures"
+	}
+
+	if platform.Name == string(llm.OllamaPlatform) {
+		installed := false
+		for _, model := range platform.Models {
+			installed = installed || model.IsDefault
+		}
+		if !installed {
+			return StatusWarn, fmt.Sprintf("%d models, the default model is not installed", len(platform.Models))
+		}
+	}
+
+	return StatusOK, fmt.Sprintf("%d models", len(platform.Models))
+}
+
+// checkCache reads the stats of the LLM cache, which queries the database for the PocketBase backend.
+// Requests are served without the cache, so a failing cache only warns.
+func (c *Checker) checkCache() (Status, string) {
+	if c.cache == nil {
+		return StatusOK, "disabled"
+	}
+
+	admin, ok := c.cache.(llm.CacheAdmin)
+	if !ok {
+		return StatusOK, ""
+	}
+
+	stats, err := admin.Stats()
+	if err != nil {
+		return StatusWarn, err.Error()
+	}
+	return StatusOK, fmt.Sprintf("%s backend, %d entries", stats.Backend, stats.Entries)
+}
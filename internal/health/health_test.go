@@ -0,0 +1,196 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubService reports the given platforms, the other methods of the service are not used by the checker
+type stubService struct {
+	llm.Service
+	platforms []llm.PlatformInfo
+}
+
+func (s *stubService) Info(ctx context.Context, options ...llm.InfoOption) llm.Info {
+	return llm.Info{Platforms: s.platforms}
+}
+
+func newTestChecker(t *testing.T, platforms ...llm.PlatformInfo) *Checker {
+	app, err := tests.NewTestApp()
+	require.NoError(t, err)
+	t.Cleanup(app.Cleanup)
+
+	checker := NewChecker(app, &stubService{platforms: platforms}, llm.NewMemoryCacheStorage())
+	checker.migrations = []*core.MigrationsList{&core.SystemMigrations}
+	return checker
+}
+
+func findCheck(t *testing.T, report *Report, name string) Check {
+	t.Helper()
+	for _, check := range report.Checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	require.FailNow(t, "missing check", name)
+	return Check{}
+}
+
+func assertCheck(t *testing.T, report *Report, name string, status Status, message string) {
+	t.Helper()
+	check := findCheck(t, report, name)
+	assert.Equal(t, status, check.Status, name)
+	assert.Equal(t, message, check.Message, name)
+}
+
+func TestCheckerRun(t *testing.T) {
+	checker := newTestChecker(t,
+		llm.PlatformInfo{Name: "ollama", IsDefault: true, Models: []*llm.ModelInfo{{Name: "gemma3:4b", IsDefault: true}}, Duration: 40 * time.Millisecond},
+		llm.PlatformInfo{Name: "openai", Err: errors.New("invalid API key"), Duration: 1500 * time.Millisecond},
+	)
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, StatusWarn, report.Status, "a failing platform which isn't the default only warns")
+	assert.Equal(t, StatusOK, findCheck(t, report, "database").Status)
+	assert.Equal(t, StatusOK, findCheck(t, report, "migrations").Status)
+	assertCheck(t, report, "llm:ollama", StatusOK, "1 models")
+	assertCheck(t, report, "llm:openai", StatusWarn, "invalid API key")
+	assertCheck(t, report, "cache", StatusOK, "memory backend, 0 entries")
+	assert.Equal(t, int64(40), findCheck(t, report, "llm:ollama").Duration, "each platform is timed")
+	assert.Equal(t, int64(1500), findCheck(t, report, "llm:openai").Duration)
+
+	summary := report.Summary()
+	assert.Equal(t, StatusWarn, summary.Status)
+	require.Len(t, summary.Checks, len(report.Checks))
+	for _, check := range summary.Checks {
+		assert.Empty(t, check.Message, check.Name)
+	}
+	assert.Equal(t, "invalid API key", findCheck(t, report, "llm:openai").Message, "the summary doesn't change the report")
+}
+
+func TestCheckerRunPendingMigrations(t *testing.T) {
+	checker := newTestChecker(t, llm.PlatformInfo{Name: "echo", IsDefault: true})
+
+	pending := &core.MigrationsList{}
+	for _, file := range []string{"1_first.go", "2_second.go", "3_third.go", "4_fourth.go"} {
+		pending.Register(func(core.App) error { return nil }, nil, file)
+	}
+	checker.migrations = append(checker.migrations, pending)
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, StatusFail, report.Status)
+	assertCheck(t, report, "migrations", StatusFail, "4 pending migrations: 1_first.go, 2_second.go, 3_third.go, ...")
+}
+
+func TestCheckerRunWithoutCache(t *testing.T) {
+	checker := newTestChecker(t, llm.PlatformInfo{Name: "echo", IsDefault: true})
+	checker.cache = nil
+
+	report := checker.Run(context.Background())
+
+	assert.Equal(t, StatusOK, report.Status)
+	assertCheck(t, report, "cache", StatusOK, "disabled")
+}
+
+func TestCheckerRunProbesThePlatforms(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models":[{"name":"gemma3:4b","model":"gemma3:4b"}]}`)
+		case "/api/show":
+			fmt.Fprint(w, `{"capabilities":["completion"]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// The models of the platform are cached by the service, as they are by default
+	llmService := llm.MemoryCacheService(&llm.Config{
+		Platform: llm.OllamaPlatform,
+		Ollama:   llm.OllamaConfig{URL: server.URL, Model: "gemma3:4b"},
+		Cache:    llm.CacheConfig{Enabled: true},
+	})
+	checker := newTestChecker(t)
+	checker.llmService = llmService
+	// The models are listed for the users before the probes
+	require.Len(t, llmService.Info(context.Background()).Platforms[0].Models, 1)
+
+	report := checker.Run(context.Background())
+	assertCheck(t, report, "llm:ollama", StatusOK, "1 models")
+
+	server.Close()
+
+	report = checker.Run(context.Background())
+	assert.Equal(t, StatusFail, report.Status, "Ollama is down")
+	assert.Equal(t, StatusFail, findCheck(t, report, "llm:ollama").Status)
+	assert.Contains(t, findCheck(t, report, "llm:ollama").Message, "failed to list Ollama models")
+
+	// The probes leave the models cached by the service as they are
+	platforms := llmService.Info(context.Background()).Platforms
+	require.Len(t, platforms, 1)
+	assert.NoError(t, platforms[0].Err)
+	assert.Len(t, platforms[0].Models, 1)
+}
+
+func TestCheckPlatform(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform llm.PlatformInfo
+		status   Status
+		message  string
+	}{
+		{
+			name:     "default platform failing",
+			platform: llm.PlatformInfo{Name: "ollama", IsDefault: true, Err: errors.New("connection refused")},
+			status:   StatusFail,
+			message:  "connection refused",
+		},
+		{
+			name:     "default platform degraded",
+			platform: llm.PlatformInfo{Name: "openai", IsDefault: true, Status: llm.PlatformStatusDegraded},
+			status:   StatusFail,
+			message:  "requests are rejected by the circuit breaker after repeated failures",
+		},
+		{
+			name:     "fallback platform degraded",
+			platform: llm.PlatformInfo{Name: "openai", Status: llm.PlatformStatusDegraded},
+			status:   StatusWarn,
+			message:  "requests are rejected by the circuit breaker after repeated failures",
+		},
+		{
+			name:     "default Ollama model not installed",
+			platform: llm.PlatformInfo{Name: "ollama", IsDefault: true, Models: []*llm.ModelInfo{{Name: "llama3.2"}}},
+			status:   StatusWarn,
+			message:  "1 models, the default model is not installed",
+		},
+		{
+			name:     "cloud platform",
+			platform: llm.PlatformInfo{Name: "openai", IsDefault: true, Models: []*llm.ModelInfo{{Name: "gpt-4.1-nano"}, {Name: "gpt-4o-mini"}}},
+			status:   StatusOK,
+			message:  "2 models",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, message := checkPlatform(tt.platform)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.message, message)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"fmt"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// AccountSettings holds the LLM settings of an account.
+// Empty values fall back to the global LLM configuration.
+type AccountSettings struct {
+	AccountID       string
+	OllamaServerURL string
+	DefaultModel    string
+	Budget          Budget
+}
+
+// FindAccountSettings loads the LLM settings of the account
+func FindAccountSettings(app core.App, accountID string) (*AccountSettings, error) {
+	record, err := app.FindRecordById(domain.CollectionAccounts, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find account %s: %w", accountID, err)
+	}
+
+	return accountSettingsFromRecord(record), nil
+}
+
+// FindAccountSettingsByOwner loads the LLM settings of the account owned by the user
+func FindAccountSettingsByOwner(app core.App, userID string) (*AccountSettings, error) {
+	record, err := app.FindFirstRecordByFilter(
+		domain.CollectionAccounts,
+		"owner = {:owner}",
+		map[string]any{"owner": userID},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find account of user %s: %w", userID, err)
+	}
+
+	return accountSettingsFromRecord(record), nil
+}
+
+func accountSettingsFromRecord(record *core.Record) *AccountSettings {
+	return &AccountSettings{
+		AccountID:       record.Id,
+		OllamaServerURL: record.GetString("ollama_server_url"),
+		DefaultModel:    record.GetString("default_llm_model"),
+		Budget: Budget{
+			DailyTokens:   record.GetInt("llm_daily_token_budget"),
+			MonthlyTokens: record.GetInt("llm_monthly_token_budget"),
+			DailyCost:     record.GetFloat("llm_daily_cost_budget"),
+			MonthlyCost:   record.GetFloat("llm_monthly_cost_budget"),
+		},
+	}
+}
+
+// ChatOptions returns the chat options applying the account settings and budget.
+// The default model only applies when no other model is set, so it can be combined with WithModel in any order.
+func (s *AccountSettings) ChatOptions() []ChatOption {
+	if s == nil {
+		return nil
+	}
+
+	opts := []ChatOption{WithAccount(s.AccountID)}
+	if s.OllamaServerURL != "" {
+		opts = append(opts, WithServerURL(s.OllamaServerURL))
+	}
+	if s.DefaultModel != "" {
+		opts = append(opts, WithDefaultModel(s.DefaultModel))
+	}
+
+	return opts
+}
@@ -0,0 +1,312 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// Budget limits the LLM usage of an account, zero values are unlimited
+	Budget struct {
+		DailyTokens   int     `json:"dailyTokens"`
+		MonthlyTokens int     `json:"monthlyTokens"`
+		DailyCost     float64 `json:"dailyCost"`
+		MonthlyCost   float64 `json:"monthlyCost"`
+	}
+
+	// BudgetPeriod is the period a budget applies to, periods start at midnight UTC
+	BudgetPeriod string
+
+	// BudgetStatus reports the usage of an account against its budget
+	BudgetStatus struct {
+		AccountID string              `json:"accountId"`
+		Daily     *BudgetPeriodStatus `json:"daily"`
+		Monthly   *BudgetPeriodStatus `json:"monthly"`
+	}
+
+	// BudgetPeriodStatus reports the usage of the current period, remaining values are nil when unlimited
+	BudgetPeriodStatus struct {
+		Period          BudgetPeriod `json:"period"`
+		TokenLimit      int          `json:"tokenLimit"`
+		TokensUsed      int          `json:"tokensUsed"`
+		TokensRemaining *int         `json:"tokensRemaining"`
+		CostLimit       float64      `json:"costLimit"`
+		CostUsed        float64      `json:"costUsed"`
+		CostRemaining   *float64     `json:"costRemaining"`
+		ResetsAt        time.Time    `json:"resetsAt"`
+	}
+
+	// BudgetExceededError is returned instead of calling the platform once an account used up its budget
+	BudgetExceededError struct {
+		AccountID string
+		Period    BudgetPeriod
+		// Resource is either "tokens" or "cost"
+		Resource string
+		Limit    float64
+		Used     float64
+		ResetsAt time.Time
+	}
+
+	// budgetStore loads the budgets of accounts and their usage as recorded in the usage ledger
+	budgetStore interface {
+		Budget(accountID string) (Budget, error)
+		// Usage returns the tokens and cost used by the account since the given time
+		Usage(accountID string, since time.Time) (int, float64, error)
+	}
+
+	// budgetService enforces the account budgets before calling the delegate, the usage is recorded
+	// by the ledger service it wraps. Requests without an account are not limited.
+	budgetService struct {
+		delegate Service
+		store    budgetStore
+		now      func() time.Time
+	}
+
+	pocketBaseBudgetStore struct {
+		app core.App
+	}
+)
+
+const (
+	BudgetPeriodDaily   BudgetPeriod = "daily"
+	BudgetPeriodMonthly BudgetPeriod = "monthly"
+)
+
+var (
+	// ErrBudgetExceeded is the error all budget errors wrap, see BudgetExceededError
+	ErrBudgetExceeded = errors.New("LLM budget exceeded")
+)
+
+// newBudgetService wraps the service with the account budgets
+func newBudgetService(delegate Service, store budgetStore) Service {
+	return &budgetService{
+		delegate: delegate,
+		store:    store,
+		now:      time.Now,
+	}
+}
+
+// Unwrap returns the decorated service
+func (s *budgetService) Unwrap() Service {
+	return s.delegate
+}
+
+// FindBudgetStatus reports the usage of the account against its budget for the current day and month
+func FindBudgetStatus(app core.App, accountID string) (*BudgetStatus, error) {
+	return budgetStatus(&pocketBaseBudgetStore{app: app}, accountID, time.Now())
+}
+
+func (s *budgetService) Chat(ctx context.Context, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.call(options, func() (string, *domain.Usage, error) {
+		return s.delegate.Chat(ctx, prompt, systemPrompt, options...)
+	})
+}
+
+func (s *budgetService) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.call(options, func() (string, *domain.Usage, error) {
+		return s.delegate.ChatWithHistory(ctx, messages, systemPrompt, options...)
+	})
+}
+
+func (s *budgetService) ChatStream(ctx context.Context, prompt string, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.call(options, func() (string, *domain.Usage, error) {
+		return s.delegate.ChatStream(ctx, prompt, systemPrompt, handler, options...)
+	})
+}
+
+func (s *budgetService) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.call(options, func() (string, *domain.Usage, error) {
+		return s.delegate.ChatWithHistoryStream(ctx, messages, systemPrompt, handler, options...)
+	})
+}
+
+func (s *budgetService) ChatWithTools(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (*ChatResponse, error) {
+	var response *ChatResponse
+	_, _, err := s.call(options, func() (string, *domain.Usage, error) {
+		var err error
+		response, err = s.delegate.ChatWithTools(ctx, messages, systemPrompt, handler, options...)
+		if err != nil {
+			return "", nil, err
+		}
+		return response.Response, response.Usage, nil
+	})
+	return response, err
+}
+
+func (s *budgetService) DescribeImage(ctx context.Context, reader io.Reader, fileName string, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.call(options, func() (string, *domain.Usage, error) {
+		return s.delegate.DescribeImage(ctx, reader, fileName, prompt, systemPrompt, options...)
+	})
+}
+
+func (s *budgetService) Embed(ctx context.Context, texts []string, options ...ChatOption) ([][]float32, *domain.Usage, error) {
+	var embeddings [][]float32
+	_, usage, err := s.call(options, func() (string, *domain.Usage, error) {
+		var usage *domain.Usage
+		var err error
+		embeddings, usage, err = s.delegate.Embed(ctx, texts, options...)
+		return "", usage, err
+	})
+	return embeddings, usage, err
+}
+
+func (s *budgetService) Info(ctx context.Context, options ...InfoOption) Info {
+	return s.delegate.Info(ctx, options...)
+}
+
+// call checks the budget of the account of the options before calling fn.
+// A request can overshoot the budget, the following requests are rejected.
+func (s *budgetService) call(options []ChatOption, fn func() (string, *domain.Usage, error)) (string, *domain.Usage, error) {
+	params := &ChatParameters{}
+	for _, option := range options {
+		option(params)
+	}
+
+	if params.AccountID != "" {
+		if err := s.checkBudget(params.AccountID); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return fn()
+}
+
+// checkBudget returns a BudgetExceededError if the account used up its daily or monthly budget.
+// The budget is not enforced when it can't be loaded, failing every request would be worse.
+func (s *budgetService) checkBudget(accountID string) error {
+	status, err := budgetStatus(s.store, accountID, s.now())
+	if err != nil {
+		log.Error().Err(err).Str("accountId", accountID).Msg("Failed to load LLM budget, not enforcing it")
+		return nil
+	}
+
+	for _, period := range []*BudgetPeriodStatus{status.Daily, status.Monthly} {
+		if period.TokensRemaining != nil && *period.TokensRemaining <= 0 {
+			return &BudgetExceededError{
+				AccountID: accountID,
+				Period:    period.Period,
+				Resource:  "tokens",
+				Limit:     float64(period.TokenLimit),
+				Used:      float64(period.TokensUsed),
+				ResetsAt:  period.ResetsAt,
+			}
+		}
+		if period.CostRemaining != nil && *period.CostRemaining <= 0 {
+			return &BudgetExceededError{
+				AccountID: accountID,
+				Period:    period.Period,
+				Resource:  "cost",
+				Limit:     period.CostLimit,
+				Used:      period.CostUsed,
+				ResetsAt:  period.ResetsAt,
+			}
+		}
+	}
+
+	return nil
+}
+
+// budgetStatus computes the usage of the account against its budget at the given time
+func budgetStatus(store budgetStore, accountID string, now time.Time) (*BudgetStatus, error) {
+	budget, err := store.Budget(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.UTC()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	daily, err := budgetPeriodStatus(store, accountID, BudgetPeriodDaily, dayStart, dayStart.AddDate(0, 0, 1), budget.DailyTokens, budget.DailyCost)
+	if err != nil {
+		return nil, err
+	}
+
+	monthly, err := budgetPeriodStatus(store, accountID, BudgetPeriodMonthly, monthStart, monthStart.AddDate(0, 1, 0), budget.MonthlyTokens, budget.MonthlyCost)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BudgetStatus{
+		AccountID: accountID,
+		Daily:     daily,
+		Monthly:   monthly,
+	}, nil
+}
+
+func budgetPeriodStatus(store budgetStore, accountID string, period BudgetPeriod, start, end time.Time, tokenLimit int, costLimit float64) (*BudgetPeriodStatus, error) {
+	tokens, cost, err := store.Usage(accountID, start)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &BudgetPeriodStatus{
+		Period:     period,
+		TokenLimit: tokenLimit,
+		TokensUsed: tokens,
+		CostLimit:  costLimit,
+		CostUsed:   cost,
+		ResetsAt:   end,
+	}
+	if tokenLimit > 0 {
+		remaining := max(tokenLimit-tokens, 0)
+		status.TokensRemaining = &remaining
+	}
+	if costLimit > 0 {
+		remaining := max(costLimit-cost, 0)
+		status.CostRemaining = &remaining
+	}
+
+	return status, nil
+}
+
+func (e *BudgetExceededError) Error() string {
+	if e.Resource == "cost" {
+		return fmt.Sprintf("%s LLM cost budget of %.2f exceeded (%.2f used), resets at %s",
+			e.Period, e.Limit, e.Used, e.ResetsAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s LLM token budget of %.0f exceeded (%.0f used), resets at %s",
+		e.Period, e.Limit, e.Used, e.ResetsAt.Format(time.RFC3339))
+}
+
+func (e *BudgetExceededError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// Budget loads the budget from the account record
+func (s *pocketBaseBudgetStore) Budget(accountID string) (Budget, error) {
+	settings, err := FindAccountSettings(s.app, accountID)
+	if err != nil {
+		return Budget{}, err
+	}
+	return settings.Budget, nil
+}
+
+// Usage sums the usage recorded for the account since the given time, cache hits are free
+func (s *pocketBaseBudgetStore) Usage(accountID string, since time.Time) (int, float64, error) {
+	var total struct {
+		Tokens int     `db:"tokens"`
+		Cost   float64 `db:"cost"`
+	}
+
+	err := s.app.DB().
+		Select("COALESCE(SUM(total_tokens), 0) AS tokens", "COALESCE(SUM(cost), 0) AS cost").
+		From(domain.CollectionLLMUsage).
+		Where(dbx.HashExp{"account": accountID, "cache_hit": false}).
+		AndWhere(dbx.NewExp("created >= {:since}", dbx.Params{"since": since.UTC().Format(types.DefaultDateLayout)})).
+		One(&total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to sum LLM usage of account %s: %w", accountID, err)
+	}
+
+	return total.Tokens, total.Cost, nil
+}
@@ -0,0 +1,112 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryBudgetStore keeps budgets and the usage ledger in memory, usage is recorded at the time returned by now
+type memoryBudgetStore struct {
+	budgets map[string]Budget
+	usage   map[string][]recordedUsage
+	now     func() time.Time
+}
+
+type recordedUsage struct {
+	at     time.Time
+	tokens int
+	cost   float64
+}
+
+func newMemoryBudgetStore(now func() time.Time) *memoryBudgetStore {
+	return &memoryBudgetStore{
+		budgets: map[string]Budget{},
+		usage:   map[string][]recordedUsage{},
+		now:     now,
+	}
+}
+
+func (m *memoryBudgetStore) Budget(accountID string) (Budget, error) {
+	return m.budgets[accountID], nil
+}
+
+func (m *memoryBudgetStore) Usage(accountID string, since time.Time) (int, float64, error) {
+	tokens, cost := 0, 0.0
+	for _, usage := range m.usage[accountID] {
+		if !usage.at.Before(since) {
+			tokens += usage.tokens
+			cost += usage.cost
+		}
+	}
+	return tokens, cost, nil
+}
+
+func (m *memoryBudgetStore) Record(entry *UsageEntry) error {
+	if !entry.CacheHit {
+		m.usage[entry.AccountID] = append(m.usage[entry.AccountID], recordedUsage{at: m.now(), tokens: entry.TotalTokens, cost: entry.Cost})
+	}
+	return nil
+}
+
+func TestBudgetServiceEnforcesBudget(t *testing.T) {
+	now := time.Date(2025, 5, 14, 18, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	store := newMemoryBudgetStore(clock)
+	store.budgets["acc1"] = Budget{DailyTokens: 10}
+
+	ledger := newLedgerService(newService(&Config{}, []Platform{newEchoPlatform()}), store)
+	s := newBudgetService(ledger, store).(*budgetService)
+	s.now = clock
+
+	// The first request overshoots the budget, the next one is rejected
+	_, usage, err := s.Chat(context.Background(), "Hello there, how are you?", "", WithAccount("acc1"))
+	require.NoError(t, err)
+	assert.Greater(t, usage.TotalTokens, 10)
+
+	_, _, err = s.Chat(context.Background(), "Hello", "", WithAccount("acc1"))
+	require.ErrorIs(t, err, ErrBudgetExceeded)
+	var budgetErr *BudgetExceededError
+	require.ErrorAs(t, err, &budgetErr)
+	assert.Equal(t, BudgetPeriodDaily, budgetErr.Period)
+	assert.Equal(t, "tokens", budgetErr.Resource)
+	assert.Equal(t, time.Date(2025, 5, 15, 0, 0, 0, 0, time.UTC), budgetErr.ResetsAt)
+
+	// Other accounts and requests without an account are not limited
+	_, _, err = s.Chat(context.Background(), "Hello", "", WithAccount("acc2"))
+	require.NoError(t, err)
+	_, _, err = s.Chat(context.Background(), "Hello", "")
+	require.NoError(t, err)
+
+	// The daily budget resets the next day
+	now = now.Add(6 * time.Hour)
+	_, _, err = s.Chat(context.Background(), "Hello", "", WithAccount("acc1"))
+	require.NoError(t, err)
+}
+
+func TestBudgetStatus(t *testing.T) {
+	now := time.Date(2025, 5, 14, 18, 30, 0, 0, time.UTC)
+	store := newMemoryBudgetStore(func() time.Time { return now })
+	store.budgets["acc1"] = Budget{MonthlyTokens: 1000, MonthlyCost: 2.5}
+	store.usage["acc1"] = []recordedUsage{
+		{at: now.AddDate(0, -1, 0), tokens: 500, cost: 1},
+		{at: now.AddDate(0, 0, -3), tokens: 300, cost: 1},
+		{at: now.Add(-time.Hour), tokens: 200, cost: 0.5},
+	}
+
+	status, err := budgetStatus(store, "acc1", now)
+
+	require.NoError(t, err)
+	assert.Equal(t, 200, status.Daily.TokensUsed)
+	assert.Nil(t, status.Daily.TokensRemaining)
+	assert.Nil(t, status.Daily.CostRemaining)
+	assert.Equal(t, 500, status.Monthly.TokensUsed)
+	require.NotNil(t, status.Monthly.TokensRemaining)
+	assert.Equal(t, 500, *status.Monthly.TokensRemaining)
+	require.NotNil(t, status.Monthly.CostRemaining)
+	assert.InDelta(t, 1.0, *status.Monthly.CostRemaining, 1e-9)
+	assert.Equal(t, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), status.Monthly.ResetsAt)
+}
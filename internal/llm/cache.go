@@ -1,9 +1,13 @@
 package llm
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"hash"
+	"strconv"
+	"sync"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/rs/zerolog/log"
@@ -13,7 +17,9 @@ import (
 type cachedPlatform struct {
 	delegate Platform
 	storage  CacheStorage
-	models   []*ModelInfo
+	// models caches the models of the delegate, they only change when a model is pulled or deleted
+	mutex  sync.Mutex
+	models []*ModelInfo
 }
 
 // cacheEntry represents a cached response
@@ -35,25 +41,76 @@ func (c *cachedPlatform) Type() PlatformType {
 	return c.delegate.Type()
 }
 
-func (c *cachedPlatform) Models() ([]*ModelInfo, error) {
-	if c.models == nil {
-		models, err := c.delegate.Models()
-		if err != nil {
-			return nil, err
-		}
-		c.models = models
+// Unwrap returns the decorated platform
+func (c *cachedPlatform) Unwrap() Platform {
+	return c.delegate
+}
+
+// recordPlatform records the platform in the usage unless the delegate did already
+func (c *cachedPlatform) recordPlatform(usage *domain.Usage) {
+	if usage.Platform == "" {
+		usage.Platform = string(c.delegate.Type())
+	}
+}
+
+// Models lists the models of the delegate once, the list is kept until the cache is cleared
+func (c *cachedPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	c.mutex.Lock()
+	models := c.models
+	c.mutex.Unlock()
+	if models != nil {
+		return models, nil
+	}
+
+	// The delegate is called without the lock, a slow platform doesn't hold the other callers
+	models, err := c.delegate.Models(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return c.models, nil
+
+	c.mutex.Lock()
+	c.models = models
+	c.mutex.Unlock()
+	return models, nil
 }
 
+// ClearModelsCache drops the cached models, the next call lists the models of the delegate
 func (c *cachedPlatform) ClearModelsCache() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.models = nil
 }
 
+// clearModelsCache clears the models cached by the decorators of the platform
+func clearModelsCache(platform Platform) {
+	for platform != nil {
+		if cached, ok := platform.(interface{ ClearModelsCache() }); ok {
+			cached.ClearModelsCache()
+		}
+
+		wrapper, ok := platform.(interface{ Unwrap() Platform })
+		if !ok {
+			break
+		}
+		platform = wrapper.Unwrap()
+	}
+}
+
 // Chat implements the Platform interface with caching
-func (c *cachedPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
+func (c *cachedPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return c.chatStream(ctx, params, nil)
+}
+
+// ChatStream implements the Platform interface with caching.
+// A cached response is delivered to the handler as a single chunk.
+func (c *cachedPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return c.chatStream(ctx, params, handler)
+}
+
+// chatStream looks up the cache before calling the delegate, streaming when a handler is provided
+func (c *cachedPlatform) chatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
 	// Generate cache key from parameters
-	cacheKey := c.storage.GetChatCacheKey(params)
+	cacheKey := c.storage.GetChatCacheKey(c.delegate.Type(), params)
 
 	// Check if we should use cache
 	shouldUseCache := true
@@ -76,6 +133,9 @@ func (c *cachedPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 				if response.Usage != nil {
 					response.Usage.CacheHit = true
 				}
+				if err := replayCachedResponse(response.Response, handler); err != nil {
+					return nil, err
+				}
 				return response, nil
 			}
 		}
@@ -84,14 +144,21 @@ func (c *cachedPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 	log.Debug().Str("cacheKey", cacheKey).Msg("Cache miss")
 
 	// If not cached or ignoring cache, call the delegate platform
-	response, err := c.delegate.Chat(params)
+	var response *ChatResponse
+	var err error
+	if handler != nil {
+		response, err = c.delegate.ChatStream(ctx, params, handler)
+	} else {
+		response, err = c.delegate.Chat(ctx, params)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Mark as not a cache hit
+	// Mark as not a cache hit, recording the platform so that cached responses can be purged by backend
 	if response.Usage != nil {
 		response.Usage.CacheHit = false
+		c.recordPlatform(response.Usage)
 	}
 
 	// Store the response if caching is not disabled
@@ -106,7 +173,7 @@ func (c *cachedPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 }
 
 // DescribeImage implements the Platform interface with caching
-func (c *cachedPlatform) DescribeImage(params *DescribeImageParameters) (*DescribeImageResponse, error) {
+func (c *cachedPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
 	// Generate cache key
 	cacheKey := c.storage.GetDescribeImageCacheKey(params)
 
@@ -137,14 +204,15 @@ func (c *cachedPlatform) DescribeImage(params *DescribeImageParameters) (*Descri
 	}
 
 	// If not cached or ignoring cache, call the delegate platform
-	result, err := c.delegate.DescribeImage(params)
+	result, err := c.delegate.DescribeImage(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 
-	// Mark as not a cache hit
+	// Mark as not a cache hit, recording the platform so that cached responses can be purged by backend
 	if result.Usage != nil {
 		result.Usage.CacheHit = false
+		c.recordPlatform(result.Usage)
 	}
 
 	// Store the response if caching is not disabled
@@ -159,13 +227,29 @@ func (c *cachedPlatform) DescribeImage(params *DescribeImageParameters) (*Descri
 }
 
 // ChatWithHistory implements conversation history chat with caching
-func (c *cachedPlatform) ChatWithHistory(messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+func (c *cachedPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	return c.chatWithHistoryStream(ctx, messages, params, nil)
+}
+
+// ChatWithHistoryStream implements streamed conversation history chat with caching.
+// A cached response is delivered to the handler as a single chunk.
+func (c *cachedPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return c.chatWithHistoryStream(ctx, messages, params, handler)
+}
+
+// chatWithHistoryStream looks up the cache before calling the delegate, streaming when a handler is provided
+func (c *cachedPlatform) chatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
 	if len(messages) == 0 {
 		return nil, errors.New("no messages provided for chat with history")
 	}
 
+	// Tool calls and their results depend on the state of the app, they are never cached
+	if len(params.Tools) > 0 {
+		return c.chatWithHistoryUncached(ctx, messages, params, handler)
+	}
+
 	// Generate cache key for this conversation history
-	cacheKey := c.storage.GetChatWithHistoryCacheKey(messages, params.SystemPrompt, params.Model)
+	cacheKey := c.storage.GetChatWithHistoryCacheKey(c.delegate.Type(), messages, params)
 
 	// Check if we should use cache
 	shouldUseCache := true
@@ -193,6 +277,9 @@ func (c *cachedPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 				if response.Usage != nil {
 					response.Usage.CacheHit = true
 				}
+				if err := replayCachedResponse(response.Response, handler); err != nil {
+					return nil, err
+				}
 				return response, nil
 			}
 		}
@@ -205,16 +292,11 @@ func (c *cachedPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 		Msg("Cache miss for chat with history")
 
 	// If not cached or ignoring cache, call the delegate platform
-	response, err := c.delegate.ChatWithHistory(messages, params)
+	response, err := c.chatWithHistoryUncached(ctx, messages, params, handler)
 	if err != nil {
 		return nil, err
 	}
 
-	// Mark as not a cache hit
-	if response.Usage != nil {
-		response.Usage.CacheHit = false
-	}
-
 	// Store the response if caching is not disabled
 	if shouldUseCache {
 		if err := c.storage.SetChatWithHistoryResponse(cacheKey, messages, params.SystemPrompt, params.Model, response); err != nil {
@@ -232,12 +314,104 @@ func (c *cachedPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 	return response, nil
 }
 
-// generateCacheKey creates a hash from the prompt, system prompt, model name and backend
-func generateCacheKey(prompt, systemPrompt string, modelName string, backend PlatformType) string {
+// chatWithHistoryUncached calls the delegate platform, streaming when a handler is provided
+func (c *cachedPlatform) chatWithHistoryUncached(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	var response *ChatResponse
+	var err error
+	if handler != nil {
+		response, err = c.delegate.ChatWithHistoryStream(ctx, messages, params, handler)
+	} else {
+		response, err = c.delegate.ChatWithHistory(ctx, messages, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Mark as not a cache hit, recording the platform so that cached responses can be purged by backend
+	if response.Usage != nil {
+		response.Usage.CacheHit = false
+		c.recordPlatform(response.Usage)
+	}
+
+	return response, nil
+}
+
+// Embed implements the Platform interface with caching
+func (c *cachedPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	cacheKey := c.storage.GetEmbedCacheKey(c.delegate.Type(), params)
+
+	shouldUseCache := params.Cache == nil || !params.Cache.DisableCache
+	if shouldUseCache && (params.Cache == nil || !params.Cache.IgnoreCache) {
+		if response, err := c.storage.GetEmbedResponse(cacheKey); err == nil {
+			log.Debug().Str("cacheKey", cacheKey).Int("texts", len(params.Texts)).Msg("Cache hit for embeddings")
+			if response.Usage != nil {
+				response.Usage.CacheHit = true
+			}
+			return response, nil
+		}
+	}
+
+	response, err := c.delegate.Embed(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mark as not a cache hit, recording the platform so that cached embeddings can be purged by backend
+	if response.Usage != nil {
+		response.Usage.CacheHit = false
+		c.recordPlatform(response.Usage)
+	}
+
+	if shouldUseCache {
+		if err := c.storage.SetEmbedResponse(cacheKey, params, response); err != nil {
+			// Just log the error but don't fail the request
+			log.Error().Err(err).Msg("Failed to cache LLM embeddings")
+		}
+	}
+
+	return response, nil
+}
+
+// replayCachedResponse delivers a cached response to the stream handler, if any, as a single chunk
+func replayCachedResponse(response string, handler StreamHandler) error {
+	if handler == nil || response == "" {
+		return nil
+	}
+	return handler(response)
+}
+
+// generateCacheKey creates a hash from the prompt, system prompt, model name, backend and generation settings
+func generateCacheKey(prompt, systemPrompt string, modelName string, backend PlatformType, generation string) string {
 	hasher := sha256.New()
 	hasher.Write([]byte(prompt))
 	hasher.Write([]byte(systemPrompt))
 	hasher.Write([]byte(modelName))
 	hasher.Write([]byte(string(backend)))
+	hasher.Write([]byte(generation))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// serverCacheKey identifies the platform serving a chat, the accounts with their own Ollama server don't share its responses
+func serverCacheKey(platform PlatformType, params *ChatParameters) string {
+	return string(platform) + "|" + normalizeOllamaURL(params.ServerURL)
+}
+
+// writeServerKey adds the platform and the Ollama server of the chat to the hash
+func writeServerKey(hasher hash.Hash, platform PlatformType, params *ChatParameters) {
+	hasher.Write([]byte(serverCacheKey(platform, params)))
+	hasher.Write([]byte{0})
+}
+
+// embedCacheKey creates a hash from the platform, the model and the texts.
+// The texts are length-prefixed so that different splits of the same characters don't collide.
+func embedCacheKey(platform PlatformType, params *EmbedParameters) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(platform))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(params.Model))
+	for _, text := range params.Texts {
+		hasher.Write([]byte(strconv.Itoa(len(text)) + ":"))
+		hasher.Write([]byte(text))
+	}
 	return hex.EncodeToString(hasher.Sum(nil))
 }
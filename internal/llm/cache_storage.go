@@ -1,11 +1,17 @@
 package llm
 
-import "github.com/busybytelab.com/glimmer/internal/domain"
+import (
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
 
 // CacheStorage interface for storing and retrieving LLM responses
 type CacheStorage interface {
-	// GetChatCacheKey generates a cache key for a chat request
-	GetChatCacheKey(params *ChatParameters) string
+	// GetChatCacheKey generates a cache key for a chat request, responses of different platforms and Ollama servers don't mix
+	GetChatCacheKey(platform PlatformType, params *ChatParameters) string
 
 	// GetChatResponse retrieves a cached chat response
 	GetChatResponse(cacheKey string) (*ChatResponse, error)
@@ -13,8 +19,8 @@ type CacheStorage interface {
 	// SetChatResponse stores a chat response in the cache
 	SetChatResponse(cacheKey string, params *ChatParameters, response *ChatResponse) error
 
-	// GetChatWithHistoryCacheKey generates a cache key for a chat request with message history
-	GetChatWithHistoryCacheKey(messages []*domain.ChatItem, systemPrompt, model string) string
+	// GetChatWithHistoryCacheKey generates a cache key for a chat request with message history on the platform
+	GetChatWithHistoryCacheKey(platform PlatformType, messages []*domain.ChatItem, params *ChatParameters) string
 
 	// GetChatWithHistoryResponse retrieves a cached chat with history response
 	GetChatWithHistoryResponse(cacheKey string) (*ChatResponse, error)
@@ -30,4 +36,69 @@ type CacheStorage interface {
 
 	// SetDescribeImageResponse stores an image description in the cache
 	SetDescribeImageResponse(cacheKey string, params *DescribeImageParameters, response *DescribeImageResponse) error
+
+	// GetEmbedCacheKey generates a cache key for an embed request, embeddings of different platforms don't mix
+	GetEmbedCacheKey(platform PlatformType, params *EmbedParameters) string
+
+	// GetEmbedResponse retrieves cached embeddings
+	GetEmbedResponse(cacheKey string) (*EmbedResponse, error)
+
+	// SetEmbedResponse stores embeddings in the cache
+	SetEmbedResponse(cacheKey string, params *EmbedParameters, response *EmbedResponse) error
+}
+
+// CacheAdmin is implemented by the cache storages that report their stats and can be purged
+type CacheAdmin interface {
+	// Stats returns the counters and the size of the cache
+	Stats() (CacheStats, error)
+
+	// Purge deletes the cached responses matching the filter and returns how many were deleted
+	Purge(filter CachePurgeFilter) (int, error)
+}
+
+var (
+	_ CacheAdmin = (*MemoryCacheStorage)(nil)
+	_ CacheAdmin = (*PocketBaseCacheStorage)(nil)
+)
+
+// CacheStats holds the counters of a cache. The hit, miss and expiration counters are those of the running instance.
+type CacheStats struct {
+	Backend     CacheBackendType `json:"backend"`
+	Hits        uint64           `json:"hits"`
+	Misses      uint64           `json:"misses"`
+	Evictions   uint64           `json:"evictions"`
+	Expirations uint64           `json:"expirations"`
+	Entries     int              `json:"entries"`
+	// Expired is the number of entries whose TTL elapsed that are not purged yet
+	Expired    int   `json:"expired"`
+	Bytes      int64 `json:"bytes"`
+	MaxEntries int   `json:"maxEntries,omitempty"`
+	MaxBytes   int64 `json:"maxBytes,omitempty"`
+}
+
+// CachePurgeFilter selects the cached responses to purge, the zero filter selects all of them
+type CachePurgeFilter struct {
+	// Model is the model name without the platform, e.g. "gemma3:1b"
+	Model string
+	// Backend is the platform that answered, e.g. "ollama"
+	Backend string
+	// OlderThan selects the responses cached at least that long ago
+	OlderThan time.Duration
+	// ExpiredOnly selects the responses whose TTL elapsed
+	ExpiredOnly bool
+}
+
+// NewCacheStorage creates the cache storage of the configured backend, nil when the cache is disabled
+func NewCacheStorage(config *Config, app core.App) CacheStorage {
+	if !config.Cache.Enabled {
+		return nil
+	}
+
+	if config.Cache.Backend == string(PocketBaseCache) {
+		log.Info().Msg("Creating PocketBase-backed LLM cache storage")
+		return NewPocketBaseCacheStorageWithConfig(app, config.Cache)
+	}
+
+	log.Info().Msg("Creating in-memory LLM cache storage")
+	return NewMemoryCacheStorageWithConfig(config.Cache)
 }
@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// Capability is a feature a model supports, such as reading images
+	Capability string
+
+	// ModelCapabilityError is returned for a request the chosen model can't serve, e.g. a worksheet photo
+	// sent to a text-only model or a chat history longer than the context of the model
+	ModelCapabilityError struct {
+		Model string
+		// Capability is the missing capability, empty when the prompt exceeds the context length
+		Capability    Capability
+		ContextLength int
+		PromptTokens  int
+	}
+
+	// modelDescriber is implemented by the platforms knowing the capabilities of their models
+	modelDescriber interface {
+		// describeModel returns the capabilities and the context length of the model, nil when the platform doesn't know the model
+		describeModel(ctx context.Context, model string, serverURL string) (*ModelInfo, error)
+		// defaultModel returns the model of the requests naming none, the embedding model for embeddings
+		defaultModel(embedding bool) string
+	}
+
+	// knownModel describes a model of a platform whose API doesn't report capabilities
+	knownModel struct {
+		capabilities  []Capability
+		contextLength int
+	}
+)
+
+const (
+	CapabilityCompletion Capability = "completion"
+	CapabilityVision     Capability = "vision"
+	// CapabilityJSON is the support of JSON responses and structured outputs, see WithResponseFormat
+	CapabilityJSON      Capability = "json"
+	CapabilityTools     Capability = "tools"
+	CapabilityEmbedding Capability = "embedding"
+)
+
+var (
+	// ErrModelCapability is the error all capability errors wrap, see ModelCapabilityError
+	ErrModelCapability = errors.New("model doesn't support the request")
+
+	chatModel      = []Capability{CapabilityCompletion, CapabilityJSON, CapabilityTools}
+	multimodalChat = []Capability{CapabilityCompletion, CapabilityVision, CapabilityJSON, CapabilityTools}
+	embeddingModel = []Capability{CapabilityEmbedding}
+	// anthropicChat lacks the tools, the Anthropic platform doesn't send them
+	anthropicChat = []Capability{CapabilityCompletion, CapabilityVision, CapabilityJSON}
+
+	// toolPlatforms are the platforms sending the tools of the requests to the models, the others would answer without them
+	toolPlatforms = []PlatformType{OllamaPlatform, OpenAIPlatform}
+
+	// knownModels describes the models of the cloud platforms by name prefix, the longest prefix wins
+	knownModels = map[PlatformType]map[string]knownModel{
+		OpenAIPlatform: {
+			"gpt-3.5-turbo":          {capabilities: chatModel, contextLength: 16385},
+			"gpt-4":                  {capabilities: chatModel, contextLength: 8192},
+			"gpt-4-turbo":            {capabilities: multimodalChat, contextLength: 128000},
+			"gpt-4o":                 {capabilities: multimodalChat, contextLength: 128000},
+			"gpt-4.1":                {capabilities: multimodalChat, contextLength: 1047576},
+			"gpt-5":                  {capabilities: multimodalChat, contextLength: 400000},
+			"o3":                     {capabilities: multimodalChat, contextLength: 200000},
+			"o3-mini":                {capabilities: chatModel, contextLength: 200000},
+			"o4-mini":                {capabilities: multimodalChat, contextLength: 200000},
+			"text-embedding-3":       {capabilities: embeddingModel, contextLength: 8191},
+			"text-embedding-ada-002": {capabilities: embeddingModel, contextLength: 8191},
+		},
+		AnthropicPlatform: {
+			"claude-": {capabilities: anthropicChat, contextLength: 200000},
+		},
+	}
+)
+
+func (e *ModelCapabilityError) Error() string {
+	if e.Capability == "" {
+		return fmt.Sprintf("the request of about %d tokens exceeds the context of %d tokens of model %s", e.PromptTokens, e.ContextLength, e.Model)
+	}
+	return fmt.Sprintf("model %s doesn't support %s", e.Model, e.Capability)
+}
+
+func (e *ModelCapabilityError) Unwrap() error {
+	return ErrModelCapability
+}
+
+// Supports returns true if the model has the capability
+func (m *ModelInfo) Supports(capability Capability) bool {
+	return slices.Contains(m.Capabilities, capability)
+}
+
+// describeKnownModel returns the known capabilities of the model of the platform, nil for unknown models
+func describeKnownModel(platform PlatformType, model string) *ModelInfo {
+	longest := ""
+	for name := range knownModels[platform] {
+		if len(name) > len(longest) && strings.HasPrefix(model, name) {
+			longest = name
+		}
+	}
+	if longest == "" {
+		return nil
+	}
+
+	known := knownModels[platform][longest]
+	return &ModelInfo{
+		Name:          model,
+		Capabilities:  known.capabilities,
+		ContextLength: known.contextLength,
+	}
+}
+
+// isOllamaModel returns false for the known models of the cloud platforms, Ollama models may have any other name.
+// An empty model is the default model of Ollama.
+func isOllamaModel(model string) bool {
+	for platformType := range knownModels {
+		if describeKnownModel(platformType, model) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// addKnownCapabilities sets the known capabilities and context length of the models of the platform
+func addKnownCapabilities(platform PlatformType, models []*ModelInfo) {
+	for _, model := range models {
+		if known := describeKnownModel(platform, model.Name); known != nil {
+			model.Capabilities = known.Capabilities
+			model.ContextLength = known.ContextLength
+		}
+	}
+}
+
+// describeModel returns the description of the model by the first platform of the decorator chain knowing it
+func describeModel(ctx context.Context, platform Platform, model string, serverURL string) (*ModelInfo, error) {
+	for platform != nil {
+		if describer, ok := platform.(modelDescriber); ok {
+			return describer.describeModel(ctx, model, serverURL)
+		}
+
+		wrapper, ok := platform.(interface{ Unwrap() Platform })
+		if !ok {
+			break
+		}
+		platform = wrapper.Unwrap()
+	}
+	return nil, nil
+}
+
+// requiredCapabilities returns the capabilities a chat needs besides completion
+func requiredCapabilities(params *ChatParameters, capabilities ...Capability) []Capability {
+	if params.ResponseFormat != nil {
+		capabilities = append(capabilities, CapabilityJSON)
+	}
+	if len(params.Tools) > 0 {
+		capabilities = append(capabilities, CapabilityTools)
+	}
+	return capabilities
+}
+
+// checkModel verifies that the model chosen for the request has the capabilities and a context large enough for
+// the prompt. A request naming no model is checked with the default model of the platform, the account default
+// model is already set by the options of the request. The models unknown to their platform aren't checked, neither
+// are the models that can't be described, e.g. Ollama being down, the request fails or falls back on its own.
+// Tools are checked for every model, some platforms don't send them.
+func checkModel(ctx context.Context, platform Platform, params *ChatParameters, capabilities []Capability, promptTokens int) error {
+	if slices.Contains(capabilities, CapabilityTools) && !slices.Contains(toolPlatforms, platform.Type()) {
+		return &ModelCapabilityError{Model: cmp.Or(params.Model, string(platform.Type())), Capability: CapabilityTools}
+	}
+
+	name := params.Model
+	if name == "" {
+		name = platformDefaultModel(platform, slices.Contains(capabilities, CapabilityEmbedding))
+	}
+	if name == "" {
+		return nil
+	}
+
+	model, err := describeModel(ctx, platform, name, params.ServerURL)
+	if err != nil {
+		log.Debug().Err(err).Str("model", name).Msg("Failed to describe the model, its capabilities are not checked")
+		return nil
+	}
+	if model == nil || len(model.Capabilities) == 0 {
+		return nil
+	}
+
+	for _, capability := range capabilities {
+		if !model.Supports(capability) {
+			return &ModelCapabilityError{Model: name, Capability: capability}
+		}
+	}
+
+	if model.ContextLength > 0 && promptTokens > model.ContextLength {
+		return &ModelCapabilityError{Model: name, ContextLength: model.ContextLength, PromptTokens: promptTokens}
+	}
+
+	return nil
+}
+
+// platformDefaultModel returns the default model of the first platform of the decorator chain knowing its models,
+// empty when none does
+func platformDefaultModel(platform Platform, embedding bool) string {
+	for platform != nil {
+		if describer, ok := platform.(modelDescriber); ok {
+			return describer.defaultModel(embedding)
+		}
+
+		wrapper, ok := platform.(interface{ Unwrap() Platform })
+		if !ok {
+			break
+		}
+		platform = wrapper.Unwrap()
+	}
+	return ""
+}
+
+// messagesTokenCount estimates the tokens of the system prompt and the messages
+func messagesTokenCount(systemPrompt string, messages []*domain.ChatItem) int {
+	tokens := estimateTokenCount(systemPrompt)
+	for _, message := range messages {
+		tokens += estimateTokenCount(message.Content)
+	}
+	return tokens
+}
@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeKnownModel(t *testing.T) {
+	tests := []struct {
+		platform      PlatformType
+		model         string
+		vision        bool
+		contextLength int
+	}{
+		{platform: OpenAIPlatform, model: "gpt-4o-mini", vision: true, contextLength: 128000},
+		{platform: OpenAIPlatform, model: "gpt-4.1-nano-2025-04-14", vision: true, contextLength: 1047576},
+		{platform: OpenAIPlatform, model: "gpt-4-0613", vision: false, contextLength: 8192},
+		{platform: OpenAIPlatform, model: "o3-mini", vision: false, contextLength: 200000},
+		{platform: AnthropicPlatform, model: "claude-3-5-haiku-latest", vision: true, contextLength: 200000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			described := describeKnownModel(tt.platform, tt.model)
+			require.NotNil(t, described)
+			assert.Equal(t, tt.vision, described.Supports(CapabilityVision))
+			assert.True(t, described.Supports(CapabilityCompletion))
+			assert.Equal(t, tt.contextLength, described.ContextLength)
+		})
+	}
+
+	embedding := describeKnownModel(OpenAIPlatform, "text-embedding-3-small")
+	require.NotNil(t, embedding)
+	assert.Equal(t, []Capability{CapabilityEmbedding}, embedding.Capabilities)
+
+	assert.Nil(t, describeKnownModel(OpenAIPlatform, "davinci-002"))
+	assert.Nil(t, describeKnownModel(OllamaPlatform, "gpt-4o"))
+}
+
+func TestServiceChecksModelCapabilities(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.On("ShowModel", mock.Anything, "llama3.2:1b").Return(&api.ShowResponse{
+		ModelInfo:    map[string]any{"general.architecture": "llama", "llama.context_length": float64(100)},
+		Capabilities: []model.Capability{model.CapabilityCompletion, model.CapabilityTools},
+	}, nil)
+	mockClient.On("ShowModel", mock.Anything, "custom:latest").Return(nil, errors.New("connection refused"))
+	mockClient.On("ShowModel", mock.Anything, "gemma3:4b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion},
+	}, nil)
+	mockClient.On("ChatWithModel", mock.Anything, "custom:latest", mock.Anything, false, mock.Anything, mock.Anything, mock.Anything).
+		Return(&api.ChatResponse{Message: api.Message{Role: "assistant", Content: "Hi"}, Done: true}, nil)
+
+	config := &Config{
+		Platform: OllamaPlatform,
+		Ollama:   OllamaConfig{URL: "http://localhost:11434", Model: "gemma3:4b"},
+	}
+	s := MemoryCacheService(config)
+	s.(*service).platform.(*ollamaPlatform).client = mockClient
+	ctx := context.Background()
+
+	t.Run("rejects an image for a text-only model", func(t *testing.T) {
+		_, _, err := s.DescribeImage(ctx, strings.NewReader("image"), "worksheet.png", "Read this", "", WithModel("llama3.2:1b"))
+
+		var capabilityErr *ModelCapabilityError
+		require.ErrorAs(t, err, &capabilityErr)
+		assert.Equal(t, CapabilityVision, capabilityErr.Capability)
+		assert.ErrorIs(t, err, ErrModelCapability)
+	})
+
+	t.Run("rejects embeddings with a chat model", func(t *testing.T) {
+		_, _, err := s.Embed(ctx, []string{"fractions"}, WithModel("llama3.2:1b"))
+
+		var capabilityErr *ModelCapabilityError
+		require.ErrorAs(t, err, &capabilityErr)
+		assert.Equal(t, CapabilityEmbedding, capabilityErr.Capability)
+	})
+
+	t.Run("rejects a prompt longer than the context", func(t *testing.T) {
+		_, _, err := s.Chat(ctx, strings.Repeat("long ", 100), "", WithModel("llama3.2:1b"))
+
+		var capabilityErr *ModelCapabilityError
+		require.ErrorAs(t, err, &capabilityErr)
+		assert.Empty(t, capabilityErr.Capability)
+		assert.Equal(t, 100, capabilityErr.ContextLength)
+		assert.Equal(t, 125, capabilityErr.PromptTokens)
+	})
+
+	t.Run("sends the request when the model can't be described", func(t *testing.T) {
+		response, _, err := s.Chat(ctx, "Hello", "", WithModel("custom:latest"))
+
+		require.NoError(t, err)
+		assert.Equal(t, "Hi", response)
+	})
+
+	t.Run("checks the default model", func(t *testing.T) {
+		_, _, err := s.DescribeImage(ctx, strings.NewReader("image"), "worksheet.png", "Read this", "")
+
+		var capabilityErr *ModelCapabilityError
+		require.ErrorAs(t, err, &capabilityErr)
+		assert.Equal(t, "gemma3:4b", capabilityErr.Model)
+		assert.Equal(t, CapabilityVision, capabilityErr.Capability)
+	})
+
+	// The models are described once
+	mockClient.AssertNumberOfCalls(t, "ShowModel", 3)
+}
+
+func TestInfoReportsModelPrices(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.On("ListModels", mock.Anything).Return([]*ModelInfo{{Name: "gemma3:4b"}}, nil)
+	mockClient.On("ShowModel", mock.Anything, "gemma3:4b").Return(&api.ShowResponse{}, nil)
+
+	config := &Config{
+		Platform: OllamaPlatform,
+		Ollama:   OllamaConfig{URL: "http://localhost:11434", Model: "gemma3:4b", CostPerMillionToken: 0.02},
+	}
+	s := MemoryCacheService(config)
+	s.(*service).platform.(*ollamaPlatform).client = mockClient
+
+	info := s.Info(context.Background())
+
+	require.Len(t, info.Platforms[0].Models, 1)
+	assert.Equal(t, &ModelPrice{Prompt: 0.02, Completion: 0.02}, info.Platforms[0].Models[0].Price)
+}
+
+func TestAnthropicChatWithToolsFailsTheCapabilityCheck(t *testing.T) {
+	requests := 0
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		requests++
+		writeAnthropicMessage(w, "Mia is doing great", 10, 3)
+	})
+
+	config := &Config{Platform: AnthropicPlatform}
+	s := newService(config, []Platform{newTestAnthropicPlatform(server.URL)})
+	registry := NewToolRegistry()
+	require.NoError(t, registry.Register(Tool{Name: "create_practice_session"}, func(ctx context.Context, caller ToolCaller, arguments json.RawMessage) (string, error) {
+		return "", errors.New("not called")
+	}))
+	chat := &chatService{llmService: s, tools: registry}
+	history := []*domain.ChatItem{{Role: domain.ChatItemRoleUser, Content: "Create a 10-question session for Mia"}}
+
+	for _, model := range []string{"", "claude-sonnet-4-0"} {
+		t.Run("model "+model, func(t *testing.T) {
+			_, _, err := chat.chatWithTools(context.Background(), history, "", registry.Tools(), ToolCaller{AccountID: "acc"}, nil, WithModel(model))
+
+			// The account chat answers from the history only on this error
+			var capabilityErr *ModelCapabilityError
+			require.ErrorAs(t, err, &capabilityErr)
+			assert.Equal(t, CapabilityTools, capabilityErr.Capability)
+			assert.Zero(t, requests, "the model isn't asked without its tools")
+		})
+	}
+
+	response, _, err := chat.chatWithHistory(context.Background(), history, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Mia is doing great", response)
+
+	claude := describeKnownModel(AnthropicPlatform, "claude-sonnet-4-0")
+	require.NotNil(t, claude)
+	assert.False(t, claude.Supports(CapabilityTools))
+}
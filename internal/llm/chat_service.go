@@ -1,8 +1,10 @@
 package llm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/pocketbase/pocketbase/core"
@@ -24,7 +26,11 @@ type ChatService interface {
 	UpdateChatLabel(chatID, label string) error
 
 	// ChatCompletion sends a user message to the LLM and stores the result in the chat history
-	ChatCompletion(chatID, userMessage string, opts ...ChatOption) (string, *domain.Usage, error)
+	ChatCompletion(ctx context.Context, chatID, userMessage string, opts ...ChatOption) (string, *domain.Usage, error)
+
+	// ChatCompletionStream is the streaming variant of ChatCompletion. The response is delivered to the handler
+	// as it is generated and the complete assistant message is stored once the stream is done.
+	ChatCompletionStream(ctx context.Context, chatID, userMessage string, handler StreamHandler, opts ...ChatOption) (string, *domain.Usage, error)
 
 	// AddChatMessage adds a message to a chat
 	AddChatMessage(chatID, role, content string, usage *domain.Usage) (*domain.ChatItem, error)
@@ -33,17 +39,27 @@ type ChatService interface {
 	GetChatMessages(chatID string, limit, offset int) ([]*domain.ChatItem, error)
 }
 
+// FlaggedChatContent replaces the content of the chat answers the moderation flagged, until a parent reviews them
+const FlaggedChatContent = "This answer is waiting for a parent to review it."
+
+// maxToolRounds bounds the requests of a chat completion that offer tools to the model.
+// The request following the last round doesn't offer them, so that the model answers with what it has.
+const maxToolRounds = 5
+
 // chatService implements ChatService interface
 type chatService struct {
 	app        core.App
 	llmService Service
+	// tools are offered to the model in the chats of an account, nil when there are none
+	tools *ToolRegistry
 }
 
-// NewChatService creates a new ChatService instance
-func NewChatService(app core.App, llmService Service) ChatService {
+// NewChatService creates a new ChatService instance, the tools may be nil
+func NewChatService(app core.App, llmService Service, tools *ToolRegistry) ChatService {
 	return &chatService{
 		app:        app,
 		llmService: llmService,
+		tools:      tools,
 	}
 }
 
@@ -170,7 +186,18 @@ func (s *chatService) UpdateChatLabel(chatID, label string) error {
 }
 
 // ChatCompletion sends a user message to the LLM and stores the result in the chat history
-func (s *chatService) ChatCompletion(chatID, userMessage string, opts ...ChatOption) (string, *domain.Usage, error) {
+func (s *chatService) ChatCompletion(ctx context.Context, chatID, userMessage string, opts ...ChatOption) (string, *domain.Usage, error) {
+	return s.chatCompletion(ctx, chatID, userMessage, nil, opts...)
+}
+
+// ChatCompletionStream sends a user message to the LLM, streams the response to the handler
+// and stores the complete result in the chat history
+func (s *chatService) ChatCompletionStream(ctx context.Context, chatID, userMessage string, handler StreamHandler, opts ...ChatOption) (string, *domain.Usage, error) {
+	return s.chatCompletion(ctx, chatID, userMessage, handler, opts...)
+}
+
+// chatCompletion runs a chat completion, streaming the response when a handler is provided
+func (s *chatService) chatCompletion(ctx context.Context, chatID, userMessage string, handler StreamHandler, opts ...ChatOption) (string, *domain.Usage, error) {
 	if chatID == "" {
 		return "", nil, errors.New("chat ID is required")
 	}
@@ -191,55 +218,93 @@ func (s *chatService) ChatCompletion(chatID, userMessage string, opts ...ChatOpt
 		return "", nil, fmt.Errorf("failed to add user message to chat: %w", err)
 	}
 
-	// Use the model from the chat if not specified in options
-	model := chat.Model
-
-	// If model is still empty, use a default model
-	info := s.llmService.Info()
-	if model == "" && len(info.Platforms) > 0 && len(info.Platforms[0].Models) > 0 {
-		model = info.Platforms[0].Models[0].Name
+	// Get the LLM response, options from the caller take precedence over the chat model
+	chatOpts := []ChatOption{WithUser(chat.UserID), WithPurpose(PurposeChat)}
+	if chat.Model != "" {
+		chatOpts = append(chatOpts, WithModel(chat.Model))
+	}
+	if chat.LLMParameters != nil {
+		chatOpts = append(chatOpts, WithSampling(*chat.LLMParameters))
 	}
+	chatOpts = append(chatOpts, opts...)
 
-	// Get the LLM response
-	var chatOpts []ChatOption
-	if model != "" {
-		chatOpts = append(chatOpts, WithModel(model))
+	// If neither the chat nor the options pick a model or a server, use the first model of the default platform
+	params := &ChatParameters{}
+	for _, opt := range chatOpts {
+		opt(params)
+	}
+	if params.Model == "" && params.ServerURL == "" {
+		info := s.llmService.Info(ctx)
+		if len(info.Platforms) > 0 && len(info.Platforms[0].Models) > 0 {
+			chatOpts = append(chatOpts, WithDefaultModel(info.Platforms[0].Models[0].Name))
+		}
 	}
 
 	// If chat has items, use them for context
 	var llmResponse string
 	var usage *domain.Usage
 
+	// Track whether any chunk reached the handler, a partially streamed response can't be retried
+	streamed := false
+	var streamHandler StreamHandler
+	if handler != nil {
+		streamHandler = func(chunk string) error {
+			streamed = true
+			return handler(chunk)
+		}
+	}
+
 	// Get previous messages for context
 	previousMessages, err := s.GetChatMessages(chatID, 100, 0) // Limit to recent messages
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to get previous messages, proceeding with single message")
 
 		// Fallback to regular Chat without history
-		llmResponse, usage, err = s.llmService.Chat(userMessage, chat.SystemPrompt, chatOpts...)
+		llmResponse, usage, err = s.chat(ctx, userMessage, chat.SystemPrompt, streamHandler, chatOpts...)
 		if err != nil {
 			return "", nil, fmt.Errorf("failed to get LLM response: %w", err)
 		}
 	} else {
-		// Use ChatWithHistory for conversation context
-		llmResponse, usage, err = s.llmService.ChatWithHistory(previousMessages, chat.SystemPrompt, chatOpts...)
+		// Use ChatWithHistory for conversation context, with the tools when the chat is made for an account
+		if tools := s.tools.Tools(); len(tools) > 0 && params.AccountID != "" {
+			caller := ToolCaller{AccountID: params.AccountID, UserID: chat.UserID}
+			llmResponse, usage, err = s.chatWithTools(ctx, previousMessages, chat.SystemPrompt, tools, caller, streamHandler, chatOpts...)
+
+			// A model that can't call tools answers from the chat history only
+			var capabilityErr *ModelCapabilityError
+			if errors.As(err, &capabilityErr) && capabilityErr.Capability == CapabilityTools {
+				llmResponse, usage, err = s.chatWithHistory(ctx, previousMessages, chat.SystemPrompt, streamHandler, chatOpts...)
+			}
+		} else {
+			llmResponse, usage, err = s.chatWithHistory(ctx, previousMessages, chat.SystemPrompt, streamHandler, chatOpts...)
+		}
 		if err != nil {
+			if streamed || ctx.Err() != nil {
+				return "", nil, fmt.Errorf("failed to stream LLM response: %w", err)
+			}
+			if errors.Is(err, ErrContentBlocked) {
+				return "", nil, fmt.Errorf("failed to get LLM response: %w", err)
+			}
+
 			log.Warn().Err(err).Msg("ChatWithHistory failed, falling back to single message Chat")
 
 			// Fallback to regular Chat if conversational context fails
-			llmResponse, usage, err = s.llmService.Chat(userMessage, chat.SystemPrompt, chatOpts...)
+			llmResponse, usage, err = s.chat(ctx, userMessage, chat.SystemPrompt, streamHandler, chatOpts...)
 			if err != nil {
 				return "", nil, fmt.Errorf("failed to get LLM response: %w", err)
 			}
 		}
 	}
 
-	// Add assistant response to chat
+	// Add assistant response to chat, a flagged response is withheld for a parent review
 	_, err = s.AddChatMessage(chatID, "assistant", llmResponse, usage)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to add assistant message to chat")
 		// Don't return an error, the LLM response was generated successfully
 	}
+	if usage != nil && usage.Moderation != nil {
+		llmResponse = FlaggedChatContent
+	}
 
 	// Update chat record with the new token usage
 	chatRecord, err := s.app.FindRecordById(domain.CollectionChats, chatID)
@@ -261,6 +326,100 @@ func (s *chatService) ChatCompletion(chatID, userMessage string, opts ...ChatOpt
 	return llmResponse, usage, nil
 }
 
+// chat calls the streaming or non-streaming Chat of the LLM service depending on the handler
+func (s *chatService) chat(ctx context.Context, prompt, systemPrompt string, handler StreamHandler, opts ...ChatOption) (string, *domain.Usage, error) {
+	if handler != nil {
+		return s.llmService.ChatStream(ctx, prompt, systemPrompt, handler, opts...)
+	}
+	return s.llmService.Chat(ctx, prompt, systemPrompt, opts...)
+}
+
+// chatWithHistory calls the streaming or non-streaming ChatWithHistory of the LLM service depending on the handler
+func (s *chatService) chatWithHistory(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, opts ...ChatOption) (string, *domain.Usage, error) {
+	if handler != nil {
+		return s.llmService.ChatWithHistoryStream(ctx, messages, systemPrompt, handler, opts...)
+	}
+	return s.llmService.ChatWithHistory(ctx, messages, systemPrompt, opts...)
+}
+
+// chatWithTools offers the tools to the model, runs the tools it calls and sends their results back until it answers.
+// The tool calls and their results are only sent to the model, they are not stored in the chat history.
+// Failing tools are reported to the model. The returned response holds the content of every round,
+// as it was streamed, and the usage adds up the rounds.
+func (s *chatService) chatWithTools(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, tools []Tool, caller ToolCaller, handler StreamHandler, opts ...ChatOption) (string, *domain.Usage, error) {
+	history := append([]*domain.ChatItem{}, messages...)
+	toolOpts := append(append([]ChatOption{}, opts...), WithTools(tools...))
+
+	var content strings.Builder
+	var usage *domain.Usage
+	for round := 0; ; round++ {
+		roundOpts := toolOpts
+		if round == maxToolRounds {
+			roundOpts = opts
+		}
+
+		response, err := s.llmService.ChatWithTools(ctx, history, systemPrompt, handler, roundOpts...)
+		if err != nil {
+			return "", nil, err
+		}
+		content.WriteString(response.Response)
+		usage = addUsage(usage, response.Usage)
+
+		if len(response.ToolCalls) == 0 || round == maxToolRounds {
+			return content.String(), usage, nil
+		}
+
+		history = append(history, &domain.ChatItem{
+			Role:      domain.ChatItemRoleAssistant,
+			Content:   response.Response,
+			ToolCalls: response.ToolCalls,
+		})
+		for _, call := range response.ToolCalls {
+			log.Info().
+				Str("tool", call.Name).
+				Str("accountId", caller.AccountID).
+				RawJSON("arguments", call.Arguments).
+				Msg("Calling chat tool")
+
+			result, err := s.tools.Call(ctx, caller, call.Name, call.Arguments)
+			if err != nil {
+				log.Warn().Err(err).Str("tool", call.Name).Msg("Chat tool failed")
+				result = "Error: " + err.Error()
+			}
+			history = append(history, &domain.ChatItem{
+				Role:       domain.ChatItemRoleTool,
+				Content:    result,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
+
+// addUsage adds the tokens and cost of the usage to the total, the model is the one of the last usage.
+// The total is flagged when any usage is.
+func addUsage(total *domain.Usage, usage *domain.Usage) *domain.Usage {
+	if usage == nil {
+		return total
+	}
+	if total == nil {
+		sum := *usage
+		return &sum
+	}
+
+	total.LlmModelName = usage.LlmModelName
+	total.Platform = usage.Platform
+	total.CacheHit = total.CacheHit && usage.CacheHit
+	total.Cost += usage.Cost
+	total.PromptTokens += usage.PromptTokens
+	total.CachedPromptTokens += usage.CachedPromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	if total.Moderation == nil {
+		total.Moderation = usage.Moderation
+	}
+	return total
+}
+
 // AddChatMessage adds a message to a chat
 func (s *chatService) AddChatMessage(chatID, role, content string, usage *domain.Usage) (*domain.ChatItem, error) {
 	if chatID == "" {
@@ -300,6 +459,13 @@ func (s *chatService) AddChatMessage(chatID, role, content string, usage *domain
 	record.Set("content", content)
 	record.Set("order", order)
 
+	// The content of a flagged message is withheld for a parent review
+	if usage != nil && usage.Moderation != nil {
+		record.Set("content", FlaggedChatContent)
+		record.Set("flagged_content", content)
+		record.Set("moderation_reason", usage.Moderation.Reason)
+	}
+
 	// Add usage data if provided
 	if usage != nil {
 		record.Set("prompt_tokens", usage.PromptTokens)
@@ -401,17 +567,36 @@ func (s *chatService) recordToChat(record *core.Record) *domain.Chat {
 	updated := record.GetDateTime("updated")
 
 	return &domain.Chat{
-		ID:           record.Id,
-		UserID:       record.GetString("user"),
-		Label:        record.GetString("label"),
-		SystemPrompt: record.GetString("system_prompt"),
-		Model:        record.GetString("model"),
-		TotalTokens:  record.GetInt("total_tokens"),
-		TotalCost:    record.GetFloat("total_cost"),
-		Created:      created.Time(),
-		Updated:      updated.Time(),
-		Items:        nil, // Will be populated by GetChat if needed
+		ID:            record.Id,
+		UserID:        record.GetString("user"),
+		Label:         record.GetString("label"),
+		SystemPrompt:  record.GetString("system_prompt"),
+		Model:         record.GetString("model"),
+		TotalTokens:   record.GetInt("total_tokens"),
+		TotalCost:     record.GetFloat("total_cost"),
+		Created:       created.Time(),
+		Updated:       updated.Time(),
+		Items:         nil, // Will be populated by GetChat if needed
+		LLMParameters: RecordSamplingParameters(record),
+	}
+}
+
+// RecordSamplingParameters reads the llm_parameters field of a chat or practice topic record, nil when not set
+func RecordSamplingParameters(record *core.Record) *domain.SamplingParameters {
+	if record.GetString("llm_parameters") == "" {
+		return nil
+	}
+
+	var params domain.SamplingParameters
+	if err := record.UnmarshalJSONField("llm_parameters", &params); err != nil {
+		log.Warn().Err(err).Str("collection", record.Collection().Name).Str("id", record.Id).Msg("Invalid LLM parameters, using defaults")
+		return nil
+	}
+
+	if params.IsEmpty() {
+		return nil
 	}
+	return &params
 }
 
 // recordToChatItem converts a PocketBase record to a domain.ChatItem
@@ -432,13 +617,14 @@ func (s *chatService) recordToChatItem(record *core.Record) *domain.ChatItem {
 	}
 
 	return &domain.ChatItem{
-		ID:      record.Id,
-		ChatID:  record.GetString("chat"),
-		Role:    record.GetString("role"),
-		Content: record.GetString("content"),
-		Usage:   usage,
-		Order:   record.GetInt("order"),
-		Created: created.Time(),
-		Updated: updated.Time(),
+		ID:               record.Id,
+		ChatID:           record.GetString("chat"),
+		Role:             record.GetString("role"),
+		Content:          record.GetString("content"),
+		Usage:            usage,
+		Order:            record.GetInt("order"),
+		Created:          created.Time(),
+		Updated:          updated.Time(),
+		ModerationReason: record.GetString("moderation_reason"),
 	}
 }
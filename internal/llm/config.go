@@ -1,21 +1,61 @@
 package llm
 
+import "time"
+
 type (
 	// Config for LLM service
 	Config struct {
 		Platform PlatformType `json:"platform"`
-		OpenAI   OpenAIConfig `json:"openai"`
-		Ollama   OllamaConfig `json:"ollama"`
-		Cache    CacheConfig  `json:"cache"`
+		// Fallbacks are tried in order when the default Platform is unavailable, for instance Ollama being down
+		Fallbacks []PlatformType `json:"fallbacks"`
+		// ModelMappings pick the model of a fallback platform for a requested model,
+		// unmapped models fall back to the default model of the fallback platform
+		ModelMappings []ModelMapping `json:"modelMappings"`
+		// Platforms are registered next to the default Platform, a model of any of them is picked with "platform/model"
+		Platforms []PlatformType  `json:"platforms"`
+		OpenAI    OpenAIConfig    `json:"openai"`
+		Anthropic AnthropicConfig `json:"anthropic"`
+		Ollama    OllamaConfig    `json:"ollama"`
+		Cache     CacheConfig     `json:"cache"`
+		// Pricing holds the prices per model, models without a price use the CostPerMillionToken of their platform
+		Pricing PricingTable `json:"pricing"`
+		// Resilience retries transient platform failures and stops calling failing upstreams
+		Resilience ResilienceConfig `json:"resilience"`
+		// Moderation screens the responses of every platform before they reach the learners
+		Moderation ModerationConfig `json:"moderation"`
+		// Concurrency limits the concurrent requests of the platforms, such as a home Ollama server
+		Concurrency ConcurrencyConfig `json:"concurrency"`
+		// Redaction replaces the personal identifiers in the requests to cloud platforms with placeholders
+		Redaction RedactionConfig `json:"redaction"`
+	}
+
+	// ModelMapping maps a model of the default platform to the model used when falling back to another platform
+	ModelMapping struct {
+		Model         string       `json:"model"`
+		Platform      PlatformType `json:"platform"`
+		FallbackModel string       `json:"fallbackModel"`
 	}
 
 	// OpenAIConfig holds configuration for OpenAI services
 	OpenAIConfig struct {
 		APIKey              string   `json:"apiKey"`
-		CostPerMillionToken float64  `json:"costPerMillionToken"`
+		CostPerMillionToken float64  `json:"costPerMillionToken"` // Blended price of models missing from the pricing table
 		Model               string   `json:"model"`
 		BaseURL             string   `json:"baseUrl"`
 		AllowedModels       []string `json:"allowedModels"` // List of models that are allowed to be used
+		EmbeddingModel      string   `json:"embeddingModel"`
+		// disableClientRetries turns off the retries of the OpenAI client when the resilient platform retries instead
+		disableClientRetries bool
+	}
+
+	// AnthropicConfig holds configuration for the Anthropic Messages API
+	AnthropicConfig struct {
+		APIKey              string   `json:"apiKey"`
+		CostPerMillionToken float64  `json:"costPerMillionToken"` // Blended price of models missing from the pricing table
+		Model               string   `json:"model"`
+		BaseURL             string   `json:"baseUrl"`
+		AllowedModels       []string `json:"allowedModels"` // List of models that are allowed to be used
+		MaxTokens           int      `json:"maxTokens"`     // Upper bound of generated tokens, required by the Messages API
 	}
 
 	// OllamaConfig holds configuration for Ollama services
@@ -23,11 +63,41 @@ type (
 		URL         string `json:"url"`
 		FallbackURL string `json:"fallbackUrl"` // Fallback URL to use if the primary URL is unavailable
 		Model       string `json:"model"`
+		// EmbeddingModel is the model used for embeddings, chat models usually can't embed
+		EmbeddingModel string `json:"embeddingModel"`
+		// Timeout is the upper bound for a single request, requests are also cancelled with their context
+		Timeout time.Duration `json:"timeout"`
+		// CostPerMillionToken is a notional cost such as electricity, zero by default
+		CostPerMillionToken float64 `json:"costPerMillionToken"`
 	}
 
 	// CacheConfig holds caching configuration for LLM responses
 	CacheConfig struct {
 		Enabled bool   `json:"enabled"`
 		Backend string `json:"backend"` //
+		// MaxEntries and MaxBytes bound the memory cache, the least recently used entries are evicted first
+		MaxEntries int   `json:"maxEntries"`
+		MaxBytes   int64 `json:"maxBytes"`
+		// CleanupInterval is how often expired entries are purged from the memory cache
+		CleanupInterval time.Duration `json:"cleanupInterval"`
+		// CleanupCron is the schedule of the job purging expired responses from the PocketBase cache
+		CleanupCron string `json:"cleanupCron"`
+		// TTLs per request type, zero values use the defaults
+		ChatTTL        time.Duration `json:"chatTtl"`
+		ChatHistoryTTL time.Duration `json:"chatHistoryTtl"`
+		ImageTTL       time.Duration `json:"imageTtl"`
+		EmbedTTL       time.Duration `json:"embedTtl"`
+	}
+
+	// ResilienceConfig holds the retry and circuit breaker configuration of the platforms
+	ResilienceConfig struct {
+		Enabled        bool          `json:"enabled"`
+		MaxRetries     int           `json:"maxRetries"`     // Retries after the first attempt, 0 only guards with the circuit breaker
+		InitialBackoff time.Duration `json:"initialBackoff"` // Delay before the first retry, doubled for every further retry
+		MaxBackoff     time.Duration `json:"maxBackoff"`
+		// FailureThreshold is the number of consecutive failures that opens the circuit breaker of an upstream
+		FailureThreshold int `json:"failureThreshold"`
+		// OpenDuration is how long an open circuit breaker rejects requests before letting a trial request through
+		OpenDuration time.Duration `json:"openDuration"`
 	}
 )
@@ -2,7 +2,9 @@ package llm
 
 import (
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -27,28 +29,98 @@ func LoadConfig() *Config {
 			Model:               "gpt-4.1-nano",
 			CostPerMillionToken: 0.15, // Approximate cost for GPT-4o mini
 			BaseURL:             "https://api.openai.com/v1",
+			EmbeddingModel:      defaultOpenAIEmbeddingModel,
+		},
+		Anthropic: AnthropicConfig{
+			Model:               defaultAnthropicModel,
+			CostPerMillionToken: 1.6, // Approximate blended cost for Claude 3.5 Haiku
+			BaseURL:             anthropicBaseURL,
+			MaxTokens:           defaultAnthropicMaxTokens,
 		},
 		Ollama: OllamaConfig{
-			Model: "gemma3:1b",
-			URL:   "http://localhost:11434",
+			Model:          "gemma3:1b",
+			URL:            "http://localhost:11434",
+			Timeout:        defaultOllamaTimeout,
+			EmbeddingModel: defaultOllamaEmbeddingModel,
 		},
 		Cache: CacheConfig{
+			Enabled:         true,
+			Backend:         string(MemoryCache),
+			MaxEntries:      defaultCacheMaxEntries,
+			MaxBytes:        defaultCacheMaxBytes,
+			CleanupInterval: defaultCacheCleanupInterval,
+			CleanupCron:     defaultCacheCleanupCron,
+			ChatTTL:         defaultChatCacheTTL,
+			ChatHistoryTTL:  defaultChatHistoryCacheTTL,
+			ImageTTL:        defaultImageCacheTTL,
+			EmbedTTL:        defaultEmbedCacheTTL,
+		},
+		Resilience: ResilienceConfig{
+			Enabled:          true,
+			MaxRetries:       defaultRetryMaxRetries,
+			InitialBackoff:   defaultRetryInitialBackoff,
+			MaxBackoff:       defaultRetryMaxBackoff,
+			FailureThreshold: defaultBreakerFailureThreshold,
+			OpenDuration:     defaultBreakerOpenDuration,
+		},
+		Moderation: ModerationConfig{
 			Enabled: true,
-			Backend: string(MemoryCache),
+			Action:  ModerationFlag,
+			Words:   defaultModerationWords(),
+		},
+		Redaction: RedactionConfig{
+			Enabled: true,
+		},
+		Concurrency: ConcurrencyConfig{
+			Limits: map[PlatformType]int{OllamaPlatform: defaultOllamaConcurrency},
 		},
 	}
 
 	// Override with environment variables if provided
+	// LLM_PLATFORM is either a single platform or an ordered chain such as "ollama,openai",
+	// the platforms after the first one are used when the ones before are unavailable
 	if platform := os.Getenv("LLM_PLATFORM"); platform != "" {
-		switch strings.ToLower(platform) {
-		case "openai":
-			config.Platform = OpenAIPlatform
-		case "ollama":
-			config.Platform = OllamaPlatform
-		case "echo":
-			config.Platform = EchoPlatform
-		default:
-			log.Warn().Str("platform", platform).Msg("Unknown LLM platform, defaulting to Ollama")
+		chain := strings.Split(platform, ",")
+		if platformType, ok := parsePlatformType(chain[0]); ok {
+			config.Platform = platformType
+		} else {
+			log.Warn().Str("platform", chain[0]).Msg("Unknown LLM platform, defaulting to Ollama")
+		}
+
+		for _, fallback := range chain[1:] {
+			if platformType, ok := parsePlatformType(fallback); ok && platformType != config.Platform {
+				config.Fallbacks = append(config.Fallbacks, platformType)
+			} else {
+				log.Warn().Str("platform", fallback).Msg("Unknown or duplicate fallback LLM platform, ignoring it")
+			}
+		}
+	}
+
+	// Model mappings look like "gemma3:1b=openai/gpt-4.1-nano,gemma3:12b=openai/gpt-4.1-mini"
+	if mappings := os.Getenv("LLM_FALLBACK_MODELS"); mappings != "" {
+		for _, mapping := range strings.Split(mappings, ",") {
+			model, target, _ := strings.Cut(strings.TrimSpace(mapping), "=")
+			platform, fallbackModel, _ := strings.Cut(target, "/")
+			platformType, ok := parsePlatformType(platform)
+			if model == "" || fallbackModel == "" || !ok {
+				log.Warn().Str("mapping", mapping).Msg("Invalid fallback model mapping, ignoring it")
+				continue
+			}
+			config.ModelMappings = append(config.ModelMappings, ModelMapping{
+				Model:         model,
+				Platform:      platformType,
+				FallbackModel: fallbackModel,
+			})
+		}
+	}
+
+	if platforms := os.Getenv("LLM_PLATFORMS"); platforms != "" {
+		for _, platform := range strings.Split(platforms, ",") {
+			if platformType, ok := parsePlatformType(platform); ok {
+				config.Platforms = append(config.Platforms, platformType)
+			} else {
+				log.Warn().Str("platform", platform).Msg("Unknown LLM platform, ignoring it")
+			}
 		}
 	}
 
@@ -61,6 +133,10 @@ func LoadConfig() *Config {
 		config.OpenAI.Model = model
 	}
 
+	if model := os.Getenv("OPENAI_EMBEDDING_MODEL"); model != "" {
+		config.OpenAI.EmbeddingModel = model
+	}
+
 	if allowedModels := os.Getenv("OPENAI_ALLOWED_MODELS"); allowedModels != "" {
 		config.OpenAI.AllowedModels = strings.Split(allowedModels, ",")
 	}
@@ -69,6 +145,23 @@ func LoadConfig() *Config {
 		config.OpenAI.BaseURL = baseURL
 	}
 
+	// Anthropic configuration
+	if apiKey := os.Getenv("ANTHROPIC_API_KEY"); apiKey != "" {
+		config.Anthropic.APIKey = apiKey
+	}
+
+	if model := os.Getenv("ANTHROPIC_MODEL"); model != "" {
+		config.Anthropic.Model = model
+	}
+
+	if allowedModels := os.Getenv("ANTHROPIC_ALLOWED_MODELS"); allowedModels != "" {
+		config.Anthropic.AllowedModels = strings.Split(allowedModels, ",")
+	}
+
+	if baseURL := os.Getenv("ANTHROPIC_BASE_URL"); baseURL != "" {
+		config.Anthropic.BaseURL = baseURL
+	}
+
 	// Ollama configuration
 	if url := os.Getenv("OLLAMA_URL"); url != "" {
 		config.Ollama.URL = url
@@ -82,6 +175,43 @@ func LoadConfig() *Config {
 		config.Ollama.Model = model
 	}
 
+	if model := os.Getenv("OLLAMA_EMBEDDING_MODEL"); model != "" {
+		config.Ollama.EmbeddingModel = model
+	}
+
+	if timeout := os.Getenv("OLLAMA_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil && d > 0 {
+			config.Ollama.Timeout = d
+		} else {
+			log.Warn().Str("timeout", timeout).Msg("Invalid Ollama timeout, using default")
+		}
+	}
+
+	if cost := os.Getenv("OLLAMA_COST_PER_MILLION_TOKEN"); cost != "" {
+		if c, err := strconv.ParseFloat(cost, 64); err == nil && c >= 0 {
+			config.Ollama.CostPerMillionToken = c
+		} else {
+			log.Warn().Str("cost", cost).Msg("Invalid Ollama cost per million token, using default")
+		}
+	}
+
+	// Pricing table, prices of the environment take precedence over the ones of the file
+	if pricingFile := os.Getenv("LLM_PRICING_FILE"); pricingFile != "" {
+		if table, err := LoadPricingTable(pricingFile); err == nil {
+			config.Pricing = config.Pricing.Merge(table)
+		} else {
+			log.Warn().Err(err).Str("file", pricingFile).Msg("Failed to load LLM pricing file, ignoring it")
+		}
+	}
+
+	if pricing := os.Getenv("LLM_PRICING"); pricing != "" {
+		if table, err := ParsePricing(pricing); err == nil {
+			config.Pricing = config.Pricing.Merge(table)
+		} else {
+			log.Warn().Err(err).Msg("Invalid LLM pricing, ignoring it")
+		}
+	}
+
 	// Cache configuration
 	if cacheEnabled := os.Getenv("LLM_CACHE_ENABLED"); cacheEnabled != "" {
 		config.Cache.Enabled = cacheEnabled != "false" && cacheEnabled != "0"
@@ -99,14 +229,173 @@ func LoadConfig() *Config {
 		}
 	}
 
+	if maxEntries := os.Getenv("LLM_CACHE_MAX_ENTRIES"); maxEntries != "" {
+		if n, err := strconv.Atoi(maxEntries); err == nil && n > 0 {
+			config.Cache.MaxEntries = n
+		} else {
+			log.Warn().Str("maxEntries", maxEntries).Msg("Invalid LLM cache max entries, using default")
+		}
+	}
+
+	if maxBytes := os.Getenv("LLM_CACHE_MAX_BYTES"); maxBytes != "" {
+		if n, err := strconv.ParseInt(maxBytes, 10, 64); err == nil && n > 0 {
+			config.Cache.MaxBytes = n
+		} else {
+			log.Warn().Str("maxBytes", maxBytes).Msg("Invalid LLM cache max bytes, using default")
+		}
+	}
+
+	loadDuration("LLM_CACHE_CLEANUP_INTERVAL", &config.Cache.CleanupInterval)
+	if cleanupCron := os.Getenv("LLM_CACHE_CLEANUP_CRON"); cleanupCron != "" {
+		config.Cache.CleanupCron = cleanupCron
+	}
+	loadDuration("LLM_CACHE_CHAT_TTL", &config.Cache.ChatTTL)
+	loadDuration("LLM_CACHE_HISTORY_TTL", &config.Cache.ChatHistoryTTL)
+	loadDuration("LLM_CACHE_IMAGE_TTL", &config.Cache.ImageTTL)
+	loadDuration("LLM_CACHE_EMBED_TTL", &config.Cache.EmbedTTL)
+
+	// Retry and circuit breaker configuration
+	if retryEnabled := os.Getenv("LLM_RETRY_ENABLED"); retryEnabled != "" {
+		config.Resilience.Enabled = retryEnabled != "false" && retryEnabled != "0"
+	}
+
+	if maxRetries := os.Getenv("LLM_MAX_RETRIES"); maxRetries != "" {
+		if n, err := strconv.Atoi(maxRetries); err == nil && n >= 0 {
+			config.Resilience.MaxRetries = n
+		} else {
+			log.Warn().Str("maxRetries", maxRetries).Msg("Invalid LLM max retries, using default")
+		}
+	}
+
+	loadDuration("LLM_RETRY_BACKOFF", &config.Resilience.InitialBackoff)
+	loadDuration("LLM_RETRY_MAX_BACKOFF", &config.Resilience.MaxBackoff)
+
+	if threshold := os.Getenv("LLM_BREAKER_THRESHOLD"); threshold != "" {
+		if n, err := strconv.Atoi(threshold); err == nil && n > 0 {
+			config.Resilience.FailureThreshold = n
+		} else {
+			log.Warn().Str("threshold", threshold).Msg("Invalid circuit breaker threshold, using default")
+		}
+	}
+
+	loadDuration("LLM_BREAKER_OPEN_DURATION", &config.Resilience.OpenDuration)
+
+	// Moderation configuration
+	if moderationEnabled := os.Getenv("LLM_MODERATION_ENABLED"); moderationEnabled != "" {
+		config.Moderation.Enabled = moderationEnabled != "false" && moderationEnabled != "0"
+	}
+
+	if action := os.Getenv("LLM_MODERATION_ACTION"); action != "" {
+		switch ModerationAction(strings.ToLower(action)) {
+		case ModerationFlag, ModerationBlock:
+			config.Moderation.Action = ModerationAction(strings.ToLower(action))
+		default:
+			log.Warn().Str("action", action).Msg("Unknown moderation action, flagging unsafe responses")
+		}
+	}
+
+	// The words file replaces the default word list, LLM_MODERATION_WORDS adds to either
+	if wordsFile := os.Getenv("LLM_MODERATION_WORDS_FILE"); wordsFile != "" {
+		if words, err := loadModerationList(wordsFile); err == nil {
+			config.Moderation.Words = words
+		} else {
+			log.Warn().Err(err).Str("file", wordsFile).Msg("Failed to load moderation words, using the default words")
+		}
+	}
+
+	if words := os.Getenv("LLM_MODERATION_WORDS"); words != "" {
+		config.Moderation.Words = append(config.Moderation.Words, strings.Split(words, ",")...)
+	}
+
+	// Patterns are regular expressions, one per line
+	if patternsFile := os.Getenv("LLM_MODERATION_PATTERNS_FILE"); patternsFile != "" {
+		if patterns, err := loadModerationList(patternsFile); err == nil {
+			config.Moderation.Patterns = patterns
+		} else {
+			log.Warn().Err(err).Str("file", patternsFile).Msg("Failed to load moderation patterns, ignoring them")
+		}
+	}
+
+	if judge := os.Getenv("LLM_MODERATION_JUDGE"); judge != "" {
+		config.Moderation.Judge = judge != "false" && judge != "0"
+	}
+
+	if judgeModel := os.Getenv("LLM_MODERATION_JUDGE_MODEL"); judgeModel != "" {
+		config.Moderation.JudgeModel = judgeModel
+	}
+
+	// LLM_CONCURRENCY limits the concurrent requests per platform, e.g. "ollama=1,openai=8", 0 is unlimited
+	if concurrency := os.Getenv("LLM_CONCURRENCY"); concurrency != "" {
+		for _, entry := range strings.Split(concurrency, ",") {
+			name, value, _ := strings.Cut(entry, "=")
+			platformType, ok := parsePlatformType(name)
+			limit, err := strconv.Atoi(strings.TrimSpace(value))
+			if !ok || err != nil || limit < 0 {
+				log.Warn().Str("concurrency", entry).Msg("Invalid LLM concurrency limit, ignoring it")
+				continue
+			}
+			config.Concurrency.Limits[platformType] = limit
+		}
+	}
+
+	// Redaction configuration, LLM_REDACTION_IDENTIFIERS are redacted for every account
+	if redactionEnabled := os.Getenv("LLM_REDACTION_ENABLED"); redactionEnabled != "" {
+		config.Redaction.Enabled = redactionEnabled != "false" && redactionEnabled != "0"
+	}
+
+	if identifiers := os.Getenv("LLM_REDACTION_IDENTIFIERS"); identifiers != "" {
+		config.Redaction.Identifiers = strings.Split(identifiers, ",")
+	}
+
 	log.Info().
 		Str("platform", string(config.Platform)).
+		Interface("fallbacks", config.Fallbacks).
+		Interface("platforms", config.Platforms).
 		Str("ollamaURL", config.Ollama.URL).
 		Str("ollamaModel", config.Ollama.Model).
 		Str("openaiModel", config.OpenAI.Model).
+		Str("anthropicModel", config.Anthropic.Model).
 		Bool("cacheEnabled", config.Cache.Enabled).
 		Str("cacheBackend", config.Cache.Backend).
+		Bool("retryEnabled", config.Resilience.Enabled).
+		Int("maxRetries", config.Resilience.MaxRetries).
+		Bool("moderationEnabled", config.Moderation.Enabled).
+		Str("moderationAction", string(config.Moderation.Action)).
+		Bool("moderationJudge", config.Moderation.Judge).
+		Interface("concurrency", config.Concurrency.Limits).
+		Bool("redactionEnabled", config.Redaction.Enabled).
+		Int("redactedIdentifiers", len(config.Redaction.Identifiers)).
 		Msg("LLM configuration loaded")
 
 	return config
 }
+
+// loadDuration overrides the duration with the environment variable if it holds a valid positive duration
+func loadDuration(name string, duration *time.Duration) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		*duration = d
+	} else {
+		log.Warn().Str(name, value).Msg("Invalid duration, using default")
+	}
+}
+
+// parsePlatformType returns the platform type of the name, false if the platform is unknown
+func parsePlatformType(name string) (PlatformType, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "openai":
+		return OpenAIPlatform, true
+	case "ollama":
+		return OllamaPlatform, true
+	case "echo":
+		return EchoPlatform, true
+	case "anthropic":
+		return AnthropicPlatform, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,182 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/rs/zerolog/log"
+)
+
+// fallbackPlatform sends requests to the first platform of the chain and moves on to the next one
+// when a platform is unavailable. The response usage records the platform that answered.
+type fallbackPlatform struct {
+	chain         []Platform
+	modelMappings []ModelMapping
+}
+
+// newFallbackPlatform creates a platform trying the chain in order, the first platform is the primary one
+func newFallbackPlatform(chain []Platform, modelMappings []ModelMapping) Platform {
+	return &fallbackPlatform{
+		chain:         chain,
+		modelMappings: modelMappings,
+	}
+}
+
+// Type returns the type of the primary platform
+func (f *fallbackPlatform) Type() PlatformType {
+	return f.chain[0].Type()
+}
+
+// Unwrap returns the primary platform
+func (f *fallbackPlatform) Unwrap() Platform {
+	return f.chain[0]
+}
+
+// Models lists the models of the primary platform, the fallbacks are registered as platforms of their own
+func (f *fallbackPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	return f.chain[0].Models(ctx)
+}
+
+// Chat implements the Platform interface
+func (f *fallbackPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return f.chat(ctx, params, nil, func(platform Platform, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+		return platform.Chat(ctx, params)
+	})
+}
+
+// ChatStream implements the Platform interface
+func (f *fallbackPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return f.chat(ctx, params, handler, func(platform Platform, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+		return platform.ChatStream(ctx, params, handler)
+	})
+}
+
+// ChatWithHistory implements the Platform interface
+func (f *fallbackPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	return f.chat(ctx, params, nil, func(platform Platform, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+		return platform.ChatWithHistory(ctx, messages, params)
+	})
+}
+
+// ChatWithHistoryStream implements the Platform interface
+func (f *fallbackPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return f.chat(ctx, params, handler, func(platform Platform, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+		return platform.ChatWithHistoryStream(ctx, messages, params, handler)
+	})
+}
+
+// DescribeImage implements the Platform interface.
+// The image can only be sent to a fallback platform if it can be read again from the start.
+func (f *fallbackPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
+	seeker, canSeek := params.Reader.(io.Seeker)
+
+	var err error
+	for i, platform := range f.chain {
+		if i > 0 {
+			if !canSeek {
+				break
+			}
+			if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr != nil {
+				break
+			}
+		}
+
+		attempt := *params
+		attempt.ChatParameters = f.parameters(i, platform, &params.ChatParameters)
+
+		var response *DescribeImageResponse
+		response, err = platform.DescribeImage(ctx, &attempt)
+		if err == nil {
+			response.Usage.Platform = string(platform.Type())
+			return response, nil
+		}
+
+		if !f.shouldFallback(ctx, err, i) {
+			return nil, err
+		}
+		f.logFallback(err, platform, i)
+	}
+
+	return nil, err
+}
+
+// Embed implements the Platform interface with the primary platform only.
+// Embeddings of different models can't be compared, so falling back would mix incompatible vectors.
+func (f *fallbackPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	response, err := f.chain[0].Embed(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Usage.Platform = string(f.chain[0].Type())
+	return response, nil
+}
+
+// chat sends the request down the chain until a platform answers. A stream is not moved to
+// another platform once something was streamed, the caller already has a partial response.
+func (f *fallbackPlatform) chat(ctx context.Context, params *ChatParameters, handler StreamHandler, send func(Platform, *ChatParameters, StreamHandler) (*ChatResponse, error)) (*ChatResponse, error) {
+	streamed := false
+	var trackedHandler StreamHandler
+	if handler != nil {
+		trackedHandler = func(chunk string) error {
+			streamed = true
+			return handler(chunk)
+		}
+	}
+
+	var err error
+	for i, platform := range f.chain {
+		attempt := f.parameters(i, platform, params)
+
+		var response *ChatResponse
+		response, err = send(platform, &attempt, trackedHandler)
+		if err == nil {
+			response.Usage.Platform = string(platform.Type())
+			return response, nil
+		}
+
+		if streamed || !f.shouldFallback(ctx, err, i) {
+			return nil, err
+		}
+		f.logFallback(err, platform, i)
+	}
+
+	return nil, err
+}
+
+// parameters returns the parameters for the i-th platform of the chain, mapping the model for fallback platforms.
+// A model without mapping can't be expected to exist on another platform, so its default model is used.
+func (f *fallbackPlatform) parameters(i int, platform Platform, params *ChatParameters) ChatParameters {
+	attempt := *params
+	if i == 0 || params.Model == "" {
+		return attempt
+	}
+
+	attempt.Model = ""
+	for _, mapping := range f.modelMappings {
+		if mapping.Model == params.Model && mapping.Platform == platform.Type() {
+			attempt.Model = mapping.FallbackModel
+			break
+		}
+	}
+	return attempt
+}
+
+// shouldFallback returns true if the error means the platform is unavailable, or forbidden for the request,
+// and another platform is left to try
+func (f *fallbackPlatform) shouldFallback(ctx context.Context, err error, i int) bool {
+	if i == len(f.chain)-1 || ctx.Err() != nil {
+		return false
+	}
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrCloudPlatformForbidden) || isRetryableError(err)
+}
+
+func (f *fallbackPlatform) logFallback(err error, platform Platform, i int) {
+	log.Warn().
+		Err(err).
+		Str("platform", string(platform.Type())).
+		Str("fallback", string(f.chain[i+1].Type())).
+		Msg("LLM platform unavailable, falling back to the next platform")
+}
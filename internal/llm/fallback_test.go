@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackPlatform(t *testing.T) {
+	mappings := []ModelMapping{
+		{Model: "gemma3:1b", Platform: OpenAIPlatform, FallbackModel: "gpt-4.1-nano"},
+	}
+
+	tests := []struct {
+		name             string
+		primaryErrors    []error
+		model            string
+		expectedPlatform string
+		expectedModels   []string
+		expectError      bool
+	}{
+		{
+			name:             "primary answers",
+			model:            "gemma3:1b",
+			expectedPlatform: string(OllamaPlatform),
+		},
+		{
+			name:             "primary down",
+			primaryErrors:    []error{syscall.ECONNREFUSED},
+			model:            "gemma3:1b",
+			expectedPlatform: string(OpenAIPlatform),
+			expectedModels:   []string{"gpt-4.1-nano"},
+		},
+		{
+			name:             "unmapped model uses the fallback default",
+			primaryErrors:    []error{api.StatusError{StatusCode: http.StatusServiceUnavailable}},
+			model:            "llama3.2",
+			expectedPlatform: string(OpenAIPlatform),
+			expectedModels:   []string{""},
+		},
+		{
+			name:          "request errors don't fall back",
+			primaryErrors: []error{api.StatusError{StatusCode: http.StatusNotFound}},
+			model:         "gemma3:1b",
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &flakyPlatform{platformType: OllamaPlatform, errors: tt.primaryErrors}
+			fallback := &flakyPlatform{platformType: OpenAIPlatform}
+			platform := newFallbackPlatform([]Platform{primary, fallback}, mappings)
+
+			resp, err := platform.Chat(context.Background(), &ChatParameters{Prompt: "Hello", Model: tt.model})
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Equal(t, 0, fallback.calls)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedPlatform, resp.Usage.Platform)
+			assert.Equal(t, tt.expectedModels, fallback.models)
+		})
+	}
+}
+
+func TestFallbackPlatformDoesNotSwitchAfterStreaming(t *testing.T) {
+	primary := &flakyPlatform{platformType: OllamaPlatform, errors: []error{syscall.ECONNRESET}, chunks: []string{"Hel"}}
+	fallback := &flakyPlatform{platformType: OpenAIPlatform}
+	platform := newFallbackPlatform([]Platform{primary, fallback}, nil)
+
+	_, err := platform.ChatStream(context.Background(), &ChatParameters{Prompt: "Hello"}, func(chunk string) error {
+		return nil
+	})
+
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, 0, fallback.calls)
+}
+
+func TestLLMServiceRecordsFallbackPlatform(t *testing.T) {
+	primary := &flakyPlatform{platformType: OllamaPlatform, errors: []error{syscall.ECONNREFUSED}}
+	fallback := &flakyPlatform{platformType: OpenAIPlatform}
+	s := newService(&Config{}, []Platform{newFallbackPlatform([]Platform{primary, fallback}, nil), fallback})
+
+	_, usage, err := s.Chat(context.Background(), "Hello", "")
+
+	require.NoError(t, err)
+	assert.Equal(t, string(OpenAIPlatform), usage.Platform)
+	// The model is qualified with the platform that answered, so that the chat keeps using it
+	assert.Equal(t, "openai/echo", usage.LlmModelName)
+}
+
+func TestNewPlatformsSharesFallbackPlatforms(t *testing.T) {
+	cfg := &Config{
+		Platform:  EchoPlatform,
+		Fallbacks: []PlatformType{OpenAIPlatform},
+		Platforms: []PlatformType{OpenAIPlatform},
+	}
+
+	platforms := NewPlatforms(cfg, nil)
+
+	require.Len(t, platforms, 2)
+	chain := platforms[0].(*fallbackPlatform).chain
+	require.Len(t, chain, 2)
+	assert.Equal(t, EchoPlatform, chain[0].Type())
+	assert.Same(t, platforms[1], chain[1])
+}
@@ -0,0 +1,198 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// Purpose tells what an LLM request is for, e.g. to break down the usage ledger
+	Purpose string
+
+	// UsageEntry is a row of the usage ledger, one per LLM request
+	UsageEntry struct {
+		AccountID        string
+		UserID           string
+		Purpose          Purpose
+		Platform         string
+		Model            string
+		PromptTokens     int
+		CompletionTokens int
+		TotalTokens      int
+		// Cost is zero for cache hits, nothing was spent on them
+		Cost     float64
+		Latency  time.Duration
+		CacheHit bool
+		// Error is the error of a failed request
+		Error string
+	}
+
+	// usageLedger stores the usage entries
+	usageLedger interface {
+		Record(entry *UsageEntry) error
+	}
+
+	// ledgerService records every request made through the delegate in the usage ledger
+	ledgerService struct {
+		delegate Service
+		ledger   usageLedger
+	}
+
+	pocketBaseUsageLedger struct {
+		app core.App
+	}
+)
+
+const (
+	PurposeChat              Purpose = "chat"
+	PurposeSessionGeneration Purpose = "session-generation"
+	PurposeGrading           Purpose = "grading"
+	// PurposeHint gives a learner a hint on a practice item
+	PurposeHint             Purpose = "hint"
+	PurposeImageDescription Purpose = "image-description"
+	// PurposeWorksheetExtraction turns the photo of a worksheet into practice items
+	PurposeWorksheetExtraction Purpose = "worksheet-extraction"
+	PurposeEmbedding           Purpose = "embedding"
+	PurposeOther               Purpose = "other"
+)
+
+// newLedgerService wraps the service, recording its requests in the ledger
+func newLedgerService(delegate Service, ledger usageLedger) Service {
+	return &ledgerService{
+		delegate: delegate,
+		ledger:   ledger,
+	}
+}
+
+// Unwrap returns the decorated service
+func (s *ledgerService) Unwrap() Service {
+	return s.delegate
+}
+
+func (s *ledgerService) Chat(ctx context.Context, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.record(PurposeOther, options, func() (string, *domain.Usage, error) {
+		return s.delegate.Chat(ctx, prompt, systemPrompt, options...)
+	})
+}
+
+func (s *ledgerService) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.record(PurposeOther, options, func() (string, *domain.Usage, error) {
+		return s.delegate.ChatWithHistory(ctx, messages, systemPrompt, options...)
+	})
+}
+
+func (s *ledgerService) ChatStream(ctx context.Context, prompt string, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.record(PurposeOther, options, func() (string, *domain.Usage, error) {
+		return s.delegate.ChatStream(ctx, prompt, systemPrompt, handler, options...)
+	})
+}
+
+func (s *ledgerService) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.record(PurposeOther, options, func() (string, *domain.Usage, error) {
+		return s.delegate.ChatWithHistoryStream(ctx, messages, systemPrompt, handler, options...)
+	})
+}
+
+func (s *ledgerService) ChatWithTools(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (*ChatResponse, error) {
+	var response *ChatResponse
+	_, _, err := s.record(PurposeOther, options, func() (string, *domain.Usage, error) {
+		var err error
+		response, err = s.delegate.ChatWithTools(ctx, messages, systemPrompt, handler, options...)
+		if err != nil {
+			return "", nil, err
+		}
+		return response.Response, response.Usage, nil
+	})
+	return response, err
+}
+
+func (s *ledgerService) DescribeImage(ctx context.Context, reader io.Reader, fileName string, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.record(PurposeImageDescription, options, func() (string, *domain.Usage, error) {
+		return s.delegate.DescribeImage(ctx, reader, fileName, prompt, systemPrompt, options...)
+	})
+}
+
+func (s *ledgerService) Embed(ctx context.Context, texts []string, options ...ChatOption) ([][]float32, *domain.Usage, error) {
+	var embeddings [][]float32
+	_, usage, err := s.record(PurposeEmbedding, options, func() (string, *domain.Usage, error) {
+		var usage *domain.Usage
+		var err error
+		embeddings, usage, err = s.delegate.Embed(ctx, texts, options...)
+		return "", usage, err
+	})
+	return embeddings, usage, err
+}
+
+func (s *ledgerService) Info(ctx context.Context, options ...InfoOption) Info {
+	return s.delegate.Info(ctx, options...)
+}
+
+// record calls fn and records its usage, or its error, with the account, user and purpose of the options.
+// The purpose defaults to defaultPurpose when the options don't set one.
+func (s *ledgerService) record(defaultPurpose Purpose, options []ChatOption, fn func() (string, *domain.Usage, error)) (string, *domain.Usage, error) {
+	params := &ChatParameters{Purpose: defaultPurpose}
+	for _, option := range options {
+		option(params)
+	}
+
+	start := time.Now()
+	response, usage, err := fn()
+
+	entry := &UsageEntry{
+		AccountID: params.AccountID,
+		UserID:    params.UserID,
+		Purpose:   params.Purpose,
+		Model:     params.Model,
+		Latency:   time.Since(start),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if usage != nil {
+		entry.Platform = usage.Platform
+		entry.Model = usage.LlmModelName
+		entry.PromptTokens = usage.PromptTokens
+		entry.CompletionTokens = usage.CompletionTokens
+		entry.TotalTokens = usage.TotalTokens
+		entry.CacheHit = usage.CacheHit
+		if !usage.CacheHit {
+			entry.Cost = usage.Cost
+		}
+	}
+
+	if recordErr := s.ledger.Record(entry); recordErr != nil {
+		log.Error().Err(recordErr).Str("accountId", entry.AccountID).Msg("Failed to record LLM usage")
+	}
+
+	return response, usage, err
+}
+
+// Record saves the entry as a record of the usage collection
+func (l *pocketBaseUsageLedger) Record(entry *UsageEntry) error {
+	collection, err := l.app.FindCachedCollectionByNameOrId(domain.CollectionLLMUsage)
+	if err != nil {
+		return fmt.Errorf("failed to find %s collection: %w", domain.CollectionLLMUsage, err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("account", entry.AccountID)
+	record.Set("user", entry.UserID)
+	record.Set("purpose", string(entry.Purpose))
+	record.Set("platform", entry.Platform)
+	record.Set("model", entry.Model)
+	record.Set("prompt_tokens", entry.PromptTokens)
+	record.Set("completion_tokens", entry.CompletionTokens)
+	record.Set("total_tokens", entry.TotalTokens)
+	record.Set("cost", entry.Cost)
+	record.Set("latency_ms", entry.Latency.Milliseconds())
+	record.Set("cache_hit", entry.CacheHit)
+	record.Set("error", entry.Error)
+
+	return l.app.Save(record)
+}
@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryUsageLedger keeps the recorded entries in memory
+type memoryUsageLedger struct {
+	entries []*UsageEntry
+}
+
+func (m *memoryUsageLedger) Record(entry *UsageEntry) error {
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+func TestLedgerServiceRecordsRequests(t *testing.T) {
+	ledger := &memoryUsageLedger{}
+	delegate := newService(&Config{Cache: CacheConfig{Enabled: true}}, []Platform{newCachedPlatform(newEchoPlatform(), NewMemoryCacheStorage())})
+	s := newLedgerService(delegate, ledger)
+
+	opts := []ChatOption{WithAccount("acc1"), WithUser("user1"), WithPurpose(PurposeSessionGeneration)}
+	_, usage, err := s.Chat(context.Background(), "Hello", "", opts...)
+	require.NoError(t, err)
+	_, _, err = s.Chat(context.Background(), "Hello", "", opts...)
+	require.NoError(t, err)
+
+	require.Len(t, ledger.entries, 2)
+	entry := ledger.entries[0]
+	assert.Equal(t, "acc1", entry.AccountID)
+	assert.Equal(t, "user1", entry.UserID)
+	assert.Equal(t, PurposeSessionGeneration, entry.Purpose)
+	assert.Equal(t, string(EchoPlatform), entry.Platform)
+	assert.Equal(t, "echo", entry.Model)
+	assert.Equal(t, usage.TotalTokens, entry.TotalTokens)
+	assert.False(t, entry.CacheHit)
+
+	// The second request is answered from the cache
+	assert.True(t, ledger.entries[1].CacheHit)
+	assert.Zero(t, ledger.entries[1].Cost)
+}
+
+func TestLedgerServiceRecordsErrors(t *testing.T) {
+	ledger := &memoryUsageLedger{}
+	failing := &flakyPlatform{errors: []error{errors.New("model not found")}}
+	s := newLedgerService(newService(&Config{}, []Platform{failing}), ledger)
+
+	_, _, err := s.Chat(context.Background(), "Hello", "", WithModel("missing"))
+
+	require.Error(t, err)
+	require.Len(t, ledger.entries, 1)
+	assert.Equal(t, PurposeOther, ledger.entries[0].Purpose)
+	assert.Equal(t, "missing", ledger.entries[0].Model)
+	assert.Equal(t, "model not found", ledger.entries[0].Error)
+}
@@ -0,0 +1,306 @@
+package llm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// Priority orders the requests waiting for a platform, higher priorities are served first
+	Priority int
+
+	// QueueHandler receives the position of a request waiting for a platform, 1 being the next request served.
+	// It is called when the request is queued, whenever its position changes and with 0 once it is served.
+	QueueHandler func(position int)
+
+	// ConcurrencyConfig limits the requests a platform serves at the same time, the other requests wait in a priority queue
+	ConcurrencyConfig struct {
+		// Limits holds the number of concurrent requests per platform, platforms without limit are not queued
+		Limits map[PlatformType]int `json:"limits"`
+	}
+
+	// limitedPlatform queues the requests to the delegate beyond the concurrency limit of the platform.
+	// Each upstream has its own limit, so that the Ollama server of an account doesn't wait for the one of the house.
+	limitedPlatform struct {
+		delegate Platform
+		limit    int
+		mutex    sync.Mutex
+		limiters map[string]*priorityLimiter
+	}
+
+	// priorityLimiter lets limit requests run at the same time, the waiting requests are served
+	// by priority then in the order they arrived
+	priorityLimiter struct {
+		mutex   sync.Mutex
+		limit   int
+		active  int
+		waiting []*limiterWaiter
+	}
+
+	limiterWaiter struct {
+		priority Priority
+		// ready is closed once the waiter holds a slot, moved is signaled when its position changed
+		ready chan struct{}
+		moved chan struct{}
+	}
+)
+
+const (
+	// PriorityLow is for the batch requests nobody is waiting for, such as generating practice sessions
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is for the parents, such as their chat
+	PriorityNormal
+	// PriorityHigh is for the learners waiting for an answer, such as grading and hints
+	PriorityHigh
+)
+
+const (
+	// defaultOllamaConcurrency is the number of generations a home Ollama server serves at the same time
+	defaultOllamaConcurrency = 2
+)
+
+var (
+	// purposePriorities are the priorities of the purposes, the other purposes have PriorityNormal.
+	// Answers are graded by comparing them with the correct answer and hints come with the practice items,
+	// so no request is made for grading or hints yet, their priority is set for the LLM grading and hints to come.
+	purposePriorities = map[Purpose]Priority{
+		PurposeGrading:             PriorityHigh,
+		PurposeHint:                PriorityHigh,
+		PurposeChat:                PriorityNormal,
+		PurposeSessionGeneration:   PriorityLow,
+		PurposeWorksheetExtraction: PriorityLow,
+		PurposeEmbedding:           PriorityLow,
+	}
+)
+
+// newLimitedPlatform wraps the platform with a concurrency limit per upstream
+func newLimitedPlatform(delegate Platform, limit int) Platform {
+	return &limitedPlatform{
+		delegate: delegate,
+		limit:    limit,
+		limiters: make(map[string]*priorityLimiter),
+	}
+}
+
+// Type returns the platform type of the delegate
+func (l *limitedPlatform) Type() PlatformType {
+	return l.delegate.Type()
+}
+
+// Unwrap returns the decorated platform
+func (l *limitedPlatform) Unwrap() Platform {
+	return l.delegate
+}
+
+// Models lists the models of the delegate, listing models doesn't wait
+func (l *limitedPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	return l.delegate.Models(ctx)
+}
+
+// Chat implements the Platform interface, waiting for a slot
+func (l *limitedPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	release, err := l.acquire(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.delegate.Chat(ctx, params)
+}
+
+// ChatStream implements the Platform interface, the slot is held until the stream is done
+func (l *limitedPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	release, err := l.acquire(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.delegate.ChatStream(ctx, params, handler)
+}
+
+// ChatWithHistory implements the Platform interface, waiting for a slot
+func (l *limitedPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	release, err := l.acquire(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.delegate.ChatWithHistory(ctx, messages, params)
+}
+
+// ChatWithHistoryStream implements the Platform interface, the slot is held until the stream is done
+func (l *limitedPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	release, err := l.acquire(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.delegate.ChatWithHistoryStream(ctx, messages, params, handler)
+}
+
+// DescribeImage implements the Platform interface, waiting for a slot
+func (l *limitedPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
+	release, err := l.acquire(ctx, &params.ChatParameters)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.delegate.DescribeImage(ctx, params)
+}
+
+// Embed implements the Platform interface, waiting for a slot
+func (l *limitedPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	release, err := l.acquire(ctx, &params.ChatParameters)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.delegate.Embed(ctx, params)
+}
+
+// acquire waits for a slot of the upstream of the request, the returned function releases it
+func (l *limitedPlatform) acquire(ctx context.Context, params *ChatParameters) (func(), error) {
+	upstream := string(l.delegate.Type())
+	if params.ServerURL != "" {
+		upstream += "@" + normalizeOllamaURL(params.ServerURL)
+	}
+
+	l.mutex.Lock()
+	limiter, ok := l.limiters[upstream]
+	if !ok {
+		limiter = &priorityLimiter{limit: l.limit}
+		l.limiters[upstream] = limiter
+	}
+	l.mutex.Unlock()
+
+	return limiter.acquire(ctx, params.priority(), params.OnQueued)
+}
+
+// priority returns the priority of the request, set with WithPriority or derived from its purpose
+func (p *ChatParameters) priority() Priority {
+	if p.Priority != nil {
+		return *p.Priority
+	}
+	if priority, ok := purposePriorities[p.Purpose]; ok {
+		return priority
+	}
+	return PriorityNormal
+}
+
+// acquire takes a slot, waiting in the queue while all slots are taken. The queue handler, if any,
+// is called from the calling goroutine only. A request cancelled while waiting leaves the queue.
+func (l *priorityLimiter) acquire(ctx context.Context, priority Priority, onQueued QueueHandler) (func(), error) {
+	l.mutex.Lock()
+	if l.active < l.limit && len(l.waiting) == 0 {
+		l.active++
+		l.mutex.Unlock()
+		return l.release, nil
+	}
+
+	waiter := &limiterWaiter{
+		priority: priority,
+		ready:    make(chan struct{}),
+		moved:    make(chan struct{}, 1),
+	}
+	position := l.enqueue(waiter)
+	l.mutex.Unlock()
+
+	log.Debug().Int("priority", int(priority)).Int("position", position).Msg("Waiting for a free LLM platform slot")
+
+	reported := 0
+	for {
+		if onQueued != nil && position > 0 && position != reported {
+			onQueued(position)
+			reported = position
+		}
+
+		select {
+		case <-waiter.ready:
+			if onQueued != nil {
+				onQueued(0)
+			}
+			return l.release, nil
+		case <-waiter.moved:
+			l.mutex.Lock()
+			position = l.position(waiter)
+			l.mutex.Unlock()
+		case <-ctx.Done():
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			if index := l.index(waiter); index >= 0 {
+				l.waiting = append(l.waiting[:index], l.waiting[index+1:]...)
+				l.notifyFrom(index)
+				return nil, ctx.Err()
+			}
+			// The slot was handed over while the request was cancelled, it goes to the next waiter
+			l.handOver()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release frees a slot, handing it over to the first waiter if any
+func (l *priorityLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.handOver()
+}
+
+// handOver gives the slot of a finished request to the first waiter, or frees it. The mutex must be held.
+func (l *priorityLimiter) handOver() {
+	if len(l.waiting) == 0 {
+		l.active--
+		return
+	}
+
+	next := l.waiting[0]
+	l.waiting = l.waiting[1:]
+	close(next.ready)
+	l.notifyFrom(0)
+}
+
+// enqueue inserts the waiter after the waiters of the same or a higher priority and returns its position.
+// The mutex must be held.
+func (l *priorityLimiter) enqueue(waiter *limiterWaiter) int {
+	index := len(l.waiting)
+	for i, other := range l.waiting {
+		if other.priority < waiter.priority {
+			index = i
+			break
+		}
+	}
+
+	l.waiting = append(l.waiting, nil)
+	copy(l.waiting[index+1:], l.waiting[index:])
+	l.waiting[index] = waiter
+	l.notifyFrom(index + 1)
+
+	return index + 1
+}
+
+// notifyFrom signals the waiters from the index on that their position changed. The mutex must be held.
+func (l *priorityLimiter) notifyFrom(index int) {
+	for _, waiter := range l.waiting[index:] {
+		select {
+		case waiter.moved <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// index returns the index of the waiter in the queue, -1 once it left the queue. The mutex must be held.
+func (l *priorityLimiter) index(waiter *limiterWaiter) int {
+	for i, other := range l.waiting {
+		if other == waiter {
+			return i
+		}
+	}
+	return -1
+}
+
+// position returns the 1-based position of the waiter, 0 once it left the queue. The mutex must be held.
+func (l *priorityLimiter) position(waiter *limiterWaiter) int {
+	return l.index(waiter) + 1
+}
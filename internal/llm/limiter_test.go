@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queuedRequest acquires a slot of the limiter in a goroutine, reporting its queue positions
+type queuedRequest struct {
+	positions chan int
+	acquired  chan func()
+	err       chan error
+}
+
+func startQueuedRequest(ctx context.Context, limiter *priorityLimiter, priority Priority) *queuedRequest {
+	r := &queuedRequest{
+		positions: make(chan int, 10),
+		acquired:  make(chan func(), 1),
+		err:       make(chan error, 1),
+	}
+	go func() {
+		release, err := limiter.acquire(ctx, priority, func(position int) {
+			r.positions <- position
+		})
+		if err != nil {
+			r.err <- err
+			return
+		}
+		r.acquired <- release
+	}()
+	return r
+}
+
+func (r *queuedRequest) nextPosition(t *testing.T) int {
+	t.Helper()
+	select {
+	case position := <-r.positions:
+		return position
+	case <-time.After(time.Second):
+		require.FailNow(t, "no queue position reported")
+		return -1
+	}
+}
+
+func (r *queuedRequest) waitAcquired(t *testing.T) func() {
+	t.Helper()
+	select {
+	case release := <-r.acquired:
+		return release
+	case <-time.After(time.Second):
+		require.FailNow(t, "the request didn't get a slot")
+		return nil
+	}
+}
+
+func (r *queuedRequest) assertWaiting(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.acquired:
+		assert.Fail(t, "the request got a slot while the limit is reached")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestPriorityLimiterServesByPriority(t *testing.T) {
+	ctx := context.Background()
+	limiter := &priorityLimiter{limit: 1}
+
+	release, err := limiter.acquire(ctx, PriorityNormal, nil)
+	require.NoError(t, err)
+
+	generation := startQueuedRequest(ctx, limiter, PriorityLow)
+	assert.Equal(t, 1, generation.nextPosition(t))
+
+	chat := startQueuedRequest(ctx, limiter, PriorityNormal)
+	assert.Equal(t, 1, chat.nextPosition(t), "the chat goes before the generation")
+	assert.Equal(t, 2, generation.nextPosition(t))
+
+	grading := startQueuedRequest(ctx, limiter, PriorityHigh)
+	assert.Equal(t, 1, grading.nextPosition(t), "the grading goes first")
+	assert.Equal(t, 2, chat.nextPosition(t))
+	assert.Equal(t, 3, generation.nextPosition(t))
+
+	release()
+	assert.Equal(t, 0, grading.nextPosition(t))
+	release = grading.waitAcquired(t)
+	assert.Equal(t, 1, chat.nextPosition(t))
+	assert.Equal(t, 2, generation.nextPosition(t))
+	chat.assertWaiting(t)
+
+	release()
+	release = chat.waitAcquired(t)
+	release()
+	release = generation.waitAcquired(t)
+	release()
+
+	assert.Equal(t, 0, limiter.active)
+	assert.Empty(t, limiter.waiting)
+}
+
+func TestPriorityLimiterCancelledRequestLeavesTheQueue(t *testing.T) {
+	limiter := &priorityLimiter{limit: 1}
+	release, err := limiter.acquire(context.Background(), PriorityNormal, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := startQueuedRequest(ctx, limiter, PriorityHigh)
+	assert.Equal(t, 1, cancelled.nextPosition(t))
+	waiting := startQueuedRequest(context.Background(), limiter, PriorityLow)
+	assert.Equal(t, 2, waiting.nextPosition(t))
+
+	cancel()
+	select {
+	case err := <-cancelled.err:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		require.FailNow(t, "the cancelled request kept waiting")
+	}
+	assert.Equal(t, 1, waiting.nextPosition(t))
+
+	release()
+	waiting.waitAcquired(t)()
+	assert.Equal(t, 0, limiter.active)
+}
+
+func TestLimitedPlatformLimitsEachUpstream(t *testing.T) {
+	platform := newLimitedPlatform(newEchoPlatform(), 1).(*limitedPlatform)
+	ctx := context.Background()
+
+	release, err := platform.acquire(ctx, &ChatParameters{Purpose: PurposeSessionGeneration})
+	require.NoError(t, err)
+
+	// The Ollama server of an account has its own slots
+	other, err := platform.acquire(ctx, &ChatParameters{ServerURL: "http://10.0.0.2:11434"})
+	require.NoError(t, err)
+	other()
+
+	positions := make(chan int, 10)
+	done := make(chan error, 1)
+	go func() {
+		_, err := platform.Chat(ctx, &ChatParameters{Prompt: "Hello", OnQueued: func(position int) { positions <- position }})
+		done <- err
+	}()
+
+	assert.Equal(t, 1, <-positions)
+	release()
+	assert.Equal(t, 0, <-positions)
+	require.NoError(t, <-done)
+}
+
+func TestChatParametersPriority(t *testing.T) {
+	assert.Equal(t, PriorityHigh, (&ChatParameters{Purpose: PurposeGrading}).priority())
+	assert.Equal(t, PriorityNormal, (&ChatParameters{Purpose: PurposeChat}).priority())
+	assert.Equal(t, PriorityLow, (&ChatParameters{Purpose: PurposeSessionGeneration}).priority())
+	assert.Equal(t, PriorityNormal, (&ChatParameters{}).priority())
+
+	params := &ChatParameters{Purpose: PurposeSessionGeneration}
+	WithPriority(PriorityHigh)(params)
+	assert.Equal(t, PriorityHigh, params.priority())
+}
@@ -1,6 +1,7 @@
 package llm
 
 import (
+	"container/list"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -10,12 +11,21 @@ import (
 	"github.com/busybytelab.com/glimmer/internal/domain"
 )
 
-// MemoryCacheStorage implements the CacheStorage interface using an in-memory map
+// MemoryCacheStorage implements the CacheStorage interface in memory. It is bounded by a maximum number
+// of entries and an approximate size in bytes, the least recently used entries are evicted first.
+// Expired entries are purged by a janitor goroutine, see Close.
 type MemoryCacheStorage struct {
-	chatCache            map[string]*ChatCacheEntry
-	chatWithHistoryCache map[string]*ChatCacheEntry
-	imageDscCache        map[string]*ImageCacheEntry
-	mutex                sync.RWMutex
+	config CacheConfig
+	// items holds the elements of lru by request type and cache key
+	items map[memoryCacheKey]*list.Element
+	// lru holds the *memoryCacheItem values, the most recently used at the front
+	lru   *list.List
+	bytes int64
+	stats CacheStats
+	mutex sync.Mutex
+	now   func() time.Time
+	stop  chan struct{}
+	once  sync.Once
 }
 
 // ChatCacheEntry represents a cached chat response
@@ -34,18 +44,159 @@ type ImageCacheEntry struct {
 	TTL         time.Duration // 0 means no expiration
 }
 
-// NewMemoryCacheStorage creates a new memory-backed cache storage
+// EmbedCacheEntry represents cached embeddings
+type EmbedCacheEntry struct {
+	Embeddings [][]float32
+	Usage      *domain.Usage
+	CreatedAt  time.Time
+	TTL        time.Duration // 0 means no expiration
+}
+
+// cacheRequestType tells apart the cached request types, which have their own TTL
+type cacheRequestType string
+
+type memoryCacheKey struct {
+	requestType cacheRequestType
+	key         string
+}
+
+type memoryCacheItem struct {
+	key   memoryCacheKey
+	chat  *ChatCacheEntry
+	image *ImageCacheEntry
+	embed *EmbedCacheEntry
+	size  int64
+}
+
+const (
+	chatCacheRequest        cacheRequestType = "chat"
+	chatHistoryCacheRequest cacheRequestType = "history"
+	imageCacheRequest       cacheRequestType = "image"
+	embedCacheRequest       cacheRequestType = "embed"
+)
+
+const (
+	defaultCacheMaxEntries      = 10_000
+	defaultCacheMaxBytes        = 64 << 20
+	defaultCacheCleanupInterval = 10 * time.Minute
+	defaultChatCacheTTL         = 24 * time.Hour
+	// Shorter TTL for conversation history responses since conversations evolve
+	defaultChatHistoryCacheTTL = 6 * time.Hour
+	defaultImageCacheTTL       = 24 * time.Hour
+	// Embeddings of a text never change for a model, they are kept longer
+	defaultEmbedCacheTTL = 7 * 24 * time.Hour
+
+	// memoryCacheItemOverhead approximates the size of an entry besides its strings
+	memoryCacheItemOverhead = 256
+)
+
+// NewMemoryCacheStorage creates a new memory-backed cache storage with the default limits and TTLs
 func NewMemoryCacheStorage() CacheStorage {
-	return &MemoryCacheStorage{
-		chatCache:            make(map[string]*ChatCacheEntry),
-		chatWithHistoryCache: make(map[string]*ChatCacheEntry),
-		imageDscCache:        make(map[string]*ImageCacheEntry),
+	return NewMemoryCacheStorageWithConfig(CacheConfig{})
+}
+
+// NewMemoryCacheStorageWithConfig creates a new memory-backed cache storage with the limits and TTLs of the
+// configuration, zero values use the defaults. It starts the janitor purging expired entries.
+func NewMemoryCacheStorageWithConfig(config CacheConfig) *MemoryCacheStorage {
+	config = config.withDefaults()
+
+	m := &MemoryCacheStorage{
+		config: config,
+		items:  make(map[memoryCacheKey]*list.Element),
+		lru:    list.New(),
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	go m.janitor(config.CleanupInterval)
+
+	return m
+}
+
+// withDefaults returns the configuration with the defaults in place of the zero values
+func (c CacheConfig) withDefaults() CacheConfig {
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = defaultCacheMaxEntries
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaultCacheMaxBytes
+	}
+	if c.CleanupInterval <= 0 {
+		c.CleanupInterval = defaultCacheCleanupInterval
+	}
+	if c.ChatTTL <= 0 {
+		c.ChatTTL = defaultChatCacheTTL
+	}
+	if c.ChatHistoryTTL <= 0 {
+		c.ChatHistoryTTL = defaultChatHistoryCacheTTL
+	}
+	if c.ImageTTL <= 0 {
+		c.ImageTTL = defaultImageCacheTTL
+	}
+	if c.EmbedTTL <= 0 {
+		c.EmbedTTL = defaultEmbedCacheTTL
+	}
+	return c
+}
+
+// Close stops the janitor, the cache remains usable
+func (m *MemoryCacheStorage) Close() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+// Stats returns the counters of the cache
+func (m *MemoryCacheStorage) Stats() (CacheStats, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := m.stats
+	stats.Backend = MemoryCache
+	stats.Entries = m.lru.Len()
+	stats.Bytes = m.bytes
+	stats.MaxEntries = m.config.MaxEntries
+	stats.MaxBytes = m.config.MaxBytes
+
+	now := m.now()
+	for element := m.lru.Front(); element != nil; element = element.Next() {
+		if element.Value.(*memoryCacheItem).expired(now) {
+			stats.Expired++
+		}
 	}
+
+	return stats, nil
+}
+
+// Purge removes the entries matching the filter and returns how many were removed
+func (m *MemoryCacheStorage) Purge(filter CachePurgeFilter) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	purged := 0
+	for element := m.lru.Back(); element != nil; {
+		previous := element.Prev()
+		if m.matches(element.Value.(*memoryCacheItem), filter, now) {
+			m.remove(element)
+			purged++
+		}
+		element = previous
+	}
+	if filter.ExpiredOnly {
+		m.stats.Expirations += uint64(purged)
+	}
+
+	return purged, nil
+}
+
+// PurgeExpired removes the expired entries and returns how many were removed
+func (m *MemoryCacheStorage) PurgeExpired() int {
+	purged, _ := m.Purge(CachePurgeFilter{ExpiredOnly: true})
+	return purged
 }
 
 // GetChatCacheKey generates a cache key for a chat request
-func (m *MemoryCacheStorage) GetChatCacheKey(params *ChatParameters) string {
+func (m *MemoryCacheStorage) GetChatCacheKey(platform PlatformType, params *ChatParameters) string {
 	hasher := sha256.New()
+	writeServerKey(hasher, platform, params)
 	hasher.Write([]byte(params.Prompt))
 	hasher.Write([]byte(params.SystemPrompt))
 	model := params.Model
@@ -53,89 +204,49 @@ func (m *MemoryCacheStorage) GetChatCacheKey(params *ChatParameters) string {
 		model = "default"
 	}
 	hasher.Write([]byte(model))
+	hasher.Write([]byte(params.generationCacheKey()))
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
 // GetChatResponse retrieves a cached chat response
 func (m *MemoryCacheStorage) GetChatResponse(cacheKey string) (*ChatResponse, error) {
-	m.mutex.RLock()
-	entry, exists := m.chatCache[cacheKey]
-	m.mutex.RUnlock()
-
-	if !exists {
-		return nil, errors.New("record not found")
-	}
-
-	// Check expiration
-	if entry.TTL > 0 && time.Since(entry.CreatedAt) > entry.TTL {
-		// Remove expired entry
-		m.mutex.Lock()
-		delete(m.chatCache, cacheKey)
-		m.mutex.Unlock()
-		return nil, errors.New("record expired")
-	}
-
-	// Create a copy of usage data to avoid concurrent access issues
-	usageCopy := &domain.Usage{
-		LlmModelName:     entry.Usage.LlmModelName,
-		CacheHit:         true, // Override to indicate a cache hit
-		Cost:             entry.Usage.Cost,
-		PromptTokens:     entry.Usage.PromptTokens,
-		CompletionTokens: entry.Usage.CompletionTokens,
-		TotalTokens:      entry.Usage.TotalTokens,
+	item, err := m.get(memoryCacheKey{requestType: chatCacheRequest, key: cacheKey})
+	if err != nil {
+		return nil, err
 	}
 
 	return &ChatResponse{
-		Response: entry.Response,
-		Usage:    usageCopy,
+		Response: item.chat.Response,
+		Usage:    copyUsage(item.chat.Usage, true), // Override to indicate a cache hit
 	}, nil
 }
 
 // SetChatResponse stores a chat response in the cache
 func (m *MemoryCacheStorage) SetChatResponse(cacheKey string, params *ChatParameters, response *ChatResponse) error {
-	// Default TTL (24 hours)
-	ttl := 24 * time.Hour
-
-	// Create a copy of usage data to avoid concurrent access issues
-	var usageCopy *domain.Usage
-	if response.Usage != nil {
-		usageCopy = &domain.Usage{
-			LlmModelName:     response.Usage.LlmModelName,
-			CacheHit:         response.Usage.CacheHit,
-			Cost:             response.Usage.Cost,
-			PromptTokens:     response.Usage.PromptTokens,
-			CompletionTokens: response.Usage.CompletionTokens,
-			TotalTokens:      response.Usage.TotalTokens,
-		}
-	}
-
-	entry := &ChatCacheEntry{
-		Response:  response.Response,
-		Usage:     usageCopy,
-		CreatedAt: time.Now(),
-		TTL:       ttl,
-	}
-
-	m.mutex.Lock()
-	m.chatCache[cacheKey] = entry
-	m.mutex.Unlock()
-
+	m.setChat(memoryCacheKey{requestType: chatCacheRequest, key: cacheKey}, m.config.ChatTTL, response)
 	return nil
 }
 
 // GetChatWithHistoryCacheKey generates a cache key for a chat request with message history
-func (m *MemoryCacheStorage) GetChatWithHistoryCacheKey(messages []*domain.ChatItem, systemPrompt, model string) string {
+func (m *MemoryCacheStorage) GetChatWithHistoryCacheKey(platform PlatformType, messages []*domain.ChatItem, params *ChatParameters) string {
 	hasher := sha256.New()
 
+	// Add the platform and the Ollama server of the account
+	writeServerKey(hasher, platform, params)
+
 	// Add system prompt
-	hasher.Write([]byte(systemPrompt))
+	hasher.Write([]byte(params.SystemPrompt))
 
 	// Add model
+	model := params.Model
 	if model == "" {
 		model = "default"
 	}
 	hasher.Write([]byte(model))
 
+	// Add generation settings
+	hasher.Write([]byte(params.generationCacheKey()))
+
 	// Add all messages in sequence
 	for _, msg := range messages {
 		hasher.Write([]byte(msg.Role))
@@ -147,68 +258,20 @@ func (m *MemoryCacheStorage) GetChatWithHistoryCacheKey(messages []*domain.ChatI
 
 // GetChatWithHistoryResponse retrieves a cached chat with history response
 func (m *MemoryCacheStorage) GetChatWithHistoryResponse(cacheKey string) (*ChatResponse, error) {
-	m.mutex.RLock()
-	entry, exists := m.chatWithHistoryCache[cacheKey]
-	m.mutex.RUnlock()
-
-	if !exists {
-		return nil, errors.New("record not found")
-	}
-
-	// Check expiration
-	if entry.TTL > 0 && time.Since(entry.CreatedAt) > entry.TTL {
-		// Remove expired entry
-		m.mutex.Lock()
-		delete(m.chatWithHistoryCache, cacheKey)
-		m.mutex.Unlock()
-		return nil, errors.New("record expired")
-	}
-
-	// Create a copy of usage data to avoid concurrent access issues
-	usageCopy := &domain.Usage{
-		LlmModelName:     entry.Usage.LlmModelName,
-		CacheHit:         true, // Override to indicate a cache hit
-		Cost:             entry.Usage.Cost,
-		PromptTokens:     entry.Usage.PromptTokens,
-		CompletionTokens: entry.Usage.CompletionTokens,
-		TotalTokens:      entry.Usage.TotalTokens,
+	item, err := m.get(memoryCacheKey{requestType: chatHistoryCacheRequest, key: cacheKey})
+	if err != nil {
+		return nil, err
 	}
 
 	return &ChatResponse{
-		Response: entry.Response,
-		Usage:    usageCopy,
+		Response: item.chat.Response,
+		Usage:    copyUsage(item.chat.Usage, true), // Override to indicate a cache hit
 	}, nil
 }
 
 // SetChatWithHistoryResponse stores a chat with history response in the cache
 func (m *MemoryCacheStorage) SetChatWithHistoryResponse(cacheKey string, messages []*domain.ChatItem, systemPrompt, model string, response *ChatResponse) error {
-	// Shorter TTL for conversation history responses (6 hours) since conversations evolve
-	ttl := 6 * time.Hour
-
-	// Create a copy of usage data to avoid concurrent access issues
-	var usageCopy *domain.Usage
-	if response.Usage != nil {
-		usageCopy = &domain.Usage{
-			LlmModelName:     response.Usage.LlmModelName,
-			CacheHit:         response.Usage.CacheHit,
-			Cost:             response.Usage.Cost,
-			PromptTokens:     response.Usage.PromptTokens,
-			CompletionTokens: response.Usage.CompletionTokens,
-			TotalTokens:      response.Usage.TotalTokens,
-		}
-	}
-
-	entry := &ChatCacheEntry{
-		Response:  response.Response,
-		Usage:     usageCopy,
-		CreatedAt: time.Now(),
-		TTL:       ttl,
-	}
-
-	m.mutex.Lock()
-	m.chatWithHistoryCache[cacheKey] = entry
-	m.mutex.Unlock()
-
+	m.setChat(memoryCacheKey{requestType: chatHistoryCacheRequest, key: cacheKey}, m.config.ChatHistoryTTL, response)
 	return nil
 }
 
@@ -221,67 +284,197 @@ func (m *MemoryCacheStorage) GetDescribeImageCacheKey(params *DescribeImageParam
 
 // GetDescribeImageResponse retrieves a cached image description
 func (m *MemoryCacheStorage) GetDescribeImageResponse(cacheKey string) (*DescribeImageResponse, error) {
-	m.mutex.RLock()
-	entry, exists := m.imageDscCache[cacheKey]
-	m.mutex.RUnlock()
+	item, err := m.get(memoryCacheKey{requestType: imageCacheRequest, key: cacheKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DescribeImageResponse{
+		Description: item.image.Description,
+		Usage:       copyUsage(item.image.Usage, true), // Override to indicate a cache hit
+	}, nil
+}
+
+// SetDescribeImageResponse stores an image description in the cache
+func (m *MemoryCacheStorage) SetDescribeImageResponse(cacheKey string, params *DescribeImageParameters, response *DescribeImageResponse) error {
+	key := memoryCacheKey{requestType: imageCacheRequest, key: cacheKey}
+	m.set(&memoryCacheItem{
+		key: key,
+		image: &ImageCacheEntry{
+			Description: response.Description,
+			Usage:       copyUsage(response.Usage, false),
+			CreatedAt:   m.now(),
+			TTL:         m.config.ImageTTL,
+		},
+		size: int64(len(cacheKey)+len(response.Description)) + memoryCacheItemOverhead,
+	})
+	return nil
+}
+
+// GetEmbedCacheKey generates a cache key for an embed request on the platform
+func (m *MemoryCacheStorage) GetEmbedCacheKey(platform PlatformType, params *EmbedParameters) string {
+	return "embed-" + embedCacheKey(platform, params)
+}
+
+// GetEmbedResponse retrieves cached embeddings
+func (m *MemoryCacheStorage) GetEmbedResponse(cacheKey string) (*EmbedResponse, error) {
+	item, err := m.get(memoryCacheKey{requestType: embedCacheRequest, key: cacheKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmbedResponse{
+		Embeddings: item.embed.Embeddings,
+		Usage:      copyUsage(item.embed.Usage, true), // Override to indicate a cache hit
+	}, nil
+}
+
+// SetEmbedResponse stores embeddings in the cache
+func (m *MemoryCacheStorage) SetEmbedResponse(cacheKey string, params *EmbedParameters, response *EmbedResponse) error {
+	size := int64(len(cacheKey)) + memoryCacheItemOverhead
+	for _, embedding := range response.Embeddings {
+		size += int64(4 * len(embedding))
+	}
+
+	key := memoryCacheKey{requestType: embedCacheRequest, key: cacheKey}
+	m.set(&memoryCacheItem{
+		key: key,
+		embed: &EmbedCacheEntry{
+			Embeddings: response.Embeddings,
+			Usage:      copyUsage(response.Usage, false),
+			CreatedAt:  m.now(),
+			TTL:        m.config.EmbedTTL,
+		},
+		size: size,
+	})
+	return nil
+}
+
+func (m *MemoryCacheStorage) setChat(key memoryCacheKey, ttl time.Duration, response *ChatResponse) {
+	m.set(&memoryCacheItem{
+		key: key,
+		chat: &ChatCacheEntry{
+			Response:  response.Response,
+			Usage:     copyUsage(response.Usage, false),
+			CreatedAt: m.now(),
+			TTL:       ttl,
+		},
+		size: int64(len(key.key)+len(response.Response)) + memoryCacheItemOverhead,
+	})
+}
 
+// get returns the item of the key and marks it as the most recently used, expired items are removed
+func (m *MemoryCacheStorage) get(key memoryCacheKey) (*memoryCacheItem, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	element, exists := m.items[key]
 	if !exists {
+		m.stats.Misses++
 		return nil, errors.New("record not found")
 	}
 
-	// Check expiration
-	if entry.TTL > 0 && time.Since(entry.CreatedAt) > entry.TTL {
-		// Remove expired entry
-		m.mutex.Lock()
-		delete(m.imageDscCache, cacheKey)
-		m.mutex.Unlock()
+	item := element.Value.(*memoryCacheItem)
+	if item.expired(m.now()) {
+		m.remove(element)
+		m.stats.Expirations++
+		m.stats.Misses++
 		return nil, errors.New("record expired")
 	}
 
-	// Create a copy of usage data to avoid concurrent access issues
-	usageCopy := &domain.Usage{
-		LlmModelName:     entry.Usage.LlmModelName,
-		CacheHit:         true, // Override to indicate a cache hit
-		Cost:             entry.Usage.Cost,
-		PromptTokens:     entry.Usage.PromptTokens,
-		CompletionTokens: entry.Usage.CompletionTokens,
-		TotalTokens:      entry.Usage.TotalTokens,
+	m.lru.MoveToFront(element)
+	m.stats.Hits++
+
+	// Items are never modified once stored, so they can be read without the lock
+	return item, nil
+}
+
+// set stores the item, evicting the least recently used items until the cache is within its limits.
+// Items larger than the whole cache are not stored.
+func (m *MemoryCacheStorage) set(item *memoryCacheItem) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if element, exists := m.items[item.key]; exists {
+		m.remove(element)
+	}
+	if item.size > m.config.MaxBytes {
+		return
 	}
 
-	return &DescribeImageResponse{
-		Description: entry.Description,
-		Usage:       usageCopy,
-	}, nil
+	m.items[item.key] = m.lru.PushFront(item)
+	m.bytes += item.size
+
+	for m.lru.Len() > m.config.MaxEntries || m.bytes > m.config.MaxBytes {
+		m.remove(m.lru.Back())
+		m.stats.Evictions++
+	}
 }
 
-// SetDescribeImageResponse stores an image description in the cache
-func (m *MemoryCacheStorage) SetDescribeImageResponse(cacheKey string, params *DescribeImageParameters, response *DescribeImageResponse) error {
-	// Default TTL (24 hours)
-	ttl := 24 * time.Hour
-
-	// Create a copy of usage data to avoid concurrent access issues
-	var usageCopy *domain.Usage
-	if response.Usage != nil {
-		usageCopy = &domain.Usage{
-			LlmModelName:     response.Usage.LlmModelName,
-			CacheHit:         response.Usage.CacheHit,
-			Cost:             response.Usage.Cost,
-			PromptTokens:     response.Usage.PromptTokens,
-			CompletionTokens: response.Usage.CompletionTokens,
-			TotalTokens:      response.Usage.TotalTokens,
+func (m *MemoryCacheStorage) remove(element *list.Element) {
+	item := m.lru.Remove(element).(*memoryCacheItem)
+	delete(m.items, item.key)
+	m.bytes -= item.size
+}
+
+// janitor purges the expired entries every interval until the cache is closed
+func (m *MemoryCacheStorage) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.PurgeExpired()
+		case <-m.stop:
+			return
 		}
 	}
+}
 
-	entry := &ImageCacheEntry{
-		Description: response.Description,
-		Usage:       usageCopy,
-		CreatedAt:   time.Now(),
-		TTL:         ttl,
+// matches reports whether the item matches the purge filter
+func (m *MemoryCacheStorage) matches(item *memoryCacheItem, filter CachePurgeFilter, now time.Time) bool {
+	usage, createdAt := item.usageAndCreatedAt()
+	switch {
+	case filter.Model != "" && (usage == nil || usage.LlmModelName != filter.Model):
+		return false
+	case filter.Backend != "" && (usage == nil || usage.Platform != filter.Backend):
+		return false
+	case filter.OlderThan > 0 && now.Sub(createdAt) < filter.OlderThan:
+		return false
+	case filter.ExpiredOnly && !item.expired(now):
+		return false
 	}
+	return true
+}
 
-	m.mutex.Lock()
-	m.imageDscCache[cacheKey] = entry
-	m.mutex.Unlock()
+func (i *memoryCacheItem) expired(now time.Time) bool {
+	switch {
+	case i.chat != nil:
+		return i.chat.TTL > 0 && now.Sub(i.chat.CreatedAt) > i.chat.TTL
+	case i.embed != nil:
+		return i.embed.TTL > 0 && now.Sub(i.embed.CreatedAt) > i.embed.TTL
+	}
+	return i.image.TTL > 0 && now.Sub(i.image.CreatedAt) > i.image.TTL
+}
 
-	return nil
+func (i *memoryCacheItem) usageAndCreatedAt() (*domain.Usage, time.Time) {
+	switch {
+	case i.chat != nil:
+		return i.chat.Usage, i.chat.CreatedAt
+	case i.embed != nil:
+		return i.embed.Usage, i.embed.CreatedAt
+	}
+	return i.image.Usage, i.image.CreatedAt
+}
+
+// copyUsage copies the usage to avoid concurrent access issues
+func copyUsage(usage *domain.Usage, cacheHit bool) *domain.Usage {
+	if usage == nil {
+		return &domain.Usage{CacheHit: cacheHit}
+	}
+
+	usageCopy := *usage
+	usageCopy.CacheHit = cacheHit
+	return &usageCopy
 }
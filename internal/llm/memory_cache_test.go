@@ -1,10 +1,13 @@
 package llm
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestMemoryCacheStorage tests the memory cache storage implementation
@@ -42,11 +45,11 @@ func TestMemoryCacheStorage(t *testing.T) {
 
 	// Test getting a cache key
 	t.Run("GetChatCacheKey", func(t *testing.T) {
-		key := storage.GetChatCacheKey(params)
+		key := storage.GetChatCacheKey(OllamaPlatform, params)
 		assert.NotEmpty(t, key)
 
 		// Getting the key again with the same parameters should give the same key
-		key2 := storage.GetChatCacheKey(params)
+		key2 := storage.GetChatCacheKey(OllamaPlatform, params)
 		assert.Equal(t, key, key2)
 
 		// Modifying parameters should give a different key
@@ -55,14 +58,14 @@ func TestMemoryCacheStorage(t *testing.T) {
 			SystemPrompt: testSystemPrompt,
 			Model:        testModel,
 		}
-		keyModified := storage.GetChatCacheKey(paramsModified)
+		keyModified := storage.GetChatCacheKey(OllamaPlatform, paramsModified)
 		assert.NotEqual(t, key, keyModified)
 	})
 
 	// Test setting and getting a chat response
 	t.Run("SetGetChatResponse", func(t *testing.T) {
 		// Generate a key for the parameters
-		key := storage.GetChatCacheKey(params)
+		key := storage.GetChatCacheKey(OllamaPlatform, params)
 
 		// Initially, there should be no response in the cache
 		cachedResponse, err := storage.GetChatResponse(key)
@@ -169,7 +172,7 @@ func TestMemoryCacheStorage(t *testing.T) {
 		}
 
 		// Get a cache key for the history
-		historyKey := storage.GetChatWithHistoryCacheKey(messages, testSystemPrompt, testModel)
+		historyKey := storage.GetChatWithHistoryCacheKey(OllamaPlatform, messages, &ChatParameters{SystemPrompt: testSystemPrompt, Model: testModel})
 		assert.NotEmpty(t, historyKey)
 
 		// Initially, there should be no response in the cache
@@ -211,7 +214,7 @@ func TestMemoryCacheStorage(t *testing.T) {
 		copy(modifiedMessages, messages)
 		modifiedMessages[3].Content = "What is the capital of Germany?"
 
-		modifiedKey := storage.GetChatWithHistoryCacheKey(modifiedMessages, testSystemPrompt, testModel)
+		modifiedKey := storage.GetChatWithHistoryCacheKey(OllamaPlatform, modifiedMessages, &ChatParameters{SystemPrompt: testSystemPrompt, Model: testModel})
 		assert.NotEqual(t, historyKey, modifiedKey)
 
 		// No response for the modified key
@@ -220,3 +223,114 @@ func TestMemoryCacheStorage(t *testing.T) {
 		assert.Nil(t, cachedResponse)
 	})
 }
+
+func TestMemoryCacheStorageEvictsLeastRecentlyUsed(t *testing.T) {
+	storage := NewMemoryCacheStorageWithConfig(CacheConfig{MaxEntries: 2})
+	t.Cleanup(storage.Close)
+	response := &ChatResponse{Response: "cached", Usage: &domain.Usage{TotalTokens: 3}}
+
+	require.NoError(t, storage.SetChatResponse("a", &ChatParameters{}, response))
+	require.NoError(t, storage.SetChatResponse("b", &ChatParameters{}, response))
+	// Reading "a" makes "b" the least recently used entry
+	_, err := storage.GetChatResponse("a")
+	require.NoError(t, err)
+	require.NoError(t, storage.SetChatWithHistoryResponse("c", nil, "", "", response))
+
+	_, err = storage.GetChatResponse("b")
+	assert.Error(t, err)
+	_, err = storage.GetChatResponse("a")
+	assert.NoError(t, err)
+	_, err = storage.GetChatWithHistoryResponse("c")
+	assert.NoError(t, err)
+
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Entries)
+	assert.Equal(t, 2, stats.MaxEntries)
+}
+
+func TestMemoryCacheStorageBoundsBytes(t *testing.T) {
+	storage := NewMemoryCacheStorageWithConfig(CacheConfig{MaxBytes: 2*memoryCacheItemOverhead + 100})
+	t.Cleanup(storage.Close)
+
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, storage.SetChatResponse(key, &ChatParameters{}, &ChatResponse{Response: strings.Repeat("x", 40)}))
+	}
+	// Responses larger than the whole cache are not stored
+	require.NoError(t, storage.SetChatResponse("d", &ChatParameters{}, &ChatResponse{Response: strings.Repeat("x", 1000)}))
+
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Entries)
+	assert.LessOrEqual(t, stats.Bytes, stats.MaxBytes)
+	_, err = storage.GetChatResponse("a")
+	assert.Error(t, err)
+	_, err = storage.GetChatResponse("d")
+	assert.Error(t, err)
+}
+
+func TestMemoryCacheStorageExpiresEntriesPerRequestType(t *testing.T) {
+	now := time.Now()
+	storage := NewMemoryCacheStorageWithConfig(CacheConfig{ChatTTL: time.Hour, ChatHistoryTTL: time.Minute, ImageTTL: time.Hour})
+	t.Cleanup(storage.Close)
+	storage.now = func() time.Time { return now }
+	response := &ChatResponse{Response: "cached"}
+
+	require.NoError(t, storage.SetChatResponse("chat", &ChatParameters{}, response))
+	require.NoError(t, storage.SetChatWithHistoryResponse("history", nil, "", "", response))
+	require.NoError(t, storage.SetDescribeImageResponse("image", &DescribeImageParameters{}, &DescribeImageResponse{Description: "cat"}))
+
+	now = now.Add(10 * time.Minute)
+	_, err := storage.GetChatWithHistoryResponse("history")
+	assert.Error(t, err)
+	_, err = storage.GetChatResponse("chat")
+	assert.NoError(t, err)
+
+	// The janitor purges the entries nobody reads
+	now = now.Add(time.Hour)
+	assert.Equal(t, 2, storage.PurgeExpired())
+
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Zero(t, stats.Entries)
+	assert.Zero(t, stats.Bytes)
+	assert.Equal(t, uint64(3), stats.Expirations)
+}
+
+func TestMemoryCacheStoragePurge(t *testing.T) {
+	now := time.Now()
+	storage := NewMemoryCacheStorageWithConfig(CacheConfig{})
+	t.Cleanup(storage.Close)
+	storage.now = func() time.Time { return now }
+
+	set := func(key, platform, model string) {
+		response := &ChatResponse{Response: key, Usage: &domain.Usage{Platform: platform, LlmModelName: model}}
+		require.NoError(t, storage.SetChatResponse(key, &ChatParameters{}, response))
+	}
+	set("old", "ollama", "gemma3:1b")
+	now = now.Add(2 * time.Hour)
+	set("gemma", "ollama", "gemma3:1b")
+	set("gpt", "openai", "gpt-4.1-nano")
+	set("claude", "anthropic", "claude-3-5-haiku-latest")
+
+	purged, err := storage.Purge(CachePurgeFilter{OlderThan: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	purged, err = storage.Purge(CachePurgeFilter{Model: "gemma3:1b"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	purged, err = storage.Purge(CachePurgeFilter{Backend: "openai"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	_, err = storage.GetChatResponse("claude")
+	assert.NoError(t, err)
+	purged, err = storage.Purge(CachePurgeFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+}
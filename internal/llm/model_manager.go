@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type (
+	// ModelManager administrates the models installed on the servers of a platform hosting its models, such as
+	// the Ollama servers. An empty server URL is the configured server, the others are the servers of the accounts.
+	ModelManager interface {
+		// PullModel downloads the model, reporting the progress of the download to the handler
+		PullModel(ctx context.Context, serverURL, model string, handler PullProgressHandler) error
+		// DeleteModel removes the model, ErrModelNotFound is returned when it isn't installed
+		DeleteModel(ctx context.Context, serverURL, model string) error
+		// ShowModel returns the details of the model, ErrModelNotFound is returned when it isn't installed
+		ShowModel(ctx context.Context, serverURL, model string) (*ModelDetails, error)
+		// LoadModel loads the model in memory ahead of the first request and keeps it loaded for keepAlive.
+		// A negative duration keeps it loaded until the server stops, zero unloads it.
+		LoadModel(ctx context.Context, serverURL, model string, keepAlive time.Duration) error
+	}
+
+	// PullProgressHandler receives the progress of a model download, an error stops the download
+	PullProgressHandler func(progress PullProgress) error
+
+	// PullProgress reports the step of a model download, e.g. "pulling manifest" or "success",
+	// the layers being downloaded have a digest and their downloaded bytes
+	PullProgress struct {
+		Status    string `json:"status"`
+		Digest    string `json:"digest,omitempty"`
+		Total     int64  `json:"total,omitempty"`
+		Completed int64  `json:"completed,omitempty"`
+	}
+
+	// ModelDetails describes an installed model
+	ModelDetails struct {
+		Name              string `json:"name"`
+		Family            string `json:"family"`
+		ParameterSize     string `json:"parameterSize"`
+		QuantizationLevel string `json:"quantizationLevel"`
+		// ContextLength is the context window of the model in tokens, zero when unknown
+		ContextLength int `json:"contextLength"`
+		// Capabilities such as "completion", "tools", "vision" or "embedding"
+		Capabilities []string `json:"capabilities"`
+		// Parameters are the default parameters of the model, e.g. "temperature" or "stop" which may be repeated
+		Parameters map[string][]string `json:"parameters"`
+	}
+
+	// ollamaModelManager manages the models of the Ollama servers of the service. The models listed by the
+	// decorators of the platform are cleared once a model of the configured server is pulled or deleted,
+	// so that the service lists it right away.
+	ollamaModelManager struct {
+		ollama   *ollamaPlatform
+		platform Platform
+	}
+)
+
+var (
+	ErrModelNotFound = errors.New("model not found")
+)
+
+// NewModelManager returns the manager of the models of the Ollama platform registered in the service,
+// nil when Ollama isn't registered
+func NewModelManager(llmService Service) ModelManager {
+	s := unwrapService(llmService)
+	if s == nil {
+		return nil
+	}
+
+	for _, platform := range s.platforms {
+		if ollama := unwrapOllamaPlatform(platform); ollama != nil {
+			return &ollamaModelManager{ollama: ollama, platform: platform}
+		}
+	}
+	return nil
+}
+
+// PullModel implements the ModelManager interface
+func (m *ollamaModelManager) PullModel(ctx context.Context, serverURL, model string, handler PullProgressHandler) error {
+	defer m.clearModelsCache(serverURL)
+	return m.ollama.PullModel(ctx, serverURL, model, handler)
+}
+
+// DeleteModel implements the ModelManager interface
+func (m *ollamaModelManager) DeleteModel(ctx context.Context, serverURL, model string) error {
+	defer m.clearModelsCache(serverURL)
+	return m.ollama.DeleteModel(ctx, serverURL, model)
+}
+
+// ShowModel implements the ModelManager interface
+func (m *ollamaModelManager) ShowModel(ctx context.Context, serverURL, model string) (*ModelDetails, error) {
+	return m.ollama.ShowModel(ctx, serverURL, model)
+}
+
+// LoadModel implements the ModelManager interface
+func (m *ollamaModelManager) LoadModel(ctx context.Context, serverURL, model string, keepAlive time.Duration) error {
+	return m.ollama.LoadModel(ctx, serverURL, model, keepAlive)
+}
+
+// clearModelsCache clears the models listed by the decorators, they are the models of the configured server
+func (m *ollamaModelManager) clearModelsCache(serverURL string) {
+	if !m.ollama.usesPool(serverURL) {
+		clearModelsCache(m.platform)
+	}
+}
+
+// unwrapService returns the service decorated by the budget, the ledger and the redaction, nil for other services
+func unwrapService(llmService Service) *service {
+	for llmService != nil {
+		if s, ok := llmService.(*service); ok {
+			return s
+		}
+
+		wrapper, ok := llmService.(interface{ Unwrap() Service })
+		if !ok {
+			break
+		}
+		llmService = wrapper.Unwrap()
+	}
+	return nil
+}
+
+// unwrapOllamaPlatform returns the Ollama platform decorated by the platform, nil for other platforms
+func unwrapOllamaPlatform(platform Platform) *ollamaPlatform {
+	for platform != nil {
+		if ollama, ok := platform.(*ollamaPlatform); ok {
+			return ollama
+		}
+
+		wrapper, ok := platform.(interface{ Unwrap() Platform })
+		if !ok {
+			break
+		}
+		platform = wrapper.Unwrap()
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestModelManager(client OllamaClient) *ollamaPlatform {
+	platform := newOllamaPlatform(OllamaConfig{URL: "http://localhost:11434", Model: "gemma3:4b"}).(*ollamaPlatform)
+	platform.client = client
+	return platform
+}
+
+func TestOllamaPlatformPullModel(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.PullProgress = []api.ProgressResponse{
+		{Status: "pulling manifest"},
+		{Status: "pulling aeda25e63ebd", Digest: "sha256:aeda25e63ebd", Total: 3338792448, Completed: 1048576},
+		{Status: "success"},
+	}
+	mockClient.On("PullModel", mock.Anything, "gemma3:4b", mock.Anything).Return(nil)
+
+	var progress []PullProgress
+	err := newTestModelManager(mockClient).PullModel(context.Background(), "", "gemma3:4b", func(p PullProgress) error {
+		progress = append(progress, p)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []PullProgress{
+		{Status: "pulling manifest"},
+		{Status: "pulling aeda25e63ebd", Digest: "sha256:aeda25e63ebd", Total: 3338792448, Completed: 1048576},
+		{Status: "success"},
+	}, progress)
+	mockClient.AssertExpectations(t)
+
+	t.Run("the handler stops the download", func(t *testing.T) {
+		stop := errors.New("client gone")
+		err := newTestModelManager(mockClient).PullModel(context.Background(), "", "gemma3:4b", func(p PullProgress) error {
+			return stop
+		})
+		assert.ErrorIs(t, err, stop)
+	})
+}
+
+func TestOllamaPlatformDeleteModel(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.On("DeleteModel", mock.Anything, "gemma3:4b").Return(nil)
+	mockClient.On("DeleteModel", mock.Anything, "missing:1b").Return(fmt.Errorf("failed to delete model with Ollama: %w", api.StatusError{StatusCode: http.StatusNotFound}))
+
+	platform := newTestModelManager(mockClient)
+
+	require.NoError(t, platform.DeleteModel(context.Background(), "", "gemma3:4b"))
+	assert.ErrorIs(t, platform.DeleteModel(context.Background(), "", "missing:1b"), ErrModelNotFound)
+	mockClient.AssertExpectations(t)
+}
+
+func TestOllamaPlatformShowModel(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.On("ShowModel", mock.Anything, "gemma3:4b").Return(&api.ShowResponse{
+		Parameters: "stop                           \"<end_of_turn>\"\nstop                           \"<eos>\"\ntemperature                    1\ntop_k                          64",
+		Details:    api.ModelDetails{Family: "gemma3", ParameterSize: "4.3B", QuantizationLevel: "Q4_K_M"},
+		ModelInfo: map[string]any{
+			"general.architecture":  "gemma3",
+			"gemma3.context_length": float64(131072),
+		},
+		Capabilities: []model.Capability{model.CapabilityCompletion, model.CapabilityVision},
+	}, nil)
+	mockClient.On("ShowModel", mock.Anything, "missing:1b").Return(nil, api.StatusError{StatusCode: http.StatusNotFound})
+
+	platform := newTestModelManager(mockClient)
+
+	details, err := platform.ShowModel(context.Background(), "", "gemma3:4b")
+	require.NoError(t, err)
+	assert.Equal(t, &ModelDetails{
+		Name:              "gemma3:4b",
+		Family:            "gemma3",
+		ParameterSize:     "4.3B",
+		QuantizationLevel: "Q4_K_M",
+		ContextLength:     131072,
+		Capabilities:      []string{"completion", "vision"},
+		Parameters: map[string][]string{
+			"stop":        {"<end_of_turn>", "<eos>"},
+			"temperature": {"1"},
+			"top_k":       {"64"},
+		},
+	}, details)
+
+	_, err = platform.ShowModel(context.Background(), "", "missing:1b")
+	assert.ErrorIs(t, err, ErrModelNotFound)
+	mockClient.AssertExpectations(t)
+}
+
+func TestOllamaPlatformLoadModel(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.On("LoadModel", mock.Anything, "gemma3:4b", time.Hour).Return(nil)
+	mockClient.On("LoadModel", mock.Anything, "gemma3:4b", time.Duration(0)).Return(nil)
+
+	platform := newTestModelManager(mockClient)
+
+	require.NoError(t, platform.LoadModel(context.Background(), "", "gemma3:4b", time.Hour))
+	require.NoError(t, platform.LoadModel(context.Background(), "", "gemma3:4b", 0), "unloads the model")
+	mockClient.AssertExpectations(t)
+}
+
+func TestNewModelManager(t *testing.T) {
+	newModelManager := func(cfg *Config) (ModelManager, []Platform) {
+		platforms := NewPlatforms(cfg, nil)
+		return NewModelManager(newBudgetService(newService(cfg, platforms), nil)), platforms
+	}
+
+	manager, platforms := newModelManager(&Config{Platform: OllamaPlatform})
+	require.NotNil(t, manager)
+	assert.Same(t, unwrapOllamaPlatform(platforms[0]), manager.(*ollamaModelManager).ollama, "the manager shares the platform of the service")
+
+	manager, platforms = newModelManager(&Config{Platform: OpenAIPlatform, Fallbacks: []PlatformType{OllamaPlatform}})
+	require.NotNil(t, manager)
+	assert.Same(t, unwrapOllamaPlatform(platforms[1]), manager.(*ollamaModelManager).ollama)
+
+	manager, _ = newModelManager(&Config{Platform: OpenAIPlatform})
+	assert.Nil(t, manager)
+}
+
+func TestModelManagerRefreshesServiceModels(t *testing.T) {
+	ctx := context.Background()
+	cfg := &Config{
+		Platform: OllamaPlatform,
+		Ollama:   OllamaConfig{URL: "http://localhost:11434", Model: "gemma3:4b"},
+		Cache:    CacheConfig{Enabled: true},
+	}
+	platforms := NewPlatforms(cfg, NewMemoryCacheStorage())
+	llmService := newBudgetService(newService(cfg, platforms), nil)
+
+	mockClient := new(MockOllamaClient)
+	unwrapOllamaPlatform(platforms[0]).client = mockClient
+	mockClient.On("ListModels", mock.Anything).Return([]*ModelInfo{}, nil).Once()
+	mockClient.On("ListModels", mock.Anything).Return([]*ModelInfo{{Name: "gemma3:4b"}}, nil).Once()
+	mockClient.On("ListModels", mock.Anything).Return([]*ModelInfo{}, nil).Once()
+	mockClient.On("ShowModel", mock.Anything, "gemma3:4b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion},
+	}, nil)
+	mockClient.On("PullModel", mock.Anything, "gemma3:4b", mock.Anything).Return(nil)
+	mockClient.On("DeleteModel", mock.Anything, "gemma3:4b").Return(nil)
+
+	manager := NewModelManager(llmService)
+	require.NotNil(t, manager)
+
+	assert.Empty(t, llmService.Info(ctx).Platforms[0].Models)
+	assert.Empty(t, llmService.Info(ctx).Platforms[0].Models, "the models are cached")
+
+	require.NoError(t, manager.PullModel(ctx, "", "gemma3:4b", func(PullProgress) error { return nil }))
+	models := llmService.Info(ctx).Platforms[0].Models
+	require.Len(t, models, 1, "the pulled model is listed")
+	assert.Equal(t, "gemma3:4b", models[0].Name)
+	assert.Equal(t, []Capability{CapabilityCompletion, CapabilityJSON}, models[0].Capabilities)
+
+	require.NoError(t, manager.DeleteModel(ctx, "", "gemma3:4b"))
+	assert.Empty(t, llmService.Info(ctx).Platforms[0].Models, "the deleted model isn't listed")
+
+	// The description of the deleted model is dropped from the platform of the service
+	_, err := describeModel(ctx, platforms[0], "gemma3:4b", "")
+	require.NoError(t, err)
+	mockClient.AssertNumberOfCalls(t, "ShowModel", 2)
+	mockClient.AssertExpectations(t)
+}
+
+func TestModelManagerManagesAccountServers(t *testing.T) {
+	ctx := context.Background()
+	cfg := &Config{
+		Platform: OllamaPlatform,
+		Ollama:   OllamaConfig{URL: "http://localhost:11434", Model: "gemma3:4b"},
+		Cache:    CacheConfig{Enabled: true},
+	}
+	platforms := NewPlatforms(cfg, NewMemoryCacheStorage())
+	llmService := newBudgetService(newService(cfg, platforms), nil)
+
+	configuredClient := new(MockOllamaClient)
+	configuredClient.On("ListModels", mock.Anything).Return([]*ModelInfo{}, nil).Once()
+	accountClient := new(MockOllamaClient)
+	accountClient.On("ShowModel", mock.Anything, "qwen3:8b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion},
+	}, nil)
+	accountClient.On("PullModel", mock.Anything, "qwen3:8b", mock.Anything).Return(nil)
+
+	ollama := unwrapOllamaPlatform(platforms[0])
+	ollama.client = configuredClient
+	ollama.clients.newClient = func(url string, timeout time.Duration) (OllamaClient, error) {
+		assert.Equal(t, "http://gpu-box:11434", url)
+		return accountClient, nil
+	}
+
+	manager := NewModelManager(llmService)
+	require.NotNil(t, manager)
+
+	assert.Empty(t, llmService.Info(ctx).Platforms[0].Models)
+	_, err := describeModel(ctx, platforms[0], "qwen3:8b", "http://gpu-box:11434/")
+	require.NoError(t, err)
+
+	require.NoError(t, manager.PullModel(ctx, "http://gpu-box:11434", "qwen3:8b", func(PullProgress) error { return nil }))
+
+	// The description of the pulled model is dropped for the server of the account
+	_, err = describeModel(ctx, platforms[0], "qwen3:8b", "http://gpu-box:11434")
+	require.NoError(t, err)
+	accountClient.AssertNumberOfCalls(t, "ShowModel", 2)
+	accountClient.AssertExpectations(t)
+
+	// The models of the configured server are still cached
+	assert.Empty(t, llmService.Info(ctx).Platforms[0].Models)
+	configuredClient.AssertExpectations(t)
+	configuredClient.AssertNotCalled(t, "PullModel", mock.Anything, mock.Anything, mock.Anything)
+}
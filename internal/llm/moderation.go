@@ -0,0 +1,400 @@
+package llm
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// ModerationAction is what happens to an LLM response the moderation finds unsafe
+	ModerationAction string
+
+	// ModerationConfig holds the configuration of the moderation of the LLM responses
+	ModerationConfig struct {
+		Enabled bool `json:"enabled"`
+		// Action is ModerationFlag or ModerationBlock
+		Action ModerationAction `json:"action"`
+		// Words are matched as whole words ignoring the case, Patterns are regular expressions
+		Words    []string `json:"words"`
+		Patterns []string `json:"patterns"`
+		// Judge asks the model whether the responses that pass the word list and patterns are safe,
+		// JudgeModel picks another model of the platform for it
+		Judge      bool   `json:"judge"`
+		JudgeModel string `json:"judgeModel"`
+	}
+
+	// ModerationError is returned instead of a response the moderation blocked
+	ModerationError struct {
+		Reason string
+		// Source is the classifier that blocked the response, see domain.Moderation
+		Source string
+	}
+
+	// moderatedPlatform reviews the responses of the delegate before they reach the learners.
+	// Unsafe responses are either blocked or returned flagged with the reason in their usage.
+	moderatedPlatform struct {
+		delegate   Platform
+		cfg        ModerationConfig
+		classifier *moderationClassifier
+	}
+
+	// moderationClassifier flags the text matching the word list or one of the patterns
+	moderationClassifier struct {
+		words    *regexp.Regexp
+		patterns []*regexp.Regexp
+	}
+
+	// moderationVerdict is the answer of the judge
+	moderationVerdict struct {
+		Safe   bool   `json:"safe"`
+		Reason string `json:"reason,omitempty"`
+	}
+)
+
+const (
+	// ModerationFlag returns unsafe responses flagged for a parent review
+	ModerationFlag ModerationAction = "flag"
+	// ModerationBlock returns a ModerationError instead of unsafe responses
+	ModerationBlock ModerationAction = "block"
+
+	moderationSourceLocal = "local"
+	moderationSourceJudge = "judge"
+
+	// moderationStreamWindow is how much of the streamed text is held back from the handler until the text after it
+	// is classified, so that words split across chunks are found before any of them is streamed
+	moderationStreamWindow = 256
+
+	moderationJudgePrompt = `You review content written by an AI tutor for children aged 6 to 12.
+The content is unsafe when it is sexual, violent, hateful, encourages self-harm, dangerous activities or the use of drugs, alcohol or weapons, contains profanity or asks the child for personal information.
+Answer with a JSON object, {"safe": true} when the content is appropriate for the children, {"safe": false, "reason": "..."} with a short reason otherwise.`
+)
+
+var (
+	ErrContentBlocked = errors.New("content blocked by moderation")
+
+	//go:embed moderation_words.txt
+	defaultModerationWordsText string
+
+	moderationVerdictSchema = JSONSchemaOf(moderationVerdict{})
+)
+
+// newModeratedPlatform wraps the platform with the moderation, invalid patterns are skipped
+func newModeratedPlatform(delegate Platform, cfg ModerationConfig) Platform {
+	if cfg.Action != ModerationBlock {
+		cfg.Action = ModerationFlag
+	}
+
+	return &moderatedPlatform{
+		delegate:   delegate,
+		cfg:        cfg,
+		classifier: newModerationClassifier(cfg.Words, cfg.Patterns),
+	}
+}
+
+// Type returns the platform type of the delegate
+func (m *moderatedPlatform) Type() PlatformType {
+	return m.delegate.Type()
+}
+
+// Unwrap returns the decorated platform
+func (m *moderatedPlatform) Unwrap() Platform {
+	return m.delegate
+}
+
+// Models lists the models of the delegate
+func (m *moderatedPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	return m.delegate.Models(ctx)
+}
+
+// Chat implements the Platform interface with moderation
+func (m *moderatedPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return m.moderate(ctx, params, nil, func(StreamHandler) (*ChatResponse, error) {
+		return m.delegate.Chat(ctx, params)
+	})
+}
+
+// ChatStream implements the Platform interface with moderation, see moderate for what reaches the handler
+func (m *moderatedPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return m.moderate(ctx, params, handler, func(handler StreamHandler) (*ChatResponse, error) {
+		return m.delegate.ChatStream(ctx, params, handler)
+	})
+}
+
+// ChatWithHistory implements the Platform interface with moderation
+func (m *moderatedPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	return m.moderate(ctx, params, nil, func(StreamHandler) (*ChatResponse, error) {
+		return m.delegate.ChatWithHistory(ctx, messages, params)
+	})
+}
+
+// ChatWithHistoryStream implements the Platform interface with moderation, see moderate for what reaches the handler
+func (m *moderatedPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return m.moderate(ctx, params, handler, func(handler StreamHandler) (*ChatResponse, error) {
+		return m.delegate.ChatWithHistoryStream(ctx, messages, params, handler)
+	})
+}
+
+// DescribeImage implements the Platform interface with moderation of the description
+func (m *moderatedPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
+	response, err := m.delegate.DescribeImage(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	reviewed, err := m.review(ctx, &params.ChatParameters, &ChatResponse{Response: response.Description, Usage: response.Usage})
+	if err != nil {
+		return nil, err
+	}
+	return &DescribeImageResponse{Description: reviewed.Response, Usage: reviewed.Usage}, nil
+}
+
+// Embed embeds with the delegate, embeddings are never shown to anyone
+func (m *moderatedPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	return m.delegate.Embed(ctx, params)
+}
+
+// moderate calls the delegate and reviews its response. While streaming, the last moderationStreamWindow bytes
+// are held back until the text after them is classified, so a match of the word list or a pattern never reaches
+// the handler and the rest of the response is held back from it. The held back end of the response is only
+// streamed once the whole response is reviewed, the judge included.
+func (m *moderatedPlatform) moderate(ctx context.Context, params *ChatParameters, handler StreamHandler, call func(handler StreamHandler) (*ChatResponse, error)) (*ChatResponse, error) {
+	var moderatedHandler StreamHandler
+	var streamed strings.Builder
+	emitted := 0
+	held := false
+	if handler != nil {
+		moderatedHandler = func(chunk string) error {
+			if held {
+				return nil
+			}
+
+			streamed.WriteString(chunk)
+			text := streamed.String()
+			if moderation := m.classifier.classify(text[max(emitted-moderationStreamWindow, 0):]); moderation != nil {
+				log.Warn().Str("reason", moderation.Reason).Msg("Holding back the rest of a streamed LLM response")
+				held = true
+				return nil
+			}
+
+			end := len(text) - moderationStreamWindow
+			for end > emitted && !utf8.RuneStart(text[end]) {
+				end--
+			}
+			if end <= emitted {
+				return nil
+			}
+			safe := text[emitted:end]
+			emitted = end
+			return handler(safe)
+		}
+	}
+
+	response, err := call(moderatedHandler)
+	if err != nil {
+		return nil, err
+	}
+	reviewed, err := m.review(ctx, params, response)
+	if err != nil {
+		return nil, err
+	}
+
+	if handler != nil && !held && !isModerated(reviewed) && streamed.Len() > emitted {
+		if err := handler(streamed.String()[emitted:]); err != nil {
+			return nil, err
+		}
+	}
+	return reviewed, nil
+}
+
+// isModerated returns whether the review flagged the response
+func isModerated(response *ChatResponse) bool {
+	return response != nil && response.Usage != nil && response.Usage.Moderation != nil
+}
+
+// review classifies the response, then asks the judge if enabled. The returned response is a copy,
+// the response of the delegate may be shared with the cache.
+func (m *moderatedPlatform) review(ctx context.Context, params *ChatParameters, response *ChatResponse) (*ChatResponse, error) {
+	if response == nil || strings.TrimSpace(response.Response) == "" {
+		return response, nil
+	}
+
+	reviewed := *response
+	usage := domain.Usage{}
+	if response.Usage != nil {
+		usage = *response.Usage
+	}
+	reviewed.Usage = &usage
+
+	moderation := m.classifier.classify(response.Response)
+	if moderation == nil && m.cfg.Judge {
+		var judgeUsage *domain.Usage
+		moderation, judgeUsage = m.judge(ctx, params, response.Response)
+		// The judge is paid for like the response, it is free when cached
+		if judgeUsage != nil && !judgeUsage.CacheHit {
+			usage.PromptTokens += judgeUsage.PromptTokens
+			usage.CachedPromptTokens += judgeUsage.CachedPromptTokens
+			usage.CompletionTokens += judgeUsage.CompletionTokens
+			usage.TotalTokens += judgeUsage.TotalTokens
+		}
+	}
+	if moderation == nil {
+		return &reviewed, nil
+	}
+
+	log.Warn().
+		Str("platform", string(m.delegate.Type())).
+		Str("accountId", params.AccountID).
+		Str("purpose", string(params.Purpose)).
+		Str("source", moderation.Source).
+		Str("reason", moderation.Reason).
+		Str("action", string(m.cfg.Action)).
+		Msg("Moderation found an unsafe LLM response")
+
+	if m.cfg.Action == ModerationBlock {
+		return nil, &ModerationError{Reason: moderation.Reason, Source: moderation.Source}
+	}
+
+	usage.Moderation = moderation
+	return &reviewed, nil
+}
+
+// judge asks the model whether the content is safe. Content the judge can't review is flagged,
+// the parent reviews it rather than the learner seeing it unreviewed.
+func (m *moderatedPlatform) judge(ctx context.Context, params *ChatParameters, content string) (*domain.Moderation, *domain.Usage) {
+	temperature := 0.0
+	judgeParams := &ChatParameters{
+		Prompt:       content,
+		SystemPrompt: moderationJudgePrompt,
+		Model:        params.Model,
+		ServerURL:    params.ServerURL,
+		AccountID:    params.AccountID,
+		UserID:       params.UserID,
+		Purpose:      params.Purpose,
+		ResponseFormat: &ResponseFormat{
+			Type:   ResponseFormatJSONSchema,
+			Name:   "moderation_verdict",
+			Schema: moderationVerdictSchema,
+		},
+		Sampling:  domain.SamplingParameters{Temperature: &temperature},
+		LocalOnly: params.LocalOnly,
+		redactor:  params.redactor,
+	}
+	if m.cfg.JudgeModel != "" {
+		judgeParams.Model = m.cfg.JudgeModel
+	}
+
+	response, err := m.delegate.Chat(ctx, judgeParams)
+	if err != nil {
+		log.Warn().Err(err).Str("model", judgeParams.Model).Msg("Moderation judge failed, flagging the response")
+		return &domain.Moderation{Reason: "the moderation judge could not review the content", Source: moderationSourceJudge}, nil
+	}
+
+	var verdict moderationVerdict
+	if err := json.Unmarshal([]byte(strings.TrimSpace(response.Response)), &verdict); err != nil {
+		log.Warn().Err(err).Str("verdict", response.Response).Msg("Invalid moderation judge verdict, flagging the response")
+		return &domain.Moderation{Reason: "the moderation judge gave no valid verdict", Source: moderationSourceJudge}, response.Usage
+	}
+	if verdict.Safe {
+		return nil, response.Usage
+	}
+
+	reason := strings.TrimSpace(verdict.Reason)
+	if reason == "" {
+		reason = "the moderation judge found the content unsafe"
+	}
+	return &domain.Moderation{Reason: reason, Source: moderationSourceJudge}, response.Usage
+}
+
+// newModerationClassifier compiles the word list and the patterns, invalid patterns are skipped
+func newModerationClassifier(words, patterns []string) *moderationClassifier {
+	c := &moderationClassifier{}
+
+	alternatives := make([]string, 0, len(words))
+	for _, word := range words {
+		fields := strings.Fields(word)
+		if len(fields) == 0 {
+			continue
+		}
+		for i, field := range fields {
+			fields[i] = regexp.QuoteMeta(field)
+		}
+		// The words of a phrase may be separated by any whitespace
+		alternatives = append(alternatives, strings.Join(fields, `\s+`))
+	}
+	if len(alternatives) > 0 {
+		c.words = regexp.MustCompile(`(?i)\b(?:` + strings.Join(alternatives, "|") + `)\b`)
+	}
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Warn().Err(err).Str("pattern", pattern).Msg("Skipping invalid moderation pattern")
+			continue
+		}
+		c.patterns = append(c.patterns, re)
+	}
+
+	return c
+}
+
+// classify returns why the text is unsafe, nil when it is safe
+func (c *moderationClassifier) classify(text string) *domain.Moderation {
+	if c.words != nil {
+		if match := c.words.FindString(text); match != "" {
+			return &domain.Moderation{Reason: fmt.Sprintf("contains %q", strings.ToLower(match)), Source: moderationSourceLocal}
+		}
+	}
+
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(text) {
+			return &domain.Moderation{Reason: fmt.Sprintf("matches the pattern %q", pattern.String()), Source: moderationSourceLocal}
+		}
+	}
+
+	return nil
+}
+
+func (e *ModerationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrContentBlocked, e.Reason)
+}
+
+func (e *ModerationError) Unwrap() error {
+	return ErrContentBlocked
+}
+
+// defaultModerationWords returns the embedded word list of the moderation
+func defaultModerationWords() []string {
+	return parseModerationList(defaultModerationWordsText)
+}
+
+// loadModerationList reads a word list or a pattern list file
+func loadModerationList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read moderation list: %w", err)
+	}
+	return parseModerationList(string(data)), nil
+}
+
+// parseModerationList returns the entries of a list, one per line, skipping blank lines and # comments
+func parseModerationList(text string) []string {
+	var entries []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries
+}
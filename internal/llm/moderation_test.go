@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// moderationTestPlatform streams the chunks as its response and answers the judge with the verdict
+type moderationTestPlatform struct {
+	echoPlatform
+	chunks   []string
+	verdict  string
+	judgeErr error
+	// usage is shared by the responses, like the responses of the cache
+	usage *domain.Usage
+	// judgeParams are the parameters of the last request to the judge
+	judgeParams *ChatParameters
+}
+
+func (p *moderationTestPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return p.ChatStream(ctx, params, nil)
+}
+
+func (p *moderationTestPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	if params.SystemPrompt == moderationJudgePrompt {
+		p.judgeParams = params
+		if p.judgeErr != nil {
+			return nil, p.judgeErr
+		}
+		return &ChatResponse{Response: p.verdict, Usage: &domain.Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 110}}, nil
+	}
+
+	for _, chunk := range p.chunks {
+		if handler != nil {
+			if err := handler(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &ChatResponse{Response: strings.Join(p.chunks, ""), Usage: p.usage}, nil
+}
+
+func newModerationTestPlatform(chunks ...string) *moderationTestPlatform {
+	return &moderationTestPlatform{
+		chunks: chunks,
+		usage:  &domain.Usage{PromptTokens: 20, CompletionTokens: 5, TotalTokens: 25},
+	}
+}
+
+func TestModerationClassifier(t *testing.T) {
+	classifier := newModerationClassifier(
+		[]string{"shit", "kill yourself", " ", "self-harm"},
+		[]string{`\b\d{3}-\d{4}\b`, "(unclosed"},
+	)
+
+	tests := []struct {
+		text   string
+		reason string
+	}{
+		{text: "What is 3 + 4?"},
+		{text: "Shiitake mushrooms grow on logs.", reason: ""},
+		{text: "Oh SHIT, that's wrong", reason: `contains "shit"`},
+		{text: "You should kill\n yourself", reason: `contains "kill\n yourself"`},
+		{text: "Practice your skills yourself"},
+		{text: "Talk to a grown-up about self-harm", reason: `contains "self-harm"`},
+		{text: "Call me at 555-1234", reason: `matches the pattern "\\b\\d{3}-\\d{4}\\b"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			moderation := classifier.classify(tt.text)
+			if tt.reason == "" {
+				assert.Nil(t, moderation)
+				return
+			}
+			require.NotNil(t, moderation)
+			assert.Equal(t, tt.reason, moderation.Reason)
+			assert.Equal(t, moderationSourceLocal, moderation.Source)
+		})
+	}
+
+	assert.Len(t, classifier.patterns, 1, "invalid patterns are skipped")
+	assert.NotEmpty(t, defaultModerationWords())
+}
+
+func TestModeratedPlatformFlagsUnsafeResponses(t *testing.T) {
+	delegate := newModerationTestPlatform("This is ", "shit.")
+	platform := newModeratedPlatform(delegate, ModerationConfig{Enabled: true, Words: []string{"shit"}})
+
+	response, err := platform.Chat(context.Background(), &ChatParameters{Prompt: "Hello"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "This is shit.", response.Response)
+	require.NotNil(t, response.Usage.Moderation)
+	assert.Equal(t, `contains "shit"`, response.Usage.Moderation.Reason)
+	assert.Equal(t, 25, response.Usage.TotalTokens)
+	assert.Nil(t, delegate.usage.Moderation, "the usage of the delegate is not changed")
+	assert.Nil(t, delegate.judgeParams, "the judge is not asked about flagged responses")
+}
+
+func TestModeratedPlatformBlocksUnsafeResponses(t *testing.T) {
+	delegate := newModerationTestPlatform("This is shit.")
+	platform := newModeratedPlatform(delegate, ModerationConfig{Enabled: true, Action: ModerationBlock, Words: []string{"shit"}})
+
+	_, err := platform.Chat(context.Background(), &ChatParameters{})
+
+	require.ErrorIs(t, err, ErrContentBlocked)
+	var moderationErr *ModerationError
+	require.True(t, errors.As(err, &moderationErr))
+	assert.Equal(t, moderationSourceLocal, moderationErr.Source)
+}
+
+func TestModeratedPlatformHoldsBackUnsafeStreams(t *testing.T) {
+	delegate := newModerationTestPlatform("Hello ", "you sh", "it, bye", " now")
+	platform := newModeratedPlatform(delegate, ModerationConfig{Enabled: true, Words: []string{"shit"}})
+
+	var streamed []string
+	response, err := platform.ChatStream(context.Background(), &ChatParameters{}, func(chunk string) error {
+		streamed = append(streamed, chunk)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Empty(t, streamed, "the unsafe word was held back with the text before it")
+	assert.Equal(t, "Hello you shit, bye now", response.Response)
+	assert.NotNil(t, response.Usage.Moderation)
+}
+
+func TestModeratedPlatformStreamsBehindTheWindow(t *testing.T) {
+	safe := strings.Repeat("Fractions are parts of a whole. ", 20)
+
+	t.Run("safe stream", func(t *testing.T) {
+		delegate := newModerationTestPlatform(safe, "Well done!")
+		platform := newModeratedPlatform(delegate, ModerationConfig{Enabled: true, Words: []string{"shit"}})
+
+		var streamed []string
+		response, err := platform.ChatStream(context.Background(), &ChatParameters{}, func(chunk string) error {
+			streamed = append(streamed, chunk)
+			return nil
+		})
+
+		require.NoError(t, err)
+		require.Len(t, streamed, 3, "the end of the response is streamed once it is reviewed")
+		assert.Len(t, streamed[0], len(safe)-moderationStreamWindow)
+		assert.Equal(t, response.Response, strings.Join(streamed, ""))
+	})
+
+	t.Run("unsafe chunk", func(t *testing.T) {
+		delegate := newModerationTestPlatform(safe, "you sh", "it, bye")
+		platform := newModeratedPlatform(delegate, ModerationConfig{Enabled: true, Words: []string{"shit"}})
+
+		var streamed strings.Builder
+		_, err := platform.ChatStream(context.Background(), &ChatParameters{}, func(chunk string) error {
+			streamed.WriteString(chunk)
+			return nil
+		})
+
+		require.NoError(t, err)
+		text := safe + "you sh"
+		assert.Equal(t, text[:len(text)-moderationStreamWindow], streamed.String(), "the window before the unsafe word is held back")
+	})
+
+	t.Run("judged unsafe", func(t *testing.T) {
+		delegate := newModerationTestPlatform(safe)
+		delegate.verdict = `{"safe": false, "reason": "scary violence"}`
+		platform := newModeratedPlatform(delegate, ModerationConfig{Enabled: true, Judge: true})
+
+		var streamed strings.Builder
+		response, err := platform.ChatStream(context.Background(), &ChatParameters{}, func(chunk string) error {
+			streamed.WriteString(chunk)
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.NotNil(t, response.Usage.Moderation)
+		assert.Equal(t, safe[:len(safe)-moderationStreamWindow], streamed.String(), "the end of the response isn't streamed")
+	})
+}
+
+func TestModeratedPlatformJudge(t *testing.T) {
+	tests := []struct {
+		name     string
+		verdict  string
+		judgeErr error
+		reason   string
+	}{
+		{name: "safe", verdict: `{"safe": true}`},
+		{name: "unsafe", verdict: `{"safe": false, "reason": "scary violence"}`, reason: "scary violence"},
+		{name: "invalid verdict", verdict: "I think it's fine", reason: "the moderation judge gave no valid verdict"},
+		{name: "judge failure", judgeErr: errors.New("connection refused"), reason: "the moderation judge could not review the content"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delegate := newModerationTestPlatform("The dragon fights the knight.")
+			delegate.verdict = tt.verdict
+			delegate.judgeErr = tt.judgeErr
+			platform := newModeratedPlatform(delegate, ModerationConfig{Enabled: true, Judge: true, JudgeModel: "judge-model"})
+
+			response, err := platform.Chat(context.Background(), &ChatParameters{Model: "model", AccountID: "acc1"})
+
+			require.NoError(t, err)
+			require.NotNil(t, delegate.judgeParams)
+			assert.Equal(t, "The dragon fights the knight.", delegate.judgeParams.Prompt)
+			assert.Equal(t, "judge-model", delegate.judgeParams.Model)
+			assert.Equal(t, "acc1", delegate.judgeParams.AccountID)
+			require.NotNil(t, delegate.judgeParams.ResponseFormat)
+
+			if tt.reason == "" {
+				assert.Nil(t, response.Usage.Moderation)
+			} else {
+				require.NotNil(t, response.Usage.Moderation)
+				assert.Equal(t, tt.reason, response.Usage.Moderation.Reason)
+				assert.Equal(t, moderationSourceJudge, response.Usage.Moderation.Source)
+			}
+			if tt.judgeErr == nil {
+				assert.Equal(t, 135, response.Usage.TotalTokens, "the judge tokens are added to the usage")
+			}
+		})
+	}
+}
+
+func TestDecorateAddsModeration(t *testing.T) {
+	platform := decorate(newEchoPlatform(), &Config{Moderation: ModerationConfig{Enabled: true}}, nil)
+	assert.IsType(t, &moderatedPlatform{}, platform)
+
+	platform = decorate(newEchoPlatform(), &Config{}, nil)
+	assert.IsType(t, &echoPlatform{}, platform)
+}
@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -13,10 +14,27 @@ import (
 
 // OllamaClient defines the interface for interacting with the Ollama API
 type OllamaClient interface {
-	// ChatWithModel sends a chat request to the Ollama API
-	ChatWithModel(ctx context.Context, modelName string, messages []api.Message, stream bool, options map[string]interface{}) (*api.ChatResponse, error)
+	// ChatWithModel sends a chat request to the Ollama API.
+	// The format is either empty, the string "json" or a JSON Schema, see https://ollama.com/blog/structured-outputs
+	// The model may answer with calls of the tools instead of content.
+	ChatWithModel(ctx context.Context, modelName string, messages []api.Message, stream bool, options map[string]interface{}, format json.RawMessage, tools api.Tools) (*api.ChatResponse, error)
+	// ChatWithModelStream sends a streaming chat request to the Ollama API, calling fn for every partial response.
+	// It returns the final response, which carries the token counts but no message content.
+	ChatWithModelStream(ctx context.Context, modelName string, messages []api.Message, options map[string]interface{}, format json.RawMessage, tools api.Tools, fn func(api.ChatResponse) error) (*api.ChatResponse, error)
 	// ListModels lists all models available on the Ollama server
-	ListModels() ([]*ModelInfo, error)
+	ListModels(ctx context.Context) ([]*ModelInfo, error)
+	// Embed returns the embeddings of the texts, in the order of the texts
+	Embed(ctx context.Context, modelName string, texts []string) (*api.EmbedResponse, error)
+	// PullModel downloads the model to the Ollama server, calling fn with the progress of the download.
+	// Pulling a large model takes long, it is bounded by the context only.
+	PullModel(ctx context.Context, modelName string, fn func(api.ProgressResponse) error) error
+	// DeleteModel removes the model from the Ollama server
+	DeleteModel(ctx context.Context, modelName string) error
+	// ShowModel returns the details of the model, such as its parameters, model info and capabilities
+	ShowModel(ctx context.Context, modelName string) (*api.ShowResponse, error)
+	// LoadModel loads the model in memory and keeps it loaded for keepAlive, a negative duration keeps it
+	// loaded until the server stops and zero unloads it
+	LoadModel(ctx context.Context, modelName string, keepAlive time.Duration) error
 }
 
 // DefaultOllamaClient is the default implementation of OllamaClient
@@ -44,20 +62,25 @@ func NewOllamaClient(baseURLStr string, timeout time.Duration) (OllamaClient, er
 
 // createAPIClient creates a new Ollama API client
 func (c *DefaultOllamaClient) createAPIClient() *api.Client {
+	return c.createAPIClientWithTimeout(c.timeout)
+}
+
+// createAPIClientWithTimeout creates a new Ollama API client whose requests time out after the timeout, zero means no timeout
+func (c *DefaultOllamaClient) createAPIClientWithTimeout(timeout time.Duration) *api.Client {
 	transport := &http.Transport{
 		DisableKeepAlives: false,
 		MaxIdleConns:      100,
-		IdleConnTimeout:   defaultOllamaTimeout + 20*time.Second,
+		IdleConnTimeout:   c.timeout + 20*time.Second,
 	}
 
 	return api.NewClient(c.baseURL, &http.Client{
-		Timeout:   c.timeout,
+		Timeout:   timeout,
 		Transport: transport,
 	})
 }
 
 // ChatWithModel sends a chat request to the Ollama API
-func (c *DefaultOllamaClient) ChatWithModel(ctx context.Context, modelName string, messages []api.Message, stream bool, options map[string]interface{}) (*api.ChatResponse, error) {
+func (c *DefaultOllamaClient) ChatWithModel(ctx context.Context, modelName string, messages []api.Message, stream bool, options map[string]interface{}, format json.RawMessage, tools api.Tools) (*api.ChatResponse, error) {
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
@@ -67,6 +90,8 @@ func (c *DefaultOllamaClient) ChatWithModel(ctx context.Context, modelName strin
 		Messages: messages,
 		Stream:   &stream,
 		Options:  options,
+		Format:   format,
+		Tools:    tools,
 	}
 
 	log.Debug().
@@ -99,9 +124,49 @@ func (c *DefaultOllamaClient) ChatWithModel(ctx context.Context, modelName strin
 	return finalResponse, nil
 }
 
+// ChatWithModelStream sends a streaming chat request to the Ollama API
+func (c *DefaultOllamaClient) ChatWithModelStream(ctx context.Context, modelName string, messages []api.Message, options map[string]interface{}, format json.RawMessage, tools api.Tools, fn func(api.ChatResponse) error) (*api.ChatResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stream := true
+	apiClient := c.createAPIClient()
+	req := &api.ChatRequest{
+		Model:    modelName,
+		Messages: messages,
+		Stream:   &stream,
+		Options:  options,
+		Format:   format,
+		Tools:    tools,
+	}
+
+	log.Debug().
+		Str("model", modelName).
+		Int("messages", len(messages)).
+		Msg("Sending streaming chat request to Ollama")
+
+	var finalResponse *api.ChatResponse
+	err := apiClient.Chat(ctx, req, func(response api.ChatResponse) error {
+		if response.Done {
+			finalResponse = &response
+		}
+		return fn(response)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream chat with Ollama: %w", err)
+	}
+
+	if finalResponse == nil {
+		return nil, fmt.Errorf("no response received from Ollama")
+	}
+
+	return finalResponse, nil
+}
+
 // ListModels lists all models available on the Ollama server
-func (c *DefaultOllamaClient) ListModels() ([]*ModelInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+func (c *DefaultOllamaClient) ListModels(ctx context.Context) ([]*ModelInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
 	apiClient := c.createAPIClient()
@@ -114,9 +179,10 @@ func (c *DefaultOllamaClient) ListModels() ([]*ModelInfo, error) {
 	result := make([]*ModelInfo, 0, len(models.Models))
 	for _, model := range models.Models {
 		modelInfo := &ModelInfo{
-			Name:      model.Name,
-			SizeHuman: formatSize(model.Size),
-			IsDefault: model.Name == defaultOllamaModel,
+			Name:          model.Name,
+			SizeHuman:     formatSize(model.Size),
+			IsDefault:     model.Name == defaultOllamaModel,
+			ParameterSize: model.Details.ParameterSize,
 		}
 		result = append(result, modelInfo)
 	}
@@ -124,6 +190,90 @@ func (c *DefaultOllamaClient) ListModels() ([]*ModelInfo, error) {
 	return result, nil
 }
 
+// Embed sends an embed request to the Ollama API
+func (c *DefaultOllamaClient) Embed(ctx context.Context, modelName string, texts []string) (*api.EmbedResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	apiClient := c.createAPIClient()
+	resp, err := apiClient.Embed(ctx, &api.EmbedRequest{
+		Model: modelName,
+		Input: texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed with Ollama: %w", err)
+	}
+
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings from Ollama, got %d", len(texts), len(resp.Embeddings))
+	}
+
+	return resp, nil
+}
+
+// PullModel downloads the model to the Ollama server, reporting the progress of the download to fn
+func (c *DefaultOllamaClient) PullModel(ctx context.Context, modelName string, fn func(api.ProgressResponse) error) error {
+	stream := true
+	apiClient := c.createAPIClientWithTimeout(0)
+
+	log.Debug().Str("model", modelName).Msg("Pulling model with Ollama")
+
+	if err := apiClient.Pull(ctx, &api.PullRequest{Model: modelName, Stream: &stream}, fn); err != nil {
+		return fmt.Errorf("failed to pull model with Ollama: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteModel removes the model from the Ollama server
+func (c *DefaultOllamaClient) DeleteModel(ctx context.Context, modelName string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	apiClient := c.createAPIClient()
+	if err := apiClient.Delete(ctx, &api.DeleteRequest{Model: modelName}); err != nil {
+		return fmt.Errorf("failed to delete model with Ollama: %w", err)
+	}
+
+	return nil
+}
+
+// ShowModel returns the details of the model
+func (c *DefaultOllamaClient) ShowModel(ctx context.Context, modelName string) (*api.ShowResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	apiClient := c.createAPIClient()
+	resp, err := apiClient.Show(ctx, &api.ShowRequest{Model: modelName})
+	if err != nil {
+		return nil, fmt.Errorf("failed to show model with Ollama: %w", err)
+	}
+
+	return resp, nil
+}
+
+// LoadModel sends a generate request without prompt, which loads the model and sets how long it stays loaded
+func (c *DefaultOllamaClient) LoadModel(ctx context.Context, modelName string, keepAlive time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stream := false
+	apiClient := c.createAPIClient()
+	req := &api.GenerateRequest{
+		Model:     modelName,
+		Stream:    &stream,
+		KeepAlive: &api.Duration{Duration: keepAlive},
+	}
+
+	log.Debug().Str("model", modelName).Dur("keepAlive", keepAlive).Msg("Loading model with Ollama")
+
+	if err := apiClient.Generate(ctx, req, func(api.GenerateResponse) error { return nil }); err != nil {
+		return fmt.Errorf("failed to load model with Ollama: %w", err)
+	}
+
+	return nil
+}
+
 // formatSize formats the size in bytes to a human-readable format
 func formatSize(sizeInBytes int64) string {
 	const (
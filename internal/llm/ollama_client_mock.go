@@ -2,6 +2,8 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/ollama/ollama/api"
 	"github.com/stretchr/testify/mock"
@@ -10,6 +12,10 @@ import (
 // MockOllamaClient is a mock implementation of OllamaClient for testing
 type MockOllamaClient struct {
 	mock.Mock
+	// StreamChunks are the partial responses emitted by ChatWithModelStream
+	StreamChunks []api.ChatResponse
+	// PullProgress is the progress emitted by PullModel
+	PullProgress []api.ProgressResponse
 }
 
 // NewMockOllamaClient creates a new mock Ollama client
@@ -18,8 +24,8 @@ func NewMockOllamaClient() *MockOllamaClient {
 }
 
 // ChatWithModel implements the OllamaClient interface for testing
-func (m *MockOllamaClient) ChatWithModel(ctx context.Context, modelName string, messages []api.Message, stream bool, options map[string]interface{}) (*api.ChatResponse, error) {
-	args := m.Called(ctx, modelName, messages, stream, options)
+func (m *MockOllamaClient) ChatWithModel(ctx context.Context, modelName string, messages []api.Message, stream bool, options map[string]interface{}, format json.RawMessage, tools api.Tools) (*api.ChatResponse, error) {
+	args := m.Called(ctx, modelName, messages, stream, options, format, tools)
 
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -28,7 +34,73 @@ func (m *MockOllamaClient) ChatWithModel(ctx context.Context, modelName string,
 	return args.Get(0).(*api.ChatResponse), args.Error(1)
 }
 
-func (m *MockOllamaClient) ListModels() ([]*ModelInfo, error) {
-	args := m.Called()
+// ChatWithModelStream implements the OllamaClient interface for testing.
+// Every partial response configured in StreamChunks is passed to fn before the final response is returned.
+func (m *MockOllamaClient) ChatWithModelStream(ctx context.Context, modelName string, messages []api.Message, options map[string]interface{}, format json.RawMessage, tools api.Tools, fn func(api.ChatResponse) error) (*api.ChatResponse, error) {
+	args := m.Called(ctx, modelName, messages, options, format, tools, fn)
+
+	for _, chunk := range m.StreamChunks {
+		if err := fn(chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*api.ChatResponse), args.Error(1)
+}
+
+func (m *MockOllamaClient) ListModels(ctx context.Context) ([]*ModelInfo, error) {
+	args := m.Called(ctx)
 	return args.Get(0).([]*ModelInfo), args.Error(1)
 }
+
+// Embed implements the OllamaClient interface for testing
+func (m *MockOllamaClient) Embed(ctx context.Context, modelName string, texts []string) (*api.EmbedResponse, error) {
+	args := m.Called(ctx, modelName, texts)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*api.EmbedResponse), args.Error(1)
+}
+
+// PullModel implements the OllamaClient interface for testing.
+// Every progress configured in PullProgress is passed to fn before the result is returned.
+func (m *MockOllamaClient) PullModel(ctx context.Context, modelName string, fn func(api.ProgressResponse) error) error {
+	args := m.Called(ctx, modelName, fn)
+
+	for _, progress := range m.PullProgress {
+		if err := fn(progress); err != nil {
+			return err
+		}
+	}
+
+	return args.Error(0)
+}
+
+// DeleteModel implements the OllamaClient interface for testing
+func (m *MockOllamaClient) DeleteModel(ctx context.Context, modelName string) error {
+	args := m.Called(ctx, modelName)
+	return args.Error(0)
+}
+
+// ShowModel implements the OllamaClient interface for testing
+func (m *MockOllamaClient) ShowModel(ctx context.Context, modelName string) (*api.ShowResponse, error) {
+	args := m.Called(ctx, modelName)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*api.ShowResponse), args.Error(1)
+}
+
+// LoadModel implements the OllamaClient interface for testing
+func (m *MockOllamaClient) LoadModel(ctx context.Context, modelName string, keepAlive time.Duration) error {
+	args := m.Called(ctx, modelName, keepAlive)
+	return args.Error(0)
+}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultOllamaClientPoolSize is the number of Ollama servers a pool keeps clients for
+const defaultOllamaClientPoolSize = 16
+
+// ollamaClientPool keeps one OllamaClient per server URL so accounts with their own
+// Ollama server don't create a new client for every request.
+// When the pool is full the least recently used client is dropped.
+type ollamaClientPool struct {
+	mutex   sync.Mutex
+	size    int
+	timeout time.Duration
+	clients map[string]*pooledOllamaClient
+	// newClient creates the client for a URL, it can be replaced in tests
+	newClient func(url string, timeout time.Duration) (OllamaClient, error)
+}
+
+// pooledOllamaClient is a client in the pool along with the last time it was handed out
+type pooledOllamaClient struct {
+	client   OllamaClient
+	lastUsed time.Time
+}
+
+// newOllamaClientPool creates a client pool holding up to size clients
+func newOllamaClientPool(size int, timeout time.Duration) *ollamaClientPool {
+	if size <= 0 {
+		size = defaultOllamaClientPoolSize
+	}
+
+	return &ollamaClientPool{
+		size:      size,
+		timeout:   timeout,
+		clients:   make(map[string]*pooledOllamaClient),
+		newClient: NewOllamaClient,
+	}
+}
+
+// get returns the client for the given server URL, creating it if needed
+func (p *ollamaClientPool) get(url string) (OllamaClient, error) {
+	key := normalizeOllamaURL(url)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if pooled, ok := p.clients[key]; ok {
+		pooled.lastUsed = time.Now()
+		return pooled.client, nil
+	}
+
+	client, err := p.newClient(key, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.clients) >= p.size {
+		p.evictOldest()
+	}
+
+	p.clients[key] = &pooledOllamaClient{
+		client:   client,
+		lastUsed: time.Now(),
+	}
+
+	log.Debug().Str("url", key).Int("poolSize", len(p.clients)).Msg("Added Ollama client to pool")
+
+	return client, nil
+}
+
+// evictOldest removes the least recently used client, the caller must hold the mutex
+func (p *ollamaClientPool) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, pooled := range p.clients {
+		if oldestKey == "" || pooled.lastUsed.Before(oldest) {
+			oldestKey = key
+			oldest = pooled.lastUsed
+		}
+	}
+
+	if oldestKey != "" {
+		delete(p.clients, oldestKey)
+		log.Debug().Str("url", oldestKey).Msg("Evicted Ollama client from pool")
+	}
+}
+
+// normalizeOllamaURL trims the URL so that equivalent URLs share a client
+func normalizeOllamaURL(url string) string {
+	return strings.TrimRight(strings.TrimSpace(url), "/")
+}
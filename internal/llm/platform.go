@@ -1,9 +1,13 @@
 package llm
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"sort"
+	"strings"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/rs/zerolog/log"
@@ -17,6 +21,13 @@ type (
 		Name      string `json:"name"`
 		SizeHuman string `json:"sizeHuman"`
 		IsDefault bool   `json:"isDefault"`
+		// Capabilities are the features the model supports, empty when the platform doesn't know the model
+		Capabilities []Capability `json:"capabilities,omitempty"`
+		// ContextLength is the context window of the model in tokens, zero when unknown
+		ContextLength int    `json:"contextLength,omitempty"`
+		ParameterSize string `json:"parameterSize,omitempty"`
+		// Price is the price of the model per million tokens, nil when the model has no price
+		Price *ModelPrice `json:"price,omitempty"`
 	}
 
 	DescribeImageResult struct {
@@ -24,12 +35,24 @@ type (
 		Usage       *domain.Usage `json:"usage"`
 	}
 
+	// StreamHandler receives incremental chunks of a streamed response in the order they are produced.
+	// Returning an error aborts the stream.
+	StreamHandler func(chunk string) error
+
 	Platform interface {
 		Type() PlatformType
-		Chat(params *ChatParameters) (*ChatResponse, error)
-		ChatWithHistory(messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error)
-		DescribeImage(params *DescribeImageParameters) (*DescribeImageResponse, error)
-		Models() ([]*ModelInfo, error)
+		Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error)
+		ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error)
+		// ChatStream behaves like Chat but delivers the response incrementally to the handler.
+		// The returned ChatResponse holds the complete response and usage once the stream is done.
+		ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error)
+		// ChatWithHistoryStream behaves like ChatWithHistory but delivers the response incrementally to the handler.
+		ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error)
+		DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error)
+		// Embed returns an embedding vector per text, in the order of the texts.
+		// Platforms without an embeddings API return ErrEmbeddingsNotSupported.
+		Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error)
+		Models(ctx context.Context) ([]*ModelInfo, error)
 	}
 
 	CacheParameters struct {
@@ -42,11 +65,52 @@ type (
 		SystemPrompt string           `json:"systemPrompt"`
 		Model        string           `json:"model"`
 		Cache        *CacheParameters `json:"cache"`
+		// ServerURL overrides the configured server of platforms that support it, e.g. an account's own Ollama server
+		ServerURL string `json:"serverUrl"`
+		// AccountID is the account the request is made for, its budget applies to the request
+		AccountID string `json:"accountId"`
+		// UserID and Purpose describe who made the request and why, they are recorded in the usage ledger
+		UserID  string  `json:"userId"`
+		Purpose Purpose `json:"purpose"`
+		// ResponseFormat asks for structured JSON output, nil means plain text
+		ResponseFormat *ResponseFormat `json:"responseFormat"`
+		// Sampling tunes the generation, platforms ignore the parameters they don't support
+		Sampling domain.SamplingParameters `json:"sampling"`
+		// Tools the model may ask to call instead of answering, platforms without tool calling ignore them
+		Tools []Tool `json:"tools"`
+		// Priority orders the request in the queue of a platform at its concurrency limit, nil derives it from the purpose
+		Priority *Priority `json:"priority"`
+		// OnQueued receives the position of the request while it waits for the platform
+		OnQueued QueueHandler `json:"-"`
+		// LocalOnly forbids cloud platforms, the account keeps its learner data on the local platforms
+		LocalOnly bool `json:"localOnly"`
+		// redactor redacts the personal identifiers of the account from the requests to cloud platforms, see redactionService
+		redactor *redactor
+	}
+
+	// Tool describes a function the model can call, the parameters are a JSON Schema object
+	Tool struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	}
+
+	// ResponseFormatType is the kind of structured output requested from the model
+	ResponseFormatType string
+
+	// ResponseFormat asks the model for JSON output, optionally matching a JSON Schema
+	ResponseFormat struct {
+		Type ResponseFormatType `json:"type"`
+		// Name identifies the schema, OpenAI requires it
+		Name   string         `json:"name,omitempty"`
+		Schema map[string]any `json:"schema,omitempty"`
 	}
 
 	ChatResponse struct {
 		Response string
 		Usage    *domain.Usage
+		// ToolCalls are the tools the model asks to call, the response is usually empty when there are any
+		ToolCalls []domain.ToolCall
 	}
 
 	DescribeImageParameters struct {
@@ -59,12 +123,33 @@ type (
 		Description string
 		Usage       *domain.Usage
 	}
+
+	// EmbedParameters holds the texts to embed, the model is an embedding model and defaults
+	// to the configured embedding model of the platform. Prompts and generation settings are ignored.
+	EmbedParameters struct {
+		ChatParameters
+		Texts []string
+	}
+
+	EmbedResponse struct {
+		Embeddings [][]float32
+		Usage      *domain.Usage
+	}
 )
 
 const (
 	OpenAIPlatform PlatformType = "openai"
 	EchoPlatform   PlatformType = "echo"
 	OllamaPlatform PlatformType = "ollama"
+	// AnthropicPlatform talks to the Anthropic Messages API
+	AnthropicPlatform PlatformType = "anthropic"
+)
+
+const (
+	// ResponseFormatJSON asks for any valid JSON object
+	ResponseFormatJSON ResponseFormatType = "json"
+	// ResponseFormatJSONSchema asks for JSON matching the schema of the response format
+	ResponseFormatJSONSchema ResponseFormatType = "json_schema"
 )
 
 var (
@@ -72,33 +157,180 @@ var (
 	ErrModelNotSpecified      = errors.New("model not specified")
 	ErrPromptEmpty            = errors.New("prompt cannot be empty")
 	ErrContextMissing         = errors.New("context missing required values")
+	ErrEmbedTextsEmpty        = errors.New("texts to embed cannot be empty")
+	ErrEmbeddingsNotSupported = errors.New("platform does not support embeddings")
 )
 
-// NewPlatform creates a new LLM platform based on the configuration
+// NewPlatform creates a new LLM platform based on the configuration.
+// With fallbacks configured the platform tries the chain of platforms in order.
 func NewPlatform(cfg *Config, cacheStorage CacheStorage) Platform {
-	var platform Platform
+	return newPlatformChain(cfg, cacheStorage, map[PlatformType]Platform{})
+}
+
+// NewPlatforms creates the default platform followed by the fallback platforms and the other configured platforms.
+// Unknown and duplicate platforms are skipped.
+func NewPlatforms(cfg *Config, cacheStorage CacheStorage) []Platform {
+	created := map[PlatformType]Platform{}
+	platforms := []Platform{newPlatformChain(cfg, cacheStorage, created)}
+	registered := map[PlatformType]bool{cfg.Platform: true}
+
+	for _, platformType := range append(append([]PlatformType{}, cfg.Fallbacks...), cfg.Platforms...) {
+		if registered[platformType] {
+			continue
+		}
+
+		platform, err := newDecoratedPlatform(platformType, cfg, cacheStorage, created)
+		if err != nil {
+			log.Warn().Err(err).Str("platform", string(platformType)).Msg("Skipping unknown LLM platform")
+			continue
+		}
+
+		registered[platformType] = true
+		platforms = append(platforms, platform)
+	}
+
+	return platforms
+}
+
+// newPlatformChain creates the default platform, wrapped with its fallbacks if any.
+// Created platforms are kept so that a fallback platform that is registered on its own is shared.
+func newPlatformChain(cfg *Config, cacheStorage CacheStorage, created map[PlatformType]Platform) Platform {
+	platform, err := newDecoratedPlatform(cfg.Platform, cfg, cacheStorage, created)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("Unknown platform: %s", cfg.Platform)
+		return nil
+	}
+
+	chain := []Platform{platform}
+	for _, platformType := range cfg.Fallbacks {
+		if platformType == cfg.Platform {
+			continue
+		}
+
+		fallback, err := newDecoratedPlatform(platformType, cfg, cacheStorage, created)
+		if err != nil {
+			log.Warn().Err(err).Str("platform", string(platformType)).Msg("Skipping unknown fallback LLM platform")
+			continue
+		}
+		chain = append(chain, fallback)
+	}
+
+	if len(chain) == 1 {
+		return platform
+	}
+	return newFallbackPlatform(chain, cfg.ModelMappings)
+}
 
-	switch cfg.Platform {
+// newDecoratedPlatform returns the created platform of the type, creating and decorating it if needed
+func newDecoratedPlatform(platformType PlatformType, cfg *Config, cacheStorage CacheStorage, created map[PlatformType]Platform) (Platform, error) {
+	if platform, ok := created[platformType]; ok {
+		return platform, nil
+	}
+
+	platform, err := newPlatformOfType(platformType, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	platform = decorate(platform, cfg, cacheStorage)
+	created[platformType] = platform
+	return platform, nil
+}
+
+// newPlatformOfType creates the platform of the given type without decorators
+func newPlatformOfType(platformType PlatformType, cfg *Config) (Platform, error) {
+	switch platformType {
 	case OpenAIPlatform:
-		platform = newOpenAIPlatform(cfg.OpenAI)
+		openAICfg := cfg.OpenAI
+		// The resilient platform retries, retrying in the client as well would multiply the attempts
+		openAICfg.disableClientRetries = cfg.Resilience.Enabled
+		return newOpenAIPlatform(openAICfg), nil
 	case OllamaPlatform:
-		platform = newOllamaPlatform(cfg.Ollama)
+		return newOllamaPlatform(cfg.Ollama), nil
 	case EchoPlatform:
-		platform = newEchoPlatform()
+		return newEchoPlatform(), nil
+	case AnthropicPlatform:
+		return newAnthropicPlatform(cfg.Anthropic), nil
 	default:
-		log.Fatal().Msgf("Unknown platform: %s", cfg.Platform)
-		return nil
+		return nil, fmt.Errorf("%w: %s", ErrPlatformNotImplemented, platformType)
+	}
+}
+
+// decorate wraps the platform with retries, the cache and the moderation if enabled.
+// The cache wraps the retries so that cache hits neither wait for nor count against a failing upstream.
+// The moderation is the outer decorator so that cached responses are reviewed as well.
+func decorate(platform Platform, cfg *Config, cacheStorage CacheStorage) Platform {
+	// The limit is innermost so that neither the backoff of retries nor cache hits hold a slot
+	if limit := cfg.Concurrency.Limits[platform.Type()]; limit > 0 {
+		platform = newLimitedPlatform(platform, limit)
+	}
+
+	if cfg.Resilience.Enabled {
+		platform = newResilientPlatform(platform, cfg.Resilience)
 	}
 
-	// Wrap with cache if enabled
 	if cfg.Cache.Enabled && cacheStorage != nil {
-		log.Info().Msg("Using provided cache storage for LLM")
-		return newCachedPlatform(platform, cacheStorage)
+		log.Info().Str("platform", string(platform.Type())).Msg("Using provided cache storage for LLM")
+		platform = newCachedPlatform(platform, cacheStorage)
+	}
+
+	// Cloud platforms redact the personal identifiers of the requests, inside the moderation
+	// so that it reviews the restored responses
+	if !isLocalPlatform(platform.Type()) {
+		platform = newRedactedPlatform(platform)
+	}
+
+	if cfg.Moderation.Enabled {
+		platform = newModeratedPlatform(platform, cfg.Moderation)
 	}
 
 	return platform
 }
 
+// splitQualifiedModel splits a "platform/model" name into the platform and the model.
+// Only a prefix naming one of the given platforms counts, model names such as "hf.co/org/model" are left as is.
+func splitQualifiedModel(model string, platforms []Platform) (PlatformType, string) {
+	prefix, name, found := strings.Cut(model, "/")
+	if !found {
+		return "", model
+	}
+
+	for _, platform := range platforms {
+		if platform.Type() == PlatformType(prefix) {
+			return platform.Type(), name
+		}
+	}
+
+	return "", model
+}
+
+// qualifiedModelName prefixes the model with the platform, e.g. "ollama/gemma3:1b"
+func qualifiedModelName(platformType PlatformType, model string) string {
+	if model == "" {
+		return ""
+	}
+	return string(platformType) + "/" + model
+}
+
+// generationCacheKey returns a stable representation of the settings, other than the prompts and the model,
+// that change the generated response. It is empty when the platform defaults are used.
+func (p *ChatParameters) generationCacheKey() string {
+	if p.ResponseFormat == nil && p.Sampling.IsEmpty() {
+		return ""
+	}
+
+	// Map keys are sorted when encoding, so the same schema always gives the same key
+	data, err := json.Marshal(struct {
+		ResponseFormat *ResponseFormat           `json:"responseFormat,omitempty"`
+		Sampling       domain.SamplingParameters `json:"sampling"`
+	}{p.ResponseFormat, p.Sampling})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to encode generation settings for the cache key")
+		return ""
+	}
+	return string(data)
+}
+
 func sortModels(models []*ModelInfo) {
 	sort.Slice(models, func(i, j int) bool {
 		return models[i].Name < models[j].Name
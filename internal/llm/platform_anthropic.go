@@ -0,0 +1,605 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	anthropicPlatform struct {
+		cfg        *AnthropicConfig
+		httpClient *http.Client
+	}
+
+	// anthropicContent is a content block of a message, either text or a base64 encoded image
+	anthropicContent struct {
+		Type   string                  `json:"type"`
+		Text   string                  `json:"text,omitempty"`
+		Source *anthropicContentSource `json:"source,omitempty"`
+	}
+
+	anthropicContentSource struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+	}
+
+	anthropicMessage struct {
+		Role    string             `json:"role"`
+		Content []anthropicContent `json:"content"`
+	}
+
+	anthropicRequest struct {
+		Model     string             `json:"model"`
+		MaxTokens int                `json:"max_tokens"`
+		System    string             `json:"system,omitempty"`
+		Messages  []anthropicMessage `json:"messages"`
+		Stream    bool               `json:"stream,omitempty"`
+		// Temperature and TopP are left to the API defaults when nil
+		Temperature *float64 `json:"temperature,omitempty"`
+		TopP        *float64 `json:"top_p,omitempty"`
+	}
+
+	// anthropicUsage holds the token counts, input tokens don't include the ones read from or written to the prompt cache
+	anthropicUsage struct {
+		InputTokens              int `json:"input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	}
+
+	anthropicResponse struct {
+		ID         string             `json:"id"`
+		Model      string             `json:"model"`
+		Content    []anthropicContent `json:"content"`
+		StopReason string             `json:"stop_reason"`
+		Usage      anthropicUsage     `json:"usage"`
+	}
+
+	anthropicErrorResponse struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	// anthropicStreamEvent holds the fields of the streamed events we use,
+	// see https://docs.anthropic.com/en/api/messages-streaming
+	anthropicStreamEvent struct {
+		Type    string             `json:"type"`
+		Message *anthropicResponse `json:"message,omitempty"`
+		Delta   *struct {
+			Type       string `json:"type"`
+			Text       string `json:"text"`
+			StopReason string `json:"stop_reason"`
+		} `json:"delta,omitempty"`
+		Usage *anthropicUsage `json:"usage,omitempty"`
+		Error *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
+	}
+
+	// anthropicAPIError is an error returned by the API, the stream reports errors without a status code
+	anthropicAPIError struct {
+		StatusCode int
+		Type       string
+		Message    string
+	}
+
+	anthropicModelsResponse struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+		} `json:"data"`
+	}
+)
+
+const (
+	anthropicBaseURL      = "https://api.anthropic.com"
+	anthropicAPIVersion   = "2023-06-01"
+	anthropicTimeout      = 2 * time.Minute
+	defaultAnthropicModel = "claude-3-5-haiku-latest"
+	// defaultAnthropicMaxTokens is used when no max tokens are configured, the Messages API requires a value
+	defaultAnthropicMaxTokens = 4096
+)
+
+// newAnthropicPlatform creates a new Anthropic platform
+func newAnthropicPlatform(cfg AnthropicConfig) Platform {
+	if cfg.Model == "" {
+		cfg.Model = defaultAnthropicModel
+	}
+
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = anthropicBaseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+
+	if cfg.MaxTokens <= 0 {
+		cfg.MaxTokens = defaultAnthropicMaxTokens
+	}
+
+	return &anthropicPlatform{
+		cfg:        &cfg,
+		httpClient: &http.Client{},
+	}
+}
+
+// Type returns the platform type
+func (a *anthropicPlatform) Type() PlatformType {
+	return AnthropicPlatform
+}
+
+// Models lists the models of the Anthropic API, limited to the allowed models when configured
+func (a *anthropicPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, anthropicTimeout)
+	defer cancel()
+
+	req, err := a.newRequest(ctx, http.MethodGet, "/v1/models?limit=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, anthropicError(resp)
+	}
+
+	var modelsResp anthropicModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&modelsResp); err != nil {
+		return nil, fmt.Errorf("error decoding models: %w", err)
+	}
+
+	models := make([]*ModelInfo, 0, len(modelsResp.Data))
+	for _, model := range modelsResp.Data {
+		models = append(models, &ModelInfo{
+			Name:      model.ID,
+			SizeHuman: "N/A",
+			IsDefault: model.ID == a.cfg.Model,
+		})
+	}
+
+	if len(a.cfg.AllowedModels) > 0 {
+		models = filterModels(models, a.cfg.AllowedModels)
+	}
+	addKnownCapabilities(AnthropicPlatform, models)
+
+	sortModels(models)
+
+	return models, nil
+}
+
+// describeModel returns the known capabilities of the model, the Anthropic API doesn't report them
+func (a *anthropicPlatform) describeModel(ctx context.Context, model string, serverURL string) (*ModelInfo, error) {
+	return describeKnownModel(AnthropicPlatform, model), nil
+}
+
+// defaultModel returns the configured model, Anthropic has no embedding models
+func (a *anthropicPlatform) defaultModel(embedding bool) string {
+	if embedding {
+		return ""
+	}
+	return a.cfg.Model
+}
+
+// Chat sends a chat request to Anthropic
+func (a *anthropicPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return a.chatStream(ctx, params, nil)
+}
+
+// ChatStream sends a chat request to Anthropic and streams the response to the handler
+func (a *anthropicPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return a.chatStream(ctx, params, handler)
+}
+
+// chatStream sends a single prompt to Anthropic, streaming the response when a handler is provided
+func (a *anthropicPlatform) chatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	if params.Prompt == "" {
+		return nil, ErrPromptEmpty
+	}
+
+	model := a.model(params)
+	req := &anthropicRequest{
+		Model:     model,
+		MaxTokens: a.maxTokens(params),
+		System:    withResponseFormatInstructions(params.SystemPrompt, params.ResponseFormat),
+		// Seed and context length aren't supported by the Messages API
+		Temperature: params.Sampling.Temperature,
+		TopP:        params.Sampling.TopP,
+		Messages: []anthropicMessage{
+			{
+				Role:    domain.ChatItemRoleUser,
+				Content: []anthropicContent{{Type: "text", Text: params.Prompt}},
+			},
+		},
+	}
+
+	resp, err := a.send(ctx, req, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := a.usage(model, resp.Usage)
+
+	log.Debug().
+		Str("model", model).
+		Int("promptTokens", usage.PromptTokens).
+		Int("completionTokens", usage.CompletionTokens).
+		Int("totalTokens", usage.TotalTokens).
+		Int("cachedPromptTokens", usage.CachedPromptTokens).
+		Bool("stream", handler != nil).
+		Msg("Anthropic chat response received")
+
+	return &ChatResponse{
+		Response: resp.text(),
+		Usage:    usage,
+	}, nil
+}
+
+// ChatWithHistory sends a chat request with message history to Anthropic
+func (a *anthropicPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	return a.chatWithHistoryStream(ctx, messages, params, nil)
+}
+
+// ChatWithHistoryStream sends a chat request with message history to Anthropic and streams the response to the handler
+func (a *anthropicPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return a.chatWithHistoryStream(ctx, messages, params, handler)
+}
+
+// chatWithHistoryStream sends the message history to Anthropic, streaming the response when a handler is provided.
+// The Messages API has no system role, system messages of the history are added to the system prompt.
+func (a *anthropicPlatform) chatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages provided")
+	}
+
+	model := a.model(params)
+	systemPrompts := []string{}
+	if params.SystemPrompt != "" {
+		systemPrompts = append(systemPrompts, params.SystemPrompt)
+	}
+
+	anthropicMessages := make([]anthropicMessage, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case domain.ChatItemRoleSystem:
+			systemPrompts = append(systemPrompts, msg.Content)
+		case domain.ChatItemRoleUser, domain.ChatItemRoleAssistant:
+			anthropicMessages = append(anthropicMessages, anthropicMessage{
+				Role:    msg.Role,
+				Content: []anthropicContent{{Type: "text", Text: msg.Content}},
+			})
+		default:
+			log.Warn().Str("role", msg.Role).Msg("Unknown message role encountered while converting to Anthropic format")
+			return nil, fmt.Errorf("unknown message role: %s", msg.Role)
+		}
+	}
+
+	req := &anthropicRequest{
+		Model:       model,
+		MaxTokens:   a.maxTokens(params),
+		System:      withResponseFormatInstructions(strings.Join(systemPrompts, "\n\n"), params.ResponseFormat),
+		Temperature: params.Sampling.Temperature,
+		TopP:        params.Sampling.TopP,
+		Messages:    anthropicMessages,
+	}
+
+	resp, err := a.send(ctx, req, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := a.usage(model, resp.Usage)
+
+	log.Debug().
+		Str("model", model).
+		Int("promptTokens", usage.PromptTokens).
+		Int("completionTokens", usage.CompletionTokens).
+		Int("totalTokens", usage.TotalTokens).
+		Int("cachedPromptTokens", usage.CachedPromptTokens).
+		Int("messageCount", len(messages)).
+		Bool("hasSystemPrompt", req.System != "").
+		Bool("stream", handler != nil).
+		Msg("Anthropic chat with history response received")
+
+	return &ChatResponse{
+		Response: resp.text(),
+		Usage:    usage,
+	}, nil
+}
+
+// Embed is not supported, Anthropic has no embeddings API
+func (a *anthropicPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	return nil, ErrEmbeddingsNotSupported
+}
+
+// DescribeImage sends an image to Anthropic for description
+func (a *anthropicPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
+	if params.Reader == nil {
+		return nil, ErrContextMissing
+	}
+
+	data, err := io.ReadAll(params.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	prompt := params.Prompt
+	if prompt == "" {
+		prompt = "Describe this image."
+	}
+
+	model := a.model(&params.ChatParameters)
+	req := &anthropicRequest{
+		Model:     model,
+		MaxTokens: a.cfg.MaxTokens,
+		System:    params.SystemPrompt,
+		Messages: []anthropicMessage{
+			{
+				Role: domain.ChatItemRoleUser,
+				Content: []anthropicContent{
+					{
+						Type: "image",
+						Source: &anthropicContentSource{
+							Type:      "base64",
+							MediaType: imageMediaType(params.FileName, data),
+							Data:      base64.StdEncoding.EncodeToString(data),
+						},
+					},
+					{Type: "text", Text: prompt},
+				},
+			},
+		},
+	}
+
+	resp, err := a.send(ctx, req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error sending image description request: %w", err)
+	}
+
+	usage := a.usage(model, resp.Usage)
+
+	log.Debug().
+		Str("model", model).
+		Int("promptTokens", usage.PromptTokens).
+		Int("completionTokens", usage.CompletionTokens).
+		Int("totalTokens", usage.TotalTokens).
+		Int("cachedPromptTokens", usage.CachedPromptTokens).
+		Msg("Anthropic image description received")
+
+	return &DescribeImageResponse{
+		Description: resp.text(),
+		Usage:       usage,
+	}, nil
+}
+
+// model returns the model of the parameters or the configured model
+func (a *anthropicPlatform) model(params *ChatParameters) string {
+	if params.Model != "" {
+		return params.Model
+	}
+	return a.cfg.Model
+}
+
+// maxTokens returns the max tokens of the parameters or the configured max tokens
+func (a *anthropicPlatform) maxTokens(params *ChatParameters) int {
+	if params.Sampling.MaxTokens != nil && *params.Sampling.MaxTokens > 0 {
+		return *params.Sampling.MaxTokens
+	}
+	return a.cfg.MaxTokens
+}
+
+// usage converts the Anthropic usage, the cost is computed by the service from the pricing table
+func (a *anthropicPlatform) usage(model string, usage anthropicUsage) *domain.Usage {
+	promptTokens := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+	return &domain.Usage{
+		LlmModelName:       model,
+		CacheHit:           false,
+		PromptTokens:       promptTokens,
+		CachedPromptTokens: usage.CacheReadInputTokens,
+		CompletionTokens:   usage.OutputTokens,
+		TotalTokens:        promptTokens + usage.OutputTokens,
+	}
+}
+
+// send sends the request to the Messages API and returns the message.
+// When handler is not nil the request is streamed and every text delta is passed to the handler,
+// the returned message is then accumulated from the streamed events.
+func (a *anthropicPlatform) send(ctx context.Context, req *anthropicRequest, handler StreamHandler) (*anthropicResponse, error) {
+	if req.Model == "" {
+		return nil, ErrModelNotSpecified
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, anthropicTimeout)
+	defer cancel()
+
+	req.Stream = handler != nil
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	httpReq, err := a.newRequest(ctx, http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending chat request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, anthropicError(resp)
+	}
+
+	if handler == nil {
+		var message anthropicResponse
+		if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+			return nil, fmt.Errorf("error decoding chat response: %w", err)
+		}
+
+		if len(message.Content) == 0 {
+			return nil, fmt.Errorf("no response from API")
+		}
+
+		return &message, nil
+	}
+
+	return readAnthropicStream(resp.Body, handler)
+}
+
+// newRequest creates a request to the Anthropic API with the authentication headers
+func (a *anthropicPlatform) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, a.cfg.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("x-api-key", a.cfg.APIKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+	req.Header.Set("content-type", "application/json")
+
+	return req, nil
+}
+
+// readAnthropicStream reads the Server-Sent Events of a streamed message, passing the text deltas to the handler
+func readAnthropicStream(body io.Reader, handler StreamHandler) (*anthropicResponse, error) {
+	message := &anthropicResponse{}
+	var text strings.Builder
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			// Event names are repeated in the data, blank lines separate the events
+			continue
+		}
+
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return nil, fmt.Errorf("error decoding stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				message.ID = event.Message.ID
+				message.Model = event.Message.Model
+				message.Usage = event.Message.Usage
+			}
+		case "content_block_delta":
+			if event.Delta != nil && event.Delta.Text != "" {
+				text.WriteString(event.Delta.Text)
+				if err := handler(event.Delta.Text); err != nil {
+					return nil, err
+				}
+			}
+		case "message_delta":
+			if event.Delta != nil {
+				message.StopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				message.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error != nil {
+				return nil, &anthropicAPIError{Type: event.Error.Type, Message: event.Error.Message}
+			}
+			return nil, fmt.Errorf("anthropic stream error")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error streaming chat request: %w", err)
+	}
+
+	if text.Len() == 0 {
+		return nil, fmt.Errorf("no response from API")
+	}
+
+	message.Content = []anthropicContent{{Type: "text", Text: text.String()}}
+	return message, nil
+}
+
+// anthropicError converts a failed response to an error, using the error message of the API when available
+func anthropicError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var errResp anthropicErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
+		return &anthropicAPIError{StatusCode: resp.StatusCode, Type: errResp.Error.Type, Message: errResp.Error.Message}
+	}
+
+	return &anthropicAPIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
+
+func (e *anthropicAPIError) Error() string {
+	switch {
+	case e.StatusCode == 0:
+		return fmt.Sprintf("anthropic stream error (%s): %s", e.Type, e.Message)
+	case e.Type == "":
+		return fmt.Sprintf("anthropic API error (%d): %s", e.StatusCode, e.Message)
+	default:
+		return fmt.Sprintf("anthropic API error (%d %s): %s", e.StatusCode, e.Type, e.Message)
+	}
+}
+
+// text returns the text content of the message
+func (r *anthropicResponse) text() string {
+	var text strings.Builder
+	for _, content := range r.Content {
+		if content.Type == "text" {
+			text.WriteString(content.Text)
+		}
+	}
+	return text.String()
+}
+
+// withResponseFormatInstructions adds the response format to the system prompt.
+// The Messages API has no JSON mode, so the model is instructed to answer with JSON instead.
+func withResponseFormatInstructions(systemPrompt string, format *ResponseFormat) string {
+	if format == nil {
+		return systemPrompt
+	}
+
+	instructions := "Respond only with a valid JSON object, without any text or code fences around it."
+	if format.Type == ResponseFormatJSONSchema && format.Schema != nil {
+		if schema, err := json.Marshal(format.Schema); err == nil {
+			instructions = fmt.Sprintf("Respond only with a valid JSON object matching this JSON Schema, without any text or code fences around it:\n%s", schema)
+		}
+	}
+
+	if systemPrompt == "" {
+		return instructions
+	}
+	return systemPrompt + "\n\n" + instructions
+}
+
+// imageMediaType returns the media type of the image from the file name, or from its content when the extension is unknown
+func imageMediaType(fileName string, data []byte) string {
+	if mediaType := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName))); strings.HasPrefix(mediaType, "image/") {
+		return mediaType
+	}
+	return http.DetectContentType(data)
+}
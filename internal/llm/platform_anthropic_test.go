@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAnthropicTestServer starts a stand-in for the Anthropic API, every request to /v1/messages
+// is decoded and passed to the handler along with the response writer
+func newAnthropicTestServer(t *testing.T, handler func(w http.ResponseWriter, req anthropicRequest)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-key", r.Header.Get("x-api-key"))
+		assert.Equal(t, anthropicAPIVersion, r.Header.Get("anthropic-version"))
+
+		switch r.URL.Path {
+		case "/v1/messages":
+			var req anthropicRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			handler(w, req)
+		case "/v1/models":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"data":[{"id":"claude-sonnet-4-0"},{"id":"claude-3-5-haiku-latest"},{"id":"claude-3-opus-latest"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestAnthropicPlatform(baseURL string) Platform {
+	return newAnthropicPlatform(AnthropicConfig{
+		APIKey:        "test-key",
+		BaseURL:       baseURL,
+		AllowedModels: []string{"claude-3-5-haiku-latest", "claude-sonnet-4-0"},
+	})
+}
+
+func writeAnthropicMessage(w http.ResponseWriter, text string, inputTokens, outputTokens int) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-haiku-latest",
+		"content":[{"type":"text","text":%q}],"stop_reason":"end_turn",
+		"usage":{"input_tokens":%d,"output_tokens":%d}}`, text, inputTokens, outputTokens)
+}
+
+func TestAnthropicPlatform_Chat(t *testing.T) {
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		assert.Equal(t, defaultAnthropicModel, req.Model)
+		assert.Equal(t, defaultAnthropicMaxTokens, req.MaxTokens)
+		assert.Equal(t, "You are a helpful assistant", req.System)
+		assert.False(t, req.Stream)
+		require.Len(t, req.Messages, 1)
+		assert.Equal(t, "user", req.Messages[0].Role)
+		assert.Equal(t, "Hello", req.Messages[0].Content[0].Text)
+
+		writeAnthropicMessage(w, "Hi there", 10, 3)
+	})
+
+	platform := newTestAnthropicPlatform(server.URL)
+	resp, err := platform.Chat(context.Background(), &ChatParameters{
+		Prompt:       "Hello",
+		SystemPrompt: "You are a helpful assistant",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Hi there", resp.Response)
+	assert.Equal(t, defaultAnthropicModel, resp.Usage.LlmModelName)
+	assert.Equal(t, 10, resp.Usage.PromptTokens)
+	assert.Equal(t, 3, resp.Usage.CompletionTokens)
+	assert.Equal(t, 13, resp.Usage.TotalTokens)
+	assert.Zero(t, resp.Usage.Cost) // The service prices the usage
+}
+
+func TestAnthropicPlatform_ChatWithHistory(t *testing.T) {
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		assert.Equal(t, "claude-sonnet-4-0", req.Model)
+		// System messages of the history are moved to the system prompt
+		assert.Equal(t, "Be brief\n\nAnswer in French", req.System)
+		require.Len(t, req.Messages, 3)
+		assert.Equal(t, "user", req.Messages[0].Role)
+		assert.Equal(t, "assistant", req.Messages[1].Role)
+		assert.Equal(t, "user", req.Messages[2].Role)
+
+		writeAnthropicMessage(w, "Bonjour", 20, 2)
+	})
+
+	platform := newTestAnthropicPlatform(server.URL)
+	messages := []*domain.ChatItem{
+		{Role: domain.ChatItemRoleSystem, Content: "Answer in French"},
+		{Role: domain.ChatItemRoleUser, Content: "Hi"},
+		{Role: domain.ChatItemRoleAssistant, Content: "Salut"},
+		{Role: domain.ChatItemRoleUser, Content: "How are you?"},
+	}
+
+	resp, err := platform.ChatWithHistory(context.Background(), messages, &ChatParameters{
+		SystemPrompt: "Be brief",
+		Model:        "claude-sonnet-4-0",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "Bonjour", resp.Response)
+	assert.Equal(t, "claude-sonnet-4-0", resp.Usage.LlmModelName)
+	assert.Equal(t, 22, resp.Usage.TotalTokens)
+}
+
+func TestAnthropicPlatform_ChatStream(t *testing.T) {
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		assert.True(t, req.Stream)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`event: message_start` + "\n" + `data: {"type":"message_start","message":{"id":"msg_1","model":"claude-3-5-haiku-latest","content":[],"usage":{"input_tokens":8,"output_tokens":1}}}`,
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`event: ping` + "\n" + `data: {"type":"ping"}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" there"}}`,
+			`event: content_block_stop` + "\n" + `data: {"type":"content_block_stop","index":0}`,
+			`event: message_delta` + "\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":4}}`,
+			`event: message_stop` + "\n" + `data: {"type":"message_stop"}`,
+		}
+		for _, event := range events {
+			fmt.Fprint(w, event+"\n\n")
+		}
+	})
+
+	platform := newTestAnthropicPlatform(server.URL)
+
+	var chunks []string
+	resp, err := platform.ChatStream(context.Background(), &ChatParameters{Prompt: "Hi"}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hello", " there"}, chunks)
+	assert.Equal(t, "Hello there", resp.Response)
+	assert.Equal(t, 8, resp.Usage.PromptTokens)
+	assert.Equal(t, 4, resp.Usage.CompletionTokens)
+}
+
+func TestAnthropicPlatform_ChatStreamError(t *testing.T) {
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: error\n"+`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`+"\n\n")
+	})
+
+	platform := newTestAnthropicPlatform(server.URL)
+	_, err := platform.ChatStream(context.Background(), &ChatParameters{Prompt: "Hi"}, func(chunk string) error {
+		return nil
+	})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Overloaded")
+}
+
+func TestAnthropicPlatform_APIError(t *testing.T) {
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: field required"}}`)
+	})
+
+	platform := newTestAnthropicPlatform(server.URL)
+	_, err := platform.Chat(context.Background(), &ChatParameters{Prompt: "Hi"})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400 invalid_request_error")
+	assert.Contains(t, err.Error(), "max_tokens: field required")
+}
+
+func TestAnthropicPlatform_DescribeImage(t *testing.T) {
+	image := []byte("\x89PNG\r\n\x1a\nfake image data")
+
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		require.Len(t, req.Messages, 1)
+		content := req.Messages[0].Content
+		require.Len(t, content, 2)
+		assert.Equal(t, "image", content[0].Type)
+		assert.Equal(t, "base64", content[0].Source.Type)
+		assert.Equal(t, "image/png", content[0].Source.MediaType)
+		assert.Equal(t, base64.StdEncoding.EncodeToString(image), content[0].Source.Data)
+		assert.Equal(t, "text", content[1].Type)
+		assert.Equal(t, "What is in this worksheet?", content[1].Text)
+
+		writeAnthropicMessage(w, "A math worksheet", 100, 4)
+	})
+
+	platform := newTestAnthropicPlatform(server.URL)
+	resp, err := platform.DescribeImage(context.Background(), &DescribeImageParameters{
+		ChatParameters: ChatParameters{Prompt: "What is in this worksheet?"},
+		Reader:         bytes.NewReader(image),
+		FileName:       "worksheet.png",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "A math worksheet", resp.Description)
+	assert.Equal(t, 104, resp.Usage.TotalTokens)
+}
+
+func TestAnthropicPlatform_Models(t *testing.T) {
+	server := newAnthropicTestServer(t, func(w http.ResponseWriter, req anthropicRequest) {
+		t.Fatal("unexpected messages request")
+	})
+
+	platform := newTestAnthropicPlatform(server.URL)
+	models, err := platform.Models(context.Background())
+
+	require.NoError(t, err)
+	// Only the allowed models are listed, sorted by name
+	require.Len(t, models, 2)
+	assert.Equal(t, "claude-3-5-haiku-latest", models[0].Name)
+	assert.True(t, models[0].IsDefault)
+	assert.Equal(t, "claude-sonnet-4-0", models[1].Name)
+	assert.False(t, models[1].IsDefault)
+}
+
+func TestImageMediaType(t *testing.T) {
+	assert.Equal(t, "image/jpeg", imageMediaType("photo.JPG", nil))
+	assert.Equal(t, "image/png", imageMediaType("upload", []byte("\x89PNG\r\n\x1a\n")))
+	assert.True(t, strings.HasPrefix(imageMediaType("", []byte("GIF89a")), "image/gif"))
+}
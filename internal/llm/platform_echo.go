@@ -1,13 +1,22 @@
 package llm
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"strings"
 	"time"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 )
 
+const (
+	// echoEmbeddingDimensions is the length of the echo embeddings
+	echoEmbeddingDimensions = 16
+	echoEmbeddingModel      = "echo-embed"
+)
+
 // echoPlatform is a simple platform that echoes the prompt back
 // It's useful for testing without calling external APIs
 type echoPlatform struct{}
@@ -22,11 +31,11 @@ func (e *echoPlatform) Type() PlatformType {
 	return EchoPlatform
 }
 
-func (e *echoPlatform) Models() ([]*ModelInfo, error) {
+func (e *echoPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
 	return nil, nil
 }
 
-func (e *echoPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
+func (e *echoPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
 	// Just echo back the prompt and system prompt
 	response := fmt.Sprintf("Echo response to: %s\nSystem context: %s", params.Prompt, params.SystemPrompt)
 
@@ -44,7 +53,7 @@ func (e *echoPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 }
 
 // ChatWithHistory echoes back information about the chat history
-func (e *echoPlatform) ChatWithHistory(messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+func (e *echoPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
 	// Count messages by role
 	userCount, assistantCount, systemCount := 0, 0, 0
 
@@ -99,7 +108,7 @@ func (e *echoPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Chat
 }
 
 // DescribeImage returns a simple echo response for image description
-func (e *echoPlatform) DescribeImage(params *DescribeImageParameters) (*DescribeImageResponse, error) {
+func (e *echoPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
 	if params.Reader == nil {
 		return nil, ErrContextMissing
 	}
@@ -128,3 +137,106 @@ func (e *echoPlatform) DescribeImage(params *DescribeImageParameters) (*Describe
 		},
 	}, nil
 }
+
+// Embed returns deterministic embeddings: the words of a text are hashed into a fixed number of
+// dimensions and the vector is normalized, so texts sharing words are similar
+func (e *echoPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	if len(params.Texts) == 0 {
+		return nil, ErrEmbedTextsEmpty
+	}
+
+	promptTokens := 0
+	embeddings := make([][]float32, len(params.Texts))
+	for i, text := range params.Texts {
+		embeddings[i] = echoEmbedding(text)
+		promptTokens += estimateTokenCount(text)
+	}
+
+	return &EmbedResponse{
+		Embeddings: embeddings,
+		Usage: &domain.Usage{
+			LlmModelName: echoEmbeddingModel,
+			PromptTokens: promptTokens,
+			TotalTokens:  promptTokens,
+		},
+	}, nil
+}
+
+// echoEmbedding hashes the lower-cased words of the text into a normalized vector
+func echoEmbedding(text string) []float32 {
+	embedding := make([]float32, echoEmbeddingDimensions)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(word))
+		embedding[hash.Sum32()%echoEmbeddingDimensions]++
+	}
+
+	var norm float64
+	for _, value := range embedding {
+		norm += float64(value * value)
+	}
+	if norm == 0 {
+		return embedding
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range embedding {
+		embedding[i] = float32(float64(embedding[i]) / norm)
+	}
+	return embedding
+}
+
+// ChatStream echoes back the prompt, delivering it word by word to the handler
+func (e *echoPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	response, err := e.Chat(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := streamWords(ctx, response.Response, handler); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// ChatWithHistoryStream echoes back information about the chat history, delivering it word by word to the handler
+func (e *echoPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	response, err := e.ChatWithHistory(ctx, messages, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := streamWords(ctx, response.Response, handler); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// streamWords splits the text into words, keeping the separators, and passes them to the handler one by one.
+// It stops early when the context is cancelled.
+func streamWords(ctx context.Context, text string, handler StreamHandler) error {
+	if handler == nil {
+		return nil
+	}
+
+	start := 0
+	for i, r := range text {
+		if r == ' ' || r == '\n' {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := handler(text[start : i+1]); err != nil {
+				return err
+			}
+			start = i + 1
+		}
+	}
+
+	if start < len(text) {
+		return handler(text[start:])
+	}
+
+	return nil
+}
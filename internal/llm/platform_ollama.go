@@ -1,23 +1,39 @@
 package llm
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
 	"github.com/rs/zerolog/log"
 )
 
 type ollamaPlatform struct {
 	cfg    *OllamaConfig
 	client OllamaClient
+	// clients holds the clients of servers other than the configured one, e.g. per account servers
+	clients *ollamaClientPool
+	// described holds the described models per server and model, see describeModel
+	mutex     sync.Mutex
+	described map[string]*ModelInfo
 }
 
 const (
 	defaultOllamaTimeout = 30 * time.Minute
 	defaultOllamaModel   = "llama3.2:1b"
+	// defaultOllamaEmbeddingModel is a small embedding model, pull it with "ollama pull nomic-embed-text"
+	defaultOllamaEmbeddingModel = "nomic-embed-text"
 )
 
 func newOllamaPlatform(cfg OllamaConfig) Platform {
@@ -25,20 +41,27 @@ func newOllamaPlatform(cfg OllamaConfig) Platform {
 		cfg.Model = defaultOllamaModel
 	}
 
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultOllamaTimeout
+	}
+
 	log.Debug().
 		Str("model", cfg.Model).
 		Str("url", cfg.URL).
+		Dur("timeout", cfg.Timeout).
 		Msg("Creating new Ollama platform")
 
 	// Create the Ollama client
-	client, err := NewOllamaClient(cfg.URL, defaultOllamaTimeout)
+	client, err := NewOllamaClient(cfg.URL, cfg.Timeout)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create Ollama client, will attempt to create on first use")
 	}
 
 	return &ollamaPlatform{
-		cfg:    &cfg,
-		client: client,
+		cfg:       &cfg,
+		client:    client,
+		clients:   newOllamaClientPool(defaultOllamaClientPoolSize, cfg.Timeout),
+		described: make(map[string]*ModelInfo),
 	}
 }
 
@@ -47,8 +70,13 @@ func (o *ollamaPlatform) Type() PlatformType {
 	return OllamaPlatform
 }
 
-func (o *ollamaPlatform) Models() ([]*ModelInfo, error) {
-	models, err := o.client.ListModels()
+func (o *ollamaPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	client, err := o.getClient("")
+	if err != nil {
+		return nil, err
+	}
+
+	models, err := client.ListModels(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list Ollama models: %w", err)
 	}
@@ -57,20 +85,99 @@ func (o *ollamaPlatform) Models() ([]*ModelInfo, error) {
 		if model.Name == o.cfg.Model {
 			model.IsDefault = true
 		}
+
+		described, err := o.describeModel(ctx, model.Name, "")
+		if err != nil {
+			log.Warn().Err(err).Str("model", model.Name).Msg("Failed to describe Ollama model")
+			continue
+		}
+		model.Capabilities = described.Capabilities
+		model.ContextLength = described.ContextLength
+		if model.ParameterSize == "" {
+			model.ParameterSize = described.ParameterSize
+		}
 	}
 	sortModels(models)
 
 	return models, nil
 }
 
-// getClient gets the client, creating it if necessary
-func (o *ollamaPlatform) getClient() (OllamaClient, error) {
+// describeModel returns the capabilities and the context length of a model of the server.
+// Models are described once per server, until they are pulled or deleted through the platform.
+func (o *ollamaPlatform) describeModel(ctx context.Context, model string, serverURL string) (*ModelInfo, error) {
+	if !o.usesPool(serverURL) {
+		serverURL = ""
+	}
+	key := describedKey(serverURL, model)
+
+	o.mutex.Lock()
+	described, ok := o.described[key]
+	o.mutex.Unlock()
+	if ok {
+		return described, nil
+	}
+
+	resp, err := o.show(ctx, serverURL, model)
+	if err != nil {
+		return nil, err
+	}
+
+	described = &ModelInfo{
+		Name:          model,
+		Capabilities:  ollamaCapabilities(resp.Capabilities),
+		ContextLength: ollamaContextLength(resp.ModelInfo),
+		ParameterSize: resp.Details.ParameterSize,
+	}
+
+	o.mutex.Lock()
+	o.described[key] = described
+	o.mutex.Unlock()
+
+	return described, nil
+}
+
+// defaultModel returns the configured model, the configured embedding model for embeddings
+func (o *ollamaPlatform) defaultModel(embedding bool) string {
+	if embedding {
+		return cmp.Or(o.cfg.EmbeddingModel, defaultOllamaEmbeddingModel)
+	}
+	return o.cfg.Model
+}
+
+// forgetModel drops the description of a model of the server, after it was pulled or deleted
+func (o *ollamaPlatform) forgetModel(serverURL string, model string) {
+	if !o.usesPool(serverURL) {
+		serverURL = ""
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.described, describedKey(serverURL, model))
+}
+
+// describedKey keys the described models by server and model, the configured server has an empty URL
+func describedKey(serverURL string, model string) string {
+	return normalizeOllamaURL(serverURL) + " " + model
+}
+
+// getClient gets the client for the server URL, creating it if necessary.
+// An empty URL or the configured URL returns the client of the configured server,
+// any other URL is served from the client pool.
+func (o *ollamaPlatform) getClient(serverURL string) (OllamaClient, error) {
+	if o.usesPool(serverURL) {
+		client, err := o.clients.get(serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Ollama client for %s: %w", serverURL, err)
+		}
+		return client, nil
+	}
+
 	if o.client != nil {
 		return o.client, nil
 	}
 
 	// Try to create the client
-	client, err := NewOllamaClient(o.cfg.URL, defaultOllamaTimeout)
+	client, err := NewOllamaClient(o.cfg.URL, o.cfg.Timeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Ollama client: %w", err)
 	}
@@ -80,7 +187,17 @@ func (o *ollamaPlatform) getClient() (OllamaClient, error) {
 }
 
 // Chat sends a chat request to Ollama
-func (o *ollamaPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
+func (o *ollamaPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return o.chatStream(ctx, params, nil)
+}
+
+// ChatStream sends a chat request to Ollama and streams the response to the handler
+func (o *ollamaPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return o.chatStream(ctx, params, handler)
+}
+
+// chatStream sends a single prompt to Ollama, streaming the response when a handler is provided
+func (o *ollamaPlatform) chatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
 	if params.Prompt == "" {
 		return nil, ErrPromptEmpty
 	}
@@ -94,28 +211,6 @@ func (o *ollamaPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 		return nil, ErrModelNotSpecified
 	}
 
-	// Get or create the client
-	client, err := o.getClient()
-	if err != nil {
-		// If primary URL fails and fallback is configured, try the fallback
-		if o.cfg.FallbackURL != "" {
-			log.Warn().
-				Str("primary", o.cfg.URL).
-				Str("fallback", o.cfg.FallbackURL).
-				Err(err).
-				Msg("Primary Ollama URL failed, attempting fallback")
-
-			fallbackClient, fallbackErr := NewOllamaClient(o.cfg.FallbackURL, defaultOllamaTimeout)
-			if fallbackErr != nil {
-				return nil, fmt.Errorf("failed to create fallback client: %w", fallbackErr)
-			}
-
-			client = fallbackClient
-		} else {
-			return nil, err
-		}
-	}
-
 	// Create messages array with user's prompt
 	messages := []api.Message{
 		{
@@ -135,51 +230,18 @@ func (o *ollamaPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 		}, messages...)
 	}
 
-	// Set options (if any in the future)
-	options := map[string]interface{}{}
-
-	// Non-streaming mode for now
-	stream := false
-
-	// Create context
-	ctx, cancel := context.WithTimeout(context.Background(), defaultOllamaTimeout)
-	defer cancel()
-
 	log.Debug().
 		Str("model", model).
+		Str("serverUrl", params.ServerURL).
 		Int("messagesCount", len(messages)).
 		Bool("hasSystemPrompt", params.SystemPrompt != "").
+		Bool("stream", handler != nil).
 		Msg("Sending request to Ollama")
 
 	// Send the chat request
-	resp, err := client.ChatWithModel(ctx, model, messages, stream, options)
+	_, responseText, err := o.send(ctx, params.ServerURL, model, messages, ollamaOptions(params.Sampling), ollamaFormat(params.ResponseFormat), nil, handler)
 	if err != nil {
-		// If primary URL fails and fallback is configured, try the fallback
-		if o.cfg.FallbackURL != "" && client != o.client {
-			log.Warn().
-				Str("primary", o.cfg.URL).
-				Str("fallback", o.cfg.FallbackURL).
-				Err(err).
-				Msg("Primary Ollama URL failed, attempting fallback")
-
-			fallbackClient, fallbackErr := NewOllamaClient(o.cfg.FallbackURL, defaultOllamaTimeout)
-			if fallbackErr != nil {
-				return nil, fmt.Errorf("failed to create fallback client: %w", fallbackErr)
-			}
-
-			resp, err = fallbackClient.ChatWithModel(ctx, model, messages, stream, options)
-			if err != nil {
-				return nil, fmt.Errorf("failed to use fallback: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to chat with Ollama: %w", err)
-		}
-	}
-
-	// Get the response content
-	responseText := ""
-	if resp != nil {
-		responseText = resp.Message.Content
+		return nil, err
 	}
 
 	// Estimate token count based on word count (rough approximation)
@@ -200,9 +262,9 @@ func (o *ollamaPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 	return &ChatResponse{
 		Response: responseText,
 		Usage: &domain.Usage{
-			LlmModelName:     model,
-			CacheHit:         false,
-			Cost:             0, // Ollama is free, so cost is 0
+			LlmModelName: model,
+			CacheHit:     false,
+			// The cost is computed by the service, a notional cost can be configured for Ollama
 			PromptTokens:     promptTokens,
 			CompletionTokens: completionTokens,
 			TotalTokens:      totalTokens,
@@ -211,12 +273,17 @@ func (o *ollamaPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 }
 
 // ChatWithHistory sends a chat request with message history to Ollama
-func (o *ollamaPlatform) ChatWithHistory(messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
-	client, err := o.getClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get Ollama client: %w", err)
-	}
+func (o *ollamaPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	return o.chatWithHistoryStream(ctx, messages, params, nil)
+}
 
+// ChatWithHistoryStream sends a chat request with message history to Ollama and streams the response to the handler
+func (o *ollamaPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return o.chatWithHistoryStream(ctx, messages, params, handler)
+}
+
+// chatWithHistoryStream sends the message history to Ollama, streaming the response when a handler is provided
+func (o *ollamaPlatform) chatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
 	if len(messages) == 0 {
 		return nil, fmt.Errorf("no messages provided")
 	}
@@ -242,48 +309,23 @@ func (o *ollamaPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 	}
 
 	// Add the rest of the messages
-	for _, msg := range messages {
-		apiMessages = append(apiMessages, api.Message{
-			Role:    msg.Role, // Assuming roles like "user", "assistant", "system" are compatible
-			Content: msg.Content,
-		})
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), defaultOllamaTimeout)
-	defer cancel()
+	apiMessages = append(apiMessages, ollamaMessages(messages)...)
 
 	log.Debug().
 		Str("model", modelName).
+		Str("serverUrl", params.ServerURL).
 		Int("messagesCount", len(apiMessages)).
 		Bool("hasSystemPrompt", params.SystemPrompt != "").
+		Int("tools", len(params.Tools)).
+		Bool("stream", handler != nil).
 		Msg("Sending historical chat request to Ollama")
 
-	// Use the same fallback logic from the existing Chat method
-	resp, err := client.ChatWithModel(ctx, modelName, apiMessages, false, nil)
+	resp, content, err := o.send(ctx, params.ServerURL, modelName, apiMessages, ollamaOptions(params.Sampling), ollamaFormat(params.ResponseFormat), ollamaTools(params.Tools), handler)
 	if err != nil {
-		// If primary URL fails and fallback is configured, try the fallback
-		if o.cfg.FallbackURL != "" {
-			log.Warn().
-				Str("primary", o.cfg.URL).
-				Str("fallback", o.cfg.FallbackURL).
-				Err(err).
-				Msg("Primary Ollama URL failed, attempting fallback")
-
-			fallbackClient, fallbackErr := NewOllamaClient(o.cfg.FallbackURL, defaultOllamaTimeout)
-			if fallbackErr != nil {
-				return nil, fmt.Errorf("failed to create fallback client: %w", fallbackErr)
-			}
-
-			resp, err = fallbackClient.ChatWithModel(ctx, modelName, apiMessages, false, nil)
-			if err != nil {
-				return nil, fmt.Errorf("failed to use fallback: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to chat with Ollama: %w", err)
-		}
+		return nil, err
 	}
 
-	// Ensure resp and resp.Message are not nil before accessing fields
+	// Ensure resp is not nil before accessing fields
 	if resp == nil {
 		log.Error().Str("model", modelName).Msg("Ollama chat response was nil")
 		return nil, fmt.Errorf("Ollama chat response was nil for model %s", modelName)
@@ -299,29 +341,513 @@ func (o *ollamaPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 		Int("promptTokens", promptTokens).
 		Int("completionTokens", completionTokens).
 		Int("totalTokens", totalTokens).
-		Str("response", resp.Message.Content).
+		Str("response", content).
 		Msg("Ollama chat with history response received")
 
 	// Create the response
 	return &ChatResponse{
-		Response: resp.Message.Content,
+		Response: content,
 		Usage: &domain.Usage{
-			LlmModelName:     modelName,
-			CacheHit:         false, // Cache handling is done by cachedPlatform decorator
-			Cost:             0,     // Ollama is typically local, so cost is 0
+			LlmModelName: modelName,
+			CacheHit:     false, // Cache handling is done by cachedPlatform decorator
+			// The cost is computed by the service, a notional cost can be configured for Ollama
 			PromptTokens:     promptTokens,
 			CompletionTokens: completionTokens,
 			TotalTokens:      totalTokens,
 		},
+		ToolCalls: ollamaToolCalls(resp.Message.ToolCalls),
 	}, nil
 }
 
-// DescribeImage sends an image to Ollama for description
-// Note: This is a simplified implementation as Ollama may have limited image capabilities
-func (o *ollamaPlatform) DescribeImage(params *DescribeImageParameters) (*DescribeImageResponse, error) {
-	// For now, we'll just return a not implemented error for Ollama
-	// This can be expanded in the future if Ollama adds better image capabilities
-	return nil, ErrPlatformNotImplemented
+// send sends the messages to Ollama and returns the final response along with the full message content.
+// The request goes to serverURL when set, otherwise to the configured server.
+// If the configured server fails and a fallback URL is configured, the request is retried against the fallback.
+// When handler is not nil the response is streamed to it as it is generated.
+func (o *ollamaPlatform) send(ctx context.Context, serverURL string, model string, messages []api.Message, options map[string]interface{}, format json.RawMessage, tools api.Tools, handler StreamHandler) (*api.ChatResponse, string, error) {
+	// Get or create the client
+	client, err := o.getClient(serverURL)
+	if err != nil {
+		// If primary URL fails and fallback is configured, try the fallback.
+		// Servers other than the configured one don't use the fallback.
+		if o.cfg.FallbackURL == "" || o.usesPool(serverURL) {
+			return nil, "", err
+		}
+
+		log.Warn().
+			Str("primary", o.cfg.URL).
+			Str("fallback", o.cfg.FallbackURL).
+			Err(err).
+			Msg("Primary Ollama URL failed, attempting fallback")
+
+		client, err = NewOllamaClient(o.cfg.FallbackURL, o.cfg.Timeout)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create fallback client: %w", err)
+		}
+	}
+
+	resp, content, err := o.sendWithClient(ctx, client, model, messages, options, format, tools, handler)
+	if err == nil {
+		return resp, content, nil
+	}
+
+	// If primary URL fails and fallback is configured, try the fallback.
+	// A stream that already delivered content to the handler can't be restarted.
+	if o.cfg.FallbackURL == "" || client != o.client || content != "" || ctx.Err() != nil {
+		return nil, "", fmt.Errorf("failed to chat with Ollama: %w", err)
+	}
+
+	log.Warn().
+		Str("primary", o.cfg.URL).
+		Str("fallback", o.cfg.FallbackURL).
+		Err(err).
+		Msg("Primary Ollama URL failed, attempting fallback")
+
+	fallbackClient, fallbackErr := NewOllamaClient(o.cfg.FallbackURL, o.cfg.Timeout)
+	if fallbackErr != nil {
+		return nil, "", fmt.Errorf("failed to create fallback client: %w", fallbackErr)
+	}
+
+	resp, content, err = o.sendWithClient(ctx, fallbackClient, model, messages, options, format, tools, handler)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to use fallback: %w", err)
+	}
+
+	return resp, content, nil
+}
+
+// usesPool returns true when requests to the server URL are served by the client pool
+func (o *ollamaPlatform) usesPool(serverURL string) bool {
+	return serverURL != "" && normalizeOllamaURL(serverURL) != normalizeOllamaURL(o.cfg.URL)
+}
+
+// sendWithClient sends the messages using the given client, streaming when a handler is provided.
+// The returned content holds whatever was received, even when the stream fails part way through.
+// Tool calls streamed before the final response are set on the final response.
+func (o *ollamaPlatform) sendWithClient(ctx context.Context, client OllamaClient, model string, messages []api.Message, options map[string]interface{}, format json.RawMessage, tools api.Tools, handler StreamHandler) (*api.ChatResponse, string, error) {
+	if handler == nil {
+		resp, err := client.ChatWithModel(ctx, model, messages, false, options, format, tools)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if resp == nil {
+			return nil, "", nil
+		}
+
+		return resp, resp.Message.Content, nil
+	}
+
+	var content strings.Builder
+	var toolCalls []api.ToolCall
+	resp, err := client.ChatWithModelStream(ctx, model, messages, options, format, tools, func(chunk api.ChatResponse) error {
+		if !chunk.Done {
+			toolCalls = append(toolCalls, chunk.Message.ToolCalls...)
+		}
+		if chunk.Message.Content == "" {
+			return nil
+		}
+		content.WriteString(chunk.Message.Content)
+		return handler(chunk.Message.Content)
+	})
+
+	if resp != nil && len(resp.Message.ToolCalls) == 0 {
+		resp.Message.ToolCalls = toolCalls
+	}
+
+	return resp, content.String(), err
+}
+
+// DescribeImage sends an image to Ollama for description, the model must be a vision model such as gemma3
+func (o *ollamaPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
+	if params.Reader == nil {
+		return nil, ErrContextMissing
+	}
+
+	model := params.Model
+	if model == "" {
+		model = o.cfg.Model
+	}
+
+	if model == "" {
+		return nil, ErrModelNotSpecified
+	}
+
+	data, err := io.ReadAll(params.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	prompt := params.Prompt
+	if prompt == "" {
+		prompt = "Describe this image."
+	}
+
+	messages := []api.Message{
+		{
+			Role:    "user",
+			Content: prompt,
+			Images:  []api.ImageData{data},
+		},
+	}
+	if params.SystemPrompt != "" {
+		messages = append([]api.Message{
+			{
+				Role:    "system",
+				Content: params.SystemPrompt,
+			},
+		}, messages...)
+	}
+
+	log.Debug().
+		Str("model", model).
+		Str("serverUrl", params.ServerURL).
+		Str("fileName", params.FileName).
+		Int("imageBytes", len(data)).
+		Msg("Sending image to Ollama")
+
+	resp, description, err := o.send(ctx, params.ServerURL, model, messages, ollamaOptions(params.Sampling), ollamaFormat(params.ResponseFormat), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ollama reports the token counts of the image and the response, estimate them otherwise
+	promptTokens := estimateTokenCount(prompt)
+	completionTokens := estimateTokenCount(description)
+	if resp != nil && resp.PromptEvalCount > 0 {
+		promptTokens = resp.PromptEvalCount
+		completionTokens = resp.EvalCount
+	}
+
+	return &DescribeImageResponse{
+		Description: description,
+		Usage: &domain.Usage{
+			LlmModelName: model,
+			// The cost is computed by the service, a notional cost can be configured for Ollama
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}, nil
+}
+
+// Embed sends the texts to the Ollama embed endpoint
+func (o *ollamaPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	if len(params.Texts) == 0 {
+		return nil, ErrEmbedTextsEmpty
+	}
+
+	model := params.Model
+	if model == "" {
+		model = o.cfg.EmbeddingModel
+	}
+	if model == "" {
+		model = defaultOllamaEmbeddingModel
+	}
+
+	client, err := o.getClient(params.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Embed(ctx, model, params.Texts)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().
+		Str("model", model).
+		Int("texts", len(params.Texts)).
+		Int("promptTokens", resp.PromptEvalCount).
+		Msg("Ollama embeddings received")
+
+	return &EmbedResponse{
+		Embeddings: resp.Embeddings,
+		Usage: &domain.Usage{
+			LlmModelName: model,
+			PromptTokens: resp.PromptEvalCount,
+			TotalTokens:  resp.PromptEvalCount,
+		},
+	}, nil
+}
+
+// PullModel downloads the model to the Ollama server, reporting the progress to the handler.
+// An empty server URL is the configured server.
+func (o *ollamaPlatform) PullModel(ctx context.Context, serverURL string, model string, handler PullProgressHandler) error {
+	client, err := o.getClient(serverURL)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("model", model).Str("serverURL", serverURL).Msg("Pulling Ollama model")
+	defer o.forgetModel(serverURL, model)
+
+	err = client.PullModel(ctx, model, func(progress api.ProgressResponse) error {
+		return handler(PullProgress{
+			Status:    progress.Status,
+			Digest:    progress.Digest,
+			Total:     progress.Total,
+			Completed: progress.Completed,
+		})
+	})
+	if err != nil {
+		return ollamaModelError(model, err)
+	}
+
+	log.Info().Str("model", model).Str("serverURL", serverURL).Msg("Ollama model pulled")
+	return nil
+}
+
+// DeleteModel removes the model from the Ollama server, an empty server URL is the configured server
+func (o *ollamaPlatform) DeleteModel(ctx context.Context, serverURL string, model string) error {
+	client, err := o.getClient(serverURL)
+	if err != nil {
+		return err
+	}
+
+	if err := client.DeleteModel(ctx, model); err != nil {
+		return ollamaModelError(model, err)
+	}
+	o.forgetModel(serverURL, model)
+
+	log.Info().Str("model", model).Str("serverURL", serverURL).Msg("Ollama model deleted")
+	return nil
+}
+
+// ShowModel returns the details of a model of the Ollama server, an empty server URL is the configured server
+func (o *ollamaPlatform) ShowModel(ctx context.Context, serverURL string, model string) (*ModelDetails, error) {
+	resp, err := o.show(ctx, serverURL, model)
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities := make([]string, 0, len(resp.Capabilities))
+	for _, capability := range resp.Capabilities {
+		capabilities = append(capabilities, string(capability))
+	}
+
+	return &ModelDetails{
+		Name:              model,
+		Family:            resp.Details.Family,
+		ParameterSize:     resp.Details.ParameterSize,
+		QuantizationLevel: resp.Details.QuantizationLevel,
+		ContextLength:     ollamaContextLength(resp.ModelInfo),
+		Capabilities:      capabilities,
+		Parameters:        ollamaParameters(resp.Parameters),
+	}, nil
+}
+
+// show returns the details of a model of the server as reported by Ollama
+func (o *ollamaPlatform) show(ctx context.Context, serverURL string, model string) (*api.ShowResponse, error) {
+	client, err := o.getClient(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.ShowModel(ctx, model)
+	if err != nil {
+		return nil, ollamaModelError(model, err)
+	}
+	return resp, nil
+}
+
+// LoadModel loads the model on the Ollama server and keeps it loaded for keepAlive, so that the first request
+// of a learner doesn't wait for the model to load. An empty server URL is the configured server.
+func (o *ollamaPlatform) LoadModel(ctx context.Context, serverURL string, model string, keepAlive time.Duration) error {
+	client, err := o.getClient(serverURL)
+	if err != nil {
+		return err
+	}
+
+	if err := client.LoadModel(ctx, model, keepAlive); err != nil {
+		return ollamaModelError(model, err)
+	}
+
+	log.Info().Str("model", model).Str("serverURL", serverURL).Dur("keepAlive", keepAlive).Msg("Ollama model keep alive set")
+	return nil
+}
+
+// ollamaModelError returns ErrModelNotFound for a model the Ollama server doesn't have
+func ollamaModelError(model string, err error) error {
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrModelNotFound, model)
+	}
+	return err
+}
+
+// ollamaCapabilities converts the capabilities reported by Ollama, every completion model can answer in JSON
+func ollamaCapabilities(capabilities []model.Capability) []Capability {
+	var result []Capability
+	for _, capability := range capabilities {
+		switch capability {
+		case model.CapabilityCompletion:
+			result = append(result, CapabilityCompletion, CapabilityJSON)
+		case model.CapabilityTools:
+			result = append(result, CapabilityTools)
+		case model.CapabilityVision:
+			result = append(result, CapabilityVision)
+		case model.CapabilityEmbedding:
+			result = append(result, CapabilityEmbedding)
+		}
+	}
+	return result
+}
+
+// ollamaContextLength returns the context length of the model info, which is keyed by the architecture
+// of the model, e.g. "gemma3.context_length"
+func ollamaContextLength(modelInfo map[string]any) int {
+	architecture, _ := modelInfo["general.architecture"].(string)
+	if architecture == "" {
+		return 0
+	}
+
+	switch length := modelInfo[architecture+".context_length"].(type) {
+	case float64:
+		return int(length)
+	case int:
+		return length
+	default:
+		return 0
+	}
+}
+
+// ollamaParameters parses the parameters of a model, one "name value" per line where names may repeat
+func ollamaParameters(parameters string) map[string][]string {
+	result := make(map[string][]string)
+	for _, line := range strings.Split(parameters, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		result[name] = append(result[name], value)
+	}
+	return result
+}
+
+// ollamaOptions converts the sampling parameters to Ollama model options, nil when none are set
+func ollamaOptions(sampling domain.SamplingParameters) map[string]interface{} {
+	if sampling.IsEmpty() {
+		return nil
+	}
+
+	options := map[string]interface{}{}
+	if sampling.Temperature != nil {
+		options["temperature"] = *sampling.Temperature
+	}
+	if sampling.TopP != nil {
+		options["top_p"] = *sampling.TopP
+	}
+	if sampling.Seed != nil {
+		options["seed"] = *sampling.Seed
+	}
+	if sampling.MaxTokens != nil {
+		options["num_predict"] = *sampling.MaxTokens
+	}
+	if sampling.ContextLength != nil {
+		options["num_ctx"] = *sampling.ContextLength
+	}
+	return options
+}
+
+// ollamaFormat converts the response format to the format field of the Ollama chat request
+func ollamaFormat(format *ResponseFormat) json.RawMessage {
+	if format == nil {
+		return nil
+	}
+
+	if format.Type == ResponseFormatJSONSchema && format.Schema != nil {
+		schema, err := json.Marshal(format.Schema)
+		if err == nil {
+			return schema
+		}
+		log.Warn().Err(err).Msg("Failed to encode JSON schema, asking Ollama for plain JSON")
+	}
+
+	return json.RawMessage(`"json"`)
+}
+
+// ollamaMessages converts the chat history to Ollama messages.
+// Ollama identifies tool results by the name of the tool, which is found from the call the result answers.
+func ollamaMessages(messages []*domain.ChatItem) []api.Message {
+	toolNames := map[string]string{}
+	apiMessages := make([]api.Message, 0, len(messages))
+	for _, msg := range messages {
+		apiMessage := api.Message{
+			Role:    msg.Role, // Assuming roles like "user", "assistant", "system" are compatible
+			Content: msg.Content,
+		}
+
+		for _, call := range msg.ToolCalls {
+			toolNames[call.ID] = call.Name
+
+			var arguments api.ToolCallFunctionArguments
+			if len(call.Arguments) > 0 {
+				if err := json.Unmarshal(call.Arguments, &arguments); err != nil {
+					log.Warn().Err(err).Str("tool", call.Name).Msg("Invalid tool call arguments, sending none to Ollama")
+				}
+			}
+			apiMessage.ToolCalls = append(apiMessage.ToolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{Name: call.Name, Arguments: arguments},
+			})
+		}
+
+		if msg.Role == domain.ChatItemRoleTool {
+			apiMessage.ToolName = toolNames[msg.ToolCallID]
+		}
+
+		apiMessages = append(apiMessages, apiMessage)
+	}
+	return apiMessages
+}
+
+// ollamaTools converts the tools to Ollama tools, the JSON Schema of the parameters is converted through its encoding
+func ollamaTools(tools []Tool) api.Tools {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	apiTools := make(api.Tools, 0, len(tools))
+	for _, tool := range tools {
+		function := api.ToolFunction{Name: tool.Name, Description: tool.Description}
+		if tool.Parameters != nil {
+			data, err := json.Marshal(tool.Parameters)
+			if err == nil {
+				err = json.Unmarshal(data, &function.Parameters)
+			}
+			if err != nil {
+				log.Warn().Err(err).Str("tool", tool.Name).Msg("Failed to convert the tool parameters for Ollama")
+			}
+		}
+		apiTools = append(apiTools, api.Tool{Type: "function", Function: function})
+	}
+	return apiTools
+}
+
+// ollamaToolCalls converts the tool calls of an Ollama response.
+// Ollama doesn't identify the calls, so they are identified by their position in the response.
+func ollamaToolCalls(calls []api.ToolCall) []domain.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	toolCalls := make([]domain.ToolCall, 0, len(calls))
+	for i, call := range calls {
+		arguments, err := json.Marshal(call.Function.Arguments)
+		if err != nil || call.Function.Arguments == nil {
+			arguments = json.RawMessage(`{}`)
+		}
+		toolCalls = append(toolCalls, domain.ToolCall{
+			ID:        fmt.Sprintf("call_%d", i),
+			Name:      call.Function.Name,
+			Arguments: arguments,
+		})
+	}
+	return toolCalls
 }
 
 // estimateTokenCount provides a rough estimate of tokens from text
@@ -1,8 +1,12 @@
 package llm
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"slices"
 	"strings"
 	"time"
@@ -10,6 +14,7 @@ import (
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
 	"github.com/rs/zerolog/log"
 )
 
@@ -56,6 +61,8 @@ const (
 	openAIBaseURL      = "https://api.openai.com/v1"
 	openAITimeout      = 60 * time.Second
 	defaultOpenAIModel = "gpt-4.1-nano"
+	// defaultOpenAIEmbeddingModel is the embedding model used when neither the request nor the config name one
+	defaultOpenAIEmbeddingModel = "text-embedding-3-small"
 )
 
 // newOpenAIPlatform creates a new OpenAI platform
@@ -68,10 +75,15 @@ func newOpenAIPlatform(cfg OpenAIConfig) Platform {
 		cfg.BaseURL = openAIBaseURL
 	}
 
-	client := openai.NewClient(
+	opts := []option.RequestOption{
 		option.WithAPIKey(cfg.APIKey),
 		option.WithBaseURL(cfg.BaseURL),
-	)
+	}
+	if cfg.disableClientRetries {
+		opts = append(opts, option.WithMaxRetries(0))
+	}
+
+	client := openai.NewClient(opts...)
 
 	return &openAIPlatform{
 		cfg:    &cfg,
@@ -84,9 +96,9 @@ func (o *openAIPlatform) Type() PlatformType {
 	return OpenAIPlatform
 }
 
-func (o *openAIPlatform) Models() ([]*ModelInfo, error) {
+func (o *openAIPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), openAITimeout)
+	ctx, cancel := context.WithTimeout(ctx, openAITimeout)
 	defer cancel()
 
 	// Fetch models from OpenAI
@@ -111,12 +123,26 @@ func (o *openAIPlatform) Models() ([]*ModelInfo, error) {
 	}
 	// Filter models to only include allowed models
 	models = filterModels(models, o.cfg.AllowedModels)
+	addKnownCapabilities(OpenAIPlatform, models)
 
 	sortModels(models)
 
 	return models, nil
 }
 
+// describeModel returns the known capabilities of the model, the OpenAI API doesn't report them
+func (o *openAIPlatform) describeModel(ctx context.Context, model string, serverURL string) (*ModelInfo, error) {
+	return describeKnownModel(OpenAIPlatform, model), nil
+}
+
+// defaultModel returns the configured model, the configured embedding model for embeddings
+func (o *openAIPlatform) defaultModel(embedding bool) string {
+	if embedding {
+		return cmp.Or(o.cfg.EmbeddingModel, defaultOpenAIEmbeddingModel)
+	}
+	return o.cfg.Model
+}
+
 // filterModels filters the models to only include allowed models
 func filterModels(models []*ModelInfo, allowedModels []string) []*ModelInfo {
 	filteredModels := make([]*ModelInfo, 0, len(models))
@@ -129,7 +155,17 @@ func filterModels(models []*ModelInfo, allowedModels []string) []*ModelInfo {
 }
 
 // Chat sends a chat request to OpenAI
-func (o *openAIPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
+func (o *openAIPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return o.chatStream(ctx, params, nil)
+}
+
+// ChatStream sends a chat request to OpenAI and streams the response to the handler
+func (o *openAIPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return o.chatStream(ctx, params, handler)
+}
+
+// chatStream sends a single prompt to OpenAI, streaming the response when a handler is provided
+func (o *openAIPlatform) chatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
 	if params.Prompt == "" {
 		return nil, ErrPromptEmpty
 	}
@@ -143,10 +179,6 @@ func (o *openAIPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 		return nil, ErrModelNotSpecified
 	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), openAITimeout)
-	defer cancel()
-
 	// Create chat completion request
 	req := openai.ChatCompletionNewParams{
 		Model: model,
@@ -168,44 +200,53 @@ func (o *openAIPlatform) Chat(params *ChatParameters) (*ChatResponse, error) {
 		},
 	}
 
-	// Send the request
-	resp, err := o.client.Chat.Completions.New(ctx, req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending chat request: %w", err)
+	if params.ResponseFormat != nil {
+		req.ResponseFormat = openAIResponseFormat(params.ResponseFormat)
 	}
+	applyOpenAISampling(&req, params.Sampling)
 
-	// Ensure we have at least one choice
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from API")
+	// Send the request
+	resp, err := o.send(ctx, req, handler)
+	if err != nil {
+		return nil, err
 	}
 
-	// Calculate cost
-	cost := float64(resp.Usage.TotalTokens) * o.cfg.CostPerMillionToken / 1_000_000
-
 	log.Debug().
 		Str("model", model).
 		Int64("promptTokens", resp.Usage.PromptTokens).
 		Int64("completionTokens", resp.Usage.CompletionTokens).
 		Int64("totalTokens", resp.Usage.TotalTokens).
-		Float64("cost", cost).
+		Int64("cachedPromptTokens", resp.Usage.PromptTokensDetails.CachedTokens).
+		Bool("stream", handler != nil).
 		Msg("OpenAI chat response received")
 
 	// Create the response
 	return &ChatResponse{
 		Response: resp.Choices[0].Message.Content,
 		Usage: &domain.Usage{
-			LlmModelName:     model,
-			CacheHit:         false,
-			Cost:             cost,
-			PromptTokens:     int(resp.Usage.PromptTokens),
-			CompletionTokens: int(resp.Usage.CompletionTokens),
-			TotalTokens:      int(resp.Usage.TotalTokens),
+			LlmModelName: model,
+			CacheHit:     false,
+			// The cost is computed by the service from the pricing table
+			PromptTokens:       int(resp.Usage.PromptTokens),
+			CachedPromptTokens: int(resp.Usage.PromptTokensDetails.CachedTokens),
+			CompletionTokens:   int(resp.Usage.CompletionTokens),
+			TotalTokens:        int(resp.Usage.TotalTokens),
 		},
 	}, nil
 }
 
 // ChatWithHistory sends a chat request with message history to OpenAI
-func (o *openAIPlatform) ChatWithHistory(messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+func (o *openAIPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	return o.chatWithHistoryStream(ctx, messages, params, nil)
+}
+
+// ChatWithHistoryStream sends a chat request with message history to OpenAI and streams the response to the handler
+func (o *openAIPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return o.chatWithHistoryStream(ctx, messages, params, handler)
+}
+
+// chatWithHistoryStream sends the message history to OpenAI, streaming the response when a handler is provided
+func (o *openAIPlatform) chatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
 	if len(messages) == 0 {
 		return nil, fmt.Errorf("no messages provided")
 	}
@@ -220,10 +261,6 @@ func (o *openAIPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 		return nil, ErrModelNotSpecified
 	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), openAITimeout)
-	defer cancel()
-
 	// Convert domain.ChatItem to openai.ChatCompletionMessageParamUnion
 	openaiMessages := make([]openai.ChatCompletionMessageParamUnion, 0, len(messages)+1) // +1 for system prompt
 
@@ -259,13 +296,25 @@ func (o *openAIPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 				},
 			}
 		case domain.ChatItemRoleAssistant:
-			openAIMsg = openai.ChatCompletionMessageParamUnion{
-				OfAssistant: &openai.ChatCompletionAssistantMessageParam{
-					Content: openai.ChatCompletionAssistantMessageParamContentUnion{
-						OfString: openai.String(msg.Content),
+			assistant := &openai.ChatCompletionAssistantMessageParam{}
+			// An assistant message calling tools may have no content
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				assistant.Content = openai.ChatCompletionAssistantMessageParamContentUnion{
+					OfString: openai.String(msg.Content),
+				}
+			}
+			for _, call := range msg.ToolCalls {
+				assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+					ID: call.ID,
+					Function: openai.ChatCompletionMessageToolCallFunctionParam{
+						Name:      call.Name,
+						Arguments: string(call.Arguments),
 					},
-				},
+				})
 			}
+			openAIMsg = openai.ChatCompletionMessageParamUnion{OfAssistant: assistant}
+		case domain.ChatItemRoleTool:
+			openAIMsg = openai.ToolMessage(msg.Content, msg.ToolCallID)
 		default:
 			log.Warn().Str("role", msg.Role).Msg("Unknown message role encountered while converting to OpenAI format")
 			return nil, fmt.Errorf("unknown message role: %s", msg.Role)
@@ -279,46 +328,184 @@ func (o *openAIPlatform) ChatWithHistory(messages []*domain.ChatItem, params *Ch
 		Messages: openaiMessages,
 	}
 
-	// Send the request
-	resp, err := o.client.Chat.Completions.New(ctx, req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending chat request: %w", err)
+	if params.ResponseFormat != nil {
+		req.ResponseFormat = openAIResponseFormat(params.ResponseFormat)
 	}
+	applyOpenAISampling(&req, params.Sampling)
+	req.Tools = openAITools(params.Tools)
 
-	// Ensure we have at least one choice
-	if len(resp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from API")
+	// Send the request
+	resp, err := o.send(ctx, req, handler)
+	if err != nil {
+		return nil, err
 	}
 
-	// Calculate cost
-	cost := float64(resp.Usage.TotalTokens) * o.cfg.CostPerMillionToken / 1_000_000
-
 	log.Debug().
 		Str("model", model).
 		Int64("promptTokens", resp.Usage.PromptTokens).
 		Int64("completionTokens", resp.Usage.CompletionTokens).
 		Int64("totalTokens", resp.Usage.TotalTokens).
-		Float64("cost", cost).
+		Int64("cachedPromptTokens", resp.Usage.PromptTokensDetails.CachedTokens).
 		Int("messageCount", len(messages)).
 		Bool("hasSystemPrompt", params.SystemPrompt != "").
+		Int("toolCalls", len(resp.Choices[0].Message.ToolCalls)).
+		Bool("stream", handler != nil).
 		Msg("OpenAI chat with history response received")
 
 	// Create the response
 	return &ChatResponse{
 		Response: resp.Choices[0].Message.Content,
 		Usage: &domain.Usage{
-			LlmModelName:     model,
-			CacheHit:         false,
-			Cost:             cost,
-			PromptTokens:     int(resp.Usage.PromptTokens),
-			CompletionTokens: int(resp.Usage.CompletionTokens),
-			TotalTokens:      int(resp.Usage.TotalTokens),
+			LlmModelName: model,
+			CacheHit:     false,
+			// The cost is computed by the service from the pricing table
+			PromptTokens:       int(resp.Usage.PromptTokens),
+			CachedPromptTokens: int(resp.Usage.PromptTokensDetails.CachedTokens),
+			CompletionTokens:   int(resp.Usage.CompletionTokens),
+			TotalTokens:        int(resp.Usage.TotalTokens),
 		},
+		ToolCalls: openAIToolCalls(resp.Choices[0].Message.ToolCalls),
 	}, nil
 }
 
+// send sends the chat completion request and returns the completion.
+// When handler is not nil the request is streamed and every content delta is passed to the handler,
+// the returned completion is then accumulated from the streamed chunks.
+func (o *openAIPlatform) send(ctx context.Context, req openai.ChatCompletionNewParams, handler StreamHandler) (*openai.ChatCompletion, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, openAITimeout)
+	defer cancel()
+
+	if handler == nil {
+		resp, err := o.client.Chat.Completions.New(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("error sending chat request: %w", err)
+		}
+
+		// Ensure we have at least one choice
+		if len(resp.Choices) == 0 {
+			return nil, fmt.Errorf("no response from API")
+		}
+
+		return resp, nil
+	}
+
+	// Ask for the usage to be reported in the last chunk of the stream
+	req.StreamOptions = openai.ChatCompletionStreamOptionsParam{
+		IncludeUsage: openai.Bool(true),
+	}
+
+	stream := o.client.Chat.Completions.NewStreaming(ctx, req)
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			if err := handler(chunk.Choices[0].Delta.Content); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("error streaming chat request: %w", err)
+	}
+
+	// Ensure we have at least one choice
+	if len(acc.Choices) == 0 {
+		return nil, fmt.Errorf("no response from API")
+	}
+
+	return &acc.ChatCompletion, nil
+}
+
+// openAITools converts the tools to OpenAI function tools
+func openAITools(tools []Tool) []openai.ChatCompletionToolParam {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	openAITools := make([]openai.ChatCompletionToolParam, 0, len(tools))
+	for _, tool := range tools {
+		function := shared.FunctionDefinitionParam{
+			Name:       tool.Name,
+			Parameters: shared.FunctionParameters(tool.Parameters),
+		}
+		if tool.Description != "" {
+			function.Description = openai.String(tool.Description)
+		}
+		openAITools = append(openAITools, openai.ChatCompletionToolParam{Function: function})
+	}
+	return openAITools
+}
+
+// openAIToolCalls converts the tool calls of an OpenAI completion, the arguments are a JSON encoded object
+func openAIToolCalls(calls []openai.ChatCompletionMessageToolCall) []domain.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	toolCalls := make([]domain.ToolCall, 0, len(calls))
+	for _, call := range calls {
+		arguments := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(arguments) {
+			arguments = json.RawMessage(`{}`)
+		}
+		toolCalls = append(toolCalls, domain.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: arguments,
+		})
+	}
+	return toolCalls
+}
+
+// applyOpenAISampling sets the sampling parameters on the request, OpenAI has no context length setting
+func applyOpenAISampling(req *openai.ChatCompletionNewParams, sampling domain.SamplingParameters) {
+	if sampling.Temperature != nil {
+		req.Temperature = openai.Float(*sampling.Temperature)
+	}
+	if sampling.TopP != nil {
+		req.TopP = openai.Float(*sampling.TopP)
+	}
+	if sampling.Seed != nil {
+		req.Seed = openai.Int(int64(*sampling.Seed))
+	}
+	if sampling.MaxTokens != nil {
+		req.MaxCompletionTokens = openai.Int(int64(*sampling.MaxTokens))
+	}
+}
+
+// openAIResponseFormat converts the response format to the OpenAI response_format parameter.
+// Schemas are not strict, strict mode doesn't allow optional fields.
+func openAIResponseFormat(format *ResponseFormat) openai.ChatCompletionNewParamsResponseFormatUnion {
+	if format.Type == ResponseFormatJSONSchema && format.Schema != nil {
+		name := format.Name
+		if name == "" {
+			name = "response"
+		}
+
+		return openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{
+				JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   name,
+					Schema: format.Schema,
+					Strict: openai.Bool(false),
+				},
+			},
+		}
+	}
+
+	return openai.ChatCompletionNewParamsResponseFormatUnion{
+		OfJSONObject: &shared.ResponseFormatJSONObjectParam{},
+	}
+}
+
 // DescribeImage sends an image to OpenAI for description
-func (o *openAIPlatform) DescribeImage(params *DescribeImageParameters) (*DescribeImageResponse, error) {
+func (o *openAIPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
 	if params.Reader == nil {
 		return nil, ErrContextMissing
 	}
@@ -332,11 +519,22 @@ func (o *openAIPlatform) DescribeImage(params *DescribeImageParameters) (*Descri
 		return nil, ErrModelNotSpecified
 	}
 
+	data, err := io.ReadAll(params.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	prompt := params.Prompt
+	if prompt == "" {
+		prompt = "Describe this image."
+	}
+
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), openAITimeout)
+	ctx, cancel := context.WithTimeout(ctx, openAITimeout)
 	defer cancel()
 
-	// Create chat completion request with image
+	// Create chat completion request with the image inlined as a data URL
+	imageURL := fmt.Sprintf("data:%s;base64,%s", imageMediaType(params.FileName, data), base64.StdEncoding.EncodeToString(data))
 	req := openai.ChatCompletionNewParams{
 		Model: model,
 		Messages: []openai.ChatCompletionMessageParamUnion{
@@ -347,16 +545,18 @@ func (o *openAIPlatform) DescribeImage(params *DescribeImageParameters) (*Descri
 					},
 				},
 			},
-			{
-				OfUser: &openai.ChatCompletionUserMessageParam{
-					Content: openai.ChatCompletionUserMessageParamContentUnion{
-						OfString: openai.String(params.Prompt),
-					},
-				},
-			},
+			openai.UserMessage([]openai.ChatCompletionContentPartUnionParam{
+				openai.TextContentPart(prompt),
+				openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: imageURL}),
+			}),
 		},
 	}
 
+	if params.ResponseFormat != nil {
+		req.ResponseFormat = openAIResponseFormat(params.ResponseFormat)
+	}
+	applyOpenAISampling(&req, params.Sampling)
+
 	// Send the request
 	resp, err := o.client.Chat.Completions.New(ctx, req)
 	if err != nil {
@@ -368,27 +568,87 @@ func (o *openAIPlatform) DescribeImage(params *DescribeImageParameters) (*Descri
 		return nil, fmt.Errorf("no response from API")
 	}
 
-	// Calculate cost
-	cost := float64(resp.Usage.TotalTokens) * o.cfg.CostPerMillionToken / 1_000_000
-
 	log.Debug().
 		Str("model", model).
 		Int64("promptTokens", resp.Usage.PromptTokens).
 		Int64("completionTokens", resp.Usage.CompletionTokens).
 		Int64("totalTokens", resp.Usage.TotalTokens).
-		Float64("cost", cost).
+		Int64("cachedPromptTokens", resp.Usage.PromptTokensDetails.CachedTokens).
 		Msg("OpenAI image description received")
 
 	// Create the response
 	return &DescribeImageResponse{
 		Description: resp.Choices[0].Message.Content,
 		Usage: &domain.Usage{
-			LlmModelName:     model,
-			CacheHit:         false,
-			Cost:             cost,
-			PromptTokens:     int(resp.Usage.PromptTokens),
-			CompletionTokens: int(resp.Usage.CompletionTokens),
-			TotalTokens:      int(resp.Usage.TotalTokens),
+			LlmModelName: model,
+			CacheHit:     false,
+			// The cost is computed by the service from the pricing table
+			PromptTokens:       int(resp.Usage.PromptTokens),
+			CachedPromptTokens: int(resp.Usage.PromptTokensDetails.CachedTokens),
+			CompletionTokens:   int(resp.Usage.CompletionTokens),
+			TotalTokens:        int(resp.Usage.TotalTokens),
+		},
+	}, nil
+}
+
+// Embed sends the texts to the OpenAI embeddings endpoint
+func (o *openAIPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	if len(params.Texts) == 0 {
+		return nil, ErrEmbedTextsEmpty
+	}
+
+	model := params.Model
+	if model == "" {
+		model = o.cfg.EmbeddingModel
+	}
+	if model == "" {
+		model = defaultOpenAIEmbeddingModel
+	}
+
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, openAITimeout)
+	defer cancel()
+
+	resp, err := o.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Model: model,
+		Input: openai.EmbeddingNewParamsInputUnion{
+			OfArrayOfStrings: params.Texts,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error sending embeddings request: %w", err)
+	}
+
+	if len(resp.Data) != len(params.Texts) {
+		return nil, fmt.Errorf("expected %d embeddings from API, got %d", len(params.Texts), len(resp.Data))
+	}
+
+	// The embeddings are returned with the index of their text, not necessarily in order
+	embeddings := make([][]float32, len(params.Texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || int(data.Index) >= len(embeddings) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		embedding := make([]float32, len(data.Embedding))
+		for i, value := range data.Embedding {
+			embedding[i] = float32(value)
+		}
+		embeddings[data.Index] = embedding
+	}
+
+	log.Debug().
+		Str("model", model).
+		Int("texts", len(params.Texts)).
+		Int64("promptTokens", resp.Usage.PromptTokens).
+		Msg("OpenAI embeddings received")
+
+	return &EmbedResponse{
+		Embeddings: embeddings,
+		Usage: &domain.Usage{
+			LlmModelName: model,
+			// The cost is computed by the service from the pricing table
+			PromptTokens: int(resp.Usage.PromptTokens),
+			TotalTokens:  int(resp.Usage.TotalTokens),
 		},
 	}, nil
 }
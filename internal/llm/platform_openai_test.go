@@ -2,10 +2,15 @@ package llm
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -96,7 +101,7 @@ func TestOpenAIPlatform_FilterModels(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			platform := newOpenAIPlatform(tt.cfg)
-			models, err := platform.Models()
+			models, err := platform.Models(context.Background())
 			require.NoError(t, err)
 			assert.NotEmpty(t, models)
 		})
@@ -143,7 +148,7 @@ func TestOpenAIPlatform_Chat(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp, err := platform.Chat(tt.params)
+			resp, err := platform.Chat(context.Background(), tt.params)
 			if tt.wantErr {
 				assert.Error(t, err)
 				return
@@ -226,7 +231,7 @@ func TestOpenAIPlatform_DescribeImage(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp, err := platform.DescribeImage(tt.params)
+			resp, err := platform.DescribeImage(context.Background(), tt.params)
 			if tt.wantErr {
 				assert.Error(t, err)
 				return
@@ -315,7 +320,7 @@ func TestOpenAIPlatform_Models(t *testing.T) {
 			})
 
 			// Get models
-			models, err := platform.Models()
+			models, err := platform.Models(context.Background())
 
 			// Check error condition
 			if tt.wantErr {
@@ -334,3 +339,101 @@ func TestOpenAIPlatform_Models(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenAIPlatformDescribeImageSendsImage(t *testing.T) {
+	var request struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "1", "object": "chat.completion", "model": "gpt-4.1-nano",
+			"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": "A worksheet"}}],
+			"usage": {"prompt_tokens": 120, "completion_tokens": 3, "total_tokens": 123}}`))
+	}))
+	defer server.Close()
+
+	platform := newOpenAIPlatform(OpenAIConfig{APIKey: "test-key", BaseURL: server.URL})
+
+	response, err := platform.DescribeImage(context.Background(), &DescribeImageParameters{
+		ChatParameters: ChatParameters{Prompt: "What is this?"},
+		Reader:         bytes.NewReader([]byte("\x89PNG\r\n\x1a\nimage")),
+		FileName:       "worksheet.png",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "A worksheet", response.Description)
+	assert.Equal(t, 120, response.Usage.PromptTokens)
+
+	require.Len(t, request.Messages, 2)
+	assert.Contains(t, string(request.Messages[1].Content), `"type":"image_url"`)
+	assert.Contains(t, string(request.Messages[1].Content), "data:image/png;base64,")
+	assert.Contains(t, string(request.Messages[1].Content), "What is this?")
+}
+
+func TestOpenAIPlatformChatWithTools(t *testing.T) {
+	var request struct {
+		Messages []struct {
+			Role       string `json:"role"`
+			ToolCallID string `json:"tool_call_id"`
+			ToolCalls  []struct {
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"messages"`
+		Tools []struct {
+			Type     string `json:"type"`
+			Function struct {
+				Name       string         `json:"name"`
+				Parameters map[string]any `json:"parameters"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "1", "object": "chat.completion", "model": "gpt-4.1-nano",
+			"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": null,
+				"tool_calls": [{"id": "call_abc", "type": "function", "function": {"name": "create_practice_session", "arguments": "{\"learner\":\"Mia\"}"}}]}}],
+			"usage": {"prompt_tokens": 80, "completion_tokens": 12, "total_tokens": 92}}`))
+	}))
+	defer server.Close()
+
+	platform := newOpenAIPlatform(OpenAIConfig{APIKey: "test-key", BaseURL: server.URL, Model: "gpt-4.1-nano"})
+
+	messages := []*domain.ChatItem{
+		{Role: domain.ChatItemRoleUser, Content: "How is Mia doing?"},
+		{Role: domain.ChatItemRoleAssistant, ToolCalls: []domain.ToolCall{{ID: "call_1", Name: "get_recent_results", Arguments: []byte(`{"learner":"Mia"}`)}}},
+		{Role: domain.ChatItemRoleTool, Content: `{"correct":3}`, ToolCallID: "call_1"},
+	}
+	response, err := platform.ChatWithHistory(context.Background(), messages, &ChatParameters{
+		Tools: []Tool{{
+			Name:       "create_practice_session",
+			Parameters: map[string]any{"type": "object", "properties": map[string]any{}},
+		}},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, response.ToolCalls, 1)
+	assert.Equal(t, "call_abc", response.ToolCalls[0].ID)
+	assert.Equal(t, "create_practice_session", response.ToolCalls[0].Name)
+	assert.JSONEq(t, `{"learner":"Mia"}`, string(response.ToolCalls[0].Arguments))
+
+	require.Len(t, request.Tools, 1)
+	assert.Equal(t, "function", request.Tools[0].Type)
+	assert.Equal(t, "create_practice_session", request.Tools[0].Function.Name)
+	assert.Equal(t, "object", request.Tools[0].Function.Parameters["type"])
+
+	require.Len(t, request.Messages, 3)
+	require.Len(t, request.Messages[1].ToolCalls, 1)
+	assert.Equal(t, "call_1", request.Messages[1].ToolCalls[0].ID)
+	assert.Equal(t, `{"learner":"Mia"}`, request.Messages[1].ToolCalls[0].Function.Arguments)
+	assert.Equal(t, "tool", request.Messages[2].Role)
+	assert.Equal(t, "call_1", request.Messages[2].ToolCallID)
+}
@@ -1,63 +1,97 @@
 package llm
 
 import (
+	"encoding/json"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 	"github.com/rs/zerolog/log"
 )
 
-// PocketBaseCacheStorage implements CacheStorage using PocketBase
+// defaultCacheCleanupCron purges the expired responses of the PocketBase cache every hour
+const defaultCacheCleanupCron = "0 * * * *"
+
+// PocketBaseCacheStorage implements CacheStorage using PocketBase.
+// Expired responses are misses, they are purged by a cron job, see CleanExpired.
 type PocketBaseCacheStorage struct {
-	app core.App
-	dao *LLMResponseRecordDao
+	app    core.App
+	dao    *LLMResponseRecordDao
+	config CacheConfig
+	now    func() time.Time
+	// counters of the running instance, the records don't track them
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	expirations atomic.Uint64
 }
 
-// NewPocketBaseCacheStorage creates a new PocketBase-backed cache storage
+// NewPocketBaseCacheStorage creates a new PocketBase-backed cache storage with the default TTLs
 func NewPocketBaseCacheStorage(app core.App) CacheStorage {
+	return NewPocketBaseCacheStorageWithConfig(app, CacheConfig{})
+}
+
+// NewPocketBaseCacheStorageWithConfig creates a new PocketBase-backed cache storage with the TTLs of the configuration
+func NewPocketBaseCacheStorageWithConfig(app core.App, config CacheConfig) *PocketBaseCacheStorage {
 	return &PocketBaseCacheStorage{
-		app: app,
-		dao: NewLLMResponseRecordDao(app),
+		app:    app,
+		dao:    NewLLMResponseRecordDao(app),
+		config: config.withDefaults(),
+		now:    time.Now,
 	}
 }
 
 // GetChatResponse retrieves a cached response by its key
 func (p *PocketBaseCacheStorage) GetChatResponse(key string) (*ChatResponse, error) {
-	cached, err := p.dao.FindLLMResponseRecordByKey(key)
+	cached, err := p.lookup(key)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(cached.Response) < 1 {
-		log.Error().
-			Str("cacheKey", key).
-			Msg("Cached response is empty")
-		return nil, fmt.Errorf("cached response is empty")
-	}
-
 	log.Debug().
 		Str("cacheKey", key).
 		Str("response_preview", cached.Response[:min(20, len(cached.Response))]).
 		Msg("Cache hit")
 
-	// Create usage information
-	usage := &domain.Usage{
-		LlmModelName:     cached.ModelName,
-		CacheHit:         true,
-		Cost:             0, // No cost for cache hit
-		TotalTokens:      cached.TotalTokens,
-		PromptTokens:     cached.PromptTokens,
-		CompletionTokens: cached.CompletionTokens,
-	}
-
 	return &ChatResponse{
 		Response: cached.Response,
-		Usage:    usage,
+		Usage:    cached.usage(),
 	}, nil
 }
 
+// lookup finds the cached response of the key and counts the hit or the miss
+func (p *PocketBaseCacheStorage) lookup(key string) (*LLMResponseRecord, error) {
+	cached, err := p.dao.FindLLMResponseRecordByKey(key)
+	if err != nil {
+		p.misses.Add(1)
+		return nil, err
+	}
+
+	// The key is unique, so the expired response is deleted to make room for the new one
+	if cached.Expired(p.now()) {
+		p.misses.Add(1)
+		p.expirations.Add(1)
+		if err := p.dao.DeleteLLMResponseRecord(cached); err != nil {
+			log.Error().Err(err).Str("cacheKey", key).Msg("Failed to delete expired LLM response")
+		}
+		return nil, fmt.Errorf("cached response expired")
+	}
+
+	if len(cached.Response) < 1 {
+		log.Error().
+			Str("cacheKey", key).
+			Msg("Cached response is empty")
+		p.misses.Add(1)
+		return nil, fmt.Errorf("cached response is empty")
+	}
+	p.hits.Add(1)
+
+	return cached, nil
+}
+
 // GetDescribeImageResponse retrieves a cached image description by its key
 func (p *PocketBaseCacheStorage) GetDescribeImageResponse(key string) (*DescribeImageResponse, error) {
 	// We don't cache image descriptions
@@ -73,11 +107,12 @@ func (p *PocketBaseCacheStorage) SetChatResponse(key string, params *ChatParamet
 		SystemPrompt:     params.SystemPrompt,
 		Response:         response.Response,
 		ModelName:        response.Usage.LlmModelName,
-		Backend:          string(response.Usage.LlmModelName),
+		Backend:          response.Usage.Platform,
 		PromptTokens:     response.Usage.PromptTokens,
 		CompletionTokens: response.Usage.CompletionTokens,
 		TotalTokens:      response.Usage.TotalTokens,
 		Cost:             response.Usage.Cost,
+		TTL:              int(p.config.ChatTTL.Seconds()),
 	}
 
 	// Save the record
@@ -96,17 +131,19 @@ func (p *PocketBaseCacheStorage) SetDescribeImageResponse(key string, params *De
 }
 
 // GetChatCacheKey generates a cache key from the chat parameters
-func (p *PocketBaseCacheStorage) GetChatCacheKey(params *ChatParameters) string {
+func (p *PocketBaseCacheStorage) GetChatCacheKey(platform PlatformType, params *ChatParameters) string {
 	modelName := params.Model
-	return generateCacheKey(params.Prompt, params.SystemPrompt, modelName, "")
+	return generateCacheKey(params.Prompt, params.SystemPrompt, modelName, PlatformType(serverCacheKey(platform, params)), params.generationCacheKey())
 }
 
 // GetChatWithHistoryCacheKey generates a cache key for a chat request with message history
-func (p *PocketBaseCacheStorage) GetChatWithHistoryCacheKey(messages []*domain.ChatItem, systemPrompt, model string) string {
+func (p *PocketBaseCacheStorage) GetChatWithHistoryCacheKey(platform PlatformType, messages []*domain.ChatItem, params *ChatParameters) string {
 	// Create a hash of:
 	// 1. System prompt
 	// 2. Model name
-	// 3. All messages sequentially
+	// 3. Platform and Ollama server of the account
+	// 4. All messages sequentially
+	// 5. Generation settings such as the response format and sampling parameters
 
 	// Create a unique key based on message content
 	messagesKey := ""
@@ -116,7 +153,7 @@ func (p *PocketBaseCacheStorage) GetChatWithHistoryCacheKey(messages []*domain.C
 	}
 
 	// Generate a hash that includes all the conversation history
-	return generateCacheKey(messagesKey, systemPrompt, model, "history")
+	return generateCacheKey(messagesKey, params.SystemPrompt, params.Model, PlatformType("history|"+serverCacheKey(platform, params)), params.generationCacheKey())
 }
 
 // GetChatWithHistoryResponse retrieves a cached chat with history response
@@ -154,11 +191,12 @@ func (p *PocketBaseCacheStorage) SetChatWithHistoryResponse(key string, messages
 		SystemPrompt:     enhancedSystemPrompt, // Store system prompt with some history info
 		Response:         response.Response,
 		ModelName:        response.Usage.LlmModelName,
-		Backend:          string(response.Usage.LlmModelName),
+		Backend:          response.Usage.Platform,
 		PromptTokens:     response.Usage.PromptTokens,
 		CompletionTokens: response.Usage.CompletionTokens,
 		TotalTokens:      response.Usage.TotalTokens,
 		Cost:             response.Usage.Cost,
+		TTL:              int(p.config.ChatHistoryTTL.Seconds()),
 	}
 
 	// Save the record
@@ -173,7 +211,63 @@ func (p *PocketBaseCacheStorage) SetChatWithHistoryResponse(key string, messages
 // GetDescribeImageCacheKey generates a cache key for image description parameters
 func (p *PocketBaseCacheStorage) GetDescribeImageCacheKey(params *DescribeImageParameters) string {
 	modelName := params.Model
-	return generateCacheKey(params.Prompt, params.SystemPrompt, modelName, "image")
+	return generateCacheKey(params.Prompt, params.SystemPrompt, modelName, "image", "")
+}
+
+// GetEmbedCacheKey generates a cache key for an embed request on the platform
+func (p *PocketBaseCacheStorage) GetEmbedCacheKey(platform PlatformType, params *EmbedParameters) string {
+	return embedCacheKey(platform, params)
+}
+
+// GetEmbedResponse retrieves cached embeddings, they are stored as JSON in the response
+func (p *PocketBaseCacheStorage) GetEmbedResponse(key string) (*EmbedResponse, error) {
+	cached, err := p.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var embeddings [][]float32
+	if err := json.Unmarshal([]byte(cached.Response), &embeddings); err != nil {
+		return nil, fmt.Errorf("failed to decode cached embeddings: %w", err)
+	}
+
+	return &EmbedResponse{
+		Embeddings: embeddings,
+		Usage:      cached.usage(),
+	}, nil
+}
+
+// SetEmbedResponse stores embeddings as JSON, the first text is kept as the prompt
+func (p *PocketBaseCacheStorage) SetEmbedResponse(key string, params *EmbedParameters, response *EmbedResponse) error {
+	data, err := json.Marshal(response.Embeddings)
+	if err != nil {
+		return fmt.Errorf("failed to encode embeddings: %w", err)
+	}
+
+	prompt := ""
+	if len(params.Texts) > 0 {
+		prompt = params.Texts[0]
+	}
+
+	resp := &LLMResponseRecord{
+		Key:          key,
+		Prompt:       prompt,
+		SystemPrompt: fmt.Sprintf("[EMBEDDINGS] Texts: %d", len(params.Texts)),
+		Response:     string(data),
+		ModelName:    response.Usage.LlmModelName,
+		Backend:      response.Usage.Platform,
+		PromptTokens: response.Usage.PromptTokens,
+		TotalTokens:  response.Usage.TotalTokens,
+		Cost:         response.Usage.Cost,
+		TTL:          int(p.config.EmbedTTL.Seconds()),
+	}
+
+	if err := p.dao.SaveLLMResponseRecord(resp); err != nil {
+		log.Error().Err(err).Msg("Failed to save LLM embeddings in pocketbase")
+		return err
+	}
+
+	return nil
 }
 
 // CleanableStorage is an extension of CacheStorage that can clean up expired entries
@@ -188,23 +282,67 @@ var _ CleanableStorage = (*PocketBaseCacheStorage)(nil)
 
 // CleanExpired removes expired entries from the storage
 func (p *PocketBaseCacheStorage) CleanExpired() error {
-	var expired []*LLMResponseRecord
+	_, err := p.Purge(CachePurgeFilter{ExpiredOnly: true})
+	return err
+}
 
-	err := p.app.RecordQuery(domain.CollectionLLMResponses).
-		AndWhere(dbx.NewExp("ttl > 0 AND created + ttl < NOW()")).
-		All(&expired)
+// Stats returns the counters of the running instance and the size of the cached responses
+func (p *PocketBaseCacheStorage) Stats() (CacheStats, error) {
+	var totals struct {
+		Entries int   `db:"entries"`
+		Bytes   int64 `db:"bytes"`
+		Expired int   `db:"expired"`
+	}
 
+	err := p.app.DB().
+		Select(
+			"COUNT(*) AS entries",
+			"COALESCE(SUM(LENGTH(prompt) + LENGTH(system_prompt) + LENGTH(response)), 0) AS bytes",
+			"COALESCE(SUM(CASE WHEN "+expiredLLMResponseCondition+" THEN 1 ELSE 0 END), 0) AS expired",
+		).
+		From(domain.CollectionLLMResponses).
+		One(&totals)
 	if err != nil {
-		return err
+		return CacheStats{}, fmt.Errorf("failed to count cached LLM responses: %w", err)
 	}
 
-	for _, cache := range expired {
-		if err := p.dao.DeleteLLMResponseRecord(cache); err != nil {
-			return err
-		}
+	return CacheStats{
+		Backend:     PocketBaseCache,
+		Hits:        p.hits.Load(),
+		Misses:      p.misses.Load(),
+		Expirations: p.expirations.Load(),
+		Entries:     totals.Entries,
+		Bytes:       totals.Bytes,
+		Expired:     totals.Expired,
+	}, nil
+}
+
+// Purge deletes the cached responses matching the filter and returns how many were deleted
+func (p *PocketBaseCacheStorage) Purge(filter CachePurgeFilter) (int, error) {
+	conditions := []dbx.Expression{}
+	if filter.Model != "" {
+		conditions = append(conditions, dbx.HashExp{"model_name": filter.Model})
+	}
+	if filter.Backend != "" {
+		conditions = append(conditions, dbx.HashExp{"backend": filter.Backend})
+	}
+	if filter.OlderThan > 0 {
+		before := p.now().Add(-filter.OlderThan).UTC().Format(types.DefaultDateLayout)
+		conditions = append(conditions, dbx.NewExp("created < {:before}", dbx.Params{"before": before}))
+	}
+	if filter.ExpiredOnly {
+		conditions = append(conditions, expiredLLMResponseExp)
 	}
 
-	return nil
+	purged, err := p.dao.DeleteLLMResponseRecords(dbx.And(conditions...))
+	if err != nil {
+		return 0, err
+	}
+	if filter.ExpiredOnly {
+		p.expirations.Add(uint64(purged))
+	}
+
+	return int(purged), nil
 }
 
 // Helper function to get the min of two integers
@@ -214,3 +352,26 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// RegisterCacheCleanup registers the cron job purging the expired responses of the PocketBase cache.
+// It runs whatever the configured backend, responses cached before switching backends expire too.
+func RegisterCacheCleanup(app core.App, config CacheConfig, cacheStorage CacheStorage) error {
+	storage, ok := cacheStorage.(*PocketBaseCacheStorage)
+	if !ok {
+		storage = NewPocketBaseCacheStorageWithConfig(app, config)
+	}
+
+	schedule := config.CleanupCron
+	if schedule == "" {
+		schedule = defaultCacheCleanupCron
+	}
+
+	return app.Cron().Add("llmCacheCleanup", schedule, func() {
+		purged, err := storage.Purge(CachePurgeFilter{ExpiredOnly: true})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to purge expired LLM responses")
+			return
+		}
+		log.Info().Int("purged", purged).Msg("Purged expired LLM responses")
+	})
+}
@@ -0,0 +1,156 @@
+package llm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPocketBaseCacheStorage creates a cache storage on a test app with the llm_responses collection
+func newTestPocketBaseCacheStorage(t *testing.T) (*PocketBaseCacheStorage, *tests.TestApp) {
+	app, err := tests.NewTestApp()
+	require.NoError(t, err)
+	t.Cleanup(app.Cleanup)
+
+	collection := core.NewBaseCollection(domain.CollectionLLMResponses)
+	for _, name := range []string{"key", "prompt", "system_prompt", "response", "model_name", "backend"} {
+		collection.Fields.Add(&core.TextField{Name: name})
+	}
+	for _, name := range []string{"total_tokens", "prompt_tokens", "completion_tokens", "cost", "ttl"} {
+		collection.Fields.Add(&core.NumberField{Name: name})
+	}
+	collection.Fields.Add(&core.AutodateField{Name: "created", OnCreate: true})
+	collection.Fields.Add(&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true})
+	require.NoError(t, app.Save(collection))
+
+	return NewPocketBaseCacheStorageWithConfig(app, CacheConfig{ChatTTL: time.Hour}), app
+}
+
+func setTestCachedResponse(t *testing.T, storage *PocketBaseCacheStorage, key, platform, model string) {
+	response := &ChatResponse{Response: "cached " + key, Usage: &domain.Usage{Platform: platform, LlmModelName: model}}
+	require.NoError(t, storage.SetChatResponse(key, &ChatParameters{Prompt: key}, response))
+}
+
+// ageTestCachedResponse moves the creation date of the cached response to the past
+func ageTestCachedResponse(t *testing.T, app core.App, key string, age time.Duration) {
+	created := time.Now().Add(-age).UTC().Format("2006-01-02 15:04:05.000Z")
+	_, err := app.DB().Update(domain.CollectionLLMResponses, dbx.Params{"created": created}, dbx.HashExp{"key": key}).Execute()
+	require.NoError(t, err)
+}
+
+func TestPocketBaseCacheStorageExpiresResponses(t *testing.T) {
+	storage, app := newTestPocketBaseCacheStorage(t)
+
+	setTestCachedResponse(t, storage, "fresh", "ollama", "gemma3:1b")
+	setTestCachedResponse(t, storage, "stale", "ollama", "gemma3:1b")
+	setTestCachedResponse(t, storage, "expired", "openai", "gpt-4.1-nano")
+	ageTestCachedResponse(t, app, "stale", 2*time.Hour)
+	ageTestCachedResponse(t, app, "expired", 2*time.Hour)
+
+	response, err := storage.GetChatResponse("fresh")
+	require.NoError(t, err)
+	assert.True(t, response.Usage.CacheHit)
+	assert.Equal(t, "ollama", response.Usage.Platform)
+	record, err := storage.dao.FindLLMResponseRecordByKey("fresh")
+	require.NoError(t, err)
+	assert.Equal(t, 3600, record.TTL)
+	assert.Equal(t, "ollama", record.Backend)
+
+	// An expired response is a miss and is deleted, so that it can be cached again
+	_, err = storage.GetChatResponse("stale")
+	require.Error(t, err)
+	setTestCachedResponse(t, storage, "stale", "ollama", "gemma3:1b")
+
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, PocketBaseCache, stats.Backend)
+	assert.Equal(t, 3, stats.Entries)
+	assert.Equal(t, 1, stats.Expired)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+
+	require.NoError(t, storage.CleanExpired())
+	_, err = storage.dao.FindLLMResponseRecordByKey("expired")
+	assert.Error(t, err)
+	_, err = storage.dao.FindLLMResponseRecordByKey("fresh")
+	assert.NoError(t, err)
+}
+
+func TestPocketBaseCacheStoragePurge(t *testing.T) {
+	storage, app := newTestPocketBaseCacheStorage(t)
+
+	setTestCachedResponse(t, storage, "gemma", "ollama", "gemma3:1b")
+	setTestCachedResponse(t, storage, "llama", "ollama", "llama3.2:1b")
+	setTestCachedResponse(t, storage, "gpt", "openai", "gpt-4.1-nano")
+	setTestCachedResponse(t, storage, "claude", "anthropic", "claude-3-5-haiku-latest")
+	ageTestCachedResponse(t, app, "claude", 30*time.Minute)
+
+	purged, err := storage.Purge(CachePurgeFilter{OlderThan: 10 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	purged, err = storage.Purge(CachePurgeFilter{Backend: "ollama", Model: "gemma3:1b"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	purged, err = storage.Purge(CachePurgeFilter{Backend: "ollama"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	purged, err = storage.Purge(CachePurgeFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+}
+
+func TestPocketBaseCacheStorageEmbeddings(t *testing.T) {
+	storage, _ := newTestPocketBaseCacheStorage(t)
+
+	params := &EmbedParameters{Texts: []string{"fractions", "decimals"}}
+	key := storage.GetEmbedCacheKey(OllamaPlatform, params)
+	assert.NotEqual(t, key, storage.GetEmbedCacheKey(OpenAIPlatform, params))
+
+	response := &EmbedResponse{
+		Embeddings: [][]float32{{0.25, -0.5}, {1, 0}},
+		Usage:      &domain.Usage{Platform: "ollama", LlmModelName: "nomic-embed-text", PromptTokens: 4, TotalTokens: 4},
+	}
+	require.NoError(t, storage.SetEmbedResponse(key, params, response))
+
+	cached, err := storage.GetEmbedResponse(key)
+	require.NoError(t, err)
+	assert.Equal(t, response.Embeddings, cached.Embeddings)
+	assert.True(t, cached.Usage.CacheHit)
+	assert.Equal(t, "nomic-embed-text", cached.Usage.LlmModelName)
+
+	record, err := storage.dao.FindLLMResponseRecordByKey(key)
+	require.NoError(t, err)
+	assert.Equal(t, int(defaultEmbedCacheTTL.Seconds()), record.TTL)
+}
+
+func TestCacheStorageKeysChatsByServer(t *testing.T) {
+	pocketBaseStorage, _ := newTestPocketBaseCacheStorage(t)
+	storages := map[string]CacheStorage{"memory": NewMemoryCacheStorage(), "pocketbase": pocketBaseStorage}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			params := &ChatParameters{Prompt: "What is 2+2?", Model: "llama3.2"}
+			gpuBox := &ChatParameters{Prompt: "What is 2+2?", Model: "llama3.2", ServerURL: "http://gpu-box:11434"}
+
+			key := storage.GetChatCacheKey(OllamaPlatform, params)
+			assert.NotEqual(t, key, storage.GetChatCacheKey(OpenAIPlatform, params), "platforms don't share responses")
+			assert.NotEqual(t, key, storage.GetChatCacheKey(OllamaPlatform, gpuBox), "Ollama servers don't share responses")
+			assert.Equal(t, storage.GetChatCacheKey(OllamaPlatform, gpuBox),
+				storage.GetChatCacheKey(OllamaPlatform, &ChatParameters{Prompt: "What is 2+2?", Model: "llama3.2", ServerURL: " http://gpu-box:11434/"}),
+				"the server URL is normalized")
+
+			historyKey := storage.GetChatWithHistoryCacheKey(OllamaPlatform, nil, params)
+			assert.NotEqual(t, historyKey, storage.GetChatWithHistoryCacheKey(AnthropicPlatform, nil, params))
+			assert.NotEqual(t, historyKey, storage.GetChatWithHistoryCacheKey(OllamaPlatform, nil, gpuBox))
+		})
+	}
+}
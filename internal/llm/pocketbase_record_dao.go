@@ -2,6 +2,7 @@ package llm
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/pocketbase/dbx"
@@ -115,23 +116,44 @@ func (dao *LLMResponseRecordDao) DeleteLLMResponseRecord(record *LLMResponseReco
 	return dao.app.Delete(record)
 }
 
-// DeleteExpiredLLMResponseRecords deletes all expired cache entries
-func (dao *LLMResponseRecordDao) DeleteExpiredLLMResponseRecords() error {
-	var expired []*LLMResponseRecord
-
-	err := dao.app.RecordQuery(domain.CollectionLLMResponses).
-		AndWhere(dbx.NewExp("ttl > 0 AND created + ttl < NOW()")).
-		All(&expired)
+// DeleteExpiredLLMResponseRecords deletes all expired cache entries and returns how many were deleted
+func (dao *LLMResponseRecordDao) DeleteExpiredLLMResponseRecords() (int64, error) {
+	return dao.DeleteLLMResponseRecords(expiredLLMResponseExp)
+}
 
+// DeleteLLMResponseRecords deletes the cache entries matching the expression, all of them when it is nil,
+// and returns how many were deleted. The records are deleted in bulk without triggering the record hooks.
+func (dao *LLMResponseRecordDao) DeleteLLMResponseRecords(where dbx.Expression) (int64, error) {
+	result, err := dao.app.DB().Delete(domain.CollectionLLMResponses, where).Execute()
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("failed to delete cached LLM responses: %w", err)
 	}
 
-	for _, cache := range expired {
-		if err := dao.app.Delete(cache); err != nil {
-			return err
-		}
+	return result.RowsAffected()
+}
+
+// usage returns the usage of the cached response, a cache hit costs nothing
+func (r *LLMResponseRecord) usage() *domain.Usage {
+	return &domain.Usage{
+		LlmModelName:     r.ModelName,
+		Platform:         r.Backend,
+		CacheHit:         true,
+		TotalTokens:      r.TotalTokens,
+		PromptTokens:     r.PromptTokens,
+		CompletionTokens: r.CompletionTokens,
 	}
+}
 
-	return nil
+// Expired reports whether the TTL of the cached response elapsed, responses without a TTL never expire
+func (r *LLMResponseRecord) Expired(now time.Time) bool {
+	if r.TTL <= 0 {
+		return false
+	}
+	return now.Sub(r.GetDateTime("created").Time()) > time.Duration(r.TTL)*time.Second
 }
+
+// expiredLLMResponseCondition matches the cached responses whose TTL, in seconds, elapsed.
+// The dates are stored as text, so they are compared with the SQLite date functions.
+const expiredLLMResponseCondition = "ttl > 0 AND datetime(created, '+' || ttl || ' seconds') < datetime('now')"
+
+var expiredLLMResponseExp = dbx.NewExp(expiredLLMResponseCondition)
@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// ModelPrice holds the prices of a model per million tokens
+	ModelPrice struct {
+		Prompt     float64 `json:"prompt" yaml:"prompt"`
+		Completion float64 `json:"completion" yaml:"completion"`
+		// CachedPrompt is the price of prompt tokens read from the prompt cache of the platform, nil uses Prompt
+		CachedPrompt *float64 `json:"cachedPrompt,omitempty" yaml:"cachedPrompt,omitempty"`
+	}
+
+	// PricingTable holds the model prices per platform. The model "*" prices every model of the platform
+	// without a price of its own, e.g. a notional electricity cost for Ollama.
+	PricingTable map[PlatformType]map[string]ModelPrice
+)
+
+// anyModel is the model name of the price used for models missing from the table
+const anyModel = "*"
+
+// LoadPricingTable reads a pricing table from a YAML or JSON file, for instance:
+//
+//	openai:
+//	  gpt-4.1-nano: {prompt: 0.10, completion: 0.40, cachedPrompt: 0.025}
+//	ollama:
+//	  "*": {prompt: 0.02, completion: 0.02}
+func LoadPricingTable(path string) (PricingTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	// JSON is valid YAML, so the YAML parser reads both
+	var table PricingTable
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file %s: %w", path, err)
+	}
+
+	return table, nil
+}
+
+// ParsePricing parses prices of the form "platform/model=prompt:completion[:cachedPrompt]" separated by commas,
+// e.g. "openai/gpt-4.1-nano=0.10:0.40:0.025,ollama/*=0.02:0.02"
+func ParsePricing(value string) (PricingTable, error) {
+	table := PricingTable{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, prices, found := strings.Cut(entry, "=")
+		platform, model, qualified := strings.Cut(name, "/")
+		platformType, known := parsePlatformType(platform)
+		if !found || !qualified || !known || model == "" {
+			return nil, fmt.Errorf("invalid price %q, expected platform/model=prompt:completion[:cachedPrompt]", entry)
+		}
+
+		values := strings.Split(prices, ":")
+		if len(values) < 2 || len(values) > 3 {
+			return nil, fmt.Errorf("invalid price %q, expected platform/model=prompt:completion[:cachedPrompt]", entry)
+		}
+
+		numbers := make([]float64, len(values))
+		for i, v := range values {
+			number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || number < 0 {
+				return nil, fmt.Errorf("invalid price %q: %q is not a positive number", entry, v)
+			}
+			numbers[i] = number
+		}
+
+		price := ModelPrice{Prompt: numbers[0], Completion: numbers[1]}
+		if len(numbers) == 3 {
+			price.CachedPrompt = &numbers[2]
+		}
+		table.set(platformType, model, price)
+	}
+
+	return table, nil
+}
+
+// Merge returns a table with the prices of both tables, the prices of other take precedence
+func (t PricingTable) Merge(other PricingTable) PricingTable {
+	merged := PricingTable{}
+	for _, table := range []PricingTable{t, other} {
+		for platform, models := range table {
+			for model, price := range models {
+				merged.set(platform, model, price)
+			}
+		}
+	}
+	return merged
+}
+
+// Price returns the price of the model. Models missing from the table use the longest model name
+// of the table they start with, so that dated snapshots such as "gpt-4.1-nano-2025-04-14" use the price
+// of "gpt-4.1-nano", and then the "*" price of the platform.
+func (t PricingTable) Price(platform PlatformType, model string) (ModelPrice, bool) {
+	models := t[platform]
+	if price, ok := models[model]; ok {
+		return price, true
+	}
+
+	longest := ""
+	for name := range models {
+		if name != anyModel && len(name) > len(longest) && strings.HasPrefix(model, name) {
+			longest = name
+		}
+	}
+	if longest != "" {
+		return models[longest], true
+	}
+
+	price, ok := models[anyModel]
+	return price, ok
+}
+
+// Cost returns the cost of the usage, zero if the model has no price
+func (t PricingTable) Cost(platform PlatformType, model string, usage *domain.Usage) float64 {
+	price, ok := t.Price(platform, model)
+	if !ok {
+		return 0
+	}
+
+	cachedPrice := price.Prompt
+	if price.CachedPrompt != nil {
+		cachedPrice = *price.CachedPrompt
+	}
+
+	cached := min(usage.CachedPromptTokens, usage.PromptTokens)
+	return (float64(usage.PromptTokens-cached)*price.Prompt +
+		float64(cached)*cachedPrice +
+		float64(usage.CompletionTokens)*price.Completion) / 1_000_000
+}
+
+func (t PricingTable) set(platform PlatformType, model string, price ModelPrice) {
+	if t[platform] == nil {
+		t[platform] = map[string]ModelPrice{}
+	}
+	t[platform][model] = price
+}
+
+// pricingTable returns the configured prices on top of the blended price per million tokens of every platform
+func (c *Config) pricingTable() PricingTable {
+	blended := PricingTable{}
+	for platform, cost := range map[PlatformType]float64{
+		OpenAIPlatform:    c.OpenAI.CostPerMillionToken,
+		AnthropicPlatform: c.Anthropic.CostPerMillionToken,
+		OllamaPlatform:    c.Ollama.CostPerMillionToken,
+	} {
+		if cost > 0 {
+			blended.set(platform, anyModel, ModelPrice{Prompt: cost, Completion: cost})
+		}
+	}
+
+	return blended.Merge(c.Pricing)
+}
@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPricingTableCost(t *testing.T) {
+	cached := 0.25
+	table := PricingTable{
+		OpenAIPlatform: {
+			"gpt-4.1":      {Prompt: 2, Completion: 8},
+			"gpt-4.1-nano": {Prompt: 1, Completion: 4, CachedPrompt: &cached},
+		},
+		OllamaPlatform: {
+			anyModel: {Prompt: 0.5, Completion: 0.5},
+		},
+	}
+	usage := &domain.Usage{PromptTokens: 1_000_000, CachedPromptTokens: 400_000, CompletionTokens: 500_000}
+
+	// Cached prompt tokens use the cached price
+	assert.InDelta(t, 0.6+0.1+2, table.Cost(OpenAIPlatform, "gpt-4.1-nano", usage), 1e-9)
+	// Dated snapshots use the price of the longest model name they start with
+	assert.InDelta(t, 0.6+0.1+2, table.Cost(OpenAIPlatform, "gpt-4.1-nano-2025-04-14", usage), 1e-9)
+	// Cached prompt tokens without a cached price use the prompt price
+	assert.InDelta(t, 2+4, table.Cost(OpenAIPlatform, "gpt-4.1", usage), 1e-9)
+	// The "*" price prices every model of the platform
+	assert.InDelta(t, 0.75, table.Cost(OllamaPlatform, "gemma3:1b", usage), 1e-9)
+	// Models without a price are free
+	assert.Zero(t, table.Cost(OpenAIPlatform, "o3", usage))
+	assert.Zero(t, table.Cost(AnthropicPlatform, "claude-sonnet-4-0", usage))
+}
+
+func TestParsePricing(t *testing.T) {
+	table, err := ParsePricing("openai/gpt-4.1-nano=0.10:0.40:0.025, ollama/*=0.02:0.02")
+
+	require.NoError(t, err)
+	price, ok := table.Price(OpenAIPlatform, "gpt-4.1-nano")
+	require.True(t, ok)
+	assert.Equal(t, 0.10, price.Prompt)
+	assert.Equal(t, 0.40, price.Completion)
+	require.NotNil(t, price.CachedPrompt)
+	assert.Equal(t, 0.025, *price.CachedPrompt)
+	price, ok = table.Price(OllamaPlatform, "gemma3:1b")
+	require.True(t, ok)
+	assert.Nil(t, price.CachedPrompt)
+
+	for _, invalid := range []string{"gpt-4.1=1:2", "unknown/model=1:2", "openai/gpt-4.1=1", "openai/gpt-4.1=1:x", "openai/gpt-4.1=-1:2"} {
+		_, err := ParsePricing(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLoadPricingTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+openai:
+  gpt-4.1-nano: {prompt: 0.10, completion: 0.40, cachedPrompt: 0.025}
+anthropic:
+  claude-3-5-haiku: {prompt: 0.80, completion: 4}
+`), 0o600))
+
+	table, err := LoadPricingTable(path)
+
+	require.NoError(t, err)
+	price, ok := table.Price(AnthropicPlatform, "claude-3-5-haiku-latest")
+	require.True(t, ok)
+	assert.Equal(t, ModelPrice{Prompt: 0.80, Completion: 4}, price)
+	price, ok = table.Price(OpenAIPlatform, "gpt-4.1-nano")
+	require.True(t, ok)
+	assert.Equal(t, 0.025, *price.CachedPrompt)
+}
+
+func TestServicePricesUsage(t *testing.T) {
+	config := &Config{
+		// The configured prices take precedence over the blended price of the platform
+		Ollama:  OllamaConfig{CostPerMillionToken: 1},
+		Pricing: PricingTable{EchoPlatform: {"echo": {Prompt: 1_000_000, Completion: 2_000_000}}},
+	}
+	s := newService(config, []Platform{newCachedPlatform(newEchoPlatform(), NewMemoryCacheStorage())})
+
+	_, usage, err := s.Chat(context.Background(), "Hello there", "")
+	require.NoError(t, err)
+	assert.InDelta(t, float64(usage.PromptTokens+2*usage.CompletionTokens), usage.Cost, 1e-9)
+
+	// Cache hits cost nothing
+	_, usage, err = s.Chat(context.Background(), "Hello there", "")
+	require.NoError(t, err)
+	assert.True(t, usage.CacheHit)
+	assert.Zero(t, usage.Cost)
+
+	assert.Equal(t, PricingTable{
+		OllamaPlatform: {anyModel: {Prompt: 1, Completion: 1}},
+		EchoPlatform:   {"echo": {Prompt: 1_000_000, Completion: 2_000_000}},
+	}, config.pricingTable())
+}
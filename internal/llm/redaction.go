@@ -0,0 +1,561 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// RedactionConfig holds the configuration of the redaction of personal identifiers from the requests to cloud platforms
+	RedactionConfig struct {
+		Enabled bool `json:"enabled"`
+		// Identifiers are redacted from the requests of every account, besides the names of the account
+		Identifiers []string `json:"identifiers"`
+	}
+
+	// accountPrivacy holds what the requests of an account must not send to cloud platforms
+	accountPrivacy struct {
+		// Names are the nicknames of the learners and the names of the users of the account
+		Names []string
+		// Identifiers are the other identifiers the account redacts, e.g. the name of a school
+		Identifiers []string
+		// LocalOnly forbids cloud platforms for the requests holding learner data, see learnerPurposes
+		LocalOnly bool
+	}
+
+	// privacyStore loads the privacy settings of accounts
+	privacyStore interface {
+		Privacy(accountID string) (*accountPrivacy, error)
+	}
+
+	// redactionService sets the redaction of the account on the requests, the redaction itself
+	// happens in the cloud platforms, see redactedPlatform. Local platforms get the requests as they are.
+	redactionService struct {
+		delegate Service
+		store    privacyStore
+		cfg      RedactionConfig
+	}
+
+	pocketBasePrivacyStore struct {
+		app core.App
+	}
+
+	// redactedPlatform is a cloud platform receiving the requests with the personal identifiers replaced
+	// by placeholders, the placeholders are restored in the responses.
+	redactedPlatform struct {
+		delegate Platform
+	}
+
+	// redactor replaces personal identifiers with placeholders such as "[NAME_1]" and restores them
+	redactor struct {
+		pattern *regexp.Regexp
+		// placeholders maps the normalized identifiers to their placeholder, identifiers maps them back
+		placeholders map[string]string
+		identifiers  map[string]string
+	}
+)
+
+const (
+	redactionNamePlaceholder       = "NAME"
+	redactionIdentifierPlaceholder = "PRIVATE"
+
+	// redactionPlaceholderMaxLen bounds the length of a placeholder, a streamed chunk ending with
+	// an opening bracket closer than that to its end is held back until the placeholder is complete
+	redactionPlaceholderMaxLen = 16
+)
+
+var (
+	// ErrCloudPlatformForbidden is returned by cloud platforms for the requests of accounts keeping their learner data local
+	ErrCloudPlatformForbidden = errors.New("the account doesn't allow learner data on cloud LLM platforms")
+
+	// learnerPurposes are the purposes of the requests built from learner data. The chat of the parents
+	// is not one of them, it is redacted like every other request.
+	learnerPurposes = map[Purpose]bool{
+		PurposeSessionGeneration:   true,
+		PurposeGrading:             true,
+		PurposeHint:                true,
+		PurposeWorksheetExtraction: true,
+	}
+
+	redactionPlaceholderPattern = regexp.MustCompile(`\[(?:` + redactionNamePlaceholder + `|` + redactionIdentifierPlaceholder + `)_\d+\]`)
+)
+
+// newRedactionService wraps the service with the privacy settings of the accounts, a nil store only redacts the configured identifiers
+func newRedactionService(delegate Service, store privacyStore, cfg RedactionConfig) Service {
+	return &redactionService{
+		delegate: delegate,
+		store:    store,
+		cfg:      cfg,
+	}
+}
+
+// Unwrap returns the decorated service
+func (s *redactionService) Unwrap() Service {
+	return s.delegate
+}
+
+func (s *redactionService) Chat(ctx context.Context, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	options, err := s.options(options)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.delegate.Chat(ctx, prompt, systemPrompt, options...)
+}
+
+func (s *redactionService) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	options, err := s.options(options)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.delegate.ChatWithHistory(ctx, messages, systemPrompt, options...)
+}
+
+func (s *redactionService) ChatStream(ctx context.Context, prompt string, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	options, err := s.options(options)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.delegate.ChatStream(ctx, prompt, systemPrompt, handler, options...)
+}
+
+func (s *redactionService) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	options, err := s.options(options)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.delegate.ChatWithHistoryStream(ctx, messages, systemPrompt, handler, options...)
+}
+
+func (s *redactionService) ChatWithTools(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (*ChatResponse, error) {
+	options, err := s.options(options)
+	if err != nil {
+		return nil, err
+	}
+	return s.delegate.ChatWithTools(ctx, messages, systemPrompt, handler, options...)
+}
+
+func (s *redactionService) DescribeImage(ctx context.Context, reader io.Reader, fileName string, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	options, err := s.options(options)
+	if err != nil {
+		return "", nil, err
+	}
+	return s.delegate.DescribeImage(ctx, reader, fileName, prompt, systemPrompt, options...)
+}
+
+func (s *redactionService) Embed(ctx context.Context, texts []string, options ...ChatOption) ([][]float32, *domain.Usage, error) {
+	options, err := s.options(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.delegate.Embed(ctx, texts, options...)
+}
+
+func (s *redactionService) Info(ctx context.Context, options ...InfoOption) Info {
+	return s.delegate.Info(ctx, options...)
+}
+
+// options adds the redaction and the platform restriction of the account of the options.
+// The request fails when the privacy settings can't be loaded, sending it unredacted would be worse.
+func (s *redactionService) options(options []ChatOption) ([]ChatOption, error) {
+	params := &ChatParameters{}
+	for _, option := range options {
+		option(params)
+	}
+
+	var names, identifiers []string
+	if s.cfg.Enabled {
+		identifiers = append(identifiers, s.cfg.Identifiers...)
+	}
+
+	localOnly := false
+	if params.AccountID != "" && s.store != nil {
+		privacy, err := s.store.Privacy(params.AccountID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the privacy settings of account %s: %w", params.AccountID, err)
+		}
+
+		localOnly = privacy.LocalOnly && learnerPurposes[params.Purpose]
+		if s.cfg.Enabled {
+			names = privacy.Names
+			identifiers = append(identifiers, privacy.Identifiers...)
+		}
+	}
+
+	redactor := newRedactor(names, identifiers)
+	if redactor == nil && !localOnly {
+		return options, nil
+	}
+
+	return append(options[:len(options):len(options)], func(params *ChatParameters) {
+		params.redactor = redactor
+		params.LocalOnly = localOnly
+	}), nil
+}
+
+// Privacy loads the privacy settings of the account, the names of its owner, its learners and their users
+func (s *pocketBasePrivacyStore) Privacy(accountID string) (*accountPrivacy, error) {
+	account, err := s.app.FindRecordById(domain.CollectionAccounts, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	privacy := &accountPrivacy{
+		LocalOnly:   account.GetBool("llm_local_only"),
+		Identifiers: parseRedactedIdentifiers(account.GetString("llm_redacted_identifiers")),
+	}
+
+	userIds := []string{account.GetString("owner")}
+	learners, err := s.app.FindRecordsByFilter(domain.CollectionLearners, "account = {:account}", "", 0, 0, map[string]any{"account": accountID})
+	if err != nil {
+		return nil, err
+	}
+	for _, learner := range learners {
+		privacy.Names = append(privacy.Names, learner.GetString("nickname"))
+		userIds = append(userIds, learner.GetString("user"))
+	}
+
+	users, err := s.app.FindRecordsByIds("users", userIds)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		privacy.Names = append(privacy.Names, user.GetString("name"))
+	}
+
+	return privacy, nil
+}
+
+// parseRedactedIdentifiers splits the identifiers of an account, one per line or separated by commas
+func parseRedactedIdentifiers(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r == '\n' || r == ','
+	})
+}
+
+// isLocalPlatform returns true for the platforms that don't send the requests out of the house
+func isLocalPlatform(platformType PlatformType) bool {
+	return platformType == OllamaPlatform || platformType == EchoPlatform
+}
+
+// newRedactedPlatform wraps the cloud platform with the redaction set on the requests
+func newRedactedPlatform(delegate Platform) Platform {
+	return &redactedPlatform{delegate: delegate}
+}
+
+// Type returns the platform type of the delegate
+func (r *redactedPlatform) Type() PlatformType {
+	return r.delegate.Type()
+}
+
+// Unwrap returns the decorated platform
+func (r *redactedPlatform) Unwrap() Platform {
+	return r.delegate
+}
+
+// Models lists the models of the delegate
+func (r *redactedPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	return r.delegate.Models(ctx)
+}
+
+// Chat implements the Platform interface with redaction
+func (r *redactedPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return r.redact(params, nil, func(params *ChatParameters, _ StreamHandler) (*ChatResponse, error) {
+		return r.delegate.Chat(ctx, params)
+	})
+}
+
+// ChatStream implements the Platform interface with redaction, the chunks reach the handler restored
+func (r *redactedPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return r.redact(params, handler, func(params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+		return r.delegate.ChatStream(ctx, params, handler)
+	})
+}
+
+// ChatWithHistory implements the Platform interface with redaction of the messages
+func (r *redactedPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	return r.redact(params, nil, func(params *ChatParameters, _ StreamHandler) (*ChatResponse, error) {
+		return r.delegate.ChatWithHistory(ctx, params.redactor.redactMessages(messages), params)
+	})
+}
+
+// ChatWithHistoryStream implements the Platform interface with redaction of the messages, the chunks reach the handler restored
+func (r *redactedPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	return r.redact(params, handler, func(params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+		return r.delegate.ChatWithHistoryStream(ctx, params.redactor.redactMessages(messages), params, handler)
+	})
+}
+
+// DescribeImage implements the Platform interface with redaction of the prompts, the image is sent as it is
+func (r *redactedPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
+	response, err := r.redact(&params.ChatParameters, nil, func(chatParams *ChatParameters, _ StreamHandler) (*ChatResponse, error) {
+		redacted := *params
+		redacted.ChatParameters = *chatParams
+
+		response, err := r.delegate.DescribeImage(ctx, &redacted)
+		if err != nil {
+			return nil, err
+		}
+		return &ChatResponse{Response: response.Description, Usage: response.Usage}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &DescribeImageResponse{Description: response.Response, Usage: response.Usage}, nil
+}
+
+// Embed implements the Platform interface with redaction of the texts, there is nothing to restore
+func (r *redactedPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	if params.LocalOnly {
+		return nil, fmt.Errorf("%w: %s", ErrCloudPlatformForbidden, r.delegate.Type())
+	}
+	if params.redactor == nil {
+		return r.delegate.Embed(ctx, params)
+	}
+
+	logRedaction(&params.ChatParameters, r.delegate.Type())
+	redacted := *params
+	redacted.Texts = make([]string, len(params.Texts))
+	for i, text := range params.Texts {
+		redacted.Texts[i] = params.redactor.redact(text)
+	}
+	return r.delegate.Embed(ctx, &redacted)
+}
+
+// redact calls the delegate with the prompts redacted and restores the response. The returned response
+// is a copy, the response of the delegate may be shared with the cache.
+func (r *redactedPlatform) redact(params *ChatParameters, handler StreamHandler, call func(params *ChatParameters, handler StreamHandler) (*ChatResponse, error)) (*ChatResponse, error) {
+	if params.LocalOnly {
+		return nil, fmt.Errorf("%w: %s", ErrCloudPlatformForbidden, r.delegate.Type())
+	}
+	if params.redactor == nil {
+		return call(params, handler)
+	}
+
+	logRedaction(params, r.delegate.Type())
+	redactor := params.redactor
+	redacted := *params
+	redacted.Prompt = redactor.redact(params.Prompt)
+	redacted.SystemPrompt = redactor.redact(params.SystemPrompt)
+
+	var flush func() error
+	if handler != nil {
+		handler, flush = redactor.restoreStream(handler)
+	}
+
+	response, err := call(&redacted, handler)
+	if err != nil {
+		return nil, err
+	}
+	if flush != nil {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	restored := *response
+	restored.Response = redactor.restore(response.Response)
+	if len(response.ToolCalls) > 0 {
+		restored.ToolCalls = make([]domain.ToolCall, len(response.ToolCalls))
+		for i, call := range response.ToolCalls {
+			call.Arguments = json.RawMessage(redactor.restoreJSON(string(call.Arguments)))
+			restored.ToolCalls[i] = call
+		}
+	}
+	return &restored, nil
+}
+
+// newRedactor creates a redactor for the names and the other identifiers, nil when there is nothing to redact.
+// Names also redact each of their words, so that "Jane Doe" covers "Jane". Identifiers are matched ignoring the case,
+// as whole words when they start and end with ASCII letters or digits.
+func newRedactor(names, identifiers []string) *redactor {
+	r := &redactor{
+		placeholders: map[string]string{},
+		identifiers:  map[string]string{},
+	}
+
+	add := func(identifier, kind string) {
+		key := normalizeIdentifier(identifier)
+		if utf8.RuneCountInString(key) < 2 || r.placeholders[key] != "" {
+			return
+		}
+		placeholder := fmt.Sprintf("[%s_%d]", kind, len(r.placeholders)+1)
+		r.placeholders[key] = placeholder
+		r.identifiers[placeholder] = strings.Join(strings.Fields(identifier), " ")
+	}
+
+	for _, name := range names {
+		add(name, redactionNamePlaceholder)
+	}
+	for _, name := range names {
+		if words := strings.Fields(name); len(words) > 1 {
+			for _, word := range words {
+				add(word, redactionNamePlaceholder)
+			}
+		}
+	}
+	for _, identifier := range identifiers {
+		add(identifier, redactionIdentifierPlaceholder)
+	}
+
+	if len(r.placeholders) == 0 {
+		return nil
+	}
+
+	// The longest identifiers go first, so that "Jane Doe" is redacted as a whole rather than as "Jane"
+	keys := make([]string, 0, len(r.placeholders))
+	for key := range r.placeholders {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	alternatives := make([]string, len(keys))
+	for i, key := range keys {
+		words := strings.Fields(key)
+		for j, word := range words {
+			words[j] = regexp.QuoteMeta(word)
+		}
+		alternative := strings.Join(words, `\s+`)
+
+		first, _ := utf8.DecodeRuneInString(key)
+		last, _ := utf8.DecodeLastRuneInString(key)
+		if isWordRune(first) {
+			alternative = `\b` + alternative
+		}
+		if isWordRune(last) {
+			alternative += `\b`
+		}
+		alternatives[i] = alternative
+	}
+	r.pattern = regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+
+	return r
+}
+
+// redact replaces the identifiers of the text with their placeholder
+func (r *redactor) redact(text string) string {
+	if text == "" {
+		return text
+	}
+	return r.pattern.ReplaceAllStringFunc(text, func(identifier string) string {
+		return r.placeholders[normalizeIdentifier(identifier)]
+	})
+}
+
+// redactMessages returns copies of the messages with their content and tool call arguments redacted
+func (r *redactor) redactMessages(messages []*domain.ChatItem) []*domain.ChatItem {
+	if r == nil {
+		return messages
+	}
+
+	redacted := make([]*domain.ChatItem, len(messages))
+	for i, message := range messages {
+		copied := *message
+		copied.Content = r.redact(message.Content)
+		if len(message.ToolCalls) > 0 {
+			copied.ToolCalls = make([]domain.ToolCall, len(message.ToolCalls))
+			for j, call := range message.ToolCalls {
+				call.Arguments = json.RawMessage(r.redact(string(call.Arguments)))
+				copied.ToolCalls[j] = call
+			}
+		}
+		redacted[i] = &copied
+	}
+	return redacted
+}
+
+// restore replaces the placeholders of the text with their identifier, unknown placeholders are left as they are
+func (r *redactor) restore(text string) string {
+	return redactionPlaceholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if identifier, ok := r.identifiers[placeholder]; ok {
+			return identifier
+		}
+		return placeholder
+	})
+}
+
+// restoreJSON restores the placeholders of a JSON document, the identifiers are escaped as JSON strings
+func (r *redactor) restoreJSON(text string) string {
+	return redactionPlaceholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		identifier, ok := r.identifiers[placeholder]
+		if !ok {
+			return placeholder
+		}
+		escaped, err := json.Marshal(identifier)
+		if err != nil {
+			return placeholder
+		}
+		return string(escaped[1 : len(escaped)-1])
+	})
+}
+
+// restoreStream returns a handler passing the chunks restored to the handler. A placeholder can be split across chunks,
+// so a chunk ending with what may be the start of a placeholder is held back until the next chunk, flush passes on what is left.
+func (r *redactor) restoreStream(handler StreamHandler) (StreamHandler, func() error) {
+	var pending string
+
+	restoreHandler := func(chunk string) error {
+		pending += chunk
+
+		cut := len(pending)
+		if i := strings.LastIndexByte(pending, '['); i >= 0 && len(pending)-i < redactionPlaceholderMaxLen && !strings.ContainsRune(pending[i:], ']') {
+			cut = i
+		}
+
+		text := pending[:cut]
+		pending = pending[cut:]
+		if text == "" {
+			return nil
+		}
+		return handler(r.restore(text))
+	}
+
+	flush := func() error {
+		if pending == "" {
+			return nil
+		}
+		text := pending
+		pending = ""
+		return handler(r.restore(text))
+	}
+
+	return restoreHandler, flush
+}
+
+// normalizeIdentifier lower cases the identifier and collapses its white space
+func normalizeIdentifier(identifier string) string {
+	return strings.Join(strings.Fields(strings.ToLower(identifier)), " ")
+}
+
+// isWordRune returns true for the runes \b sees as word characters, which are ASCII only
+func isWordRune(r rune) bool {
+	return r < utf8.RuneSelf && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// logRedaction logs how many identifiers of the account are redacted, never the identifiers themselves
+func logRedaction(params *ChatParameters, platformType PlatformType) {
+	if params.redactor == nil {
+		return
+	}
+	log.Debug().
+		Str("platform", string(platformType)).
+		Str("accountId", params.AccountID).
+		Int("identifiers", len(params.redactor.placeholders)).
+		Msg("Redacting personal identifiers from the LLM request")
+}
@@ -0,0 +1,206 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cloudTestPlatform records the requests it gets and answers with the chunks and tool calls
+type cloudTestPlatform struct {
+	echoPlatform
+	chunks    []string
+	toolCalls []domain.ToolCall
+	params    []*ChatParameters
+	messages  []*domain.ChatItem
+}
+
+func (p *cloudTestPlatform) Type() PlatformType {
+	return OpenAIPlatform
+}
+
+func (p *cloudTestPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	p.params = append(p.params, params)
+	for _, chunk := range p.chunks {
+		if handler != nil {
+			if err := handler(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &ChatResponse{Response: strings.Join(p.chunks, ""), Usage: &domain.Usage{}, ToolCalls: p.toolCalls}, nil
+}
+
+func (p *cloudTestPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return p.ChatStream(ctx, params, nil)
+}
+
+func (p *cloudTestPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	p.messages = messages
+	return p.ChatStream(ctx, params, nil)
+}
+
+// privacyTestStore returns the privacy settings of any account
+type privacyTestStore struct {
+	privacy *accountPrivacy
+	err     error
+}
+
+func (s *privacyTestStore) Privacy(accountID string) (*accountPrivacy, error) {
+	return s.privacy, s.err
+}
+
+func TestRedactor(t *testing.T) {
+	r := newRedactor([]string{"Mia", "Jane  Doe", "Zoë", "", "J"}, []string{"Maple Street School", "+1 555 0100"})
+	require.NotNil(t, r)
+
+	tests := []struct {
+		text     string
+		redacted string
+	}{
+		{text: "Mia is 9 years old", redacted: "[NAME_1] is 9 years old"},
+		{text: "MIA and mia", redacted: "[NAME_1] and [NAME_1]"},
+		{text: "Mia's mother is Jane Doe, Jane for short", redacted: "[NAME_1]'s mother is [NAME_2], [NAME_4] for short"},
+		{text: "Jane\nDoe goes to Miami", redacted: "[NAME_2] goes to Miami"},
+		{text: "Zoë likes maths", redacted: "[NAME_3] likes maths"},
+		{text: "She goes to maple street school, call +1 555 0100", redacted: "She goes to [PRIVATE_6], call [PRIVATE_7]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.redacted, r.redact(tt.text))
+		})
+	}
+
+	assert.Equal(t, "Mia and Jane Doe, [NAME_99] [OTHER_1]", r.restore("[NAME_1] and [NAME_2], [NAME_99] [OTHER_1]"))
+	assert.Equal(t, `{"name": "Zoë", "school": "Maple Street School"}`, r.restoreJSON(`{"name": "[NAME_3]", "school": "[PRIVATE_6]"}`))
+
+	assert.Nil(t, newRedactor(nil, []string{" ", "x"}), "nothing to redact")
+}
+
+func TestRedactorRestoreStream(t *testing.T) {
+	r := newRedactor([]string{"Mia"}, nil)
+
+	var chunks []string
+	handler, flush := r.restoreStream(func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+
+	for _, chunk := range []string{"Well done [NA", "ME_1]! Try [", "this", " one [x"} {
+		require.NoError(t, handler(chunk))
+	}
+	require.NoError(t, flush())
+
+	assert.Equal(t, "Well done Mia! Try [this one [x", strings.Join(chunks, ""))
+	assert.Equal(t, []string{"Well done ", "Mia! Try ", "[this one ", "[x"}, chunks)
+}
+
+func TestRedactedPlatform(t *testing.T) {
+	ctx := context.Background()
+	redactor := newRedactor([]string{"Mia"}, nil)
+
+	t.Run("redacts the prompts and restores the response", func(t *testing.T) {
+		delegate := &cloudTestPlatform{chunks: []string{"Great job, [NAM", "E_1]!"}}
+		platform := newRedactedPlatform(delegate)
+
+		var streamed strings.Builder
+		response, err := platform.ChatStream(ctx, &ChatParameters{Prompt: "Mia answered 4", SystemPrompt: "Talk to Mia", redactor: redactor}, func(chunk string) error {
+			streamed.WriteString(chunk)
+			return nil
+		})
+
+		require.NoError(t, err)
+		require.Len(t, delegate.params, 1)
+		assert.Equal(t, "[NAME_1] answered 4", delegate.params[0].Prompt)
+		assert.Equal(t, "Talk to [NAME_1]", delegate.params[0].SystemPrompt)
+		assert.Equal(t, "Great job, Mia!", response.Response)
+		assert.Equal(t, "Great job, Mia!", streamed.String())
+	})
+
+	t.Run("redacts the messages and restores the tool calls", func(t *testing.T) {
+		delegate := &cloudTestPlatform{toolCalls: []domain.ToolCall{{ID: "1", Name: "get_recent_results", Arguments: json.RawMessage(`{"learner": "[NAME_1]"}`)}}}
+		platform := newRedactedPlatform(delegate)
+		messages := []*domain.ChatItem{{Role: domain.ChatItemRoleUser, Content: "How is Mia doing?"}}
+
+		response, err := platform.ChatWithHistory(ctx, messages, &ChatParameters{redactor: redactor})
+
+		require.NoError(t, err)
+		require.Len(t, delegate.messages, 1)
+		assert.Equal(t, "How is [NAME_1] doing?", delegate.messages[0].Content)
+		assert.Equal(t, "How is Mia doing?", messages[0].Content, "the messages of the caller are not changed")
+		require.Len(t, response.ToolCalls, 1)
+		assert.JSONEq(t, `{"learner": "Mia"}`, string(response.ToolCalls[0].Arguments))
+		assert.JSONEq(t, `{"learner": "[NAME_1]"}`, string(delegate.toolCalls[0].Arguments), "the response of the delegate is not changed")
+	})
+
+	t.Run("rejects the requests keeping learner data local", func(t *testing.T) {
+		delegate := &cloudTestPlatform{}
+		platform := newRedactedPlatform(delegate)
+
+		_, err := platform.Chat(ctx, &ChatParameters{Prompt: "Mia answered 4", LocalOnly: true})
+
+		require.ErrorIs(t, err, ErrCloudPlatformForbidden)
+		assert.Empty(t, delegate.params)
+	})
+}
+
+func TestRedactionServiceOptions(t *testing.T) {
+	store := &privacyTestStore{privacy: &accountPrivacy{Names: []string{"Mia"}, Identifiers: []string{"Maple School"}, LocalOnly: true}}
+	s := &redactionService{store: store, cfg: RedactionConfig{Enabled: true, Identifiers: []string{"Springfield"}}}
+
+	apply := func(options []ChatOption) *ChatParameters {
+		params := &ChatParameters{}
+		for _, option := range options {
+			option(params)
+		}
+		return params
+	}
+
+	t.Run("learner data stays local", func(t *testing.T) {
+		options, err := s.options([]ChatOption{WithAccount("acc1"), WithPurpose(PurposeSessionGeneration)})
+		require.NoError(t, err)
+
+		params := apply(options)
+		assert.True(t, params.LocalOnly)
+		require.NotNil(t, params.redactor)
+		assert.Equal(t, "Mia of Maple School in Springfield", params.redactor.restore(params.redactor.redact("Mia of Maple School in Springfield")))
+		assert.Equal(t, "[NAME_1] of [PRIVATE_3] in [PRIVATE_2]", params.redactor.redact("Mia of Maple School in Springfield"))
+	})
+
+	t.Run("the chat is only redacted", func(t *testing.T) {
+		options, err := s.options([]ChatOption{WithAccount("acc1"), WithPurpose(PurposeChat)})
+		require.NoError(t, err)
+
+		params := apply(options)
+		assert.False(t, params.LocalOnly)
+		assert.NotNil(t, params.redactor)
+	})
+
+	t.Run("requests without account redact the configured identifiers", func(t *testing.T) {
+		options, err := s.options(nil)
+		require.NoError(t, err)
+
+		params := apply(options)
+		require.NotNil(t, params.redactor)
+		assert.Equal(t, "Mia in [PRIVATE_1]", params.redactor.redact("Mia in Springfield"))
+	})
+
+	t.Run("fails when the privacy settings can't be loaded", func(t *testing.T) {
+		failing := &redactionService{store: &privacyTestStore{err: errors.New("database is locked")}, cfg: s.cfg}
+
+		_, err := failing.options([]ChatOption{WithAccount("acc1")})
+		assert.Error(t, err)
+	})
+}
+
+func TestDecorateRedactsCloudPlatforms(t *testing.T) {
+	assert.IsType(t, &redactedPlatform{}, decorate(&cloudTestPlatform{}, &Config{}, nil))
+	assert.IsType(t, &echoPlatform{}, decorate(newEchoPlatform(), &Config{}, nil))
+}
@@ -0,0 +1,434 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// resilientPlatform retries transient failures of the delegate with exponential backoff and jitter,
+	// and stops calling an upstream that keeps failing until its circuit breaker lets a trial request through.
+	resilientPlatform struct {
+		delegate Platform
+		cfg      ResilienceConfig
+		mutex    sync.Mutex
+		// breakers holds a circuit breaker per upstream, see upstream
+		breakers map[string]*circuitBreaker
+		// sleep waits between retries, it can be replaced in tests
+		sleep func(ctx context.Context, d time.Duration) error
+	}
+
+	circuitState int
+
+	// circuitBreaker opens after FailureThreshold consecutive failures. Once OpenDuration has passed
+	// a single trial request is let through, its outcome closes or opens the breaker again.
+	circuitBreaker struct {
+		mutex               sync.Mutex
+		state               circuitState
+		consecutiveFailures int
+		openedAt            time.Time
+		trialInFlight       bool
+	}
+)
+
+const (
+	defaultRetryMaxRetries         = 2
+	defaultRetryInitialBackoff     = 500 * time.Millisecond
+	defaultRetryMaxBackoff         = 8 * time.Second
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenDuration     = 30 * time.Second
+)
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+const (
+	PlatformStatusOK       PlatformStatus = "ok"
+	PlatformStatusDegraded PlatformStatus = "degraded"
+)
+
+var (
+	// ErrCircuitOpen is returned without calling the upstream while its circuit breaker is open
+	ErrCircuitOpen = errors.New("circuit breaker open")
+)
+
+// newResilientPlatform wraps the platform with retries and circuit breakers
+func newResilientPlatform(delegate Platform, cfg ResilienceConfig) Platform {
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = defaultBreakerOpenDuration
+	}
+
+	return &resilientPlatform{
+		delegate: delegate,
+		cfg:      cfg,
+		breakers: make(map[string]*circuitBreaker),
+		sleep:    sleepContext,
+	}
+}
+
+// Type returns the platform type of the delegate
+func (r *resilientPlatform) Type() PlatformType {
+	return r.delegate.Type()
+}
+
+// Unwrap returns the decorated platform
+func (r *resilientPlatform) Unwrap() Platform {
+	return r.delegate
+}
+
+// Degraded returns true while the circuit breaker of any upstream is not closed
+func (r *resilientPlatform) Degraded() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, breaker := range r.breakers {
+		if breaker.currentState() != circuitClosed {
+			return true
+		}
+	}
+	return false
+}
+
+// Models lists the models of the delegate, listing models is neither retried nor counted by the breakers
+func (r *resilientPlatform) Models(ctx context.Context) ([]*ModelInfo, error) {
+	return r.delegate.Models(ctx)
+}
+
+// Chat implements the Platform interface with retries
+func (r *resilientPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	var response *ChatResponse
+	err := r.call(ctx, params, nil, func(handler StreamHandler) error {
+		var err error
+		response, err = r.delegate.Chat(ctx, params)
+		return err
+	})
+	return response, err
+}
+
+// ChatStream implements the Platform interface with retries, a stream is only retried if nothing was streamed yet
+func (r *resilientPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	var response *ChatResponse
+	err := r.call(ctx, params, handler, func(handler StreamHandler) error {
+		var err error
+		response, err = r.delegate.ChatStream(ctx, params, handler)
+		return err
+	})
+	return response, err
+}
+
+// ChatWithHistory implements the Platform interface with retries
+func (r *resilientPlatform) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters) (*ChatResponse, error) {
+	var response *ChatResponse
+	err := r.call(ctx, params, nil, func(handler StreamHandler) error {
+		var err error
+		response, err = r.delegate.ChatWithHistory(ctx, messages, params)
+		return err
+	})
+	return response, err
+}
+
+// ChatWithHistoryStream implements the Platform interface with retries, a stream is only retried if nothing was streamed yet
+func (r *resilientPlatform) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	var response *ChatResponse
+	err := r.call(ctx, params, handler, func(handler StreamHandler) error {
+		var err error
+		response, err = r.delegate.ChatWithHistoryStream(ctx, messages, params, handler)
+		return err
+	})
+	return response, err
+}
+
+// DescribeImage implements the Platform interface with a circuit breaker.
+// It is not retried because the image reader can only be read once.
+func (r *resilientPlatform) DescribeImage(ctx context.Context, params *DescribeImageParameters) (*DescribeImageResponse, error) {
+	upstream := r.upstream(&params.ChatParameters)
+	breaker := r.breaker(upstream)
+	if !breaker.allow(r.cfg.OpenDuration) {
+		return nil, fmt.Errorf("%w for %s", ErrCircuitOpen, upstream)
+	}
+
+	response, err := r.delegate.DescribeImage(ctx, params)
+	r.record(breaker, upstream, ctx, err)
+	return response, err
+}
+
+// Embed implements the Platform interface with retries
+func (r *resilientPlatform) Embed(ctx context.Context, params *EmbedParameters) (*EmbedResponse, error) {
+	var response *EmbedResponse
+	err := r.call(ctx, &params.ChatParameters, nil, func(handler StreamHandler) error {
+		var err error
+		response, err = r.delegate.Embed(ctx, params)
+		return err
+	})
+	return response, err
+}
+
+// call runs fn until it succeeds, fails with an error that isn't retryable or runs out of retries.
+// fn receives the handler to stream to, wrapped to know whether anything was streamed.
+func (r *resilientPlatform) call(ctx context.Context, params *ChatParameters, handler StreamHandler, fn func(handler StreamHandler) error) error {
+	upstream := r.upstream(params)
+	breaker := r.breaker(upstream)
+
+	streamed := false
+	var trackedHandler StreamHandler
+	if handler != nil {
+		trackedHandler = func(chunk string) error {
+			streamed = true
+			return handler(chunk)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !breaker.allow(r.cfg.OpenDuration) {
+			return fmt.Errorf("%w for %s", ErrCircuitOpen, upstream)
+		}
+
+		err := fn(trackedHandler)
+		r.record(breaker, upstream, ctx, err)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= r.cfg.MaxRetries || streamed || ctx.Err() != nil || !isRetryableError(err) {
+			return err
+		}
+
+		delay := r.backoff(attempt)
+		log.Warn().
+			Err(err).
+			Str("upstream", upstream).
+			Int("attempt", attempt+1).
+			Dur("delay", delay).
+			Msg("LLM request failed, retrying")
+
+		if err := r.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// record updates the breaker with the outcome of a request.
+// Only failures of the upstream count, invalid requests and cancelled contexts don't.
+func (r *resilientPlatform) record(breaker *circuitBreaker, upstream string, ctx context.Context, err error) {
+	switch {
+	case err == nil:
+		breaker.success()
+	case ctx.Err() == nil && isRetryableError(err):
+		if breaker.failure(r.cfg.FailureThreshold) {
+			log.Error().Err(err).Str("upstream", upstream).Dur("openDuration", r.cfg.OpenDuration).Msg("Circuit breaker opened for LLM upstream")
+		}
+	default:
+		// The upstream answered, so it is healthy even if the request failed
+		breaker.release()
+	}
+}
+
+// backoff returns the delay before the retry following the attempt, exponential with jitter
+func (r *resilientPlatform) backoff(attempt int) time.Duration {
+	delay := r.cfg.InitialBackoff << attempt
+	if delay <= 0 || delay > r.cfg.MaxBackoff {
+		delay = r.cfg.MaxBackoff
+	}
+
+	// Pick a delay between half and the full backoff so that clients don't retry in lockstep
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// upstream identifies the server a request goes to, account servers have their own breaker
+func (r *resilientPlatform) upstream(params *ChatParameters) string {
+	if params.ServerURL != "" {
+		return string(r.delegate.Type()) + "@" + normalizeOllamaURL(params.ServerURL)
+	}
+	return string(r.delegate.Type())
+}
+
+// breaker returns the circuit breaker of the upstream, creating it if needed
+func (r *resilientPlatform) breaker(upstream string) *circuitBreaker {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	breaker, ok := r.breakers[upstream]
+	if !ok {
+		breaker = &circuitBreaker{}
+		r.breakers[upstream] = breaker
+	}
+	return breaker
+}
+
+// allow returns true if a request may be sent, moving an open breaker to half open once openDuration has passed
+func (b *circuitBreaker) allow(openDuration time.Duration) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < openDuration {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.trialInFlight = true
+		return true
+	case circuitHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// success closes the breaker
+func (b *circuitBreaker) success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = circuitClosed
+	b.consecutiveFailures = 0
+	b.trialInFlight = false
+}
+
+// failure counts a failure and returns true if it opened the breaker
+func (b *circuitBreaker) failure(threshold int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.consecutiveFailures++
+	b.trialInFlight = false
+	if b.state == circuitHalfOpen || (b.state == circuitClosed && b.consecutiveFailures >= threshold) {
+		b.state = circuitOpen
+		b.openedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// release ends a trial request without changing the state of the breaker
+func (b *circuitBreaker) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.trialInFlight = false
+}
+
+func (b *circuitBreaker) currentState() circuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
+
+// platformStatus walks the decorators of the platform and reports it as degraded if a circuit breaker is not closed
+func platformStatus(platform Platform) PlatformStatus {
+	for platform != nil {
+		if degraded, ok := platform.(interface{ Degraded() bool }); ok && degraded.Degraded() {
+			return PlatformStatusDegraded
+		}
+
+		wrapper, ok := platform.(interface{ Unwrap() Platform })
+		if !ok {
+			break
+		}
+		platform = wrapper.Unwrap()
+	}
+	return PlatformStatusOK
+}
+
+// isRetryableError returns true for errors that may go away when the request is sent again:
+// timeouts, connection failures, rate limits and server errors
+func isRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if statusCode, ok := errorStatusCode(err); ok {
+		return isRetryableStatus(statusCode)
+	}
+
+	var anthropicErr *anthropicAPIError
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.Type == "overloaded_error" || anthropicErr.Type == "api_error" || anthropicErr.Type == "rate_limit_error"
+	}
+
+	return false
+}
+
+// errorStatusCode returns the HTTP status code of an error returned by a platform API
+func errorStatusCode(err error) (int, bool) {
+	var openAIErr *openai.Error
+	if errors.As(err, &openAIErr) && openAIErr.StatusCode != 0 {
+		return openAIErr.StatusCode, true
+	}
+
+	var ollamaErr api.StatusError
+	if errors.As(err, &ollamaErr) && ollamaErr.StatusCode != 0 {
+		return ollamaErr.StatusCode, true
+	}
+
+	var anthropicErr *anthropicAPIError
+	if errors.As(err, &anthropicErr) && anthropicErr.StatusCode != 0 {
+		return anthropicErr.StatusCode, true
+	}
+
+	return 0, false
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusConflict ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
+
+// sleepContext waits for the duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyPlatform fails with the queued errors before answering like the echo platform
+type flakyPlatform struct {
+	echoPlatform
+	platformType PlatformType
+	errors       []error
+	calls        int
+	// chunks are streamed before failing
+	chunks []string
+	// models are the requested models of every call
+	models []string
+}
+
+func (f *flakyPlatform) Type() PlatformType {
+	if f.platformType != "" {
+		return f.platformType
+	}
+	return EchoPlatform
+}
+
+func (f *flakyPlatform) Chat(ctx context.Context, params *ChatParameters) (*ChatResponse, error) {
+	return f.ChatStream(ctx, params, nil)
+}
+
+func (f *flakyPlatform) ChatStream(ctx context.Context, params *ChatParameters, handler StreamHandler) (*ChatResponse, error) {
+	f.calls++
+	f.models = append(f.models, params.Model)
+	if handler != nil {
+		for _, chunk := range f.chunks {
+			if err := handler(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(f.errors) > 0 {
+		err := f.errors[0]
+		f.errors = f.errors[1:]
+		return nil, err
+	}
+	return f.echoPlatform.Chat(ctx, params)
+}
+
+func newTestResilientPlatform(delegate Platform, cfg ResilienceConfig) *resilientPlatform {
+	r := newResilientPlatform(delegate, cfg).(*resilientPlatform)
+	r.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return r
+}
+
+func TestResilientPlatformRetriesTransientErrors(t *testing.T) {
+	delegate := &flakyPlatform{errors: []error{
+		api.StatusError{StatusCode: http.StatusServiceUnavailable},
+		&anthropicAPIError{StatusCode: http.StatusTooManyRequests, Type: "rate_limit_error"},
+	}}
+	platform := newTestResilientPlatform(delegate, ResilienceConfig{Enabled: true, MaxRetries: 2})
+
+	resp, err := platform.Chat(context.Background(), &ChatParameters{Prompt: "Hello"})
+
+	require.NoError(t, err)
+	assert.Contains(t, resp.Response, "Hello")
+	assert.Equal(t, 3, delegate.calls)
+	assert.False(t, platform.Degraded())
+}
+
+func TestResilientPlatformGivesUp(t *testing.T) {
+	tests := []struct {
+		name          string
+		errors        []error
+		chunks        []string
+		expectedCalls int
+	}{
+		{
+			name:          "out of retries",
+			errors:        []error{syscall.ECONNREFUSED, syscall.ECONNREFUSED, syscall.ECONNREFUSED, syscall.ECONNREFUSED},
+			expectedCalls: 3,
+		},
+		{
+			name:          "not retryable",
+			errors:        []error{&anthropicAPIError{StatusCode: http.StatusBadRequest, Type: "invalid_request_error"}},
+			expectedCalls: 1,
+		},
+		{
+			name:          "already streamed",
+			errors:        []error{fmt.Errorf("failed: %w", syscall.ECONNRESET)},
+			chunks:        []string{"partial"},
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delegate := &flakyPlatform{errors: tt.errors, chunks: tt.chunks}
+			platform := newTestResilientPlatform(delegate, ResilienceConfig{Enabled: true, MaxRetries: 2})
+
+			_, err := platform.ChatStream(context.Background(), &ChatParameters{Prompt: "Hello"}, func(chunk string) error {
+				return nil
+			})
+
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedCalls, delegate.calls)
+		})
+	}
+}
+
+func TestResilientPlatformCircuitBreaker(t *testing.T) {
+	failure := api.StatusError{StatusCode: http.StatusBadGateway}
+	delegate := &flakyPlatform{errors: []error{failure, failure, failure}}
+	platform := newTestResilientPlatform(delegate, ResilienceConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		OpenDuration:     time.Hour,
+	})
+	s := newService(&Config{}, []Platform{newCachedPlatform(platform, NewMemoryCacheStorage())})
+
+	for range 2 {
+		_, err := platform.Chat(context.Background(), &ChatParameters{Prompt: "Hello"})
+		require.ErrorIs(t, err, failure)
+	}
+
+	// The breaker is open, the upstream isn't called
+	_, err := platform.Chat(context.Background(), &ChatParameters{Prompt: "Hello"})
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, delegate.calls)
+	assert.Equal(t, PlatformStatusDegraded, s.Info(context.Background()).Platforms[0].Status)
+
+	// Another upstream of the platform has its own breaker
+	_, err = platform.Chat(context.Background(), &ChatParameters{Prompt: "Hello", ServerURL: "http://gpu-box:11434"})
+	require.ErrorIs(t, err, failure)
+
+	// Once the open duration has passed a successful trial request closes the breaker
+	platform.breaker(string(EchoPlatform)).openedAt = time.Now().Add(-2 * time.Hour)
+	_, err = platform.Chat(context.Background(), &ChatParameters{Prompt: "Hello"})
+	require.NoError(t, err)
+	assert.Equal(t, PlatformStatusOK, s.Info(context.Background()).Platforms[0].Status)
+}
+
+func TestIsRetryableError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.True(t, isRetryableError(api.StatusError{StatusCode: http.StatusInternalServerError}))
+	assert.True(t, isRetryableError(fmt.Errorf("wrapped: %w", &openai.Error{StatusCode: http.StatusRequestTimeout})))
+	assert.True(t, isRetryableError(&anthropicAPIError{Type: "overloaded_error"}))
+	assert.True(t, isRetryableError(context.DeadlineExceeded))
+	assert.False(t, isRetryableError(ctx.Err()))
+	assert.False(t, isRetryableError(api.StatusError{StatusCode: http.StatusNotFound}))
+	assert.False(t, isRetryableError(&openai.Error{StatusCode: http.StatusUnauthorized}))
+	assert.False(t, isRetryableError(errors.New("no response from API")))
+}
+
+func TestResilientPlatformBackoff(t *testing.T) {
+	platform := newTestResilientPlatform(&echoPlatform{}, ResilienceConfig{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	})
+
+	for attempt, maxDelay := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		delay := platform.backoff(attempt)
+		assert.GreaterOrEqual(t, delay, maxDelay/2)
+		assert.LessOrEqual(t, delay, maxDelay)
+	}
+}
@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+)
+
+// JSONSchemaOf derives a JSON Schema from the type of v, following the encoding/json field names.
+// Fields tagged with omitempty are optional, every other field is required.
+// It covers the types used for structured LLM output: structs, slices, string keyed maps and scalars.
+func JSONSchemaOf(v any) map[string]any {
+	return jsonSchemaOfType(reflect.TypeOf(v))
+}
+
+func jsonSchemaOfType(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		return jsonSchemaOfStruct(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]any{
+			"type":  "array",
+			"items": jsonSchemaOfType(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]any{
+			"type":                 "object",
+			"additionalProperties": jsonSchemaOfType(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		// interfaces and other dynamic values accept anything
+		return map[string]any{}
+	}
+}
+
+func jsonSchemaOfStruct(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		optional := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			tagName, tagOptions, _ := strings.Cut(tag, ",")
+			if tagName != "" {
+				name = tagName
+			}
+			optional = strings.Contains(tagOptions, "omitempty")
+		}
+
+		properties[name] = jsonSchemaOfType(field.Type)
+		if !optional {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
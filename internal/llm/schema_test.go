@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type schemaTestItem struct {
+	Question string            `json:"question"`
+	Options  []string          `json:"options,omitempty"`
+	Points   int               `json:"points"`
+	Weight   float64           `json:"weight,omitempty"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	Ignored  string            `json:"-"`
+	internal string
+}
+
+type schemaTestContainer struct {
+	Items []*schemaTestItem `json:"items"`
+}
+
+func TestJSONSchemaOf(t *testing.T) {
+	schema := JSONSchemaOf(schemaTestContainer{})
+
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, []string{"items"}, schema["required"])
+	assert.Equal(t, false, schema["additionalProperties"])
+
+	items := schema["properties"].(map[string]any)["items"].(map[string]any)
+	assert.Equal(t, "array", items["type"])
+
+	item := items["items"].(map[string]any)
+	assert.Equal(t, "object", item["type"])
+	assert.Equal(t, []string{"question", "points"}, item["required"])
+
+	properties := item["properties"].(map[string]any)
+	assert.Len(t, properties, 5)
+	assert.Equal(t, map[string]any{"type": "string"}, properties["question"])
+	assert.Equal(t, map[string]any{"type": "array", "items": map[string]any{"type": "string"}}, properties["options"])
+	assert.Equal(t, map[string]any{"type": "integer"}, properties["points"])
+	assert.Equal(t, map[string]any{"type": "number"}, properties["weight"])
+	assert.Equal(t, map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}}, properties["extra"])
+}
+
+func TestOllamaFormat(t *testing.T) {
+	assert.Nil(t, ollamaFormat(nil))
+	assert.JSONEq(t, `"json"`, string(ollamaFormat(&ResponseFormat{Type: ResponseFormatJSON})))
+
+	schema := map[string]any{"type": "object"}
+	assert.JSONEq(t, `{"type":"object"}`, string(ollamaFormat(&ResponseFormat{Type: ResponseFormatJSONSchema, Schema: schema})))
+}
+
+func TestResponseFormatChangesCacheKey(t *testing.T) {
+	storage := NewMemoryCacheStorage()
+
+	params := &ChatParameters{Prompt: "Create questions", Model: "gemma3:1b"}
+	plainKey := storage.GetChatCacheKey(OllamaPlatform, params)
+
+	WithJSONFormat()(params)
+	jsonKey := storage.GetChatCacheKey(OllamaPlatform, params)
+
+	WithJSONSchema("items", JSONSchemaOf(schemaTestContainer{}))(params)
+	schemaKey := storage.GetChatCacheKey(OllamaPlatform, params)
+
+	assert.NotEqual(t, plainKey, jsonKey)
+	assert.NotEqual(t, jsonKey, schemaKey)
+	assert.Equal(t, schemaKey, storage.GetChatCacheKey(OllamaPlatform, params))
+}
@@ -1,7 +1,9 @@
 package llm
 
 import (
+	"context"
 	"io"
+	"time"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/pocketbase/pocketbase/core"
@@ -11,10 +13,19 @@ import (
 // Service provides a high-level API for interacting with LLM platforms
 type (
 	Service interface {
-		Chat(prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error)
-		ChatWithHistory(messages []*domain.ChatItem, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error)
-		DescribeImage(reader io.Reader, fileName string, prompt string, systemPrompt string) (string, *domain.Usage, error)
-		Info() Info
+		Chat(ctx context.Context, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error)
+		ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error)
+		// ChatStream is the streaming variant of Chat, the response is delivered to the handler as it is generated
+		ChatStream(ctx context.Context, prompt string, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error)
+		// ChatWithHistoryStream is the streaming variant of ChatWithHistory
+		ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error)
+		// ChatWithTools is ChatWithHistory offering the tools set with WithTools to the model.
+		// The response holds the tool calls of the model, if any, the response is streamed when the handler is not nil.
+		ChatWithTools(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (*ChatResponse, error)
+		DescribeImage(ctx context.Context, reader io.Reader, fileName string, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error)
+		// Embed returns an embedding vector per text, the model option names an embedding model
+		Embed(ctx context.Context, texts []string, options ...ChatOption) ([][]float32, *domain.Usage, error)
+		Info(ctx context.Context, options ...InfoOption) Info
 	}
 
 	Info struct {
@@ -23,17 +34,41 @@ type (
 
 	// PlatformInfo represents information about an LLM platform
 	PlatformInfo struct {
-		Name      string       `json:"name"`
-		IsDefault bool         `json:"isDefault"`
-		Models    []*ModelInfo `json:"models"`
+		Name      string `json:"name"`
+		IsDefault bool   `json:"isDefault"`
+		// Status is PlatformStatusDegraded while requests to the platform are rejected by its circuit breaker
+		Status PlatformStatus `json:"status"`
+		Models []*ModelInfo   `json:"models"`
+		// Err is why the models couldn't be listed, e.g. Ollama being unreachable. It may hold internal
+		// addresses and is only reported by the health checks.
+		Err error `json:"-"`
+		// Duration is how long listing the models took, only reported by the health checks
+		Duration time.Duration `json:"-"`
 	}
 
+	// PlatformStatus reports the health of a platform
+	PlatformStatus string
+
 	// ChatOption defines a function that can modify ChatParameters
 	ChatOption func(*ChatParameters)
 
+	// InfoOption defines a function that can modify InfoParameters
+	InfoOption func(*InfoParameters)
+
+	// InfoParameters tune the information about the platforms
+	InfoParameters struct {
+		// ProbeModels lists the models with the platforms themselves, past the decorators caching them
+		ProbeModels bool
+	}
+
 	service struct {
+		// platform is the default platform, used for models that don't name a platform
 		platform Platform
-		config   *Config
+		// platforms holds every registered platform, starting with the default one
+		platforms []Platform
+		config    *Config
+		// pricing prices the usage of every platform, see Config.Pricing
+		pricing PricingTable
 	}
 )
 
@@ -44,41 +79,92 @@ func MemoryCacheService(config *Config) Service {
 	// Create appropriate cache storage
 	if config.Cache.Enabled {
 		log.Info().Msg("Creating in-memory LLM cache storage")
-		cacheStorage = NewMemoryCacheStorage()
+		cacheStorage = NewMemoryCacheStorageWithConfig(config.Cache)
+	}
+
+	// Create the appropriate platforms based on configuration
+	service := Service(newService(config, NewPlatforms(config, cacheStorage)))
+
+	// Without accounts only the configured identifiers are redacted
+	if config.Redaction.Enabled && len(config.Redaction.Identifiers) > 0 {
+		service = newRedactionService(service, nil, config.Redaction)
 	}
+	return service
+}
 
-	// Create the appropriate platform based on configuration
-	platform := NewPlatform(config, cacheStorage)
+// AppService creates a new LLM service caching the responses in the cache storage, see NewCacheStorage
+func AppService(config *Config, app core.App, cacheStorage CacheStorage) Service {
+	platforms := NewPlatforms(config, cacheStorage)
+
+	// Every request is recorded in the usage ledger, requests made for an account are limited by the account budget
+	// and redacted for the cloud platforms with the privacy settings of the account
+	redaction := newRedactionService(newService(config, platforms), &pocketBasePrivacyStore{app: app}, config.Redaction)
+	ledger := newLedgerService(redaction, &pocketBaseUsageLedger{app: app})
+	return newBudgetService(ledger, &pocketBaseBudgetStore{app: app})
+}
 
+func newService(config *Config, platforms []Platform) *service {
 	return &service{
-		platform: platform,
-		config:   config,
+		platform:  platforms[0],
+		platforms: platforms,
+		config:    config,
+		pricing:   config.pricingTable(),
 	}
 }
 
-// AppService creates a new LLM service with PocketBase cache if enabled
-func AppService(config *Config, app core.App) Service {
-	var platform Platform
+// resolvePlatform returns the platform for the parameters and removes the platform from a qualified model name.
+// Models that don't name a platform go to the default platform, unless a server URL is set and the model
+// may be an Ollama model in which case they go to Ollama when it is registered.
+func (s *service) resolvePlatform(params *ChatParameters) Platform {
+	platformType, model := splitQualifiedModel(params.Model, s.platforms)
+	if platformType == "" && params.ServerURL != "" && isOllamaModel(model) {
+		platformType = OllamaPlatform
+	}
 
-	// If using PocketBase cache and it's enabled, initialize it now
-	if config.Cache.Enabled && config.Cache.Backend == string(PocketBaseCache) {
-		log.Info().Msg("Creating PocketBase-backed LLM cache storage")
-		cacheStorage := NewPocketBaseCacheStorage(app)
+	for _, platform := range s.platforms {
+		if platform.Type() == platformType {
+			params.Model = model
+			return platform
+		}
+	}
 
-		// Create a new platform with the PocketBase cache
-		platform = NewPlatform(config, cacheStorage)
-	} else {
-		platform = NewPlatform(config, NewMemoryCacheStorage())
+	return s.platform
+}
+
+// modelName returns the model name as seen by callers, qualified with the platform when several platforms are registered
+func (s *service) modelName(platformType PlatformType, model string) string {
+	if len(s.platforms) < 2 {
+		return model
 	}
+	return qualifiedModelName(platformType, model)
+}
 
-	return &service{
-		platform: platform,
-		config:   config,
+// recordPlatform records the platform that answered in the usage, which is the resolved
+// platform unless it fell back to another one, prices the usage and qualifies the model name with the platform.
+// Cache hits cost nothing.
+func (s *service) recordPlatform(platform Platform, usage *domain.Usage) {
+	if usage.Platform == "" {
+		usage.Platform = string(platform.Type())
+	}
+	usage.Cost = 0
+	if !usage.CacheHit {
+		usage.Cost = s.pricing.Cost(PlatformType(usage.Platform), usage.LlmModelName, usage)
 	}
+	usage.LlmModelName = s.modelName(PlatformType(usage.Platform), usage.LlmModelName)
 }
 
 // Chat sends a chat request to the configured LLM platform
-func (s *service) Chat(prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+func (s *service) Chat(ctx context.Context, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.chatStream(ctx, prompt, systemPrompt, nil, options...)
+}
+
+// ChatStream sends a chat request to the configured LLM platform and streams the response to the handler
+func (s *service) ChatStream(ctx context.Context, prompt string, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.chatStream(ctx, prompt, systemPrompt, handler, options...)
+}
+
+// chatStream sends a chat request, streaming the response when a handler is provided
+func (s *service) chatStream(ctx context.Context, prompt string, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
 	params := &ChatParameters{
 		Prompt:       prompt,
 		SystemPrompt: systemPrompt,
@@ -91,25 +177,62 @@ func (s *service) Chat(prompt string, systemPrompt string, options ...ChatOption
 	}
 
 	// Send the chat request
-	response, err := s.platform.Chat(params)
+	platform := s.resolvePlatform(params)
+	if err := checkModel(ctx, platform, params, requiredCapabilities(params, CapabilityCompletion), estimateTokenCount(systemPrompt+prompt)); err != nil {
+		return "", nil, err
+	}
+	var response *ChatResponse
+	var err error
+	if handler != nil {
+		response, err = platform.ChatStream(ctx, params, handler)
+	} else {
+		response, err = platform.Chat(ctx, params)
+	}
 	if err != nil {
 		return "", nil, err
 	}
+	s.recordPlatform(platform, response.Usage)
 
 	log.Debug().
+		Str("platform", response.Usage.Platform).
 		Str("model", response.Usage.LlmModelName).
 		Bool("cacheHit", response.Usage.CacheHit).
 		Int("promptTokens", response.Usage.PromptTokens).
 		Int("completionTokens", response.Usage.CompletionTokens).
 		Int("totalTokens", response.Usage.TotalTokens).
 		Float64("cost", response.Usage.Cost).
+		Bool("stream", handler != nil).
 		Msg("Chat completion performed")
 
 	return response.Response, response.Usage, nil
 }
 
 // ChatWithHistory sends a chat request with message history and explicit system prompt
-func (s *service) ChatWithHistory(messages []*domain.ChatItem, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+func (s *service) ChatWithHistory(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.chatWithHistoryStream(ctx, messages, systemPrompt, nil, options...)
+}
+
+// ChatWithHistoryStream sends a chat request with message history and streams the response to the handler
+func (s *service) ChatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	return s.chatWithHistoryStream(ctx, messages, systemPrompt, handler, options...)
+}
+
+// ChatWithTools sends a chat request with message history and tools, the response holds the tool calls of the model
+func (s *service) ChatWithTools(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (*ChatResponse, error) {
+	return s.chatWithHistory(ctx, messages, systemPrompt, handler, options...)
+}
+
+// chatWithHistoryStream sends a chat request with message history, streaming the response when a handler is provided
+func (s *service) chatWithHistoryStream(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (string, *domain.Usage, error) {
+	response, err := s.chatWithHistory(ctx, messages, systemPrompt, handler, options...)
+	if err != nil {
+		return "", nil, err
+	}
+	return response.Response, response.Usage, nil
+}
+
+// chatWithHistory sends a chat request with message history and returns the response of the platform
+func (s *service) chatWithHistory(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (*ChatResponse, error) {
 	// Create initial parameters
 	params := &ChatParameters{
 		Prompt:       "", // Not used directly when we have message history
@@ -126,12 +249,24 @@ func (s *service) ChatWithHistory(messages []*domain.ChatItem, systemPrompt stri
 	// The actual platform implementation will need to handle message history and system prompt
 	// We're passing the existing ChatParameters, which already has systemPrompt field
 	// Messages are passed separately - platforms will need to be updated to handle this pattern
-	response, err := s.platform.ChatWithHistory(messages, params)
+	platform := s.resolvePlatform(params)
+	if err := checkModel(ctx, platform, params, requiredCapabilities(params, CapabilityCompletion), messagesTokenCount(systemPrompt, messages)); err != nil {
+		return nil, err
+	}
+	var response *ChatResponse
+	var err error
+	if handler != nil {
+		response, err = platform.ChatWithHistoryStream(ctx, messages, params, handler)
+	} else {
+		response, err = platform.ChatWithHistory(ctx, messages, params)
+	}
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
+	s.recordPlatform(platform, response.Usage)
 
 	log.Debug().
+		Str("platform", response.Usage.Platform).
 		Str("model", response.Usage.LlmModelName).
 		Bool("cacheHit", response.Usage.CacheHit).
 		Int("promptTokens", response.Usage.PromptTokens).
@@ -139,13 +274,15 @@ func (s *service) ChatWithHistory(messages []*domain.ChatItem, systemPrompt stri
 		Int("totalTokens", response.Usage.TotalTokens).
 		Float64("cost", response.Usage.Cost).
 		Int("messageCount", len(messages)).
+		Int("toolCalls", len(response.ToolCalls)).
+		Bool("stream", handler != nil).
 		Msg("Chat with history completion performed")
 
-	return response.Response, response.Usage, nil
+	return response, nil
 }
 
 // DescribeImage sends an image to the configured LLM platform for description
-func (s *service) DescribeImage(reader io.Reader, fileName string, prompt string, systemPrompt string) (string, *domain.Usage, error) {
+func (s *service) DescribeImage(ctx context.Context, reader io.Reader, fileName string, prompt string, systemPrompt string, options ...ChatOption) (string, *domain.Usage, error) {
 	params := &DescribeImageParameters{
 		ChatParameters: ChatParameters{
 			Prompt:       prompt,
@@ -156,13 +293,25 @@ func (s *service) DescribeImage(reader io.Reader, fileName string, prompt string
 		FileName: fileName,
 	}
 
+	// Apply any custom options
+	for _, option := range options {
+		option(&params.ChatParameters)
+	}
+
 	// Send the image description request
-	response, err := s.platform.DescribeImage(params)
+	platform := s.resolvePlatform(&params.ChatParameters)
+	capabilities := requiredCapabilities(&params.ChatParameters, CapabilityCompletion, CapabilityVision)
+	if err := checkModel(ctx, platform, &params.ChatParameters, capabilities, estimateTokenCount(systemPrompt+prompt)); err != nil {
+		return "", nil, err
+	}
+	response, err := platform.DescribeImage(ctx, params)
 	if err != nil {
 		return "", nil, err
 	}
+	s.recordPlatform(platform, response.Usage)
 
 	log.Debug().
+		Str("platform", response.Usage.Platform).
 		Str("model", response.Usage.LlmModelName).
 		Bool("cacheHit", response.Usage.CacheHit).
 		Int("promptTokens", response.Usage.PromptTokens).
@@ -174,20 +323,107 @@ func (s *service) DescribeImage(reader io.Reader, fileName string, prompt string
 	return response.Description, response.Usage, nil
 }
 
-func (s *service) Info() Info {
-	models, err := s.platform.Models()
+// Embed sends the texts to the configured LLM platform for embedding
+func (s *service) Embed(ctx context.Context, texts []string, options ...ChatOption) ([][]float32, *domain.Usage, error) {
+	params := &EmbedParameters{Texts: texts}
+
+	// Apply any custom options
+	for _, option := range options {
+		option(&params.ChatParameters)
+	}
+
+	platform := s.resolvePlatform(&params.ChatParameters)
+	longest := 0
+	for _, text := range texts {
+		longest = max(longest, estimateTokenCount(text))
+	}
+	if err := checkModel(ctx, platform, &params.ChatParameters, []Capability{CapabilityEmbedding}, longest); err != nil {
+		return nil, nil, err
+	}
+	response, err := platform.Embed(ctx, params)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get platform models")
+		return nil, nil, err
 	}
+	s.recordPlatform(platform, response.Usage)
 
-	return Info{
-		Platforms: []PlatformInfo{
-			{
-				Name:      string(s.platform.Type()),
-				IsDefault: true,
-				Models:    models,
-			},
-		},
+	log.Debug().
+		Str("platform", response.Usage.Platform).
+		Str("model", response.Usage.LlmModelName).
+		Bool("cacheHit", response.Usage.CacheHit).
+		Int("texts", len(texts)).
+		Int("promptTokens", response.Usage.PromptTokens).
+		Float64("cost", response.Usage.Cost).
+		Msg("Embeddings performed")
+
+	return response.Embeddings, response.Usage, nil
+}
+
+// Info returns the registered platforms and their models, starting with the default platform.
+// When several platforms are registered the model names are qualified with the platform, e.g. "openai/gpt-4.1-nano".
+func (s *service) Info(ctx context.Context, options ...InfoOption) Info {
+	params := &InfoParameters{}
+	for _, option := range options {
+		option(params)
+	}
+
+	info := Info{
+		Platforms: make([]PlatformInfo, 0, len(s.platforms)),
+	}
+
+	for _, platform := range s.platforms {
+		lister := platform
+		if params.ProbeModels {
+			lister = unwrapPlatform(platform)
+		}
+
+		start := time.Now()
+		models, err := lister.Models(ctx)
+		duration := time.Since(start)
+		if err != nil {
+			log.Error().Err(err).Str("platform", string(platform.Type())).Msg("Failed to get platform models")
+		}
+
+		// Copy the models, the platform may cache them
+		platformModels := make([]*ModelInfo, 0, len(models))
+		for _, model := range models {
+			m := *model
+			if price, ok := s.pricing.Price(platform.Type(), m.Name); ok {
+				m.Price = &price
+			}
+			m.Name = s.modelName(platform.Type(), m.Name)
+			platformModels = append(platformModels, &m)
+		}
+
+		info.Platforms = append(info.Platforms, PlatformInfo{
+			Name:      string(platform.Type()),
+			IsDefault: platform == s.platform,
+			Status:    platformStatus(platform),
+			Models:    platformModels,
+			Err:       err,
+			Duration:  duration,
+		})
+	}
+
+	return info
+}
+
+// WithProbedModels lists the models with the platforms themselves, e.g. to probe the platforms. A platform that
+// fails to list its models reports the error instead of the models it listed before. The models cached by the
+// service are left as they are, the requests keep being served with them.
+func WithProbedModels() InfoOption {
+	return func(params *InfoParameters) {
+		params.ProbeModels = true
+	}
+}
+
+// unwrapPlatform returns the platform decorated by the decorators of the platform
+func unwrapPlatform(platform Platform) Platform {
+	for {
+		wrapper, ok := platform.(interface{ Unwrap() Platform })
+		if !ok {
+			return platform
+		}
+		platform = wrapper.Unwrap()
 	}
 }
 
@@ -207,3 +443,121 @@ func WithCache(ignoreCache bool, disableCache bool) ChatOption {
 		}
 	}
 }
+
+// WithDefaultModel sets the model for the chat unless a model has already been set
+func WithDefaultModel(model string) ChatOption {
+	return func(params *ChatParameters) {
+		if params.Model == "" {
+			params.Model = model
+		}
+	}
+}
+
+// WithServerURL sends the chat to a specific server instead of the configured one, only Ollama supports it
+func WithServerURL(serverURL string) ChatOption {
+	return func(params *ChatParameters) {
+		params.ServerURL = serverURL
+	}
+}
+
+// WithAccount makes the chat on behalf of the account, counting its usage against the account budget
+func WithAccount(accountID string) ChatOption {
+	return func(params *ChatParameters) {
+		params.AccountID = accountID
+	}
+}
+
+// WithUser records the user making the chat in the usage ledger
+func WithUser(userID string) ChatOption {
+	return func(params *ChatParameters) {
+		params.UserID = userID
+	}
+}
+
+// WithPurpose records what the chat is for in the usage ledger
+func WithPurpose(purpose Purpose) ChatOption {
+	return func(params *ChatParameters) {
+		params.Purpose = purpose
+	}
+}
+
+// WithPriority sets the priority of the chat in the queue of the platform, instead of the one of its purpose
+func WithPriority(priority Priority) ChatOption {
+	return func(params *ChatParameters) {
+		params.Priority = &priority
+	}
+}
+
+// WithQueueHandler reports the position of the chat in the queue of the platform, see QueueHandler
+func WithQueueHandler(handler QueueHandler) ChatOption {
+	return func(params *ChatParameters) {
+		params.OnQueued = handler
+	}
+}
+
+// WithJSONFormat asks for the response to be a valid JSON object
+func WithJSONFormat() ChatOption {
+	return func(params *ChatParameters) {
+		params.ResponseFormat = &ResponseFormat{Type: ResponseFormatJSON}
+	}
+}
+
+// WithJSONSchema asks for the response to be JSON matching the schema, see JSONSchemaOf
+func WithJSONSchema(name string, schema map[string]any) ChatOption {
+	return func(params *ChatParameters) {
+		params.ResponseFormat = &ResponseFormat{
+			Type:   ResponseFormatJSONSchema,
+			Name:   name,
+			Schema: schema,
+		}
+	}
+}
+
+// WithTools offers the tools to the model, see Service.ChatWithTools
+func WithTools(tools ...Tool) ChatOption {
+	return func(params *ChatParameters) {
+		params.Tools = tools
+	}
+}
+
+// WithTemperature sets the sampling temperature, lower values give more deterministic responses
+func WithTemperature(temperature float64) ChatOption {
+	return func(params *ChatParameters) {
+		params.Sampling.Temperature = &temperature
+	}
+}
+
+// WithTopP sets the nucleus sampling probability mass
+func WithTopP(topP float64) ChatOption {
+	return func(params *ChatParameters) {
+		params.Sampling.TopP = &topP
+	}
+}
+
+// WithSeed sets the random seed, so that the same request gives the same response where the platform supports it
+func WithSeed(seed int) ChatOption {
+	return func(params *ChatParameters) {
+		params.Sampling.Seed = &seed
+	}
+}
+
+// WithMaxTokens sets the upper bound of generated tokens
+func WithMaxTokens(maxTokens int) ChatOption {
+	return func(params *ChatParameters) {
+		params.Sampling.MaxTokens = &maxTokens
+	}
+}
+
+// WithContextLength sets the size of the context window, only Ollama supports it
+func WithContextLength(contextLength int) ChatOption {
+	return func(params *ChatParameters) {
+		params.Sampling.ContextLength = &contextLength
+	}
+}
+
+// WithSampling applies the sampling parameters that are set, e.g. the overrides of a practice topic or a chat
+func WithSampling(sampling domain.SamplingParameters) ChatOption {
+	return func(params *ChatParameters) {
+		params.Sampling = params.Sampling.Merge(sampling)
+	}
+}
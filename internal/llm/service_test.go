@@ -1,10 +1,15 @@
 package llm
 
 import (
+	"context"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -69,7 +74,7 @@ func TestLLMServiceWithRealOllama(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			// Call the Chat method
-			response, usage, err := service.Chat(tc.prompt, tc.systemPrompt, tc.options...)
+			response, usage, err := service.Chat(context.Background(), tc.prompt, tc.systemPrompt, tc.options...)
 
 			// Assert no errors
 			assert.NoError(t, err)
@@ -93,18 +98,18 @@ func TestLLMServiceWithRealOllama(t *testing.T) {
 		tc := tests[0]
 
 		// First call should be a cache miss
-		_, firstUsage, err := service.Chat(tc.prompt, tc.systemPrompt)
+		_, firstUsage, err := service.Chat(context.Background(), tc.prompt, tc.systemPrompt)
 		assert.NoError(t, err)
 		assert.False(t, firstUsage.CacheHit)
 
 		// Second call with same parameters should be a cache hit
-		_, secondUsage, err := service.Chat(tc.prompt, tc.systemPrompt)
+		_, secondUsage, err := service.Chat(context.Background(), tc.prompt, tc.systemPrompt)
 		assert.NoError(t, err)
 		assert.True(t, secondUsage.CacheHit)
 	})
 
 	// Test models
-	info := service.Info()
+	info := service.Info(context.Background())
 	assert.NotNil(t, info)
 	assert.Equal(t, 1, len(info.Platforms))
 	assert.Equal(t, OllamaPlatform, info.Platforms[0].Name)
@@ -113,6 +118,10 @@ func TestLLMServiceWithRealOllama(t *testing.T) {
 
 func TestLLMServiceWithMockOllama(t *testing.T) {
 	mockClient := new(MockOllamaClient)
+	// The default model is checked before the request
+	mockClient.On("ShowModel", mock.Anything, "gemma3:1b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion},
+	}, nil)
 
 	// Set up the expected behavior
 	mockClient.On("ChatWithModel",
@@ -121,6 +130,8 @@ func TestLLMServiceWithMockOllama(t *testing.T) {
 		mock.Anything, // messages
 		false,         // stream
 		mock.Anything, // options
+		mock.Anything, // format
+		mock.Anything, // tools
 	).Return(&api.ChatResponse{
 		Message: api.Message{
 			Role:    "assistant",
@@ -151,7 +162,7 @@ func TestLLMServiceWithMockOllama(t *testing.T) {
 	ollamaPlatform.client = mockClient
 
 	// Test Chat
-	response, usage, err := s.Chat("Hello", "You are a helpful assistant")
+	response, usage, err := s.Chat(context.Background(), "Hello", "You are a helpful assistant")
 
 	assert.NoError(t, err)
 	assert.Equal(t, "This is a mock response", response)
@@ -160,3 +171,603 @@ func TestLLMServiceWithMockOllama(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestLLMServiceStreamWithMockOllama(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	// The default model is checked before the request
+	mockClient.On("ShowModel", mock.Anything, "gemma3:1b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion},
+	}, nil)
+	mockClient.StreamChunks = []api.ChatResponse{
+		{Message: api.Message{Role: "assistant", Content: "Hello"}},
+		{Message: api.Message{Role: "assistant", Content: " there"}},
+	}
+
+	mockClient.On("ChatWithModelStream",
+		mock.Anything, // context
+		"gemma3:1b",   // model name
+		mock.Anything, // messages
+		mock.Anything, // options
+		mock.Anything, // format
+		mock.Anything, // tools
+		mock.Anything, // callback
+	).Return(&api.ChatResponse{
+		Model:      "gemma3:1b",
+		Done:       true,
+		DoneReason: "stop",
+		Metrics: api.Metrics{
+			PromptEvalCount: 12,
+			EvalCount:       2,
+		},
+	}, nil)
+
+	config := &Config{
+		Platform: OllamaPlatform,
+		Ollama: OllamaConfig{
+			URL:   "http://localhost:11434", // Mock URL
+			Model: "gemma3:1b",
+		},
+		Cache: CacheConfig{
+			Enabled: true,
+			Backend: string(MemoryCache),
+		},
+	}
+
+	s := MemoryCacheService(config)
+	cachedPlatform := s.(*service).platform.(*cachedPlatform)
+	ollamaPlatform := cachedPlatform.delegate.(*ollamaPlatform)
+	ollamaPlatform.client = mockClient
+
+	messages := []*domain.ChatItem{
+		{Role: domain.ChatItemRoleUser, Content: "Hi"},
+	}
+
+	var chunks []string
+	handler := func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}
+
+	response, usage, err := s.ChatWithHistoryStream(context.Background(), messages, "You are a helpful assistant", handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello there", response)
+	assert.Equal(t, []string{"Hello", " there"}, chunks)
+	assert.NotNil(t, usage)
+	assert.Equal(t, 12, usage.PromptTokens)
+	assert.Equal(t, 2, usage.CompletionTokens)
+	assert.False(t, usage.CacheHit)
+
+	// The second request is served from the cache as a single chunk
+	chunks = nil
+	response, usage, err = s.ChatWithHistoryStream(context.Background(), messages, "You are a helpful assistant", handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello there", response)
+	assert.Equal(t, []string{"Hello there"}, chunks)
+	assert.True(t, usage.CacheHit)
+
+	mockClient.AssertNumberOfCalls(t, "ChatWithModelStream", 1)
+}
+
+func TestEchoPlatformChatStream(t *testing.T) {
+	platform := newEchoPlatform()
+
+	var streamed strings.Builder
+	resp, err := platform.ChatStream(context.Background(), &ChatParameters{Prompt: "one two three"}, func(chunk string) error {
+		streamed.WriteString(chunk)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Response, streamed.String())
+}
+
+func TestEchoPlatformChatStreamCancelled(t *testing.T) {
+	platform := newEchoPlatform()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks := 0
+	_, err := platform.ChatStream(ctx, &ChatParameters{Prompt: "one two three"}, func(chunk string) error {
+		chunks++
+		cancel()
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, chunks)
+}
+
+func TestLLMServiceWithAccountOllamaServer(t *testing.T) {
+	defaultClient := new(MockOllamaClient)
+	accountClient := new(MockOllamaClient)
+
+	accountClient.On("ChatWithModel",
+		mock.Anything, // context
+		"qwen3:8b",    // account default model
+		mock.Anything, // messages
+		false,         // stream
+		mock.Anything, // options
+		mock.Anything, // format
+		mock.Anything, // tools
+	).Return(&api.ChatResponse{
+		Message: api.Message{
+			Role:    "assistant",
+			Content: "Response from the account server",
+		},
+		Model: "qwen3:8b",
+		Done:  true,
+	}, nil)
+
+	accountClient.On("ShowModel", mock.Anything, "qwen3:8b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion, model.CapabilityTools},
+	}, nil)
+
+	config := &Config{
+		Platform: OllamaPlatform,
+		Ollama: OllamaConfig{
+			URL:   "http://localhost:11434", // Mock URL
+			Model: "gemma3:1b",
+		},
+	}
+
+	s := MemoryCacheService(config)
+	ollamaPlatform := s.(*service).platform.(*ollamaPlatform)
+	ollamaPlatform.client = defaultClient
+
+	var createdURLs []string
+	ollamaPlatform.clients.newClient = func(url string, timeout time.Duration) (OllamaClient, error) {
+		createdURLs = append(createdURLs, url)
+		return accountClient, nil
+	}
+
+	settings := &AccountSettings{
+		AccountID:       "account1",
+		OllamaServerURL: "http://gpu-box:11434/",
+		DefaultModel:    "qwen3:8b",
+	}
+
+	for i := 0; i < 2; i++ {
+		response, usage, err := s.Chat(context.Background(), "Hello", "You are a helpful assistant", settings.ChatOptions()...)
+		assert.NoError(t, err)
+		assert.Equal(t, "Response from the account server", response)
+		assert.Equal(t, "qwen3:8b", usage.LlmModelName)
+	}
+
+	// The client is created once and reused from the pool
+	assert.Equal(t, []string{"http://gpu-box:11434"}, createdURLs)
+	accountClient.AssertNumberOfCalls(t, "ChatWithModel", 2)
+	// The model of the account server is described once
+	accountClient.AssertNumberOfCalls(t, "ShowModel", 1)
+	defaultClient.AssertNotCalled(t, "ChatWithModel", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestWithDefaultModelDoesNotOverrideModel(t *testing.T) {
+	params := &ChatParameters{}
+	WithModel("topic-model")(params)
+	WithDefaultModel("account-model")(params)
+	assert.Equal(t, "topic-model", params.Model)
+
+	params = &ChatParameters{}
+	WithDefaultModel("account-model")(params)
+	assert.Equal(t, "account-model", params.Model)
+}
+
+func TestOllamaClientPoolEvictsLeastRecentlyUsed(t *testing.T) {
+	pool := newOllamaClientPool(2, time.Minute)
+	pool.newClient = func(url string, timeout time.Duration) (OllamaClient, error) {
+		return new(MockOllamaClient), nil
+	}
+
+	first, err := pool.get("http://one:11434")
+	assert.NoError(t, err)
+	_, err = pool.get("http://two:11434")
+	assert.NoError(t, err)
+
+	// Using the first server again makes the second one the least recently used
+	again, err := pool.get("http://one:11434")
+	assert.NoError(t, err)
+	assert.Same(t, first, again)
+
+	_, err = pool.get("http://three:11434")
+	assert.NoError(t, err)
+
+	assert.Len(t, pool.clients, 2)
+	assert.Contains(t, pool.clients, "http://one:11434")
+	assert.Contains(t, pool.clients, "http://three:11434")
+}
+
+func TestLLMServiceRoutesQualifiedModels(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.On("ChatWithModel",
+		mock.Anything, // context
+		"gemma3:4b",   // model name without the platform
+		mock.Anything, // messages
+		false,         // stream
+		mock.Anything, // options
+		mock.Anything, // format
+		mock.Anything, // tools
+	).Return(&api.ChatResponse{
+		Message: api.Message{
+			Role:    "assistant",
+			Content: "Response from Ollama",
+		},
+		Model: "gemma3:4b",
+		Done:  true,
+	}, nil)
+	mockClient.On("ListModels", mock.Anything).Return([]*ModelInfo{
+		{Name: "gemma3:1b", IsDefault: false},
+		{Name: "gemma3:4b", IsDefault: false},
+	}, nil)
+	mockClient.On("ShowModel", mock.Anything, "gemma3:1b").Return(&api.ShowResponse{
+		Details:      api.ModelDetails{ParameterSize: "999.89M"},
+		ModelInfo:    map[string]any{"general.architecture": "gemma3", "gemma3.context_length": float64(32768)},
+		Capabilities: []model.Capability{model.CapabilityCompletion},
+	}, nil)
+	mockClient.On("ShowModel", mock.Anything, "gemma3:4b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion, model.CapabilityVision},
+	}, nil)
+
+	config := &Config{
+		Platform:  EchoPlatform,
+		Platforms: []PlatformType{OllamaPlatform, EchoPlatform},
+		Ollama: OllamaConfig{
+			URL:   "http://localhost:11434", // Mock URL
+			Model: "gemma3:1b",
+		},
+	}
+
+	s := MemoryCacheService(config)
+	assert.Len(t, s.(*service).platforms, 2)
+	s.(*service).platforms[1].(*ollamaPlatform).client = mockClient
+
+	// A qualified model goes to its platform
+	response, usage, err := s.Chat(context.Background(), "Hello", "", WithModel("ollama/gemma3:4b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Response from Ollama", response)
+	assert.Equal(t, "ollama/gemma3:4b", usage.LlmModelName)
+
+	// Unqualified models go to the default platform
+	response, usage, err = s.Chat(context.Background(), "Hello", "")
+	assert.NoError(t, err)
+	assert.Contains(t, response, "Hello")
+	assert.Equal(t, "echo/echo", usage.LlmModelName)
+
+	// Every platform is listed, the default one first
+	info := s.Info(context.Background())
+	assert.Len(t, info.Platforms, 2)
+	assert.Equal(t, string(EchoPlatform), info.Platforms[0].Name)
+	assert.True(t, info.Platforms[0].IsDefault)
+	assert.Equal(t, string(OllamaPlatform), info.Platforms[1].Name)
+	assert.False(t, info.Platforms[1].IsDefault)
+	assert.Equal(t, "ollama/gemma3:1b", info.Platforms[1].Models[0].Name)
+	assert.True(t, info.Platforms[1].Models[0].IsDefault)
+	assert.Equal(t, []Capability{CapabilityCompletion, CapabilityJSON}, info.Platforms[1].Models[0].Capabilities)
+	assert.Equal(t, 32768, info.Platforms[1].Models[0].ContextLength)
+	assert.Equal(t, "999.89M", info.Platforms[1].Models[0].ParameterSize)
+	assert.True(t, info.Platforms[1].Models[1].Supports(CapabilityVision))
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestLLMServiceRoutesAccountServerModels(t *testing.T) {
+	config := &Config{
+		Platform:  EchoPlatform,
+		Platforms: []PlatformType{OllamaPlatform},
+		Ollama:    OllamaConfig{URL: "http://localhost:11434", Model: "gemma3:1b"},
+	}
+	s := newService(config, NewPlatforms(config, nil))
+
+	tests := []struct {
+		model    string
+		platform PlatformType
+	}{
+		{model: "", platform: OllamaPlatform},
+		{model: "qwen3:8b", platform: OllamaPlatform},
+		{model: "hf.co/org/model:latest", platform: OllamaPlatform},
+		// Cloud models of a topic or a chat stay on the default platform, the Ollama server of the account doesn't have them
+		{model: "gpt-4.1-nano", platform: EchoPlatform},
+		{model: "claude-3-5-haiku-latest", platform: EchoPlatform},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			params := &ChatParameters{Model: tt.model, ServerURL: "http://gpu-box:11434"}
+			assert.Equal(t, tt.platform, s.resolvePlatform(params).Type())
+			assert.Equal(t, tt.model, params.Model)
+		})
+	}
+}
+
+func TestSplitQualifiedModel(t *testing.T) {
+	platforms := []Platform{newEchoPlatform(), newOllamaPlatform(OllamaConfig{URL: "http://localhost:11434"})}
+
+	platformType, model := splitQualifiedModel("ollama/gemma3:4b", platforms)
+	assert.Equal(t, OllamaPlatform, platformType)
+	assert.Equal(t, "gemma3:4b", model)
+
+	// Unregistered prefixes are part of the model name
+	platformType, model = splitQualifiedModel("hf.co/org/model:latest", platforms)
+	assert.Equal(t, PlatformType(""), platformType)
+	assert.Equal(t, "hf.co/org/model:latest", model)
+
+	platformType, model = splitQualifiedModel("openai/gpt-4.1-nano", platforms)
+	assert.Equal(t, PlatformType(""), platformType)
+	assert.Equal(t, "openai/gpt-4.1-nano", model)
+}
+
+func TestLLMServiceSendsSamplingParametersToOllama(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	// The default model is checked before the request
+	mockClient.On("ShowModel", mock.Anything, "gemma3:1b").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityCompletion},
+	}, nil)
+	mockClient.On("ChatWithModel",
+		mock.Anything, // context
+		"gemma3:1b",   // model name
+		mock.Anything, // messages
+		false,         // stream
+		map[string]interface{}{
+			"temperature": 0.2,
+			"seed":        42,
+			"num_predict": 512,
+			"num_ctx":     8192,
+		},
+		mock.Anything, // format
+		mock.Anything, // tools
+	).Return(&api.ChatResponse{
+		Message: api.Message{Role: "assistant", Content: "Deterministic response"},
+		Model:   "gemma3:1b",
+		Done:    true,
+	}, nil)
+
+	config := &Config{
+		Platform: OllamaPlatform,
+		Ollama: OllamaConfig{
+			URL:   "http://localhost:11434", // Mock URL
+			Model: "gemma3:1b",
+		},
+	}
+
+	s := MemoryCacheService(config)
+	s.(*service).platform.(*ollamaPlatform).client = mockClient
+
+	temperature := 0.9
+	topicSampling := domain.SamplingParameters{Temperature: &temperature}
+
+	// Later options take precedence, so the chat temperature overrides the topic temperature
+	response, _, err := s.Chat(context.Background(), "Hello", "",
+		WithSampling(topicSampling),
+		WithTemperature(0.2),
+		WithSeed(42),
+		WithMaxTokens(512),
+		WithContextLength(8192),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "Deterministic response", response)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestSamplingParametersChangeCacheKey(t *testing.T) {
+	storages := map[string]CacheStorage{
+		"memory":     NewMemoryCacheStorage(),
+		"pocketbase": &PocketBaseCacheStorage{},
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			params := &ChatParameters{Prompt: "Create questions", Model: "gemma3:1b"}
+			defaultKey := storage.GetChatCacheKey(OllamaPlatform, params)
+			defaultHistoryKey := storage.GetChatWithHistoryCacheKey(OllamaPlatform, nil, params)
+
+			WithTemperature(0.2)(params)
+			temperatureKey := storage.GetChatCacheKey(OllamaPlatform, params)
+
+			WithSeed(1)(params)
+			seedKey := storage.GetChatCacheKey(OllamaPlatform, params)
+
+			assert.NotEqual(t, defaultKey, temperatureKey)
+			assert.NotEqual(t, temperatureKey, seedKey)
+			assert.NotEqual(t, defaultHistoryKey, storage.GetChatWithHistoryCacheKey(OllamaPlatform, nil, params))
+
+			// The same settings always give the same key
+			same := &ChatParameters{Prompt: "Create questions", Model: "gemma3:1b"}
+			WithSeed(1)(same)
+			WithTemperature(0.2)(same)
+			assert.Equal(t, seedKey, storage.GetChatCacheKey(OllamaPlatform, same))
+		})
+	}
+}
+
+func TestEchoPlatformEmbed(t *testing.T) {
+	platform := newEchoPlatform()
+
+	response, err := platform.Embed(context.Background(), &EmbedParameters{
+		Texts: []string{"The cat sat on the mat", "the CAT sat on the mat", "Photosynthesis in plants"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, response.Embeddings, 3)
+	assert.Len(t, response.Embeddings[0], echoEmbeddingDimensions)
+	assert.Equal(t, echoEmbeddingModel, response.Usage.LlmModelName)
+
+	// The embeddings are deterministic and ignore the case
+	assert.Equal(t, response.Embeddings[0], response.Embeddings[1])
+	assert.InDelta(t, 1, dotProduct(response.Embeddings[0], response.Embeddings[0]), 1e-5)
+	assert.Less(t, dotProduct(response.Embeddings[0], response.Embeddings[2]), float32(0.99))
+
+	_, err = platform.Embed(context.Background(), &EmbedParameters{})
+	assert.ErrorIs(t, err, ErrEmbedTextsEmpty)
+}
+
+func TestLLMServiceEmbedCachesEmbeddings(t *testing.T) {
+	config := &Config{
+		Platform: EchoPlatform,
+		Cache: CacheConfig{
+			Enabled: true,
+			Backend: string(MemoryCache),
+		},
+	}
+	s := MemoryCacheService(config)
+
+	embeddings, usage, err := s.Embed(context.Background(), []string{"fractions", "decimals"})
+	assert.NoError(t, err)
+	assert.Len(t, embeddings, 2)
+	assert.False(t, usage.CacheHit)
+	assert.Equal(t, string(EchoPlatform), usage.Platform)
+
+	cached, usage, err := s.Embed(context.Background(), []string{"fractions", "decimals"})
+	assert.NoError(t, err)
+	assert.True(t, usage.CacheHit)
+	assert.Zero(t, usage.Cost)
+	assert.Equal(t, embeddings, cached)
+
+	// The texts are part of the key, a different split is a miss
+	_, usage, err = s.Embed(context.Background(), []string{"fractionsdecimals"})
+	assert.NoError(t, err)
+	assert.False(t, usage.CacheHit)
+}
+
+func TestLLMServiceEmbedWithMockOllama(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	// The embedding model is checked rather than the default chat model
+	mockClient.On("ShowModel", mock.Anything, "nomic-embed-text").Return(&api.ShowResponse{
+		Capabilities: []model.Capability{model.CapabilityEmbedding},
+	}, nil)
+	mockClient.On("Embed", mock.Anything, "nomic-embed-text", []string{"Hello"}).Return(&api.EmbedResponse{
+		Model:           "nomic-embed-text",
+		Embeddings:      [][]float32{{0.1, 0.2, 0.3}},
+		PromptEvalCount: 2,
+	}, nil)
+
+	config := &Config{
+		Platform: OllamaPlatform,
+		Ollama: OllamaConfig{
+			URL:            "http://localhost:11434", // Mock URL
+			Model:          "gemma3:1b",
+			EmbeddingModel: "nomic-embed-text",
+		},
+	}
+	s := MemoryCacheService(config)
+	s.(*service).platform.(*ollamaPlatform).client = mockClient
+
+	embeddings, usage, err := s.Embed(context.Background(), []string{"Hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float32{{0.1, 0.2, 0.3}}, embeddings)
+	assert.Equal(t, "nomic-embed-text", usage.LlmModelName)
+	assert.Equal(t, 2, usage.PromptTokens)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestAnthropicPlatformDoesNotEmbed(t *testing.T) {
+	platform := newAnthropicPlatform(AnthropicConfig{APIKey: "test"})
+
+	_, err := platform.Embed(context.Background(), &EmbedParameters{Texts: []string{"Hello"}})
+	assert.ErrorIs(t, err, ErrEmbeddingsNotSupported)
+}
+
+func dotProduct(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func TestOllamaPlatformDescribeImageSendsImage(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	image := []byte("\x89PNG\r\n\x1a\nimage")
+	mockClient.On("ChatWithModel",
+		mock.Anything, // context
+		"gemma3:4b",   // model name
+		mock.MatchedBy(func(messages []api.Message) bool {
+			last := messages[len(messages)-1]
+			return len(messages) == 2 && len(last.Images) == 1 && string(last.Images[0]) == string(image)
+		}),
+		false,         // stream
+		mock.Anything, // options
+		mock.Anything, // format
+		mock.Anything, // tools
+	).Return(&api.ChatResponse{
+		Message: api.Message{
+			Role:    "assistant",
+			Content: "A worksheet",
+		},
+		Model:   "gemma3:4b",
+		Done:    true,
+		Metrics: api.Metrics{PromptEvalCount: 300, EvalCount: 4},
+	}, nil)
+
+	platform := newOllamaPlatform(OllamaConfig{URL: "http://localhost:11434", Model: "gemma3:4b"}).(*ollamaPlatform)
+	platform.client = mockClient
+
+	response, err := platform.DescribeImage(context.Background(), &DescribeImageParameters{
+		ChatParameters: ChatParameters{Prompt: "What is this?", SystemPrompt: "You read worksheets"},
+		Reader:         strings.NewReader(string(image)),
+		FileName:       "worksheet.png",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "A worksheet", response.Description)
+	assert.Equal(t, 300, response.Usage.PromptTokens)
+	assert.Equal(t, 304, response.Usage.TotalTokens)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestOllamaPlatformChatWithTools(t *testing.T) {
+	mockClient := new(MockOllamaClient)
+	mockClient.On("ChatWithModel",
+		mock.Anything, // context
+		"qwen3:4b",    // model name
+		mock.MatchedBy(func(messages []api.Message) bool {
+			last := messages[len(messages)-1]
+			return len(messages) == 3 && len(messages[1].ToolCalls) == 1 && last.Role == "tool" && last.ToolName == "get_recent_results"
+		}),
+		false,         // stream
+		mock.Anything, // options
+		mock.Anything, // format
+		mock.MatchedBy(func(tools api.Tools) bool {
+			return len(tools) == 1 && tools[0].Function.Name == "create_practice_session" &&
+				tools[0].Function.Parameters.Properties["learner"].Type[0] == "string" &&
+				tools[0].Function.Parameters.Required[0] == "learner"
+		}),
+	).Return(&api.ChatResponse{
+		Message: api.Message{
+			Role: "assistant",
+			ToolCalls: []api.ToolCall{{
+				Function: api.ToolCallFunction{
+					Name:      "create_practice_session",
+					Arguments: api.ToolCallFunctionArguments{"learner": "Mia", "question_count": float64(10)},
+				},
+			}},
+		},
+		Model: "qwen3:4b",
+		Done:  true,
+	}, nil)
+
+	platform := newOllamaPlatform(OllamaConfig{URL: "http://localhost:11434", Model: "qwen3:4b"}).(*ollamaPlatform)
+	platform.client = mockClient
+
+	messages := []*domain.ChatItem{
+		{Role: domain.ChatItemRoleUser, Content: "How is Mia doing?"},
+		{Role: domain.ChatItemRoleAssistant, ToolCalls: []domain.ToolCall{{ID: "call_0", Name: "get_recent_results", Arguments: []byte(`{"learner":"Mia"}`)}}},
+		{Role: domain.ChatItemRoleTool, Content: `{"correct":3,"total":4}`, ToolCallID: "call_0"},
+	}
+	response, err := platform.ChatWithHistory(context.Background(), messages, &ChatParameters{
+		Tools: []Tool{{
+			Name:        "create_practice_session",
+			Description: "Create a practice session",
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"learner": map[string]any{"type": "string", "description": "Nickname"}},
+				"required":   []string{"learner"},
+			},
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, response.ToolCalls, 1)
+	assert.Equal(t, "call_0", response.ToolCalls[0].ID)
+	assert.Equal(t, "create_practice_session", response.ToolCalls[0].Name)
+	assert.JSONEq(t, `{"learner":"Mia","question_count":10}`, string(response.ToolCalls[0].Arguments))
+
+	mockClient.AssertExpectations(t)
+}
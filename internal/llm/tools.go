@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type (
+	// ToolCaller identifies who the model calls a tool for, tools only act on the data of the caller's account
+	ToolCaller struct {
+		AccountID string
+		UserID    string
+	}
+
+	// ToolHandler runs a tool with the JSON arguments chosen by the model and returns the result for the model.
+	// An error is reported to the model, which may correct the arguments and call the tool again.
+	ToolHandler func(ctx context.Context, caller ToolCaller, arguments json.RawMessage) (string, error)
+
+	// ToolRegistry holds the tools the chat can offer to the model, it is safe for concurrent use
+	ToolRegistry struct {
+		mu       sync.RWMutex
+		tools    map[string]Tool
+		handlers map[string]ToolHandler
+	}
+)
+
+// NewToolRegistry creates an empty tool registry
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools:    map[string]Tool{},
+		handlers: map[string]ToolHandler{},
+	}
+}
+
+// Register adds the tool and its handler, tool names must be unique
+func (r *ToolRegistry) Register(tool Tool, handler ToolHandler) error {
+	if tool.Name == "" {
+		return fmt.Errorf("tool name is required")
+	}
+	if handler == nil {
+		return fmt.Errorf("tool %s has no handler", tool.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tools[tool.Name]; ok {
+		return fmt.Errorf("tool %s is already registered", tool.Name)
+	}
+
+	r.tools[tool.Name] = tool
+	r.handlers[tool.Name] = handler
+	return nil
+}
+
+// Tools returns the registered tools sorted by name, nil when there are none
+func (r *ToolRegistry) Tools() []Tool {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.tools) == 0 {
+		return nil
+	}
+
+	tools := make([]Tool, 0, len(r.tools))
+	for _, tool := range r.tools {
+		tools = append(tools, tool)
+	}
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name < tools[j].Name
+	})
+	return tools
+}
+
+// Call runs the handler of the named tool
+func (r *ToolRegistry) Call(ctx context.Context, caller ToolCaller, name string, arguments json.RawMessage) (string, error) {
+	r.mu.RLock()
+	handler, ok := r.handlers[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("unknown tool: %s", name)
+	}
+
+	return handler(ctx, caller, arguments)
+}
@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedToolService answers ChatWithTools with the scripted responses in order, the other methods are not used
+type scriptedToolService struct {
+	Service
+	responses []*ChatResponse
+	// requests holds the messages and the parameters of every request
+	requests []scriptedToolRequest
+}
+
+type scriptedToolRequest struct {
+	messages []*domain.ChatItem
+	params   *ChatParameters
+}
+
+func (s *scriptedToolService) ChatWithTools(ctx context.Context, messages []*domain.ChatItem, systemPrompt string, handler StreamHandler, options ...ChatOption) (*ChatResponse, error) {
+	params := &ChatParameters{}
+	for _, option := range options {
+		option(params)
+	}
+	s.requests = append(s.requests, scriptedToolRequest{messages: messages, params: params})
+
+	response := s.responses[0]
+	if len(s.responses) > 1 {
+		s.responses = s.responses[1:]
+	}
+	if handler != nil && response.Response != "" {
+		if err := handler(response.Response); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+func TestToolRegistry(t *testing.T) {
+	registry := NewToolRegistry()
+	echo := func(ctx context.Context, caller ToolCaller, arguments json.RawMessage) (string, error) {
+		return caller.AccountID + ":" + string(arguments), nil
+	}
+
+	require.NoError(t, registry.Register(Tool{Name: "second"}, echo))
+	require.NoError(t, registry.Register(Tool{Name: "first"}, echo))
+	assert.Error(t, registry.Register(Tool{Name: "first"}, echo), "names are unique")
+	assert.Error(t, registry.Register(Tool{}, echo), "a name is required")
+	assert.Error(t, registry.Register(Tool{Name: "third"}, nil), "a handler is required")
+
+	tools := registry.Tools()
+	require.Len(t, tools, 2)
+	assert.Equal(t, "first", tools[0].Name)
+	assert.Equal(t, "second", tools[1].Name)
+
+	result, err := registry.Call(context.Background(), ToolCaller{AccountID: "acc"}, "first", json.RawMessage(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, "acc:{}", result)
+
+	_, err = registry.Call(context.Background(), ToolCaller{}, "missing", nil)
+	assert.Error(t, err)
+
+	var empty *ToolRegistry
+	assert.Nil(t, empty.Tools())
+}
+
+func TestChatServiceRunsTools(t *testing.T) {
+	registry := NewToolRegistry()
+	var calls []string
+	require.NoError(t, registry.Register(Tool{Name: "get_recent_results"}, func(ctx context.Context, caller ToolCaller, arguments json.RawMessage) (string, error) {
+		calls = append(calls, caller.AccountID+" "+string(arguments))
+		return `{"correct":3,"total":4}`, nil
+	}))
+	require.NoError(t, registry.Register(Tool{Name: "broken"}, func(ctx context.Context, caller ToolCaller, arguments json.RawMessage) (string, error) {
+		return "", errors.New("learner not found")
+	}))
+
+	llmService := &scriptedToolService{responses: []*ChatResponse{
+		{
+			Response: "Let me check. ",
+			ToolCalls: []domain.ToolCall{
+				{ID: "call_1", Name: "get_recent_results", Arguments: json.RawMessage(`{"learner":"Mia"}`)},
+				{ID: "call_2", Name: "broken", Arguments: json.RawMessage(`{}`)},
+			},
+			Usage: &domain.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, Cost: 0.1},
+		},
+		{
+			Response: "Mia got 3 of 4 right.",
+			Usage:    &domain.Usage{LlmModelName: "model", PromptTokens: 20, CompletionTokens: 5, TotalTokens: 25, Cost: 0.2},
+		},
+	}}
+	s := &chatService{llmService: llmService, tools: registry}
+
+	history := []*domain.ChatItem{{Role: domain.ChatItemRoleUser, Content: "How is Mia doing?"}}
+	var streamed string
+	response, usage, err := s.chatWithTools(context.Background(), history, "", registry.Tools(), ToolCaller{AccountID: "acc"}, func(chunk string) error {
+		streamed += chunk
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Let me check. Mia got 3 of 4 right.", response)
+	assert.Equal(t, response, streamed)
+	assert.Equal(t, []string{`acc {"learner":"Mia"}`}, calls)
+	assert.Equal(t, 40, usage.TotalTokens)
+	assert.InDelta(t, 0.3, usage.Cost, 1e-9)
+	assert.Equal(t, "model", usage.LlmModelName)
+	assert.Len(t, history, 1, "the tool exchanges are not added to the chat history")
+
+	require.Len(t, llmService.requests, 2)
+	second := llmService.requests[1].messages
+	require.Len(t, second, 4)
+	assert.Equal(t, domain.ChatItemRoleAssistant, second[1].Role)
+	assert.Len(t, second[1].ToolCalls, 2)
+	assert.Equal(t, domain.ChatItemRoleTool, second[2].Role)
+	assert.Equal(t, "call_1", second[2].ToolCallID)
+	assert.Equal(t, `{"correct":3,"total":4}`, second[2].Content)
+	assert.Equal(t, "call_2", second[3].ToolCallID)
+	assert.Contains(t, second[3].Content, "learner not found", "failing tools are reported to the model")
+}
+
+func TestChatServiceStopsOfferingToolsAfterMaxRounds(t *testing.T) {
+	registry := NewToolRegistry()
+	require.NoError(t, registry.Register(Tool{Name: "loop"}, func(ctx context.Context, caller ToolCaller, arguments json.RawMessage) (string, error) {
+		return "again", nil
+	}))
+
+	llmService := &scriptedToolService{responses: []*ChatResponse{
+		{ToolCalls: []domain.ToolCall{{ID: "call_0", Name: "loop", Arguments: json.RawMessage(`{}`)}}},
+	}}
+	s := &chatService{llmService: llmService, tools: registry}
+
+	_, _, err := s.chatWithTools(context.Background(), []*domain.ChatItem{{Role: domain.ChatItemRoleUser, Content: "Hi"}}, "", registry.Tools(), ToolCaller{}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, llmService.requests, maxToolRounds+1)
+	for _, request := range llmService.requests[:maxToolRounds] {
+		assert.Len(t, request.params.Tools, 1)
+	}
+	assert.Empty(t, llmService.requests[maxToolRounds].params.Tools, "the last request doesn't offer the tools")
+}
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// llmParametersCollections are the collections that can override the LLM sampling parameters
+var llmParametersCollections = []string{domain.CollectionPracticeTopics, domain.CollectionChats}
+
+func init() {
+	m.Register(func(app core.App) error {
+		for _, name := range llmParametersCollections {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+
+			// sampling parameters such as temperature and seed, see domain.SamplingParameters
+			if err := collection.Fields.AddMarshaledJSON([]byte(`{
+				"hidden": false,
+				"id": "llm_parameters_column",
+				"maxSize": 2000,
+				"name": "llm_parameters",
+				"presentable": false,
+				"required": false,
+				"system": false,
+				"type": "json"
+			}`)); err != nil {
+				return err
+			}
+
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		for _, name := range llmParametersCollections {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+
+			collection.Fields.RemoveById("llm_parameters_column")
+
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := fmt.Sprintf(`{
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "id_column",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "account_column",
+					"name": "account",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation",
+					"collectionId": "pbc_%s",
+					"cascadeDelete": true,
+					"maxSelect": 1
+				},
+				{
+					"hidden": false,
+					"id": "prompt_tokens_column",
+					"max": null,
+					"min": 0,
+					"name": "prompt_tokens",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "completion_tokens_column",
+					"max": null,
+					"min": 0,
+					"name": "completion_tokens",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "total_tokens_column",
+					"max": null,
+					"min": 0,
+					"name": "total_tokens",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "cost_column",
+					"max": null,
+					"min": 0,
+					"name": "cost",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "created_column",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "updated_column",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_%s",
+			"indexes": [
+				"CREATE INDEX `+"`"+`idx_llm_usage_account_created`+"`"+` ON `+"`"+`%s`+"`"+` (`+"`"+`account`+"`"+`, `+"`"+`created`+"`"+`)"
+			],
+			"name": "%s",
+			"system": false,
+			"type": "base",
+			"createRule": null,
+			"deleteRule": null,
+			"listRule": "@request.auth.id = account.owner",
+			"updateRule": null,
+			"viewRule": "@request.auth.id = account.owner"
+		}`, domain.CollectionAccounts, domain.CollectionLLMUsage, domain.CollectionLLMUsage, domain.CollectionLLMUsage)
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionLLMUsage)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// llmBudgetFields are the LLM budgets of an account, zero means unlimited, see llm.Budget.
+// Token budgets are whole numbers, cost budgets are in the currency of the platform prices.
+var llmBudgetFields = []struct {
+	name    string
+	onlyInt bool
+}{
+	{name: "llm_daily_token_budget", onlyInt: true},
+	{name: "llm_monthly_token_budget", onlyInt: true},
+	{name: "llm_daily_cost_budget", onlyInt: false},
+	{name: "llm_monthly_cost_budget", onlyInt: false},
+}
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+		if err != nil {
+			return err
+		}
+
+		for _, field := range llmBudgetFields {
+			if err := collection.Fields.AddMarshaledJSON([]byte(fmt.Sprintf(`{
+				"hidden": false,
+				"id": "%s_column",
+				"max": null,
+				"min": 0,
+				"name": "%s",
+				"onlyInt": %t,
+				"presentable": false,
+				"required": false,
+				"system": false,
+				"type": "number"
+			}`, field.name, field.name, field.onlyInt))); err != nil {
+				return err
+			}
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+		if err != nil {
+			return err
+		}
+
+		for _, field := range llmBudgetFields {
+			collection.Fields.RemoveById(field.name + "_column")
+		}
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,141 @@
+package migrations
+
+import (
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// llmUsageLedgerFields turn the usage collection into a ledger with one row per LLM request
+var llmUsageLedgerFields = []string{
+	// user is the id of the user making the request, a text field since superusers make requests too
+	`{
+		"autogeneratePattern": "",
+		"hidden": false,
+		"id": "user_column",
+		"max": 15,
+		"min": 0,
+		"name": "user",
+		"pattern": "",
+		"presentable": false,
+		"primaryKey": false,
+		"required": false,
+		"system": false,
+		"type": "text"
+	}`,
+	// purpose of the request such as chat or session-generation, see llm.Purpose
+	`{
+		"autogeneratePattern": "",
+		"hidden": false,
+		"id": "purpose_column",
+		"max": 50,
+		"min": 0,
+		"name": "purpose",
+		"pattern": "",
+		"presentable": false,
+		"primaryKey": false,
+		"required": false,
+		"system": false,
+		"type": "text"
+	}`,
+	`{
+		"autogeneratePattern": "",
+		"hidden": false,
+		"id": "platform_column",
+		"max": 50,
+		"min": 0,
+		"name": "platform",
+		"pattern": "",
+		"presentable": false,
+		"primaryKey": false,
+		"required": false,
+		"system": false,
+		"type": "text"
+	}`,
+	`{
+		"autogeneratePattern": "",
+		"hidden": false,
+		"id": "model_column",
+		"max": 2000,
+		"min": 0,
+		"name": "model",
+		"pattern": "",
+		"presentable": false,
+		"primaryKey": false,
+		"required": false,
+		"system": false,
+		"type": "text"
+	}`,
+	`{
+		"hidden": false,
+		"id": "latency_ms_column",
+		"max": null,
+		"min": 0,
+		"name": "latency_ms",
+		"onlyInt": true,
+		"presentable": false,
+		"required": false,
+		"system": false,
+		"type": "number"
+	}`,
+	`{
+		"hidden": false,
+		"id": "cache_hit_column",
+		"name": "cache_hit",
+		"presentable": false,
+		"required": false,
+		"system": false,
+		"type": "bool"
+	}`,
+	`{
+		"autogeneratePattern": "",
+		"hidden": false,
+		"id": "error_column",
+		"max": 2000,
+		"min": 0,
+		"name": "error",
+		"pattern": "",
+		"presentable": false,
+		"primaryKey": false,
+		"required": false,
+		"system": false,
+		"type": "text"
+	}`,
+}
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionLLMUsage)
+		if err != nil {
+			return err
+		}
+
+		for _, field := range llmUsageLedgerFields {
+			if err := collection.Fields.AddMarshaledJSON([]byte(field)); err != nil {
+				return err
+			}
+		}
+
+		// requests that aren't made for an account are recorded as well
+		if account, ok := collection.Fields.GetById("account_column").(*core.RelationField); ok {
+			account.Required = false
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionLLMUsage)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range []string{"user_column", "purpose_column", "platform_column", "model_column", "latency_ms_column", "cache_hit_column", "error_column"} {
+			collection.Fields.RemoveById(id)
+		}
+
+		if account, ok := collection.Fields.GetById("account_column").(*core.RelationField); ok {
+			account.Required = true
+		}
+
+		return app.Save(collection)
+	})
+}
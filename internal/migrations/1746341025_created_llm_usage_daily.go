@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"id": "pbc_llm_usage_daily",
+			"indexes": [],
+			"name": "llm_usage_daily",
+			"system": false,
+			"type": "view",
+			"createRule": null,
+			"deleteRule": null,
+			"listRule": "@request.auth.id = account.owner",
+			"updateRule": null,
+			"viewRule": "@request.auth.id = account.owner",
+			"viewQuery": "SELECT\n    (u.account || '_' || strftime('%Y%m%d', u.created)) as id,\n    u.account as account,\n    strftime('%Y-%m-%d', u.created) as day,\n    COUNT(u.id) as requests,\n    SUM(CASE WHEN u.error != '' THEN 1 ELSE 0 END) as errors,\n    SUM(CASE WHEN u.cache_hit THEN 1 ELSE 0 END) as cache_hits,\n    SUM(u.prompt_tokens) as prompt_tokens,\n    SUM(u.completion_tokens) as completion_tokens,\n    SUM(u.total_tokens) as total_tokens,\n    SUM(u.cost) as cost,\n    CAST(AVG(u.latency_ms) AS INTEGER) as avg_latency_ms\nFROM llm_usage u\nWHERE u.account != ''\nGROUP BY u.account, strftime('%Y-%m-%d', u.created)"
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_llm_usage_daily")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
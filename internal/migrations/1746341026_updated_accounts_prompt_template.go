@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// The practice session prompt template of an account replaces the embedded default template, see practice.SessionPromptData
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+		if err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "practice_session_prompt_template_column",
+			"max": 40000,
+			"name": "practice_session_prompt_template",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveById("practice_session_prompt_template_column")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,141 @@
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Prompt versions of an account take turns generating practice sessions, the weight of a version is its share of the generations
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := fmt.Sprintf(`{
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "id_column",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "account_column",
+					"name": "account",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation",
+					"collectionId": "pbc_%s",
+					"cascadeDelete": true,
+					"maxSelect": 1
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "name_column",
+					"max": 200,
+					"name": "name",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "template_column",
+					"max": 40000,
+					"name": "template",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "instructions_column",
+					"max": 40000,
+					"name": "instructions",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "weight_column",
+					"max": 100,
+					"min": 0,
+					"name": "weight",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "created_column",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "updated_column",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_%s",
+			"indexes": [],
+			"name": "%s",
+			"system": false,
+			"type": "base",
+			"createRule": "@request.auth.id = account.owner",
+			"deleteRule": "@request.auth.id = account.owner",
+			"listRule": "@request.auth.id = account.owner",
+			"updateRule": "@request.auth.id = account.owner",
+			"viewRule": "@request.auth.id = account.owner"
+		}`, domain.CollectionAccounts, domain.CollectionPromptVersions, domain.CollectionPromptVersions)
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPromptVersions)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,141 @@
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Prompt generations record every practice session generation made with a prompt version,
+// including the failed ones, so that the parse failures of the versions can be compared
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := fmt.Sprintf(`{
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "id_column",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "account_column",
+					"name": "account",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation",
+					"collectionId": "pbc_%s",
+					"cascadeDelete": true,
+					"maxSelect": 1
+				},
+				{
+					"hidden": false,
+					"id": "prompt_version_column",
+					"name": "prompt_version",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation",
+					"collectionId": "pbc_%s",
+					"cascadeDelete": true,
+					"maxSelect": 1
+				},
+				{
+					"hidden": false,
+					"id": "practice_session_column",
+					"name": "practice_session",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "relation",
+					"collectionId": "pbc_%s",
+					"cascadeDelete": false,
+					"maxSelect": 1
+				},
+				{
+					"hidden": false,
+					"id": "responses_column",
+					"max": null,
+					"min": 0,
+					"name": "responses",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "parse_failures_column",
+					"max": null,
+					"min": 0,
+					"name": "parse_failures",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "created_column",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "updated_column",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_%s",
+			"indexes": [
+				"CREATE INDEX `+"`"+`idx_prompt_generations_prompt_version`+"`"+` ON `+"`"+`%s`+"`"+` (`+"`"+`prompt_version`+"`"+`)"
+			],
+			"name": "%s",
+			"system": false,
+			"type": "base",
+			"createRule": null,
+			"deleteRule": null,
+			"listRule": "@request.auth.id = account.owner",
+			"updateRule": null,
+			"viewRule": "@request.auth.id = account.owner"
+		}`, domain.CollectionAccounts, domain.CollectionPromptVersions, domain.CollectionPracticeSessions, domain.CollectionPromptGenerations, domain.CollectionPromptGenerations, domain.CollectionPromptGenerations)
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPromptGenerations)
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// The prompt version a practice session was generated with, empty when the account prompt was used
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeSessions)
+		if err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(fmt.Sprintf(`{
+			"hidden": false,
+			"id": "prompt_version_column",
+			"name": "prompt_version",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation",
+			"collectionId": "pbc_%s",
+			"cascadeDelete": false,
+			"maxSelect": 1
+		}`, domain.CollectionPromptVersions))); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeSessions)
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveById("prompt_version_column")
+
+		return app.Save(collection)
+	})
+}
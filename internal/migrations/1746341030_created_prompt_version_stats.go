@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Prompt version stats compare the prompt versions of an account:
+//   - parse_failure_rate is the share of the LLM responses that weren't valid practice items
+//   - approval_rate is the share of the reviewed practice items the parent approved
+//   - accuracy is the share of the learners' answers that were correct
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"id": "pbc_prompt_version_stats",
+			"indexes": [],
+			"name": "prompt_version_stats",
+			"system": false,
+			"type": "view",
+			"createRule": null,
+			"deleteRule": null,
+			"listRule": "@request.auth.id = account.owner",
+			"updateRule": null,
+			"viewRule": "@request.auth.id = account.owner",
+			"viewQuery": "SELECT\n    pv.id as id,\n    pv.account as account,\n    pv.name as name,\n    pv.weight as weight,\n    COALESCE(g.generations, 0) as generations,\n    COALESCE(g.responses, 0) as responses,\n    COALESCE(g.parse_failures, 0) as parse_failures,\n    (CASE WHEN g.responses > 0 THEN ROUND(CAST(g.parse_failures AS FLOAT) / g.responses, 4) ELSE 0 END) as parse_failure_rate,\n    COALESCE(i.total_items, 0) as total_items,\n    COALESCE(i.reviewed_items, 0) as reviewed_items,\n    COALESCE(i.approved_items, 0) as approved_items,\n    (CASE WHEN i.reviewed_items > 0 THEN ROUND(CAST(i.approved_items AS FLOAT) / i.reviewed_items, 4) ELSE 0 END) as approval_rate,\n    COALESCE(r.answers, 0) as answers,\n    COALESCE(r.correct_answers, 0) as correct_answers,\n    (CASE WHEN r.answers > 0 THEN ROUND(CAST(r.correct_answers AS FLOAT) / r.answers, 4) ELSE 0 END) as accuracy\nFROM prompt_versions pv\nLEFT JOIN (\n    SELECT prompt_version, COUNT(id) as generations, SUM(responses) as responses, SUM(parse_failures) as parse_failures\n    FROM prompt_generations\n    GROUP BY prompt_version\n) g ON g.prompt_version = pv.id\nLEFT JOIN (\n    SELECT ps.prompt_version as prompt_version,\n        COUNT(pi.id) as total_items,\n        SUM(CASE WHEN pi.status NOT IN ('', 'Generated') OR pi.review_status != '' THEN 1 ELSE 0 END) as reviewed_items,\n        SUM(CASE WHEN pi.status = 'Approved' OR pi.review_status = 'APPROVED' THEN 1 ELSE 0 END) as approved_items\n    FROM practice_sessions ps\n    JOIN practice_items pi ON pi.id IN (SELECT value FROM json_each(ps.practice_items))\n    WHERE ps.prompt_version != ''\n    GROUP BY ps.prompt_version\n) i ON i.prompt_version = pv.id\nLEFT JOIN (\n    SELECT ps.prompt_version as prompt_version,\n        COUNT(pr.id) as answers,\n        SUM(CASE WHEN pr.is_correct THEN 1 ELSE 0 END) as correct_answers\n    FROM practice_sessions ps\n    JOIN practice_results pr ON pr.practice_session = ps.id\n    WHERE ps.prompt_version != ''\n    GROUP BY ps.prompt_version\n) r ON r.prompt_version = pv.id"
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_prompt_version_stats")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
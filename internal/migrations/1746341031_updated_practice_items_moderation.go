@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// The reason the moderation flagged a generated practice item, flagged items wait for a parent review
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeItems)
+		if err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "moderation_reason_column",
+			"max": 2000,
+			"name": "moderation_reason",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeItems)
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveById("moderation_reason_column")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// The content of a chat item the moderation flagged is withheld in flagged_content for a parent review,
+// the content tells the learner the answer waits for the review
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionChatItems)
+		if err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "moderation_reason_column",
+			"max": 2000,
+			"name": "moderation_reason",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "flagged_content_column",
+			"max": 200000,
+			"name": "flagged_content",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionChatItems)
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveById("moderation_reason_column")
+		collection.Fields.RemoveById("flagged_content_column")
+
+		return app.Save(collection)
+	})
+}
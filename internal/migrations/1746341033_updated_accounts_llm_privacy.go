@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// The privacy settings of an account, see llm.accountPrivacy. The learner data of an account with llm_local_only
+// never goes to cloud LLM platforms, llm_redacted_identifiers are redacted besides the names, one per line.
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+		if err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(`{
+			"hidden": false,
+			"id": "llm_local_only_column",
+			"name": "llm_local_only",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "bool"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "llm_redacted_identifiers_column",
+			"max": 2000,
+			"name": "llm_redacted_identifiers",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveById("llm_local_only_column")
+		collection.Fields.RemoveById("llm_redacted_identifiers_column")
+
+		return app.Save(collection)
+	})
+}
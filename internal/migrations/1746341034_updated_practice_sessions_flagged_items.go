@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// The practice items flagged by the moderation are kept out of the practice items of the session, which learners read,
+// until a parent approves them. A session may have no practice items while all of its items wait for the review.
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeSessions)
+		if err != nil {
+			return err
+		}
+
+		if err := collection.Fields.AddMarshaledJSON([]byte(fmt.Sprintf(`{
+			"hidden": false,
+			"id": "flagged_items_column",
+			"name": "flagged_items",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "relation",
+			"collectionId": "pbc_%s",
+			"cascadeDelete": false,
+			"maxSelect": 999,
+			"minSelect": 0
+		}`, domain.CollectionPracticeItems))); err != nil {
+			return err
+		}
+
+		practiceItems, ok := collection.Fields.GetById("practice_items_column").(*core.RelationField)
+		if !ok {
+			return fmt.Errorf("practice_items of %s is not a relation", domain.CollectionPracticeSessions)
+		}
+		practiceItems.Required = false
+		practiceItems.MinSelect = 0
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeSessions)
+		if err != nil {
+			return err
+		}
+
+		collection.Fields.RemoveById("flagged_items_column")
+		if practiceItems, ok := collection.Fields.GetById("practice_items_column").(*core.RelationField); ok {
+			practiceItems.Required = true
+			practiceItems.MinSelect = 1
+		}
+
+		return app.Save(collection)
+	})
+}
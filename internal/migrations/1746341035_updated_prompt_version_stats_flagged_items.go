@@ -0,0 +1,121 @@
+package migrations
+
+import (
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Prompt version stats count the flagged items of the sessions. The items flagged by the moderation wait for a parent
+// review with review_status NEED_EDIT, they are reported in flagged_items and aren't reviewed items until a parent
+// approves or ignores them.
+func init() {
+	m.Register(func(app core.App) error {
+		return updatePromptVersionStatsQuery(app, promptVersionStatsQuery)
+	}, func(app core.App) error {
+		return updatePromptVersionStatsQuery(app, promptVersionStatsQueryWithoutFlaggedItems)
+	})
+}
+
+// updatePromptVersionStatsQuery replaces the query of the prompt version stats view
+func updatePromptVersionStatsQuery(app core.App, query string) error {
+	collection, err := app.FindCollectionByNameOrId(domain.CollectionPromptVersionStats)
+	if err != nil {
+		return err
+	}
+
+	collection.ViewQuery = query
+	return app.Save(collection)
+}
+
+const (
+	promptVersionStatsQuery = `SELECT
+    pv.id as id,
+    pv.account as account,
+    pv.name as name,
+    pv.weight as weight,
+    COALESCE(g.generations, 0) as generations,
+    COALESCE(g.responses, 0) as responses,
+    COALESCE(g.parse_failures, 0) as parse_failures,
+    (CASE WHEN g.responses > 0 THEN ROUND(CAST(g.parse_failures AS FLOAT) / g.responses, 4) ELSE 0 END) as parse_failure_rate,
+    COALESCE(i.total_items, 0) as total_items,
+    COALESCE(i.reviewed_items, 0) as reviewed_items,
+    COALESCE(i.approved_items, 0) as approved_items,
+    COALESCE(i.flagged_items, 0) as flagged_items,
+    (CASE WHEN i.reviewed_items > 0 THEN ROUND(CAST(i.approved_items AS FLOAT) / i.reviewed_items, 4) ELSE 0 END) as approval_rate,
+    COALESCE(r.answers, 0) as answers,
+    COALESCE(r.correct_answers, 0) as correct_answers,
+    (CASE WHEN r.answers > 0 THEN ROUND(CAST(r.correct_answers AS FLOAT) / r.answers, 4) ELSE 0 END) as accuracy
+FROM prompt_versions pv
+LEFT JOIN (
+    SELECT prompt_version, COUNT(id) as generations, SUM(responses) as responses, SUM(parse_failures) as parse_failures
+    FROM prompt_generations
+    GROUP BY prompt_version
+) g ON g.prompt_version = pv.id
+LEFT JOIN (
+    SELECT ps.prompt_version as prompt_version,
+        COUNT(pi.id) as total_items,
+        SUM(CASE WHEN pi.status = 'Flagged' AND pi.review_status IN ('', 'NEED_EDIT') THEN 0
+            WHEN pi.status NOT IN ('', 'Generated') OR pi.review_status != '' THEN 1 ELSE 0 END) as reviewed_items,
+        SUM(CASE WHEN pi.status = 'Approved' OR pi.review_status = 'APPROVED' THEN 1 ELSE 0 END) as approved_items,
+        SUM(CASE WHEN pi.status = 'Flagged' THEN 1 ELSE 0 END) as flagged_items
+    FROM practice_sessions ps
+    JOIN practice_items pi ON pi.id IN (
+        SELECT value FROM json_each(ps.practice_items)
+        UNION SELECT value FROM json_each(COALESCE(NULLIF(ps.flagged_items, ''), '[]'))
+    )
+    WHERE ps.prompt_version != ''
+    GROUP BY ps.prompt_version
+) i ON i.prompt_version = pv.id
+LEFT JOIN (
+    SELECT ps.prompt_version as prompt_version,
+        COUNT(pr.id) as answers,
+        SUM(CASE WHEN pr.is_correct THEN 1 ELSE 0 END) as correct_answers
+    FROM practice_sessions ps
+    JOIN practice_results pr ON pr.practice_session = ps.id
+    WHERE ps.prompt_version != ''
+    GROUP BY ps.prompt_version
+) r ON r.prompt_version = pv.id`
+
+	promptVersionStatsQueryWithoutFlaggedItems = `SELECT
+    pv.id as id,
+    pv.account as account,
+    pv.name as name,
+    pv.weight as weight,
+    COALESCE(g.generations, 0) as generations,
+    COALESCE(g.responses, 0) as responses,
+    COALESCE(g.parse_failures, 0) as parse_failures,
+    (CASE WHEN g.responses > 0 THEN ROUND(CAST(g.parse_failures AS FLOAT) / g.responses, 4) ELSE 0 END) as parse_failure_rate,
+    COALESCE(i.total_items, 0) as total_items,
+    COALESCE(i.reviewed_items, 0) as reviewed_items,
+    COALESCE(i.approved_items, 0) as approved_items,
+    (CASE WHEN i.reviewed_items > 0 THEN ROUND(CAST(i.approved_items AS FLOAT) / i.reviewed_items, 4) ELSE 0 END) as approval_rate,
+    COALESCE(r.answers, 0) as answers,
+    COALESCE(r.correct_answers, 0) as correct_answers,
+    (CASE WHEN r.answers > 0 THEN ROUND(CAST(r.correct_answers AS FLOAT) / r.answers, 4) ELSE 0 END) as accuracy
+FROM prompt_versions pv
+LEFT JOIN (
+    SELECT prompt_version, COUNT(id) as generations, SUM(responses) as responses, SUM(parse_failures) as parse_failures
+    FROM prompt_generations
+    GROUP BY prompt_version
+) g ON g.prompt_version = pv.id
+LEFT JOIN (
+    SELECT ps.prompt_version as prompt_version,
+        COUNT(pi.id) as total_items,
+        SUM(CASE WHEN pi.status NOT IN ('', 'Generated') OR pi.review_status != '' THEN 1 ELSE 0 END) as reviewed_items,
+        SUM(CASE WHEN pi.status = 'Approved' OR pi.review_status = 'APPROVED' THEN 1 ELSE 0 END) as approved_items
+    FROM practice_sessions ps
+    JOIN practice_items pi ON pi.id IN (SELECT value FROM json_each(ps.practice_items))
+    WHERE ps.prompt_version != ''
+    GROUP BY ps.prompt_version
+) i ON i.prompt_version = pv.id
+LEFT JOIN (
+    SELECT ps.prompt_version as prompt_version,
+        COUNT(pr.id) as answers,
+        SUM(CASE WHEN pr.is_correct THEN 1 ELSE 0 END) as correct_answers
+    FROM practice_sessions ps
+    JOIN practice_results pr ON pr.practice_session = ps.id
+    WHERE ps.prompt_version != ''
+    GROUP BY ps.prompt_version
+) r ON r.prompt_version = pv.id`
+)
@@ -4,10 +4,13 @@ package chat
 // For other operations such as update, search, list, etc., use the standard PocketBase collection API.
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/busybytelab.com/glimmer/internal/route/sse"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/rs/zerolog/log"
@@ -20,6 +23,26 @@ type (
 		UserMessage  string `json:"userMessage" form:"userMessage"`
 		SystemPrompt string `json:"systemPrompt" form:"systemPrompt"`
 		Model        string `json:"model" form:"model"`
+		// Stream requests the response as Server-Sent Events, same as sending "Accept: text/event-stream"
+		Stream bool `json:"stream" form:"stream"`
+	}
+
+	// ChatStreamChunk is the payload of a "chunk" event when streaming the response
+	ChatStreamChunk struct {
+		Delta string `json:"delta"`
+	}
+
+	// ChatStreamQueue is the payload of a "queue" event, sent while the request waits for a busy LLM platform.
+	// Position 1 is the next request served, 0 means the request left the queue and the answer is being generated.
+	ChatStreamQueue struct {
+		Position int `json:"position"`
+	}
+
+	// ChatStreamError is the payload of an "error" event when streaming the response
+	ChatStreamError struct {
+		Message string `json:"message"`
+		// Status is the HTTP status the non-streaming endpoint would respond with
+		Status int `json:"status"`
 	}
 
 	// ChatResponse defines the response body for the chat endpoint
@@ -38,6 +61,9 @@ type (
 	}
 )
 
+// blockedMessage is the error message of an answer the moderation blocked, the reason is only logged
+const blockedMessage = "The answer was blocked by the content moderation"
+
 func New(chatService llm.ChatService) ChatRoutes {
 	return &chatRoutes{
 		chatService: chatService,
@@ -100,15 +126,29 @@ func (r *chatRoutes) HandleChatRequest(e *core.RequestEvent) error {
 		}
 	}
 
-	// Add options from the request if provided
-	var opts []llm.ChatOption
+	// Add options from the account and the request if provided
+	opts := accountChatOptions(e, userID)
 	if req.Model != "" {
 		opts = append(opts, llm.WithModel(req.Model))
 	}
 
+	if req.Stream || strings.Contains(e.Request.Header.Get("Accept"), "text/event-stream") {
+		return r.streamChatCompletion(e, chatID, req.UserMessage, opts)
+	}
+
 	// Process chat request
-	response, usage, err := r.chatService.ChatCompletion(chatID, req.UserMessage, opts...)
+	response, usage, err := r.chatService.ChatCompletion(e.Request.Context(), chatID, req.UserMessage, opts...)
 	if err != nil {
+		var budgetErr *llm.BudgetExceededError
+		if errors.As(err, &budgetErr) {
+			return e.TooManyRequestsError(budgetErr.Error(), nil)
+		}
+		if errors.Is(err, llm.ErrContentBlocked) {
+			return e.Error(http.StatusUnprocessableEntity, blockedMessage, nil)
+		}
+		if errors.Is(err, llm.ErrModelCapability) {
+			return e.BadRequestError(err.Error(), nil)
+		}
 		log.Error().Err(err).Msg("Failed to process chat request")
 		return e.InternalServerError("Failed to process chat request", err)
 	}
@@ -126,3 +166,65 @@ func (r *chatRoutes) HandleChatRequest(e *core.RequestEvent) error {
 		Chat:     chat,
 	})
 }
+
+// streamChatCompletion runs the chat completion and writes the response as Server-Sent Events.
+// Every generated chunk is sent as a "chunk" event, followed by a single "done" event carrying
+// the same payload as the non-streaming endpoint. Failures after the stream started are reported
+// as an "error" event. While the LLM platform is busy, "queue" events report the position of the request.
+// The response of a "done" event flagged by the moderation is llm.FlaggedChatContent, it replaces
+// the chunks streamed before.
+func (r *chatRoutes) streamChatCompletion(e *core.RequestEvent, chatID, userMessage string, opts []llm.ChatOption) error {
+	sse.Start(e)
+
+	handler := func(chunk string) error {
+		return sse.WriteEvent(e, "chunk", ChatStreamChunk{Delta: chunk})
+	}
+
+	// The queue position is only informative, a client gone away is noticed by the stream
+	opts = append(opts, llm.WithQueueHandler(func(position int) {
+		if err := sse.WriteEvent(e, "queue", ChatStreamQueue{Position: position}); err != nil {
+			log.Debug().Err(err).Str("chatID", chatID).Msg("Failed to write queue event")
+		}
+	}))
+
+	response, usage, err := r.chatService.ChatCompletionStream(e.Request.Context(), chatID, userMessage, handler, opts...)
+	if err != nil {
+		// The stream has already started, so an exceeded budget is reported with its status in the error event
+		var budgetErr *llm.BudgetExceededError
+		if errors.As(err, &budgetErr) {
+			return sse.WriteEvent(e, "error", ChatStreamError{Message: budgetErr.Error(), Status: http.StatusTooManyRequests})
+		}
+		if errors.Is(err, llm.ErrContentBlocked) {
+			return sse.WriteEvent(e, "error", ChatStreamError{Message: blockedMessage, Status: http.StatusUnprocessableEntity})
+		}
+		if errors.Is(err, llm.ErrModelCapability) {
+			return sse.WriteEvent(e, "error", ChatStreamError{Message: err.Error(), Status: http.StatusBadRequest})
+		}
+		log.Error().Err(err).Str("chatID", chatID).Msg("Failed to process streaming chat request")
+		return sse.WriteEvent(e, "error", ChatStreamError{Message: "Failed to process chat request", Status: http.StatusInternalServerError})
+	}
+
+	// Get the updated chat (with latest messages)
+	chat, err := r.chatService.GetChat(chatID)
+	if err != nil {
+		log.Warn().Err(err).Str("chatID", chatID).Msg("Failed to get updated chat")
+	}
+
+	return sse.WriteEvent(e, "done", ChatResponse{
+		Response: response,
+		Usage:    usage,
+		Chat:     chat,
+	})
+}
+
+// accountChatOptions returns the chat options for the LLM settings of the user's account.
+// When the account can't be loaded the global LLM config is used.
+func accountChatOptions(e *core.RequestEvent, userID string) []llm.ChatOption {
+	settings, err := llm.FindAccountSettingsByOwner(e.App, userID)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Failed to load account LLM settings, using global config")
+		return nil
+	}
+
+	return settings.ChatOptions()
+}
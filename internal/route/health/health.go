@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/health"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type (
+	// LiveResponse defines the response body of the liveness endpoint
+	LiveResponse struct {
+		Status health.Status `json:"status"`
+	}
+
+	HealthRoutes interface {
+		HandleLiveRequest(e *core.RequestEvent) error
+		HandleReadyRequest(e *core.RequestEvent) error
+	}
+
+	healthRoutes struct {
+		checker *health.Checker
+		// mutex guards the last report, the probes waiting for a running check get its report
+		mutex     sync.Mutex
+		report    *health.Report
+		checkedAt time.Time
+	}
+)
+
+// reportTTL is how long the report of the readiness checks is reused. The endpoint is public,
+// so frequent probes don't query the database and the LLM platforms every time.
+const reportTTL = 15 * time.Second
+
+// New creates the health routes, they are public for Docker and uptime monitors
+func New(checker *health.Checker) HealthRoutes {
+	return &healthRoutes{
+		checker: checker,
+	}
+}
+
+// HandleLiveRequest reports that Glimmer is running, without checking its dependencies
+func (r *healthRoutes) HandleLiveRequest(e *core.RequestEvent) error {
+	return e.JSON(http.StatusOK, LiveResponse{Status: health.StatusOK})
+}
+
+// HandleReadyRequest checks the dependencies of Glimmer and responds with 503 when a check failed.
+// Only superusers get the messages of the checks, they may hold internal addresses.
+// The report is reused for reportTTL.
+func (r *healthRoutes) HandleReadyRequest(e *core.RequestEvent) error {
+	report := r.run(e.Request.Context())
+
+	status := http.StatusOK
+	if report.Status == health.StatusFail {
+		status = http.StatusServiceUnavailable
+	}
+
+	if !e.HasSuperuserAuth() {
+		report = report.Summary()
+	}
+	return e.JSON(status, report)
+}
+
+// run returns the last report when it is recent, otherwise it runs the checks. The checks aren't cancelled
+// with the request, the probes waiting for them get the report.
+func (r *healthRoutes) run(ctx context.Context) *health.Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.report == nil || time.Since(r.checkedAt) >= reportTTL {
+		r.report = r.checker.Run(context.WithoutCancel(ctx))
+		r.checkedAt = time.Now()
+	}
+	return r.report
+}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// CachePurgeRequest defines the request body of the cache purge endpoint, the criteria are combined.
+	// At least one criterion is required, or All to purge the whole cache.
+	CachePurgeRequest struct {
+		Model   string `json:"model" form:"model"`
+		Backend string `json:"backend" form:"backend"`
+		// OlderThan is a duration such as "24h", responses cached at least that long ago are purged
+		OlderThan string `json:"olderThan" form:"olderThan"`
+		Expired   bool   `json:"expired" form:"expired"`
+		All       bool   `json:"all" form:"all"`
+	}
+
+	// CachePurgeResponse defines the response body of the cache purge endpoint
+	CachePurgeResponse struct {
+		Purged int `json:"purged"`
+	}
+
+	CacheRoutes interface {
+		HandleCacheStatsRequest(e *core.RequestEvent) error
+		HandleCachePurgeRequest(e *core.RequestEvent) error
+	}
+
+	cacheRoutes struct {
+		cache llm.CacheStorage
+	}
+)
+
+// NewCacheRoutes creates the routes administrating the LLM cache, the cache is nil when disabled
+func NewCacheRoutes(cache llm.CacheStorage) CacheRoutes {
+	return &cacheRoutes{
+		cache: cache,
+	}
+}
+
+// HandleCacheStatsRequest reports the counters and the size of the LLM cache
+func (r *cacheRoutes) HandleCacheStatsRequest(e *core.RequestEvent) error {
+	admin, ok := r.cache.(llm.CacheAdmin)
+	if !ok {
+		return e.NotFoundError("LLM cache is disabled", nil)
+	}
+
+	stats, err := admin.Stats()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load LLM cache stats")
+		return e.InternalServerError("Failed to load LLM cache stats", err)
+	}
+
+	return e.JSON(http.StatusOK, stats)
+}
+
+// HandleCachePurgeRequest purges the cached LLM responses by model, backend or age,
+// e.g. to invalidate the cached generations after changing a prompt
+func (r *cacheRoutes) HandleCachePurgeRequest(e *core.RequestEvent) error {
+	admin, ok := r.cache.(llm.CacheAdmin)
+	if !ok {
+		return e.NotFoundError("LLM cache is disabled", nil)
+	}
+
+	var req CachePurgeRequest
+	if err := e.BindBody(&req); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+
+	filter := llm.CachePurgeFilter{
+		Model:       req.Model,
+		Backend:     req.Backend,
+		ExpiredOnly: req.Expired,
+	}
+	if req.OlderThan != "" {
+		olderThan, err := time.ParseDuration(req.OlderThan)
+		if err != nil || olderThan <= 0 {
+			return e.BadRequestError("olderThan must be a positive duration such as 24h", err)
+		}
+		filter.OlderThan = olderThan
+	}
+
+	if filter == (llm.CachePurgeFilter{}) && !req.All {
+		return e.BadRequestError("Set model, backend, olderThan or expired, or all to purge the whole cache", nil)
+	}
+
+	purged, err := admin.Purge(filter)
+	if err != nil {
+		log.Error().Err(err).Interface("filter", filter).Msg("Failed to purge LLM cache")
+		return e.InternalServerError("Failed to purge LLM cache", err)
+	}
+
+	log.Info().Interface("filter", filter).Int("purged", purged).Msg("LLM cache purged")
+
+	return e.JSON(http.StatusOK, CachePurgeResponse{Purged: purged})
+}
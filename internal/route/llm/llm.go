@@ -1,6 +1,7 @@
 package llm
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
@@ -26,6 +27,7 @@ type (
 	LLMRoutes interface {
 		HandleChatRequest(e *core.RequestEvent) error
 		HandleInfoRequest(e *core.RequestEvent) error
+		HandleBudgetRequest(e *core.RequestEvent) error
 	}
 
 	llmRoutes struct {
@@ -57,15 +59,25 @@ func (r *llmRoutes) HandleChatRequest(e *core.RequestEvent) error {
 		req.SystemPrompt = "You are a helpful assistant."
 	}
 
-	// Process chat request
+	// Process chat request with the account settings, the model from the request takes precedence
 	var opts []llm.ChatOption
+	if e.Auth != nil {
+		opts = append(accountChatOptions(e, e.Auth.Id), llm.WithUser(e.Auth.Id))
+	}
 	if req.Model != "" {
 		opts = append(opts, llm.WithModel(req.Model))
 	}
 
 	// Send chat request to LLM service
-	response, usage, err := r.llmService.Chat(req.Prompt, req.SystemPrompt, opts...)
+	response, usage, err := r.llmService.Chat(e.Request.Context(), req.Prompt, req.SystemPrompt, opts...)
 	if err != nil {
+		var budgetErr *llm.BudgetExceededError
+		if errors.As(err, &budgetErr) {
+			return e.TooManyRequestsError(budgetErr.Error(), nil)
+		}
+		if errors.Is(err, llm.ErrModelCapability) {
+			return e.BadRequestError(err.Error(), nil)
+		}
 		log.Error().Err(err).Msg("Failed to process LLM chat request")
 		return e.InternalServerError("Failed to process chat request", err)
 	}
@@ -81,7 +93,35 @@ func (r *llmRoutes) HandleChatRequest(e *core.RequestEvent) error {
 func (r *llmRoutes) HandleInfoRequest(e *core.RequestEvent) error {
 	log.Debug().Msg("Processing LLM models info request")
 
-	info := r.llmService.Info()
+	info := r.llmService.Info(e.Request.Context())
 
 	return e.JSON(http.StatusOK, info)
 }
+
+// HandleBudgetRequest reports the LLM usage of the user's account against its daily and monthly budget
+func (r *llmRoutes) HandleBudgetRequest(e *core.RequestEvent) error {
+	settings, err := llm.FindAccountSettingsByOwner(e.App, e.Auth.Id)
+	if err != nil {
+		return e.NotFoundError("Account not found", err)
+	}
+
+	status, err := llm.FindBudgetStatus(e.App, settings.AccountID)
+	if err != nil {
+		log.Error().Err(err).Str("accountId", settings.AccountID).Msg("Failed to load LLM budget")
+		return e.InternalServerError("Failed to load LLM budget", err)
+	}
+
+	return e.JSON(http.StatusOK, status)
+}
+
+// accountChatOptions returns the chat options for the LLM settings of the user's account.
+// When the account can't be loaded the global LLM config is used.
+func accountChatOptions(e *core.RequestEvent, userID string) []llm.ChatOption {
+	settings, err := llm.FindAccountSettingsByOwner(e.App, userID)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Failed to load account LLM settings, using global config")
+		return nil
+	}
+
+	return settings.ChatOptions()
+}
@@ -0,0 +1,216 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/busybytelab.com/glimmer/internal/route/sse"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// ModelRequest defines the request body of the model pull and delete endpoints, e.g. "gemma3:4b".
+	// Account is the account whose Ollama server superusers manage, the configured server when empty.
+	ModelRequest struct {
+		Model   string `json:"model" form:"model"`
+		Account string `json:"account,omitempty" form:"account"`
+	}
+
+	// ModelLoadRequest defines the request body of the model load endpoint
+	ModelLoadRequest struct {
+		Model   string `json:"model" form:"model"`
+		Account string `json:"account,omitempty" form:"account"`
+		// KeepAlive is how long the model stays loaded, such as "1h". A negative duration keeps it loaded
+		// until Ollama stops, "0" unloads it, the default is the one of Ollama.
+		KeepAlive string `json:"keepAlive" form:"keepAlive"`
+	}
+
+	// ModelPullError is the payload of an "error" event when pulling a model
+	ModelPullError struct {
+		Message string `json:"message"`
+		// Status is the HTTP status the failure would respond with
+		Status int `json:"status"`
+	}
+
+	ModelRoutes interface {
+		HandlePullRequest(e *core.RequestEvent) error
+		HandleDeleteRequest(e *core.RequestEvent) error
+		HandleShowRequest(e *core.RequestEvent) error
+		HandleLoadRequest(e *core.RequestEvent) error
+	}
+
+	modelRoutes struct {
+		models llm.ModelManager
+	}
+)
+
+// defaultKeepAlive is how long Ollama keeps a model loaded by default
+const defaultKeepAlive = 5 * time.Minute
+
+// NewModelRoutes creates the routes administrating the models of the Ollama servers, the manager is nil without Ollama.
+// Users manage the Ollama server of their account, superusers manage the configured server or the server of an account.
+func NewModelRoutes(models llm.ModelManager) ModelRoutes {
+	return &modelRoutes{
+		models: models,
+	}
+}
+
+// HandlePullRequest downloads a model to the Ollama server. The progress is streamed as Server-Sent Events:
+// "progress" events carry llm.PullProgress, the stream ends with a "done" event or an "error" event.
+// Closing the connection cancels the download.
+func (r *modelRoutes) HandlePullRequest(e *core.RequestEvent) error {
+	if r.models == nil {
+		return e.NotFoundError("Ollama is not configured", nil)
+	}
+
+	var req ModelRequest
+	if err := e.BindBody(&req); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if strings.TrimSpace(req.Model) == "" {
+		return e.BadRequestError("Model is required", nil)
+	}
+	serverURL, err := ollamaServerURL(e, req.Account)
+	if err != nil {
+		return err
+	}
+
+	sse.Start(e)
+
+	err = r.models.PullModel(e.Request.Context(), serverURL, req.Model, func(progress llm.PullProgress) error {
+		return sse.WriteEvent(e, "progress", progress)
+	})
+	if err != nil {
+		log.Error().Err(err).Str("model", req.Model).Msg("Failed to pull model")
+		return sse.WriteEvent(e, "error", ModelPullError{Message: fmt.Sprintf("Failed to pull %s: %v", req.Model, err), Status: http.StatusBadGateway})
+	}
+
+	return sse.WriteEvent(e, "done", ModelRequest{Model: req.Model})
+}
+
+// HandleDeleteRequest removes a model from the Ollama server
+func (r *modelRoutes) HandleDeleteRequest(e *core.RequestEvent) error {
+	if r.models == nil {
+		return e.NotFoundError("Ollama is not configured", nil)
+	}
+
+	var req ModelRequest
+	if err := e.BindBody(&req); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if strings.TrimSpace(req.Model) == "" {
+		return e.BadRequestError("Model is required", nil)
+	}
+
+	serverURL, err := ollamaServerURL(e, req.Account)
+	if err != nil {
+		return err
+	}
+
+	if err := r.models.DeleteModel(e.Request.Context(), serverURL, req.Model); err != nil {
+		return modelError(e, "Failed to delete model", req.Model, err)
+	}
+
+	return e.NoContent(http.StatusNoContent)
+}
+
+// HandleShowRequest returns the details of a model of the Ollama server, the model is the "model" query parameter
+// and the account of the server is the "account" query parameter
+func (r *modelRoutes) HandleShowRequest(e *core.RequestEvent) error {
+	if r.models == nil {
+		return e.NotFoundError("Ollama is not configured", nil)
+	}
+
+	model := e.Request.URL.Query().Get("model")
+	if strings.TrimSpace(model) == "" {
+		return e.BadRequestError("Model is required", nil)
+	}
+
+	serverURL, err := ollamaServerURL(e, e.Request.URL.Query().Get("account"))
+	if err != nil {
+		return err
+	}
+
+	details, err := r.models.ShowModel(e.Request.Context(), serverURL, model)
+	if err != nil {
+		return modelError(e, "Failed to show model", model, err)
+	}
+
+	return e.JSON(http.StatusOK, details)
+}
+
+// HandleLoadRequest preloads a model on the Ollama server and sets how long it stays loaded,
+// so that the learners don't wait for the model to load
+func (r *modelRoutes) HandleLoadRequest(e *core.RequestEvent) error {
+	if r.models == nil {
+		return e.NotFoundError("Ollama is not configured", nil)
+	}
+
+	var req ModelLoadRequest
+	if err := e.BindBody(&req); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if strings.TrimSpace(req.Model) == "" {
+		return e.BadRequestError("Model is required", nil)
+	}
+
+	serverURL, err := ollamaServerURL(e, req.Account)
+	if err != nil {
+		return err
+	}
+
+	keepAlive := defaultKeepAlive
+	if req.KeepAlive != "" {
+		var err error
+		if keepAlive, err = time.ParseDuration(req.KeepAlive); err != nil {
+			return e.BadRequestError("keepAlive must be a duration such as 1h", err)
+		}
+	}
+
+	if err := r.models.LoadModel(e.Request.Context(), serverURL, req.Model, keepAlive); err != nil {
+		return modelError(e, "Failed to load model", req.Model, err)
+	}
+
+	return e.NoContent(http.StatusNoContent)
+}
+
+// ollamaServerURL returns the Ollama server managed by the request. Users manage the Ollama server of their account,
+// superusers manage the server of the account parameter, the configured server when it is empty.
+func ollamaServerURL(e *core.RequestEvent, accountID string) (string, error) {
+	var settings *llm.AccountSettings
+	var err error
+	switch {
+	case e.HasSuperuserAuth() && accountID == "":
+		return "", nil
+	case e.HasSuperuserAuth():
+		settings, err = llm.FindAccountSettings(e.App, accountID)
+	default:
+		settings, err = llm.FindAccountSettingsByOwner(e.App, e.Auth.Id)
+	}
+	if err != nil {
+		return "", e.NotFoundError("Account not found", err)
+	}
+
+	if strings.TrimSpace(settings.OllamaServerURL) == "" {
+		if e.HasSuperuserAuth() {
+			return "", e.BadRequestError("The account has no Ollama server", nil)
+		}
+		return "", e.ForbiddenError("The account has no Ollama server, only superusers manage the configured server", nil)
+	}
+	return settings.OllamaServerURL, nil
+}
+
+// modelError responds with 404 for a model missing from the Ollama server, 502 for other failures of Ollama
+func modelError(e *core.RequestEvent, message, model string, err error) error {
+	if errors.Is(err, llm.ErrModelNotFound) {
+		return e.NotFoundError(fmt.Sprintf("Model %s not found", model), nil)
+	}
+
+	log.Error().Err(err).Str("model", model).Msg(message)
+	return e.Error(http.StatusBadGateway, message, err)
+}
@@ -0,0 +1,195 @@
+package practice
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	// SessionPromptData holds the variables of the practice session prompt templates
+	SessionPromptData struct {
+		Learner PromptLearner
+		Topic   PromptTopic
+		// BasePrompt is the base prompt of the topic, or the one of the request
+		BasePrompt string
+		// Instructions is the practice session prompt extension of the account, the template has default instructions
+		Instructions string
+		// ItemCount is the number of practice items to create, zero leaves it to the prompt
+		ItemCount int
+		// Language is the default language of the account
+		Language string
+		// RecentMistakes are the latest wrong answers of the learner on the topic, most recent first
+		RecentMistakes []PromptMistake
+	}
+
+	// PromptLearner describes the learner without naming them
+	PromptLearner struct {
+		Age   int
+		Grade string
+		// YearLevel is the grade, or a year level derived from the age when the grade isn't set
+		YearLevel   string
+		Preferences []string
+		// LearningStyle describes the preferences in natural language, e.g. "learns best through concrete examples"
+		LearningStyle string
+	}
+
+	PromptTopic struct {
+		Name             string
+		Subject          string
+		Description      string
+		LearningGoals    string
+		TargetAgeRange   string
+		TargetGradeLevel string
+	}
+
+	PromptMistake struct {
+		Question      string
+		Answer        string
+		CorrectAnswer string
+	}
+)
+
+// maxPromptMistakes bounds the recent mistakes of the learner added to the prompt
+const maxPromptMistakes = 5
+
+var (
+	//go:embed prompts/practice_session.tmpl
+	defaultSessionPromptText string
+
+	// sessionPromptFuncs are the functions available to the templates, besides the text/template builtins
+	sessionPromptFuncs = template.FuncMap{
+		"join":  strings.Join,
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}
+
+	defaultSessionPrompt = template.Must(parseSessionPrompt(defaultSessionPromptText))
+)
+
+// parseSessionPrompt parses a practice session prompt template
+func parseSessionPrompt(text string) (*template.Template, error) {
+	return template.New("practice_session").Funcs(sessionPromptFuncs).Option("missingkey=error").Parse(text)
+}
+
+// buildSessionPrompt renders the practice session prompt of the account of the topic for the learner.
+// The template of the account is used when it is set, the embedded default template otherwise
+// or when the template of the account fails. The prompt version, if any, overrides the instructions
+// and the template of the account it sets.
+func buildSessionPrompt(app core.App, learner, topic, version *core.Record, basePrompt string, itemCount int) (string, error) {
+	data := SessionPromptData{
+		Learner:        promptLearner(learner),
+		Topic:          promptTopic(topic),
+		BasePrompt:     strings.TrimSpace(basePrompt),
+		ItemCount:      itemCount,
+		RecentMistakes: findRecentMistakes(app, learner.Id, topic.Id),
+	}
+
+	accountId := topic.GetString("account")
+	account, err := app.FindRecordById(domain.CollectionAccounts, accountId)
+	if err != nil {
+		log.Error().Err(err).Str("accountId", accountId).Msg("Failed to find account, using the default prompt")
+		return renderSessionPrompt(defaultSessionPrompt, data)
+	}
+
+	data.Instructions = strings.TrimSpace(account.GetString("practice_session_default_prompt_extension"))
+	data.Language = account.GetString("default_language")
+
+	text := account.GetString("practice_session_prompt_template")
+
+	if version != nil {
+		if instructions := strings.TrimSpace(version.GetString("instructions")); instructions != "" {
+			data.Instructions = instructions
+		}
+		if versionText := version.GetString("template"); strings.TrimSpace(versionText) != "" {
+			text = versionText
+		}
+	}
+	if strings.TrimSpace(text) == "" {
+		return renderSessionPrompt(defaultSessionPrompt, data)
+	}
+
+	tmpl, err := parseSessionPrompt(text)
+	if err == nil {
+		var prompt string
+		if prompt, err = renderSessionPrompt(tmpl, data); err == nil {
+			return prompt, nil
+		}
+	}
+
+	log.Warn().Err(err).Str("accountId", accountId).Msg("Invalid practice session prompt template, using the default prompt")
+	return renderSessionPrompt(defaultSessionPrompt, data)
+}
+
+// renderSessionPrompt executes the template, trimming the surrounding blank lines
+func renderSessionPrompt(tmpl *template.Template, data SessionPromptData) (string, error) {
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("failed to render the practice session prompt: %w", err)
+	}
+	return strings.TrimSpace(prompt.String()), nil
+}
+
+func promptLearner(learner *core.Record) PromptLearner {
+	preferences := learnerPreferences(learner)
+	return PromptLearner{
+		Age:           learner.GetInt("age"),
+		Grade:         learner.GetString("grade_level"),
+		YearLevel:     learnerYearLevel(learner),
+		Preferences:   preferences,
+		LearningStyle: learningStyle(preferences),
+	}
+}
+
+func promptTopic(topic *core.Record) PromptTopic {
+	return PromptTopic{
+		Name:             topic.GetString("name"),
+		Subject:          topic.GetString("subject"),
+		Description:      topic.GetString("description"),
+		LearningGoals:    topic.GetString("learning_goals"),
+		TargetAgeRange:   topic.GetString("target_age_range"),
+		TargetGradeLevel: topic.GetString("target_grade_level"),
+	}
+}
+
+// findRecentMistakes returns the latest wrong answers of the learner on the topic, the prompt goes without them on failure
+func findRecentMistakes(app core.App, learnerId, topicId string) []PromptMistake {
+	results, err := app.FindRecordsByFilter(
+		domain.CollectionPracticeResults,
+		"learner = {:learner} && is_correct = false && practice_item.practice_topic = {:topic}",
+		"-created",
+		maxPromptMistakes,
+		0,
+		map[string]any{
+			"learner": learnerId,
+			"topic":   topicId,
+		},
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("learnerId", learnerId).Msg("Failed to find the recent mistakes of the learner")
+		return nil
+	}
+
+	for path, err := range app.ExpandRecords(results, []string{"practice_item"}, nil) {
+		log.Warn().Err(err).Str("expand", path).Msg("Failed to expand the recent mistakes of the learner")
+	}
+
+	mistakes := make([]PromptMistake, 0, len(results))
+	for _, result := range results {
+		item := result.ExpandedOne("practice_item")
+		if item == nil {
+			continue
+		}
+		mistakes = append(mistakes, PromptMistake{
+			Question:      item.GetString("question_text"),
+			Answer:        getCleanCorrectAnswer(result.GetString("answer")),
+			CorrectAnswer: getCleanCorrectAnswer(item.GetString("correct_answer")),
+		})
+	}
+	return mistakes
+}
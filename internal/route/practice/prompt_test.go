@@ -0,0 +1,108 @@
+package practice
+
+import (
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSessionPrompt(t *testing.T) {
+	app := setupTestApp(t)
+
+	accountCollection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+	require.NoError(t, err)
+	account := core.NewRecord(accountCollection)
+	account.Set("name", "Family")
+	account.Set("default_language", "de")
+	require.NoError(t, app.SaveNoValidate(account))
+
+	learnerCollection, err := app.FindCollectionByNameOrId(domain.CollectionLearners)
+	require.NoError(t, err)
+	learner := core.NewRecord(learnerCollection)
+	learner.Set("nickname", "Mia")
+	learner.Set("age", 9)
+	learner.Set("learning_preferences", `["Visual"]`)
+	learner.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(learner))
+
+	topicCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeTopics)
+	require.NoError(t, err)
+	topic := core.NewRecord(topicCollection)
+	topic.Set("name", "Fractions")
+	topic.Set("subject", "Math")
+	topic.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(topic))
+
+	itemCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeItems)
+	require.NoError(t, err)
+	item := core.NewRecord(itemCollection)
+	item.Set("question_text", "What is 1/2 + 1/4?")
+	item.Set("correct_answer", `"3/4"`)
+	item.Set("practice_topic", topic.Id)
+	item.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(item))
+
+	resultCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeResults)
+	require.NoError(t, err)
+	result := core.NewRecord(resultCollection)
+	result.Set("learner", learner.Id)
+	result.Set("practice_item", item.Id)
+	result.Set("answer", "2/6")
+	result.Set("is_correct", false)
+	require.NoError(t, app.SaveNoValidate(result))
+
+	setAccount := func(t *testing.T, extension, template string) {
+		account.Set("practice_session_default_prompt_extension", extension)
+		account.Set("practice_session_prompt_template", template)
+		require.NoError(t, app.SaveNoValidate(account))
+	}
+
+	t.Run("default template with default instructions", func(t *testing.T) {
+		setAccount(t, "", "")
+
+		prompt, err := buildSessionPrompt(app, learner, topic, nil, "Create fun \"word\" problems.", 10)
+		require.NoError(t, err)
+
+		assert.Contains(t, prompt, "There is a student in year 5 who needs to improve their skills in Fractions. "+
+			"The student learns best through concrete examples and visual thinking.")
+		assert.Contains(t, prompt, "Create fun \"word\" problems.\n\nTopic: Fractions\nSubject: Math")
+		assert.Contains(t, prompt, `- What is 1/2 + 1/4? The student answered "2/6", the correct answer is "3/4".`)
+		assert.Contains(t, prompt, "INSTRUCTIONS:")
+		assert.Contains(t, prompt, "Create exactly 10 practice items.")
+		assert.Contains(t, prompt, "Write the practice items in this language: de.")
+		assert.NotContains(t, prompt, `\n`, "the prompt isn't escaped")
+		assert.NotContains(t, prompt, "Mia", "the learner isn't named")
+	})
+
+	t.Run("account instructions replace the default ones", func(t *testing.T) {
+		setAccount(t, "Only use pizza examples.", "")
+
+		prompt, err := buildSessionPrompt(app, learner, topic, nil, "", 0)
+		require.NoError(t, err)
+
+		assert.Contains(t, prompt, "Only use pizza examples.")
+		assert.NotContains(t, prompt, "INSTRUCTIONS:")
+		assert.NotContains(t, prompt, "Create exactly")
+	})
+
+	t.Run("account template", func(t *testing.T) {
+		setAccount(t, "", `{{.Topic.Name}} for age {{.Learner.Age}} ({{join .Learner.Preferences ", " | lower}}), {{.ItemCount}} items in {{.Language}}`)
+
+		prompt, err := buildSessionPrompt(app, learner, topic, nil, "", 5)
+		require.NoError(t, err)
+		assert.Equal(t, "Fractions for age 9 (visual), 5 items in de", prompt)
+	})
+
+	t.Run("invalid account template falls back to the default template", func(t *testing.T) {
+		for _, template := range []string{"{{.Topic.Name", "{{.Unknown}}"} {
+			setAccount(t, "", template)
+
+			prompt, err := buildSessionPrompt(app, learner, topic, nil, "", 0)
+			require.NoError(t, err)
+			assert.Contains(t, prompt, "Topic: Fractions\nSubject: Math")
+		}
+	})
+}
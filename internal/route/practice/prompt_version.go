@@ -0,0 +1,68 @@
+package practice
+
+import (
+	"math/rand/v2"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+// promptVersionIntN returns a random number in [0, n), tests replace it to pick a given version
+var promptVersionIntN = rand.IntN
+
+// pickPromptVersion picks one of the prompt versions of the account with a weight, in proportion to the weights,
+// so that two versions weighted 50 and 50 each generate half of the practice sessions.
+// It returns nil when the account has no weighted versions, the prompt of the account is used then.
+func pickPromptVersion(app core.App, accountId string) *core.Record {
+	versions, err := app.FindRecordsByFilter(
+		domain.CollectionPromptVersions,
+		"account = {:account} && weight > 0",
+		"created",
+		0,
+		0,
+		map[string]any{"account": accountId},
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("accountId", accountId).Msg("Failed to find prompt versions, using the account prompt")
+		return nil
+	}
+
+	total := 0
+	for _, version := range versions {
+		total += version.GetInt("weight")
+	}
+	if total == 0 {
+		return nil
+	}
+
+	n := promptVersionIntN(total)
+	for _, version := range versions {
+		n -= version.GetInt("weight")
+		if n < 0 {
+			return version
+		}
+	}
+	return nil
+}
+
+// recordPromptGeneration records a practice session generation made with the prompt version.
+// The session is empty when the generation failed, parse failures are the responses that weren't valid practice items.
+func recordPromptGeneration(app core.App, version *core.Record, sessionId string, responses, parseFailures int) {
+	collection, err := app.FindCollectionByNameOrId(domain.CollectionPromptGenerations)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find prompt_generations collection")
+		return
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("account", version.GetString("account"))
+	record.Set("prompt_version", version.Id)
+	record.Set("practice_session", sessionId)
+	record.Set("responses", responses)
+	record.Set("parse_failures", parseFailures)
+
+	if err := app.Save(record); err != nil {
+		log.Error().Err(err).Str("promptVersionId", version.Id).Msg("Failed to record prompt generation")
+	}
+}
@@ -0,0 +1,183 @@
+package practice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromptVersions(t *testing.T) {
+	app := setupTestApp(t)
+
+	accountCollection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+	require.NoError(t, err)
+	account := core.NewRecord(accountCollection)
+	account.Set("name", "Family")
+	account.Set("practice_session_default_prompt_extension", "Use everyday examples.")
+	require.NoError(t, app.SaveNoValidate(account))
+
+	learnerCollection, err := app.FindCollectionByNameOrId(domain.CollectionLearners)
+	require.NoError(t, err)
+	learner := core.NewRecord(learnerCollection)
+	learner.Set("nickname", "Mia")
+	learner.Set("age", 9)
+	learner.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(learner))
+
+	topicCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeTopics)
+	require.NoError(t, err)
+	topic := core.NewRecord(topicCollection)
+	topic.Set("name", "Fractions")
+	topic.Set("subject", "Math")
+	topic.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(topic))
+
+	versionCollection, err := app.FindCollectionByNameOrId(domain.CollectionPromptVersions)
+	require.NoError(t, err)
+	newVersion := func(name, instructions string, weight int) *core.Record {
+		version := core.NewRecord(versionCollection)
+		version.Set("account", account.Id)
+		version.Set("name", name)
+		version.Set("instructions", instructions)
+		version.Set("weight", weight)
+		require.NoError(t, app.SaveNoValidate(version))
+		return version
+	}
+
+	defer func(intN func(int) int) { promptVersionIntN = intN }(promptVersionIntN)
+	pick := func(n int) *core.Record {
+		promptVersionIntN = func(int) int { return n }
+		return pickPromptVersion(app, account.Id)
+	}
+
+	t.Run("no versions uses the account prompt", func(t *testing.T) {
+		assert.Nil(t, pickPromptVersion(app, account.Id))
+	})
+
+	versionA := newVersion("A", "Use pizza examples.", 30)
+	versionB := newVersion("B", "Use sports examples.", 70)
+	newVersion("Retired", "Use no examples.", 0)
+
+	t.Run("picks versions in proportion to their weights", func(t *testing.T) {
+		assert.Equal(t, versionA.Id, pick(0).Id)
+		assert.Equal(t, versionA.Id, pick(29).Id)
+		assert.Equal(t, versionB.Id, pick(30).Id)
+		assert.Equal(t, versionB.Id, pick(99).Id)
+	})
+
+	t.Run("version instructions replace the account instructions", func(t *testing.T) {
+		prompt, err := buildSessionPrompt(app, learner, topic, versionA, "", 0)
+		require.NoError(t, err)
+		assert.Contains(t, prompt, "Use pizza examples.")
+		assert.NotContains(t, prompt, "Use everyday examples.")
+	})
+
+	validResponse := `{"items": [
+		{"question_text": "What is 1/3 + 1/3?", "question_type": "short_answer", "correct_answer": "2/3", "explanation": "Add the numerators."},
+		{"question_text": "What is 1/2 of 8?", "question_type": "short_answer", "correct_answer": "4", "explanation": "Divide by two."}
+	]}`
+
+	t.Run("records the version and the parse failures of the generation", func(t *testing.T) {
+		promptVersionIntN = func(int) int { return 50 }
+		service := &generationService{responses: []string{"Here are your items!"}, response: validResponse}
+
+		session, err := generatePracticeSession(context.Background(), app, service, learner, topic, "", sessionOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, versionB.Id, session.GetString("prompt_version"))
+		assert.Contains(t, session.GetString("generation_prompt"), "Use sports examples.")
+
+		generations, err := app.FindAllRecords(domain.CollectionPromptGenerations)
+		require.NoError(t, err)
+		require.Len(t, generations, 1)
+		assert.Equal(t, versionB.Id, generations[0].GetString("prompt_version"))
+		assert.Equal(t, session.Id, generations[0].GetString("practice_session"))
+		assert.Equal(t, 2, generations[0].GetInt("responses"))
+		assert.Equal(t, 1, generations[0].GetInt("parse_failures"))
+	})
+
+	t.Run("records failed generations", func(t *testing.T) {
+		promptVersionIntN = func(int) int { return 0 }
+		service := &generationService{response: "Sorry, I can't help with that."}
+
+		_, err := generatePracticeSession(context.Background(), app, service, learner, topic, "", sessionOptions{})
+		require.Error(t, err)
+
+		generations, err := app.FindRecordsByFilter(domain.CollectionPromptGenerations, "prompt_version = {:version}", "", 0, 0,
+			map[string]any{"version": versionA.Id})
+		require.NoError(t, err)
+		require.Len(t, generations, 1)
+		assert.Empty(t, generations[0].GetString("practice_session"))
+		assert.Equal(t, 3, generations[0].GetInt("responses"))
+		assert.Equal(t, 3, generations[0].GetInt("parse_failures"))
+	})
+
+	t.Run("reports the stats of the versions", func(t *testing.T) {
+		session, err := app.FindFirstRecordByFilter(domain.CollectionPracticeSessions, "prompt_version = {:version}",
+			map[string]any{"version": versionB.Id})
+		require.NoError(t, err)
+		itemIds := session.GetStringSlice("practice_items")
+		require.Len(t, itemIds, 2)
+
+		approved, err := app.FindRecordById(domain.CollectionPracticeItems, itemIds[0])
+		require.NoError(t, err)
+		approved.Set("review_status", "APPROVED")
+		require.NoError(t, app.SaveNoValidate(approved))
+
+		needsEdit, err := app.FindRecordById(domain.CollectionPracticeItems, itemIds[1])
+		require.NoError(t, err)
+		needsEdit.Set("review_status", "NEED_EDIT")
+		require.NoError(t, app.SaveNoValidate(needsEdit))
+
+		resultCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeResults)
+		require.NoError(t, err)
+		for i, isCorrect := range []bool{true, true, true, false} {
+			result := core.NewRecord(resultCollection)
+			result.Set("learner", learner.Id)
+			result.Set("practice_item", itemIds[i%2])
+			result.Set("practice_session", session.Id)
+			result.Set("answer", `"4"`)
+			result.Set("is_correct", isCorrect)
+			require.NoError(t, app.SaveNoValidate(result))
+		}
+
+		stats, err := app.FindRecordById(domain.CollectionPromptVersionStats, versionB.Id)
+		require.NoError(t, err)
+		assert.Equal(t, account.Id, stats.GetString("account"))
+		assert.Equal(t, 1.0, stat(t, stats, "generations"))
+		assert.Equal(t, 0.5, stat(t, stats, "parse_failure_rate"))
+		assert.Equal(t, 2.0, stat(t, stats, "total_items"))
+		assert.Equal(t, 2.0, stat(t, stats, "reviewed_items"))
+		assert.Equal(t, 0.5, stat(t, stats, "approval_rate"))
+		assert.Equal(t, 4.0, stat(t, stats, "answers"))
+		assert.Equal(t, 0.75, stat(t, stats, "accuracy"))
+		assert.Equal(t, 0.0, stat(t, stats, "flagged_items"))
+
+		// An item flagged by the moderation waits for the parent review, it isn't reviewed yet
+		needsEdit.Set("status", practiceItemStatusFlagged)
+		require.NoError(t, app.SaveNoValidate(needsEdit))
+
+		stats, err = app.FindRecordById(domain.CollectionPromptVersionStats, versionB.Id)
+		require.NoError(t, err)
+		assert.Equal(t, 2.0, stat(t, stats, "total_items"))
+		assert.Equal(t, 1.0, stat(t, stats, "reviewed_items"))
+		assert.Equal(t, 1.0, stat(t, stats, "flagged_items"))
+		assert.Equal(t, 1.0, stat(t, stats, "approval_rate"))
+
+		stats, err = app.FindRecordById(domain.CollectionPromptVersionStats, versionA.Id)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, stat(t, stats, "parse_failure_rate"))
+		assert.Equal(t, 0.0, stat(t, stats, "total_items"))
+		assert.Equal(t, 0.0, stat(t, stats, "accuracy"))
+	})
+}
+
+// stat returns a computed column of a stats view, the view gives them as JSON
+func stat(t *testing.T, stats *core.Record, name string) float64 {
+	var value float64
+	require.NoError(t, stats.UnmarshalJSONField(name, &value))
+	return value
+}
@@ -0,0 +1,43 @@
+package practice
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+// RegisterReviewHooks moves the flagged practice items a parent approves to the practice items of their sessions,
+// learners only read the practice items of a session
+func RegisterReviewHooks(app core.App) {
+	app.OnRecordAfterUpdateSuccess(domain.CollectionPracticeItems).BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("status") == practiceItemStatusFlagged && e.Record.GetString("review_status") == "APPROVED" {
+			if err := releaseApprovedItem(e.App, e.Record.Id); err != nil {
+				log.Error().Err(err).Str("practiceItemId", e.Record.Id).Msg("Failed to release an approved practice item")
+			}
+		}
+		return e.Next()
+	})
+}
+
+// releaseApprovedItem moves the practice item from the flagged items to the practice items of the sessions holding it
+func releaseApprovedItem(app core.App, itemId string) error {
+	sessions, err := app.FindAllRecords(domain.CollectionPracticeSessions,
+		dbx.NewExp("EXISTS (SELECT 1 FROM json_each(flagged_items) WHERE value = {:item})", dbx.Params{"item": itemId}))
+	if err != nil {
+		return fmt.Errorf("failed to find the sessions of the practice item: %w", err)
+	}
+
+	for _, session := range sessions {
+		flagged := slices.DeleteFunc(session.GetStringSlice("flagged_items"), func(id string) bool { return id == itemId })
+		session.Set("flagged_items", flagged)
+		session.Set("practice_items", append(session.GetStringSlice("practice_items"), itemId))
+		if err := app.Save(session); err != nil {
+			return fmt.Errorf("failed to save practice session %s: %w", session.Id, err)
+		}
+	}
+	return nil
+}
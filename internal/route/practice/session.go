@@ -1,7 +1,9 @@
 package practice
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/busybytelab.com/glimmer/internal/domain"
 	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/busybytelab.com/glimmer/internal/route/sse"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/rs/zerolog/log"
 )
@@ -29,6 +32,31 @@ type (
 		PracticeTopicId string `json:"practiceTopicId"`
 		SystemPrompt    string `json:"systemPrompt,omitempty"`
 		BasePrompt      string `json:"basePrompt,omitempty"`
+		// Stream requests the response as Server-Sent Events, same as sending "Accept: text/event-stream"
+		Stream bool `json:"stream,omitempty"`
+	}
+
+	// SessionStreamQueue is the payload of a "queue" event, sent while the generation waits for a busy LLM platform.
+	// Position 1 is the next request served, 0 means the request left the queue and the items are being generated.
+	SessionStreamQueue struct {
+		Position int `json:"position"`
+	}
+
+	// SessionStreamError is the payload of an "error" event when streaming the response
+	SessionStreamError struct {
+		Message string `json:"message"`
+		// Status is the HTTP status the non-streaming endpoint would respond with
+		Status int `json:"status"`
+	}
+
+	// sessionOptions override how a practice session is generated, the zero value uses the practice topic
+	sessionOptions struct {
+		SystemPrompt string
+		BasePrompt   string
+		// QuestionCount asks for that many practice items, zero leaves it to the prompts
+		QuestionCount int
+		// OnQueued receives the queue position of the generation while the LLM platform is busy
+		OnQueued llm.QueueHandler
 	}
 
 	// PracticeItemResponse defines the structure for a practice item generated by LLM
@@ -49,6 +77,17 @@ type (
 	}
 )
 
+const (
+	// practiceItemStatusFlagged is the status of the practice items flagged by the moderation, they are flagged items
+	// of their session until a parent approves them
+	practiceItemStatusFlagged = "Flagged"
+	// practiceSessionStatusNeedsReview is the status of the practice sessions holding flagged practice items
+	practiceSessionStatusNeedsReview = "NeedsReview"
+)
+
+// practiceItemsSchema is the JSON Schema of the generated practice items
+var practiceItemsSchema = llm.JSONSchemaOf(LLMResponseItems{})
+
 func NewPracticeSessionRoute(llmService llm.Service) SessionRoute {
 	return &sessionRoute{
 		llmService: llmService,
@@ -81,17 +120,84 @@ func (r *sessionRoute) HandleCreatePracticeSession(e *core.RequestEvent) error {
 		return e.NotFoundError("Practice topic not found", err)
 	}
 
-	// 3. Get base prompt and system prompt from practiceTopic or request if provided
+	opts := sessionOptions{
+		SystemPrompt: req.SystemPrompt,
+		BasePrompt:   req.BasePrompt,
+	}
+	if req.Stream || strings.Contains(e.Request.Header.Get("Accept"), "text/event-stream") {
+		return r.streamPracticeSession(e, learner, topic, opts)
+	}
+
+	// 3. Ask the LLM to create practice items and save them with the session
+	practiceSession, err := generatePracticeSession(e.Request.Context(), e.App, r.llmService, learner, topic, e.Auth.Id, opts)
+	if err != nil {
+		status, message := sessionErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			return e.InternalServerError(message, err)
+		}
+		return e.Error(status, message, nil)
+	}
+
+	// Return the created practice session
+	return e.JSON(http.StatusOK, practiceSession)
+}
+
+// streamPracticeSession generates the practice session and writes the response as Server-Sent Events.
+// While the LLM platform is busy, "queue" events report the position of the generation, then a single
+// "done" event carries the practice session. Failures are reported as an "error" event.
+func (r *sessionRoute) streamPracticeSession(e *core.RequestEvent, learner, topic *core.Record, opts sessionOptions) error {
+	sse.Start(e)
+
+	// The queue position is only informative, a client gone away cancels the generation with the request context
+	opts.OnQueued = func(position int) {
+		if err := sse.WriteEvent(e, "queue", SessionStreamQueue{Position: position}); err != nil {
+			log.Debug().Err(err).Str("learnerId", learner.Id).Msg("Failed to write queue event")
+		}
+	}
+
+	practiceSession, err := generatePracticeSession(e.Request.Context(), e.App, r.llmService, learner, topic, e.Auth.Id, opts)
+	if err != nil {
+		status, message := sessionErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Error().Err(err).Str("learnerId", learner.Id).Msg("Failed to create practice session")
+		}
+		return sse.WriteEvent(e, "error", SessionStreamError{Message: message, Status: status})
+	}
+
+	return sse.WriteEvent(e, "done", practiceSession)
+}
+
+// sessionErrorStatus returns the HTTP status and the message of a failed generation
+func sessionErrorStatus(err error) (int, string) {
+	var budgetErr *llm.BudgetExceededError
+	switch {
+	case errors.As(err, &budgetErr):
+		return http.StatusTooManyRequests, budgetErr.Error()
+	case errors.Is(err, llm.ErrContentBlocked):
+		return http.StatusUnprocessableEntity, "The generated practice items were blocked by the content moderation"
+	case errors.Is(err, llm.ErrCloudPlatformForbidden):
+		return http.StatusForbidden, "The account keeps learner data on local LLM platforms, pick a local model for the practice topic"
+	case errors.Is(err, llm.ErrModelCapability):
+		return http.StatusBadRequest, err.Error()
+	default:
+		return http.StatusInternalServerError, "Failed to create practice session"
+	}
+}
+
+// generatePracticeSession asks the LLM for practice items for the learner and topic, then saves the items
+// and a practice session holding them. The user is the one asking for the session, it is recorded in the usage ledger.
+func generatePracticeSession(ctx context.Context, app core.App, llmService llm.Service, learner, topic *core.Record, userId string, opts sessionOptions) (*core.Record, error) {
+	// Get base prompt and system prompt from practiceTopic or the options if provided
 	basePrompt := topic.GetString("base_prompt")
 	systemPrompt := topic.GetString("system_prompt")
 
 	// Use provided prompts if they exist
-	if req.BasePrompt != "" {
-		basePrompt = req.BasePrompt
+	if opts.BasePrompt != "" {
+		basePrompt = opts.BasePrompt
 	}
 
-	if req.SystemPrompt != "" {
-		systemPrompt = req.SystemPrompt
+	if opts.SystemPrompt != "" {
+		systemPrompt = opts.SystemPrompt
 	}
 
 	// Set default system prompt if not provided
@@ -99,59 +205,98 @@ func (r *sessionRoute) HandleCreatePracticeSession(e *core.RequestEvent) error {
 		systemPrompt = "You are an expert educational content creator specialized in creating practice exercises for students."
 	}
 
-	// 4. Ask LLM to create practice items
-	// Get user name from the expanded relation
-	userId := learner.GetString("user")
-	learnerNickname := learner.GetString("nickname")
-	var userName string
-	if user, err := e.App.FindRecordById("_pb_users_auth_", userId); err == nil {
-		userName = user.GetString("name")
-	} else {
-		userName = userId
-	}
-
+	// The learner is logged by id, names stay out of the logs
 	log.Info().
-		Str("user", userName).
-		Str("nickname", learnerNickname).
-		Str("topic", topic.GetString("name")).
+		Str("learnerId", learner.Id).
+		Str("topicId", topic.Id).
+		Int("questionCount", opts.QuestionCount).
 		Msg("Generating practice items using LLM")
 
-	// Build learner profile and generation prompt
-	learnerProfile := buildLearnerProfile(learner, topic)
-	generationPrompt := buildGenerationPrompt(basePrompt, learnerProfile, topic, e)
+	// Get account ID from learner
+	accountId := learner.GetString("account")
+
+	// Pick the prompt version of the generation when the account compares prompt versions
+	version := pickPromptVersion(app, accountId)
+	var versionId string
+	if version != nil {
+		versionId = version.Id
+	}
+
+	// Build the generation prompt from the prompt template of the account
+	generationPrompt, err := buildSessionPrompt(app, learner, topic, version, basePrompt, opts.QuestionCount)
+	if err != nil {
+		return nil, err
+	}
 
-	// Get the LLM model from the practice topic, if not set, the service will use default
-	llmModel := topic.GetString("llm_model")
+	// Use the account's own Ollama server and default model, if not set, the service will use the global config
 	var chatOptions []llm.ChatOption
-	if llmModel != "" {
+	if settings, err := llm.FindAccountSettings(app, accountId); err == nil {
+		chatOptions = append(chatOptions, settings.ChatOptions()...)
+	} else {
+		log.Warn().Err(err).Str("accountId", accountId).Msg("Failed to load account LLM settings, using global config")
+	}
+
+	// The LLM model of the practice topic takes precedence over the account default model
+	if llmModel := topic.GetString("llm_model"); llmModel != "" {
 		chatOptions = append(chatOptions, llm.WithModel(llmModel))
 	}
 
-	// 5. Generate practice items with retry logic for JSON parsing issues
-	practiceItems, err := r.generatePracticeItemsWithRetry(generationPrompt, systemPrompt, chatOptions)
-	if err != nil {
-		return e.InternalServerError("Failed to generate practice items", err)
+	// Sampling parameters of the practice topic, e.g. a lower temperature for math drills
+	if sampling := llm.RecordSamplingParameters(topic); sampling != nil {
+		chatOptions = append(chatOptions, llm.WithSampling(*sampling))
 	}
 
-	// Get account ID from learner
-	accountId := learner.GetString("account")
+	// Record the generation in the usage ledger
+	chatOptions = append(chatOptions, llm.WithUser(userId), llm.WithPurpose(llm.PurposeSessionGeneration))
+	if opts.OnQueued != nil {
+		chatOptions = append(chatOptions, llm.WithQueueHandler(opts.OnQueued))
+	}
+
+	// Ask for JSON matching the practice items, the response is still cleaned up in case the platform ignores it
+	chatOptions = append(chatOptions, llm.WithJSONSchema("practice_items", practiceItemsSchema))
+
+	// Record the generation with the prompt version, the failed ones too, to compare the parse failures of the versions
+	var sessionId string
+	var moderation *domain.Moderation
+	responses, parseFailures := 0, 0
+	if version != nil {
+		defer func() {
+			recordPromptGeneration(app, version, sessionId, responses, parseFailures)
+		}()
+	}
+
+	// Generate practice items with retry logic for JSON parsing issues
+	practiceItems, err := generatePracticeItemsWithRetry(ctx, chatOptions, func(options []llm.ChatOption) (string, error) {
+		response, usage, err := llmService.Chat(ctx, generationPrompt, systemPrompt, options...)
+		if err == nil {
+			responses++
+			moderation = usageModeration(usage)
+		}
+		return response, err
+	})
+	if err != nil {
+		parseFailures = responses
+		return nil, fmt.Errorf("failed to generate practice items: %w", err)
+	}
+	// Only the last response was parsed
+	parseFailures = responses - 1
 
-	// 7. Create practice item records in DB
-	practiceItemIds, err := createPracticeItems(e, practiceItems, topic.Id, accountId)
+	// Create practice item records in DB
+	practiceItemIds, err := createPracticeItems(app, practiceItems, topic.Id, accountId, moderation)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create practice items in database")
-		return e.InternalServerError("Failed to save practice items", err)
+		return nil, fmt.Errorf("failed to save practice items: %w", err)
 	}
 
-	// 8. Create practice session
-	practiceSession, err := createPracticeSession(e, topic.Id, learner.Id, practiceItemIds, generationPrompt, accountId)
+	// Create practice session
+	practiceSession, err := createPracticeSession(app, topic.Id, learner.Id, practiceItemIds, generationPrompt, versionId, accountId, moderation)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create practice session")
-		return e.InternalServerError("Failed to create practice session", err)
+		return nil, err
 	}
+	sessionId = practiceSession.Id
 
-	// Return the created practice session
-	return e.JSON(http.StatusOK, practiceSession)
+	return practiceSession, nil
 }
 
 // buildLearnerProfile constructs a profile string based on learner's attributes
@@ -160,29 +305,6 @@ func buildLearnerProfile(learner *core.Record, topic *core.Record) string {
 		return ""
 	}
 
-	// Get learning preferences as a slice
-	var learningPrefs []string
-	if prefsStr := learner.GetString("learning_preferences"); prefsStr != "" {
-		if err := json.Unmarshal([]byte(prefsStr), &learningPrefs); err != nil {
-			log.Error().Err(err).Msg("Failed to unmarshal learning preferences")
-			learningPrefs = []string{} // Reset to empty if unmarshal fails
-		}
-	}
-
-	age := learner.GetInt("age")
-	gradeLevel := learner.GetString("grade_level")
-
-	// Convert age to year level if grade level is not specified
-	yearLevel := gradeLevel
-	if yearLevel == "" {
-		// Simple mapping: age 5 = year 1, age 6 = year 2, etc.
-		if age >= 5 && age <= 18 {
-			yearLevel = fmt.Sprintf("year %d", age-4)
-		} else {
-			yearLevel = fmt.Sprintf("%d years old", age)
-		}
-	}
-
 	// Get topic name for the profile
 	topicName := "the topic"
 	if topic != nil {
@@ -190,78 +312,62 @@ func buildLearnerProfile(learner *core.Record, topic *core.Record) string {
 	}
 
 	// Build natural language profile
-	profile := fmt.Sprintf("There is a student in %s who needs to improve their skills in %s.", yearLevel, topicName)
+	profile := fmt.Sprintf("There is a student in %s who needs to improve their skills in %s.", learnerYearLevel(learner), topicName)
 
 	// Add learning preferences in natural language
-	if len(learningPrefs) > 0 {
-		var learningStyle string
-		for i, pref := range learningPrefs {
-			switch strings.ToLower(pref) {
-			case "visual":
-				learningStyle += "learns best through concrete examples and visual thinking"
-			case "auditory":
-				learningStyle += "learns best through verbal explanations and talking through problems"
-			case "kinesthetic":
-				learningStyle += "learns best through interactive practice and step-by-step activities"
-			default:
-				learningStyle += fmt.Sprintf("has %s learning preferences", strings.ToLower(pref))
-			}
+	if learningStyle := learningStyle(learnerPreferences(learner)); learningStyle != "" {
+		profile += fmt.Sprintf(" The student %s.", learningStyle)
+	}
 
-			if i < len(learningPrefs)-1 {
-				learningStyle += " and "
-			}
-		}
+	return profile
+}
 
-		if learningStyle != "" {
-			profile += fmt.Sprintf(" The student %s.", learningStyle)
+// learnerPreferences returns the learning preferences of the learner, e.g. "Visual"
+func learnerPreferences(learner *core.Record) []string {
+	var learningPrefs []string
+	if prefsStr := learner.GetString("learning_preferences"); prefsStr != "" {
+		if err := json.Unmarshal([]byte(prefsStr), &learningPrefs); err != nil {
+			log.Error().Err(err).Msg("Failed to unmarshal learning preferences")
+			return nil
 		}
 	}
-
-	return profile
+	return learningPrefs
 }
 
-// buildGenerationPrompt constructs a detailed prompt for the LLM to generate practice items
-func buildGenerationPrompt(basePrompt string, learnerProfile string, topic *core.Record, e *core.RequestEvent) string {
-	// Get account to retrieve the prompt extension template
-	accountId := topic.GetString("account")
-	account, err := e.App.FindRecordById(domain.CollectionAccounts, accountId)
-	if err != nil {
-		log.Error().Err(err).Str("accountId", accountId).Msg("Failed to find account, using default prompt extension")
-		// We'll continue with an empty extension if there's an error
-	}
-
-	// Get the prompt extension from the account, or use an empty string if not available
-	// TODO: fix this, must use default extension if empty
-	promptExtension := ""
-	if account != nil {
-		promptExtension = account.GetString("practice_session_default_prompt_extension")
-	}
-
-	// Combine all parts of the prompt - learner profile comes first
-	var combinedPrompt string
-	if learnerProfile != "" {
-		combinedPrompt = fmt.Sprintf("%s\n\n%s\n\nTopic: %s\nSubject: %s\n\n%s",
-			learnerProfile,
-			basePrompt,
-			topic.GetString("name"),
-			topic.GetString("subject"),
-			promptExtension)
-	} else {
-		combinedPrompt = fmt.Sprintf("%s\n\nTopic: %s\nSubject: %s\n\n%s",
-			basePrompt,
-			topic.GetString("name"),
-			topic.GetString("subject"),
-			promptExtension)
+// learnerYearLevel returns the grade level of the learner, converting the age to a year level if the grade level is not specified
+func learnerYearLevel(learner *core.Record) string {
+	if gradeLevel := learner.GetString("grade_level"); gradeLevel != "" {
+		return gradeLevel
 	}
 
-	// Escape any JSON special characters in the prompt to ensure it's JSON-safe
-	escapedPrompt := strings.ReplaceAll(combinedPrompt, `"`, `\"`)
-	escapedPrompt = strings.ReplaceAll(escapedPrompt, `\`, `\\`)
-	escapedPrompt = strings.ReplaceAll(escapedPrompt, "\n", `\n`)
-	escapedPrompt = strings.ReplaceAll(escapedPrompt, "\t", `\t`)
-	escapedPrompt = strings.ReplaceAll(escapedPrompt, "\r", `\r`)
+	// Simple mapping: age 5 = year 1, age 6 = year 2, etc.
+	age := learner.GetInt("age")
+	if age >= 5 && age <= 18 {
+		return fmt.Sprintf("year %d", age-4)
+	}
+	return fmt.Sprintf("%d years old", age)
+}
 
-	return escapedPrompt
+// learningStyle describes the learning preferences in natural language
+func learningStyle(learningPrefs []string) string {
+	var learningStyle string
+	for i, pref := range learningPrefs {
+		switch strings.ToLower(pref) {
+		case "visual":
+			learningStyle += "learns best through concrete examples and visual thinking"
+		case "auditory":
+			learningStyle += "learns best through verbal explanations and talking through problems"
+		case "kinesthetic":
+			learningStyle += "learns best through interactive practice and step-by-step activities"
+		default:
+			learningStyle += fmt.Sprintf("has %s learning preferences", strings.ToLower(pref))
+		}
+
+		if i < len(learningPrefs)-1 {
+			learningStyle += " and "
+		}
+	}
+	return learningStyle
 }
 
 // parseAndCleanLLMResponse parses the LLM response, handling common formatting issues
@@ -298,13 +404,14 @@ func cleanLLMResponse(response string) string {
 	return strings.TrimSpace(response)
 }
 
-// createPracticeItems creates practice items in the database and returns their IDs
-func createPracticeItems(e *core.RequestEvent, items []PracticeItemResponse, topicId, accountId string) ([]string, error) {
+// createPracticeItems creates practice items in the database and returns their IDs.
+// Items of a response flagged by the moderation wait for a parent review, the moderation is nil otherwise.
+func createPracticeItems(app core.App, items []PracticeItemResponse, topicId, accountId string, moderation *domain.Moderation) ([]string, error) {
 	practiceItemIds := make([]string, 0, len(items))
 
 	for _, item := range items {
 		// Create a new practice item record
-		collection, err := e.App.FindCollectionByNameOrId(domain.CollectionPracticeItems)
+		collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeItems)
 		if err != nil {
 			return nil, fmt.Errorf("failed to find practice_items collection: %w", err)
 		}
@@ -346,9 +453,14 @@ func createPracticeItems(e *core.RequestEvent, items []PracticeItemResponse, top
 		newItem.Set("practice_topic", topicId)
 		newItem.Set("account", accountId)
 		newItem.Set("tags", "[]") // Empty tags array
+		if moderation != nil {
+			newItem.Set("status", practiceItemStatusFlagged)
+			newItem.Set("review_status", "NEED_EDIT")
+			newItem.Set("moderation_reason", moderation.Reason)
+		}
 
 		// Save the practice item
-		if err := e.App.Save(newItem); err != nil {
+		if err := app.Save(newItem); err != nil {
 			return nil, fmt.Errorf("failed to save practice item: %w", err)
 		}
 
@@ -358,10 +470,12 @@ func createPracticeItems(e *core.RequestEvent, items []PracticeItemResponse, top
 	return practiceItemIds, nil
 }
 
-// createPracticeSession creates a practice session in the database
-func createPracticeSession(e *core.RequestEvent, topicId, learnerId string, itemIds []string, generationPrompt, accountId string) (*core.Record, error) {
+// createPracticeSession creates a practice session in the database, the prompt version is empty when the account prompt was used.
+// A session generated from a response flagged by the moderation needs a parent review, its items are flagged items
+// which learners don't read until a parent approves them.
+func createPracticeSession(app core.App, topicId, learnerId string, itemIds []string, generationPrompt, promptVersionId, accountId string, moderation *domain.Moderation) (*core.Record, error) {
 	// Create a new practice session record
-	collection, err := e.App.FindCollectionByNameOrId(domain.CollectionPracticeSessions)
+	collection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeSessions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find practice_sessions collection: %w", err)
 	}
@@ -385,20 +499,35 @@ func createPracticeSession(e *core.RequestEvent, topicId, learnerId string, item
 	session.Set("practice_items", string(itemIdsJsonString))
 	// Workflow status (e.g., 'Generated', 'NeedsReview', 'Approved', 'Rejected')
 	session.Set("status", "Generated")
+	if moderation != nil {
+		session.Set("practice_items", "[]")
+		session.Set("flagged_items", string(itemIdsJsonString))
+		session.Set("status", practiceSessionStatusNeedsReview)
+	}
 	session.Set("assigned_at", time.Now())
 	session.Set("generation_prompt", generationPrompt)
+	session.Set("prompt_version", promptVersionId)
 	session.Set("account", accountId)
 
 	// Save the practice session
-	if err := e.App.Save(session); err != nil {
+	if err := app.Save(session); err != nil {
 		return nil, fmt.Errorf("failed to save practice session: %w", err)
 	}
 
 	return session, nil
 }
 
-// generatePracticeItemsWithRetry attempts to generate and parse practice items with retry logic
-func (r *sessionRoute) generatePracticeItemsWithRetry(generationPrompt, systemPrompt string, chatOptions []llm.ChatOption) ([]PracticeItemResponse, error) {
+// usageModeration returns the moderation of the usage, nil when the response wasn't flagged
+func usageModeration(usage *domain.Usage) *domain.Moderation {
+	if usage == nil {
+		return nil
+	}
+	return usage.Moderation
+}
+
+// generatePracticeItemsWithRetry attempts to generate and parse practice items with retry logic.
+// generate sends the request to the LLM with the given options and returns its response.
+func generatePracticeItemsWithRetry(ctx context.Context, chatOptions []llm.ChatOption, generate func(options []llm.ChatOption) (string, error)) ([]PracticeItemResponse, error) {
 	var practiceItems []PracticeItemResponse
 	var parseErr error
 
@@ -415,10 +544,11 @@ func (r *sessionRoute) generatePracticeItemsWithRetry(generationPrompt, systemPr
 			currentChatOptions[len(chatOptions)] = llm.WithCache(true, false)
 		}
 
-		llmResponse, _, err := r.llmService.Chat(generationPrompt, systemPrompt, currentChatOptions...)
+		llmResponse, err := generate(currentChatOptions)
 		if err != nil {
 			log.Error().Err(err).Int("attempt", attempt).Msg("Failed to generate practice items using LLM")
-			if attempt == maxRetries {
+			if attempt == maxRetries || ctx.Err() != nil || errors.Is(err, llm.ErrBudgetExceeded) ||
+				errors.Is(err, llm.ErrCloudPlatformForbidden) || errors.Is(err, llm.ErrModelCapability) {
 				return nil, err
 			}
 			continue // Try next attempt
@@ -0,0 +1,114 @@
+package practice
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleCreatePracticeSessionStream(t *testing.T) {
+	app := setupTestApp(t)
+
+	userCollection, err := app.FindCollectionByNameOrId("users")
+	require.NoError(t, err)
+	user := core.NewRecord(userCollection)
+	user.Set("email", "parent@example.com")
+	user.Set("password", "test123")
+	require.NoError(t, app.SaveNoValidate(user))
+
+	accountCollection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+	require.NoError(t, err)
+	account := core.NewRecord(accountCollection)
+	account.Set("name", "Family")
+	account.Set("owner", user.Id)
+	require.NoError(t, app.SaveNoValidate(account))
+
+	learnerCollection, err := app.FindCollectionByNameOrId(domain.CollectionLearners)
+	require.NoError(t, err)
+	learner := core.NewRecord(learnerCollection)
+	learner.Set("nickname", "Mia")
+	learner.Set("age", 9)
+	learner.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(learner))
+
+	topicCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeTopics)
+	require.NoError(t, err)
+	topic := core.NewRecord(topicCollection)
+	topic.Set("name", "Fractions")
+	topic.Set("subject", "Math")
+	topic.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(topic))
+
+	handle := func(service *generationService, req CreatePracticeSessionRequest) (*httptest.ResponseRecorder, error) {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		request := httptest.NewRequest(http.MethodPost, "/api/glimmer/v1/practice/session", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e := &core.RequestEvent{
+			App:  app,
+			Auth: user,
+			Event: router.Event{
+				Response: rec,
+				Request:  request,
+			},
+		}
+		return rec, NewPracticeSessionRoute(service).HandleCreatePracticeSession(e)
+	}
+
+	validResponse := `{"items": [
+		{"question_text": "What is 1/3 + 1/3?", "question_type": "short_answer", "correct_answer": "2/3", "explanation": "Add the numerators."}
+	]}`
+
+	t.Run("reports the queue position then the session", func(t *testing.T) {
+		service := &generationService{response: validResponse, queuePositions: []int{2, 1, 0}}
+
+		rec, err := handle(service, CreatePracticeSessionRequest{LearnerId: learner.Id, PracticeTopicId: topic.Id, Stream: true})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+		body := rec.Body.String()
+		assert.True(t, strings.HasPrefix(body, "event: queue\ndata: {\"position\":2}\n\n"+
+			"event: queue\ndata: {\"position\":1}\n\n"+
+			"event: queue\ndata: {\"position\":0}\n\n"+
+			"event: done\ndata: "), body)
+
+		var session map[string]any
+		done := strings.TrimSpace(body[strings.Index(body, "event: done\ndata: ")+len("event: done\ndata: "):])
+		require.NoError(t, json.Unmarshal([]byte(done), &session))
+		record, err := app.FindRecordById(domain.CollectionPracticeSessions, session["id"].(string))
+		require.NoError(t, err)
+		assert.Equal(t, learner.Id, record.GetString("learner"))
+	})
+
+	t.Run("reports failures as an error event", func(t *testing.T) {
+		service := &generationService{response: "Sorry, I can't help with that."}
+
+		rec, err := handle(service, CreatePracticeSessionRequest{LearnerId: learner.Id, PracticeTopicId: topic.Id, Stream: true})
+		require.NoError(t, err)
+		assert.Equal(t, "event: error\ndata: {\"message\":\"Failed to create practice session\",\"status\":500}\n\n", rec.Body.String())
+	})
+
+	t.Run("responds with the session without streaming", func(t *testing.T) {
+		service := &generationService{response: validResponse, queuePositions: []int{1, 0}}
+
+		rec, err := handle(service, CreatePracticeSessionRequest{LearnerId: learner.Id, PracticeTopicId: topic.Id})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "event:")
+
+		require.Len(t, service.params, 1)
+		assert.Nil(t, service.params[0].OnQueued)
+	})
+}
@@ -0,0 +1,263 @@
+package practice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxToolQuestionCount bounds the practice items of a session created from the chat
+	maxToolQuestionCount = 30
+	// defaultToolResultsLimit and maxToolResultsLimit bound the practice results returned to the chat
+	defaultToolResultsLimit = 10
+	maxToolResultsLimit     = 50
+)
+
+type (
+	// createSessionToolArguments are the arguments of the create_practice_session tool
+	createSessionToolArguments struct {
+		Learner       string `json:"learner"`
+		Topic         string `json:"topic"`
+		QuestionCount *int   `json:"question_count"`
+	}
+
+	// recentResultsToolArguments are the arguments of the get_recent_results tool
+	recentResultsToolArguments struct {
+		Learner string `json:"learner"`
+		Limit   int    `json:"limit"`
+	}
+
+	// toolPracticeResult is a practice result as reported to the chat
+	toolPracticeResult struct {
+		Question      string  `json:"question"`
+		Topic         string  `json:"topic,omitempty"`
+		Answer        string  `json:"answer"`
+		IsCorrect     bool    `json:"is_correct"`
+		Score         float64 `json:"score"`
+		AttemptNumber int     `json:"attempt_number"`
+		HintsUsed     int     `json:"hints_used"`
+		SubmittedAt   string  `json:"submitted_at,omitempty"`
+	}
+)
+
+var (
+	createSessionTool = llm.Tool{
+		Name: "create_practice_session",
+		Description: "Create a practice session for a learner of the account on one of the account's practice topics. " +
+			"The session is generated by the LLM and waits for the parent's review before the learner can practice.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"learner": map[string]any{
+					"type":        "string",
+					"description": "Nickname of the learner, e.g. Mia",
+				},
+				"topic": map[string]any{
+					"type":        "string",
+					"description": "Name of the practice topic, e.g. Fractions",
+				},
+				"question_count": map[string]any{
+					"type":        "integer",
+					"description": fmt.Sprintf("Number of questions, from 1 to %d. Omit it to use the number of the session prompt", maxToolQuestionCount),
+				},
+			},
+			"required": []string{"learner", "topic"},
+		},
+	}
+
+	recentResultsTool = llm.Tool{
+		Name:        "get_recent_results",
+		Description: "Get the most recent answers of a learner of the account, with whether they were correct and their score.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"learner": map[string]any{
+					"type":        "string",
+					"description": "Nickname of the learner, e.g. Mia",
+				},
+				"limit": map[string]any{
+					"type":        "integer",
+					"description": fmt.Sprintf("Number of results, from 1 to %d, %d by default", maxToolResultsLimit, defaultToolResultsLimit),
+				},
+			},
+			"required": []string{"learner"},
+		},
+	}
+)
+
+// RegisterChatTools registers the practice tools of the account chat.
+// The tools only see the learners and practice topics of the account the chat is made for.
+func RegisterChatTools(registry *llm.ToolRegistry, app core.App, llmService llm.Service) error {
+	if err := registry.Register(createSessionTool, func(ctx context.Context, caller llm.ToolCaller, arguments json.RawMessage) (string, error) {
+		return createSessionFromChat(ctx, app, llmService, caller, arguments)
+	}); err != nil {
+		return err
+	}
+
+	return registry.Register(recentResultsTool, func(ctx context.Context, caller llm.ToolCaller, arguments json.RawMessage) (string, error) {
+		return recentResultsForChat(app, caller, arguments)
+	})
+}
+
+// createSessionFromChat generates a practice session for the learner and topic named by the model
+func createSessionFromChat(ctx context.Context, app core.App, llmService llm.Service, caller llm.ToolCaller, arguments json.RawMessage) (string, error) {
+	var args createSessionToolArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	// An omitted question count leaves it to the prompts
+	questionCount := 0
+	if args.QuestionCount != nil {
+		questionCount = *args.QuestionCount
+		if questionCount < 1 || questionCount > maxToolQuestionCount {
+			return "", fmt.Errorf("question_count must be between 1 and %d", maxToolQuestionCount)
+		}
+	}
+
+	learner, err := findAccountRecordByName(app, domain.CollectionLearners, "nickname", caller.AccountID, args.Learner)
+	if err != nil {
+		return "", err
+	}
+	topic, err := findAccountRecordByName(app, domain.CollectionPracticeTopics, "name", caller.AccountID, args.Topic)
+	if err != nil {
+		return "", err
+	}
+
+	session, err := generatePracticeSession(ctx, app, llmService, learner, topic, caller.UserID, sessionOptions{
+		QuestionCount: questionCount,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return toolResult(map[string]any{
+		"session_id":     session.Id,
+		"name":           session.GetString("name"),
+		"learner":        learner.GetString("nickname"),
+		"topic":          topic.GetString("name"),
+		"question_count": len(session.GetStringSlice("practice_items")),
+		"flagged_count":  len(session.GetStringSlice("flagged_items")),
+		"status":         session.GetString("status"),
+	})
+}
+
+// recentResultsForChat returns the latest practice results of the learner named by the model, most recent first
+func recentResultsForChat(app core.App, caller llm.ToolCaller, arguments json.RawMessage) (string, error) {
+	var args recentResultsToolArguments
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultToolResultsLimit
+	}
+	limit = min(limit, maxToolResultsLimit)
+
+	learner, err := findAccountRecordByName(app, domain.CollectionLearners, "nickname", caller.AccountID, args.Learner)
+	if err != nil {
+		return "", err
+	}
+
+	records, err := app.FindRecordsByFilter(
+		domain.CollectionPracticeResults,
+		"learner = {:learner}",
+		"-created",
+		limit,
+		0,
+		map[string]any{"learner": learner.Id},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to find practice results: %w", err)
+	}
+
+	// The results are still useful without their questions
+	for path, err := range app.ExpandRecords(records, []string{"practice_item.practice_topic"}, nil) {
+		log.Warn().Err(err).Str("expand", path).Msg("Failed to expand practice results")
+	}
+
+	results := make([]toolPracticeResult, 0, len(records))
+	correct := 0
+	for _, record := range records {
+		result := toolPracticeResult{
+			Answer:        record.GetString("answer"),
+			IsCorrect:     record.GetBool("is_correct"),
+			Score:         record.GetFloat("score"),
+			AttemptNumber: record.GetInt("attempt_number"),
+			HintsUsed:     record.GetInt("hint_level_reached"),
+		}
+		if submittedAt := record.GetDateTime("submitted_at"); !submittedAt.IsZero() {
+			result.SubmittedAt = submittedAt.String()
+		}
+		if item := record.ExpandedOne("practice_item"); item != nil {
+			result.Question = item.GetString("question_text")
+			if topic := item.ExpandedOne("practice_topic"); topic != nil {
+				result.Topic = topic.GetString("name")
+			}
+		}
+		if result.IsCorrect {
+			correct++
+		}
+		results = append(results, result)
+	}
+
+	return toolResult(map[string]any{
+		"learner": learner.GetString("nickname"),
+		"correct": correct,
+		"total":   len(results),
+		"results": results,
+	})
+}
+
+// findAccountRecordByName finds the record of the account whose field matches the name, ignoring the case.
+// Without an exact match a single record containing the name matches, so that "fractions" finds "Adding Fractions".
+// The error lists the names of the account, which lets the model correct the name.
+func findAccountRecordByName(app core.App, collection, field, accountId, name string) (*core.Record, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%s is required", field)
+	}
+	if accountId == "" {
+		return nil, fmt.Errorf("the chat isn't made for an account")
+	}
+
+	records, err := app.FindRecordsByFilter(collection, "account = {:account}", field, 0, 0, map[string]any{"account": accountId})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s: %w", collection, err)
+	}
+
+	var partial []*core.Record
+	names := make([]string, 0, len(records))
+	for _, record := range records {
+		value := record.GetString(field)
+		if strings.EqualFold(value, name) {
+			return record, nil
+		}
+		if strings.Contains(strings.ToLower(value), strings.ToLower(name)) {
+			partial = append(partial, record)
+		}
+		names = append(names, value)
+	}
+
+	if len(partial) == 1 {
+		return partial[0], nil
+	}
+
+	return nil, fmt.Errorf("no %s named %q, the account has: %s", strings.ReplaceAll(collection, "_", " "), name, strings.Join(names, ", "))
+}
+
+// toolResult encodes the result of a tool as JSON for the model
+func toolResult(result any) (string, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the result: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,217 @@
+package practice
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generationService answers chats with the queued responses, then with a fixed response, the other methods are not used
+type generationService struct {
+	llm.Service
+	responses []string
+	response  string
+	// moderation is set in the usage of the responses
+	moderation *domain.Moderation
+	prompts    []string
+	params     []*llm.ChatParameters
+	// queuePositions are reported to the queue handler of the chats before answering
+	queuePositions []int
+}
+
+func (s *generationService) Chat(ctx context.Context, prompt string, systemPrompt string, options ...llm.ChatOption) (string, *domain.Usage, error) {
+	params := &llm.ChatParameters{}
+	for _, option := range options {
+		option(params)
+	}
+	s.prompts = append(s.prompts, prompt)
+	s.params = append(s.params, params)
+	if params.OnQueued != nil {
+		for _, position := range s.queuePositions {
+			params.OnQueued(position)
+		}
+	}
+	if len(s.responses) > 0 {
+		response := s.responses[0]
+		s.responses = s.responses[1:]
+		return response, &domain.Usage{Moderation: s.moderation}, nil
+	}
+	return s.response, &domain.Usage{Moderation: s.moderation}, nil
+}
+
+func TestChatTools(t *testing.T) {
+	app := setupTestApp(t)
+
+	userCollection, err := app.FindCollectionByNameOrId("users")
+	require.NoError(t, err)
+	user := core.NewRecord(userCollection)
+	user.Set("email", "parent@example.com")
+	user.Set("password", "test123")
+	require.NoError(t, app.SaveNoValidate(user))
+
+	accountCollection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+	require.NoError(t, err)
+	newAccount := func(name string) *core.Record {
+		account := core.NewRecord(accountCollection)
+		account.Set("name", name)
+		account.Set("owner", user.Id)
+		require.NoError(t, app.SaveNoValidate(account))
+		return account
+	}
+	account := newAccount("Family")
+	otherAccount := newAccount("Other family")
+
+	learnerCollection, err := app.FindCollectionByNameOrId(domain.CollectionLearners)
+	require.NoError(t, err)
+	newLearner := func(nickname string, account *core.Record) *core.Record {
+		learner := core.NewRecord(learnerCollection)
+		learner.Set("nickname", nickname)
+		learner.Set("account", account.Id)
+		learner.Set("age", 9)
+		require.NoError(t, app.SaveNoValidate(learner))
+		return learner
+	}
+	mia := newLearner("Mia", account)
+	newLearner("Leo", otherAccount)
+
+	topicCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeTopics)
+	require.NoError(t, err)
+	topic := core.NewRecord(topicCollection)
+	topic.Set("name", "Adding Fractions")
+	topic.Set("subject", "Math")
+	topic.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(topic))
+
+	itemCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeItems)
+	require.NoError(t, err)
+	item := core.NewRecord(itemCollection)
+	item.Set("question_text", "What is 1/2 + 1/4?")
+	item.Set("question_type", "short_answer")
+	item.Set("practice_topic", topic.Id)
+	item.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(item))
+
+	resultCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeResults)
+	require.NoError(t, err)
+	for _, answer := range []string{"2/6", "3/4"} {
+		result := core.NewRecord(resultCollection)
+		result.Set("learner", mia.Id)
+		result.Set("practice_item", item.Id)
+		result.Set("answer", answer)
+		result.Set("is_correct", answer == "3/4")
+		result.Set("score", 1)
+		result.Set("attempt_number", 1)
+		result.Set("account", account.Id)
+		require.NoError(t, app.SaveNoValidate(result))
+	}
+
+	service := &generationService{response: `{"items": [
+		{"question_text": "What is 1/3 + 1/3?", "question_type": "short_answer", "correct_answer": "2/3", "explanation": "Add the numerators."}
+	]}`}
+	registry := llm.NewToolRegistry()
+	require.NoError(t, RegisterChatTools(registry, app, service))
+	RegisterReviewHooks(app)
+	caller := llm.ToolCaller{AccountID: account.Id, UserID: user.Id}
+
+	t.Run("creates a practice session", func(t *testing.T) {
+		result, err := registry.Call(context.Background(), caller, "create_practice_session",
+			json.RawMessage(`{"learner": "mia", "topic": "fractions", "question_count": 10}`))
+		require.NoError(t, err)
+
+		var created map[string]any
+		require.NoError(t, json.Unmarshal([]byte(result), &created))
+		assert.Equal(t, "Mia", created["learner"])
+		assert.Equal(t, "Adding Fractions", created["topic"])
+		assert.Equal(t, "Generated", created["status"])
+
+		session, err := app.FindRecordById(domain.CollectionPracticeSessions, created["session_id"].(string))
+		require.NoError(t, err)
+		assert.Equal(t, mia.Id, session.GetString("learner"))
+		assert.Equal(t, account.Id, session.GetString("account"))
+		assert.Len(t, session.GetStringSlice("practice_items"), 1)
+
+		require.Len(t, service.prompts, 1)
+		assert.Contains(t, service.prompts[0], "Create exactly 10 practice items.")
+		assert.Equal(t, user.Id, service.params[0].UserID)
+		assert.Equal(t, llm.PurposeSessionGeneration, service.params[0].Purpose)
+	})
+
+	t.Run("returns the recent results", func(t *testing.T) {
+		result, err := registry.Call(context.Background(), caller, "get_recent_results", json.RawMessage(`{"learner": "Mia"}`))
+		require.NoError(t, err)
+
+		var results struct {
+			Correct int                  `json:"correct"`
+			Total   int                  `json:"total"`
+			Results []toolPracticeResult `json:"results"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(result), &results))
+		assert.Equal(t, 1, results.Correct)
+		assert.Equal(t, 2, results.Total)
+		require.Len(t, results.Results, 2)
+		assert.Equal(t, "What is 1/2 + 1/4?", results.Results[0].Question)
+		assert.Equal(t, "Adding Fractions", results.Results[0].Topic)
+	})
+
+	t.Run("only sees the learners of the account", func(t *testing.T) {
+		_, err := registry.Call(context.Background(), caller, "get_recent_results", json.RawMessage(`{"learner": "Leo"}`))
+		require.Error(t, err)
+		assert.True(t, strings.HasSuffix(err.Error(), "the account has: Mia"), "the error lists the learners of the account")
+	})
+
+	t.Run("holds back the practice items flagged by the moderation", func(t *testing.T) {
+		service.moderation = &domain.Moderation{Reason: `contains "scary"`, Source: "local"}
+		defer func() { service.moderation = nil }()
+
+		result, err := registry.Call(context.Background(), caller, "create_practice_session",
+			json.RawMessage(`{"learner": "Mia", "topic": "Adding Fractions", "question_count": 1}`))
+		require.NoError(t, err)
+
+		var created map[string]any
+		require.NoError(t, json.Unmarshal([]byte(result), &created))
+		assert.Equal(t, practiceSessionStatusNeedsReview, created["status"])
+
+		assert.EqualValues(t, 0, created["question_count"])
+		assert.EqualValues(t, 1, created["flagged_count"])
+
+		session, err := app.FindRecordById(domain.CollectionPracticeSessions, created["session_id"].(string))
+		require.NoError(t, err)
+		assert.Empty(t, session.GetStringSlice("practice_items"), "learners don't read the flagged items")
+		itemIds := session.GetStringSlice("flagged_items")
+		require.Len(t, itemIds, 1)
+
+		flagged, err := app.FindRecordById(domain.CollectionPracticeItems, itemIds[0])
+		require.NoError(t, err)
+		assert.Equal(t, practiceItemStatusFlagged, flagged.GetString("status"))
+		assert.Equal(t, "NEED_EDIT", flagged.GetString("review_status"))
+		assert.Equal(t, `contains "scary"`, flagged.GetString("moderation_reason"))
+
+		// The parent approves the flagged item
+		flagged.Set("review_status", "APPROVED")
+		require.NoError(t, app.Save(flagged))
+
+		session, err = app.FindRecordById(domain.CollectionPracticeSessions, session.Id)
+		require.NoError(t, err)
+		assert.Equal(t, itemIds, session.GetStringSlice("practice_items"))
+		assert.Empty(t, session.GetStringSlice("flagged_items"))
+	})
+
+	t.Run("rejects too many questions", func(t *testing.T) {
+		_, err := registry.Call(context.Background(), caller, "create_practice_session",
+			json.RawMessage(`{"learner": "Mia", "topic": "Adding Fractions", "question_count": 500}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects zero questions", func(t *testing.T) {
+		_, err := registry.Call(context.Background(), caller, "create_practice_session",
+			json.RawMessage(`{"learner": "Mia", "topic": "Adding Fractions", "question_count": 0}`))
+		assert.EqualError(t, err, "question_count must be between 1 and 30")
+	})
+}
@@ -0,0 +1,209 @@
+package practice
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/rs/zerolog/log"
+)
+
+type (
+	WorksheetRoute interface {
+		HandleCreateWorksheetSession(e *core.RequestEvent) error
+	}
+
+	worksheetRoute struct {
+		llmService llm.Service
+	}
+)
+
+const (
+	// worksheetPhotoField is the multipart field holding the photo of the worksheet
+	worksheetPhotoField = "photo"
+	// maxWorksheetPhotoSize bounds the uploaded photo, phone photos are usually a few megabytes
+	maxWorksheetPhotoSize = 10 << 20
+
+	worksheetSystemPrompt = "You are an expert educational content creator. You read photos of school worksheets " +
+		"and turn every exercise on them into practice items for students."
+)
+
+func NewWorksheetRoute(llmService llm.Service) WorksheetRoute {
+	return &worksheetRoute{
+		llmService: llmService,
+	}
+}
+
+// HandleCreateWorksheetSession creates practice items and a draft practice session from the photo of a worksheet.
+// The request is a multipart form with the learnerId, the practiceTopicId, the photo and an optional vision model.
+// The session and its items have the Generated status, so that the parent reviews them before the learner practices.
+func (r *worksheetRoute) HandleCreateWorksheetSession(e *core.RequestEvent) error {
+	e.Request.Body = http.MaxBytesReader(e.Response, e.Request.Body, maxWorksheetPhotoSize+1<<20)
+	if err := e.Request.ParseMultipartForm(maxWorksheetPhotoSize); err != nil {
+		return e.BadRequestError("Invalid multipart form", err)
+	}
+
+	learnerId := e.Request.FormValue("learnerId")
+	topicId := e.Request.FormValue("practiceTopicId")
+	if learnerId == "" || topicId == "" {
+		return e.BadRequestError("LearnerId and PracticeTopicId are required", nil)
+	}
+
+	photo, fileName, err := readWorksheetPhoto(e.Request)
+	if err != nil {
+		return e.BadRequestError(err.Error(), err)
+	}
+
+	learner, err := e.App.FindRecordById(domain.CollectionLearners, learnerId)
+	if err != nil {
+		log.Error().Err(err).Str("learnerId", learnerId).Msg("Failed to find learner")
+		return e.NotFoundError("Learner not found", err)
+	}
+
+	topic, err := e.App.FindRecordById(domain.CollectionPracticeTopics, topicId)
+	if err != nil {
+		log.Error().Err(err).Str("topicId", topicId).Msg("Failed to find practice topic")
+		return e.NotFoundError("Practice topic not found", err)
+	}
+
+	// The learner and the topic must belong to the account of the user, the extraction spends the budget of the account
+	if !isAccountOwner(e.App, e.Auth.Id, learner, topic) {
+		log.Warn().Str("learnerId", learnerId).Str("topicId", topicId).Str("userID", e.Auth.Id).Msg("User tried to use a learner or practice topic they don't own")
+		return e.ForbiddenError("Not authorized to use this learner or practice topic", nil)
+	}
+
+	accountId := learner.GetString("account")
+	extractionPrompt := buildWorksheetPrompt(buildLearnerProfile(learner, topic), topic)
+
+	// Use the account's own Ollama server and default model, the model of the request must be able to read images
+	var chatOptions []llm.ChatOption
+	if settings, err := llm.FindAccountSettings(e.App, accountId); err == nil {
+		chatOptions = append(chatOptions, settings.ChatOptions()...)
+	} else {
+		log.Warn().Err(err).Str("accountId", accountId).Msg("Failed to load account LLM settings, using global config")
+	}
+	if model := e.Request.FormValue("model"); model != "" {
+		chatOptions = append(chatOptions, llm.WithModel(model))
+	}
+
+	chatOptions = append(chatOptions,
+		llm.WithUser(e.Auth.Id),
+		llm.WithPurpose(llm.PurposeWorksheetExtraction),
+		llm.WithJSONSchema("practice_items", practiceItemsSchema),
+	)
+
+	log.Info().
+		Str("learnerId", learner.Id).
+		Str("topic", topic.GetString("name")).
+		Str("fileName", fileName).
+		Int("photoBytes", len(photo)).
+		Msg("Extracting practice items from worksheet photo")
+
+	// The photo is read again from the start on every attempt
+	ctx := e.Request.Context()
+	var moderation *domain.Moderation
+	practiceItems, err := generatePracticeItemsWithRetry(ctx, chatOptions, func(options []llm.ChatOption) (string, error) {
+		response, usage, err := r.llmService.DescribeImage(ctx, bytes.NewReader(photo), fileName, extractionPrompt, worksheetSystemPrompt, options...)
+		moderation = usageModeration(usage)
+		return response, err
+	})
+	if err != nil {
+		var budgetErr *llm.BudgetExceededError
+		if errors.As(err, &budgetErr) {
+			return e.TooManyRequestsError(budgetErr.Error(), nil)
+		}
+		if errors.Is(err, llm.ErrPlatformNotImplemented) {
+			return e.BadRequestError("The LLM platform can't read images", err)
+		}
+		if errors.Is(err, llm.ErrContentBlocked) {
+			return e.Error(http.StatusUnprocessableEntity, "The extracted practice items were blocked by the content moderation", nil)
+		}
+		if errors.Is(err, llm.ErrCloudPlatformForbidden) {
+			return e.ForbiddenError("The account keeps learner data on local LLM platforms, worksheets need a local vision model", nil)
+		}
+		if errors.Is(err, llm.ErrModelCapability) {
+			return e.BadRequestError(fmt.Sprintf("Worksheets need a vision model: %v", err), nil)
+		}
+		return e.InternalServerError("Failed to extract practice items from the worksheet", err)
+	}
+
+	practiceItemIds, err := createPracticeItems(e.App, practiceItems, topic.Id, accountId, moderation)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create practice items in database")
+		return e.InternalServerError("Failed to save practice items", err)
+	}
+
+	practiceSession, err := createPracticeSession(e.App, topic.Id, learner.Id, practiceItemIds, extractionPrompt, "", accountId, moderation)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create practice session")
+		return e.InternalServerError("Failed to create practice session", err)
+	}
+
+	return e.JSON(http.StatusOK, practiceSession)
+}
+
+// isAccountOwner checks that the records belong to the same account, owned by the user
+func isAccountOwner(app core.App, userId string, records ...*core.Record) bool {
+	accountId := records[0].GetString("account")
+	for _, record := range records {
+		if accountId == "" || record.GetString("account") != accountId {
+			return false
+		}
+	}
+
+	account, err := app.FindRecordById(domain.CollectionAccounts, accountId)
+	if err != nil {
+		log.Error().Err(err).Str("accountId", accountId).Msg("Failed to find account")
+		return false
+	}
+	return account.GetString("owner") == userId
+}
+
+// readWorksheetPhoto reads the uploaded photo, it must be an image
+func readWorksheetPhoto(req *http.Request) ([]byte, string, error) {
+	file, header, err := req.FormFile(worksheetPhotoField)
+	if err != nil {
+		return nil, "", fmt.Errorf("a worksheet photo is required")
+	}
+	defer file.Close()
+
+	photo, err := io.ReadAll(io.LimitReader(file, maxWorksheetPhotoSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read the worksheet photo")
+	}
+	if len(photo) > maxWorksheetPhotoSize {
+		return nil, "", fmt.Errorf("the worksheet photo must be at most %d MB", maxWorksheetPhotoSize>>20)
+	}
+	if !strings.HasPrefix(http.DetectContentType(photo), "image/") {
+		return nil, "", fmt.Errorf("the worksheet photo must be an image")
+	}
+
+	return photo, header.Filename, nil
+}
+
+// buildWorksheetPrompt asks the vision model to extract the exercises of the worksheet for the learner and topic
+func buildWorksheetPrompt(learnerProfile string, topic *core.Record) string {
+	var prompt strings.Builder
+	if learnerProfile != "" {
+		prompt.WriteString(learnerProfile)
+		prompt.WriteString("\n\n")
+	}
+
+	fmt.Fprintf(&prompt, "Topic: %s\nSubject: %s\n\n", topic.GetString("name"), topic.GetString("subject"))
+	prompt.WriteString("The image is a photo of a school worksheet. Extract every exercise of the worksheet as a practice item:\n" +
+		"- Keep the wording of the question, fix obvious reading mistakes only.\n" +
+		"- The question_type is one of \"multiple_choice\", \"true_false\", \"short_answer\" or \"fill_in_blank\", " +
+		"multiple_choice items list their options and true_false answers are \"True\" or \"False\".\n" +
+		"- Solve the exercise to fill in the correct answer, ignoring any answer handwritten on the worksheet.\n" +
+		"- Write an explanation and hints suited to the student, and a difficulty_level of \"easy\", \"medium\" or \"hard\".\n" +
+		"- Skip instructions, headers and anything that isn't an exercise.\n" +
+		"Respond with a JSON object with the practice items in an \"items\" array.")
+
+	return prompt.String()
+}
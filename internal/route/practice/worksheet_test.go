@@ -0,0 +1,243 @@
+package practice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/busybytelab.com/glimmer/internal/domain"
+	"github.com/busybytelab.com/glimmer/internal/llm"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// visionService answers image descriptions with a fixed response, the other methods are not used
+type visionService struct {
+	llm.Service
+	response string
+	images   [][]byte
+	prompts  []string
+}
+
+func (s *visionService) DescribeImage(ctx context.Context, reader io.Reader, fileName string, prompt string, systemPrompt string, options ...llm.ChatOption) (string, *domain.Usage, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	s.images = append(s.images, data)
+	s.prompts = append(s.prompts, prompt)
+	return s.response, &domain.Usage{}, nil
+}
+
+func newWorksheetRequest(t *testing.T, fields map[string]string, photo []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		require.NoError(t, writer.WriteField(name, value))
+	}
+	if photo != nil {
+		part, err := writer.CreateFormFile(worksheetPhotoField, "worksheet.png")
+		require.NoError(t, err)
+		_, err = part.Write(photo)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/glimmer/v1/practice/worksheet", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func testWorksheetPhoto(t *testing.T) []byte {
+	var photo bytes.Buffer
+	require.NoError(t, png.Encode(&photo, image.NewGray(image.Rect(0, 0, 8, 8))))
+	return photo.Bytes()
+}
+
+func TestHandleCreateWorksheetSession(t *testing.T) {
+	app := setupTestApp(t)
+
+	userCollection, err := app.FindCollectionByNameOrId("users")
+	require.NoError(t, err)
+	user := core.NewRecord(userCollection)
+	user.Set("email", "parent@example.com")
+	user.Set("password", "test123")
+	require.NoError(t, app.SaveNoValidate(user))
+
+	accountCollection, err := app.FindCollectionByNameOrId(domain.CollectionAccounts)
+	require.NoError(t, err)
+	account := core.NewRecord(accountCollection)
+	account.Set("name", "Family")
+	account.Set("owner", user.Id)
+	require.NoError(t, app.SaveNoValidate(account))
+
+	learnerCollection, err := app.FindCollectionByNameOrId(domain.CollectionLearners)
+	require.NoError(t, err)
+	learner := core.NewRecord(learnerCollection)
+	learner.Set("nickname", "Test Learner")
+	learner.Set("user", user.Id)
+	learner.Set("account", account.Id)
+	learner.Set("age", 9)
+	require.NoError(t, app.SaveNoValidate(learner))
+
+	topicCollection, err := app.FindCollectionByNameOrId(domain.CollectionPracticeTopics)
+	require.NoError(t, err)
+	topic := core.NewRecord(topicCollection)
+	topic.Set("name", "Fractions")
+	topic.Set("subject", "Math")
+	topic.Set("account", account.Id)
+	require.NoError(t, app.SaveNoValidate(topic))
+
+	extracted := `{"items": [
+		{"question_text": "What is 1/2 + 1/4?", "question_type": "short_answer", "correct_answer": "3/4", "explanation": "Use quarters."},
+		{"question_text": "Is 2/4 equal to 1/2?", "question_type": "true_false", "correct_answer": "True", "explanation": "Simplify 2/4."}
+	]}`
+
+	handle := func(service llm.Service, req *http.Request) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		e := &core.RequestEvent{
+			App:  app,
+			Auth: user,
+			Event: router.Event{
+				Response: rec,
+				Request:  req,
+			},
+		}
+		return rec, NewWorksheetRoute(service).HandleCreateWorksheetSession(e)
+	}
+
+	t.Run("creates a draft session from the photo", func(t *testing.T) {
+		service := &visionService{response: "```json\n" + extracted + "\n```"}
+		photo := testWorksheetPhoto(t)
+
+		rec, err := handle(service, newWorksheetRequest(t, map[string]string{
+			"learnerId":       learner.Id,
+			"practiceTopicId": topic.Id,
+		}, photo))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		require.Len(t, service.images, 1)
+		assert.Equal(t, photo, service.images[0])
+		assert.Contains(t, service.prompts[0], "Topic: Fractions")
+
+		var response map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		session, err := app.FindRecordById(domain.CollectionPracticeSessions, response["id"].(string))
+		require.NoError(t, err)
+		assert.Equal(t, "Generated", session.GetString("status"))
+		assert.Equal(t, learner.Id, session.GetString("learner"))
+
+		itemIds := session.GetStringSlice("practice_items")
+		require.Len(t, itemIds, 2)
+		item, err := app.FindRecordById(domain.CollectionPracticeItems, itemIds[0])
+		require.NoError(t, err)
+		assert.Equal(t, "What is 1/2 + 1/4?", item.GetString("question_text"))
+		assert.Equal(t, "Generated", item.GetString("status"))
+		assert.Equal(t, topic.Id, item.GetString("practice_topic"))
+	})
+
+	t.Run("retries with the photo when the response isn't JSON", func(t *testing.T) {
+		service := &visionService{response: "I can't read this worksheet"}
+
+		_, err := handle(service, newWorksheetRequest(t, map[string]string{
+			"learnerId":       learner.Id,
+			"practiceTopicId": topic.Id,
+		}, testWorksheetPhoto(t)))
+		require.Error(t, err)
+
+		require.Len(t, service.images, 3)
+		assert.Equal(t, service.images[0], service.images[2])
+	})
+
+	t.Run("rejects learners and topics of other accounts", func(t *testing.T) {
+		other := core.NewRecord(userCollection)
+		other.Set("email", "other@example.com")
+		other.Set("password", "test123")
+		require.NoError(t, app.SaveNoValidate(other))
+
+		otherAccount := core.NewRecord(accountCollection)
+		otherAccount.Set("name", "Other Family")
+		otherAccount.Set("owner", other.Id)
+		require.NoError(t, app.SaveNoValidate(otherAccount))
+
+		otherLearner := core.NewRecord(learnerCollection)
+		otherLearner.Set("nickname", "Other Learner")
+		otherLearner.Set("account", otherAccount.Id)
+		require.NoError(t, app.SaveNoValidate(otherLearner))
+
+		otherTopic := core.NewRecord(topicCollection)
+		otherTopic.Set("name", "Decimals")
+		otherTopic.Set("account", otherAccount.Id)
+		require.NoError(t, app.SaveNoValidate(otherTopic))
+
+		tests := []struct {
+			name    string
+			learner string
+			topic   string
+		}{
+			{name: "learner and topic of another account", learner: otherLearner.Id, topic: otherTopic.Id},
+			{name: "topic of another account", learner: learner.Id, topic: otherTopic.Id},
+			{name: "learner of another account", learner: otherLearner.Id, topic: topic.Id},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				service := &visionService{response: extracted}
+
+				_, err := handle(service, newWorksheetRequest(t, map[string]string{
+					"learnerId":       tt.learner,
+					"practiceTopicId": tt.topic,
+				}, testWorksheetPhoto(t)))
+				var apiErr *router.ApiError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, http.StatusForbidden, apiErr.Status)
+				assert.Empty(t, service.images)
+			})
+		}
+	})
+
+	t.Run("rejects invalid requests", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			fields map[string]string
+			photo  []byte
+		}{
+			{
+				name:   "missing learner",
+				fields: map[string]string{"practiceTopicId": topic.Id},
+				photo:  testWorksheetPhoto(t),
+			},
+			{
+				name:   "missing photo",
+				fields: map[string]string{"learnerId": learner.Id, "practiceTopicId": topic.Id},
+			},
+			{
+				name:   "not an image",
+				fields: map[string]string{"learnerId": learner.Id, "practiceTopicId": topic.Id},
+				photo:  []byte("just some text"),
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				service := &visionService{response: extracted}
+
+				_, err := handle(service, newWorksheetRequest(t, tt.fields, tt.photo))
+				var apiErr *router.ApiError
+				require.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+				assert.Empty(t, service.images)
+			})
+		}
+	})
+}
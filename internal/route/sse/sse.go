@@ -0,0 +1,34 @@
+// Package sse writes Server-Sent Events, the streaming routes report their progress with them
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Start writes the headers of an event stream, the status of the response is 200 from then on,
+// so failures are reported with events
+func Start(e *core.RequestEvent) {
+	header := e.Response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+	e.Response.WriteHeader(http.StatusOK)
+}
+
+// WriteEvent writes a single Server-Sent Event with a JSON payload and flushes it to the client
+func WriteEvent(e *core.RequestEvent, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", event, err)
+	}
+
+	if _, err := fmt.Fprintf(e.Response, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return fmt.Errorf("failed to write %s event: %w", event, err)
+	}
+	return e.Flush()
+}
@@ -1,14 +1,29 @@
 import { authService } from '$lib/services/auth';
 
+export type ModelCapability = 'completion' | 'vision' | 'json' | 'tools' | 'embedding';
+
+export type ModelPrice = {
+    prompt: number;
+    completion: number;
+    cachedPrompt?: number;
+};
+
 export type ModelInfo = {
     name: string;
     sizeHuman?: string;
     isDefault: boolean;
+    // empty when the platform doesn't know the model
+    capabilities?: ModelCapability[];
+    contextLength?: number;
+    parameterSize?: string;
+    // per million tokens
+    price?: ModelPrice;
 };
 
 export type PlatformInfo = {
     name: string;
     isDefault: boolean;
+    status: 'ok' | 'degraded';
     models: ModelInfo[];
 };
 
@@ -25,6 +40,23 @@ export type Usage = {
     TotalTokens: number;
 };
 
+export type BudgetPeriodStatus = {
+    period: 'daily' | 'monthly';
+    tokenLimit: number;
+    tokensUsed: number;
+    tokensRemaining: number | null;
+    costLimit: number;
+    costUsed: number;
+    costRemaining: number | null;
+    resetsAt: string;
+};
+
+export type BudgetStatus = {
+    accountId: string;
+    daily: BudgetPeriodStatus;
+    monthly: BudgetPeriodStatus;
+};
+
 export type ChatResponse = {
     response: string;
     usage?: Usage;
@@ -63,6 +95,26 @@ class LLMService {
         return response.json();
     }
 
+    /**
+     * Fetches the LLM usage of the account against its daily and monthly budget
+     */
+    public async getBudget(): Promise<BudgetStatus> {
+        const authToken = authService.getAuthToken();
+        if (!authToken) {
+            throw new Error('Please log in again.');
+        }
+
+        const response = await fetch(`${this.baseUrl}/budget`, {
+            headers: {
+                'Authorization': `Bearer ${authToken}`
+            }
+        });
+        if (!response.ok) {
+            throw new Error(`Error: ${response.status} ${response.statusText}`);
+        }
+        return response.json();
+    }
+
     /**
      * Sends a chat message to the LLM service
      */
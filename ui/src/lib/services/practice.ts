@@ -7,6 +7,14 @@ export interface CreatePracticeSessionRequest {
     basePrompt?: string;
 }
 
+export interface CreateWorksheetSessionRequest {
+    learnerId: string;
+    practiceTopicId: string;
+    photo: File;
+    // Vision model reading the photo, the account default model when empty
+    model?: string;
+}
+
 export interface PracticeSession {
     id: string;
     name: string;
@@ -45,6 +53,31 @@ class PracticeService {
             throw new Error(error.message);
         }
     }
+
+    async createSessionFromWorksheet(request: CreateWorksheetSessionRequest): Promise<PracticeSession> {
+        const form = new FormData();
+        form.append('learnerId', request.learnerId);
+        form.append('practiceTopicId', request.practiceTopicId);
+        form.append('photo', request.photo);
+        if (request.model) {
+            form.append('model', request.model);
+        }
+
+        const response = await fetch('/api/glimmer/v1/practice/worksheet', {
+            method: 'POST',
+            headers: {
+                'Authorization': pb.authStore.token
+            },
+            body: form
+        });
+
+        if (!response.ok) {
+            const errorData = await response.json().catch(() => ({}));
+            throw new Error(errorData.message || `Server error: ${response.status}`);
+        }
+
+        return response.json();
+    }
 }
 
 export const practiceService = new PracticeService(); 
\ No newline at end of file
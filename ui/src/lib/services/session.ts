@@ -7,6 +7,7 @@ export interface SessionWithExpandedData extends PracticeSession {
         learner?: Learner;
         practice_topic?: PracticeTopic;
         practice_items?: PracticeItem[];
+        flagged_items?: PracticeItem[];
     };
 }
 
@@ -14,8 +15,8 @@ class SessionService {
     async loadSession(id: string): Promise<SessionWithExpandedData> {
         try {
             const result = await pb.collection('practice_sessions').getOne(id, {
-                expand: 'learner,practice_topic,practice_items',
-                fields: 'id,name,status,assigned_at,completed_at,generation_prompt,learner,practice_topic,practice_items,expand'
+                expand: 'learner,practice_topic,practice_items,flagged_items',
+                fields: 'id,name,status,assigned_at,completed_at,generation_prompt,learner,practice_topic,practice_items,flagged_items,expand'
             });
 
             if (!result) {
@@ -65,11 +66,20 @@ class SessionService {
         if (session.expand?.practice_items) {
             return session.expand.practice_items;
         }
+        // All the items of a session may be flagged items waiting for a parent review
+        if (!session.practice_items?.length) {
+            return [];
+        }
         // If practice_items is not expanded, it's an array of IDs
         // We should never reach here because we always expand practice_items in loadSession
         throw new Error('Practice items not expanded. This is a data integrity error.');
     }
 
+    /** Returns the practice items flagged by the content moderation, the session must be loaded with loadSession */
+    parseFlaggedItems(session: SessionWithExpandedData): PracticeItem[] {
+        return session.expand?.flagged_items ?? [];
+    }
+
     async updateSession(id: string, data: Partial<PracticeSession>): Promise<PracticeSession> {
         return await pb.collection('practice_sessions').update(id, data) as PracticeSession;
     }
@@ -117,8 +117,11 @@ export interface PracticeItem extends PocketBaseRecord {
     /** The difficulty level of the question */
     difficulty_level?: string;
     
-    /** Current status of the practice item */
+    /** Current status of the practice item, 'Flagged' when the content moderation flagged it */
     status: string;
+
+    /** Why the content moderation flagged the item, the item waits for a parent review */
+    moderation_reason?: string;
     
     /** Associated tags for categorization */
     tags?: Record<string, any>;
@@ -192,10 +195,14 @@ export interface PracticeSession extends PocketBaseRecord {
     assigned_at: string;
     completed_at?: string;
     generation_prompt?: string;
+    /** Prompt version the session was generated with, empty when the account prompt was used */
+    prompt_version?: string;
     learner: string;
     practice_topic: string;
     account: string;
     practice_items: string;
+    /** Practice items flagged by the content moderation, learners don't see them until a parent approves them */
+    flagged_items?: string[];
     score?: number;
     expand?: {
         learner?: Learner;
@@ -204,6 +211,7 @@ export interface PracticeSession extends PocketBaseRecord {
             name: string;
         };
         practice_items?: PracticeItem[];
+        flagged_items?: PracticeItem[];
     };
 }
 
@@ -316,6 +324,8 @@ export interface Account extends PocketBaseRecord {
     ollama_server_url?: string;
     default_llm_model?: string;
     default_language?: string;
+    llm_local_only?: boolean;
+    llm_redacted_identifiers?: string;
 }
 
 // -------------------------------------------------------------------------
@@ -396,6 +406,51 @@ export interface AccountStats extends PocketBaseRecord {
     avg_practice_results_per_learner: number;
 }
 
+/**
+ * Prompt version of an account, versions with a weight share the practice session generations
+ */
+export interface PromptVersion extends PocketBaseRecord {
+    account: string;
+    name: string;
+    /** Prompt template, empty uses the account template */
+    template?: string;
+    /** Instructions replacing the account prompt extension, empty uses the account extension */
+    instructions?: string;
+    /** Share of the generations, 0 takes the version out of the comparison */
+    weight: number;
+}
+
+/**
+ * Statistics for a prompt version, generated from the prompt_version_stats view
+ * Matches all fields from the migrations 1746341030_created_prompt_version_stats.go and 1746341035_updated_prompt_version_stats_flagged_items.go
+ */
+export interface PromptVersionStats extends PocketBaseRecord {
+    account: string;
+    name: string;
+    weight: number;
+    /** Number of practice session generations made with the version */
+    generations: number;
+    /** Number of LLM responses, retries included */
+    responses: number;
+    /** Number of LLM responses that weren't valid practice items */
+    parse_failures: number;
+    /** Share of the LLM responses that weren't valid practice items */
+    parse_failure_rate: number;
+    total_items: number;
+    /** Number of practice items reviewed by the parent */
+    reviewed_items: number;
+    approved_items: number;
+    /** Number of practice items flagged by the content moderation, they aren't reviewed until the parent approves or ignores them */
+    flagged_items: number;
+    /** Share of the reviewed practice items the parent approved */
+    approval_rate: number;
+    /** Number of learner answers */
+    answers: number;
+    correct_answers: number;
+    /** Share of the learner answers that were correct */
+    accuracy: number;
+}
+
 export interface User extends PocketBaseRecord {
     email: string;
     name: string;